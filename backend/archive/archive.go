@@ -0,0 +1,326 @@
+// Package archive implements a read only backend which shows archive
+// files on another remote as directories.
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/hash"
+	libcache "github.com/rclone/rclone/lib/cache"
+)
+
+// Globals
+var (
+	errorReadOnly = errors.New("archive remotes are read only")
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "archive",
+		Description: "Read zip, tar and 7z archives as directories",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "remote",
+			Help: `Remote containing the archives.
+
+Normally should contain a ':' and a path, e.g. "myremote:path/to/dir",
+"myremote:bucket" or maybe "myremote:" (not recommended).
+
+Files ending in .zip, .tar or .7z on this remote are shown as
+directories containing the files in the archive.`,
+			Required: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote string `config:"remote"`
+}
+
+// Fs represents a remote with its archives shown as directories
+type Fs struct {
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features    // optional features
+	base     fs.Fs           // the remote the archives are on
+	wrapper  fs.Fs           // wrapping Fs, if any
+	archives *libcache.Cache // indexes of recently read archives
+}
+
+// NewFs constructs an Fs from the path.
+//
+// The returned Fs is the actual Fs, referenced by remote in the config
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Remote == "" {
+		return nil, errors.New("archive can't point to an empty remote - check the value of the remote setting")
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point archive remote at itself - check the value of the remote setting")
+	}
+	base, err := cache.Get(ctx, opt.Remote)
+	if err == fs.ErrorIsFile {
+		return nil, fmt.Errorf("archive remote %q must be a directory", opt.Remote)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", opt.Remote, err)
+	}
+
+	f := &Fs{
+		name:     name,
+		root:     strings.Trim(path.Clean("/"+root), "/"),
+		opt:      *opt,
+		base:     base,
+		archives: libcache.New(),
+	}
+	f.archives.SetFinalizer(func(value interface{}) {
+		value.(*archive).close()
+	})
+	cache.PinUntilFinalized(f.base, f)
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f).Mask(ctx, base).WrapsFs(f, base)
+
+	// Check to see if the root is a file
+	if f.root != "" {
+		isFile, err := f.isFile(ctx, f.root)
+		if err != nil {
+			return nil, err
+		}
+		if isFile {
+			f.root = path.Dir(f.root)
+			if f.root == "." {
+				f.root = ""
+			}
+			return f, fs.ErrorIsFile
+		}
+	}
+	return f, nil
+}
+
+// isFile returns true if absPath is a file either on the wrapped
+// remote or in an archive
+func (f *Fs) isFile(ctx context.Context, absPath string) (bool, error) {
+	a, inner, err := f.findArchive(ctx, absPath)
+	if err != nil {
+		return false, err
+	}
+	if a != nil {
+		m := a.members[inner]
+		return m != nil && !m.dir, nil
+	}
+	_, err = f.base.NewObject(ctx, absPath)
+	return err == nil, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("Archive '%s:%s'", f.name, f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision of the ModTimes in this Fs
+//
+// Zip files only store modification times to 2 seconds.
+func (f *Fs) Precision() time.Duration {
+	return max(f.base.Precision(), 2*time.Second)
+}
+
+// Hashes returns the supported hash types of the filesystem
+//
+// Files in zip and 7z archives have CRC-32 checksums.
+func (f *Fs) Hashes() hash.Set {
+	hashes := f.base.Hashes()
+	hashes.Add(hash.CRC32)
+	return hashes
+}
+
+// unsupportedHash returns the result for a hash an object doesn't have
+//
+// This is an empty hash if the Fs supports it so it isn't checked.
+func (f *Fs) unsupportedHash(ht hash.Type) (string, error) {
+	if f.Hashes().Contains(ht) {
+		return "", nil
+	}
+	return "", hash.ErrUnsupported
+}
+
+// absPath returns the path of remote on the wrapped remote
+func (f *Fs) absPath(remote string) string {
+	return path.Join(f.root, remote)
+}
+
+// relPath returns the path of absPath relative to the root
+func (f *Fs) relPath(absPath string) string {
+	if f.root == "" {
+		return absPath
+	}
+	return strings.TrimPrefix(absPath[len(f.root):], "/")
+}
+
+// List the objects and directories in dir into entries. The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	absDir := f.absPath(dir)
+	a, inner, err := f.findArchive(ctx, absDir)
+	if err != nil {
+		return nil, err
+	}
+	if a != nil {
+		return f.listArchive(ctx, a, absDir, inner)
+	}
+	baseEntries, err := f.base.List(ctx, absDir)
+	if err != nil {
+		return nil, err
+	}
+	entries = make(fs.DirEntries, 0, len(baseEntries))
+	for _, entry := range baseEntries {
+		remote := f.relPath(entry.Remote())
+		switch x := entry.(type) {
+		case fs.Object:
+			if x.Size() >= 0 && formatOf(remote) != nil {
+				entries = append(entries, fs.NewDir(remote, x.ModTime(ctx)))
+			} else {
+				entries = append(entries, f.newObject(x, remote))
+			}
+		case fs.Directory:
+			entries = append(entries, fs.NewDirWrapper(remote, x))
+		default:
+			return nil, fmt.Errorf("unknown object type %T", entry)
+		}
+	}
+	return entries, nil
+}
+
+// listArchive lists the directory inner in the archive a, which is
+// at absDir on the wrapped remote
+func (f *Fs) listArchive(ctx context.Context, a *archive, absDir, inner string) (entries fs.DirEntries, err error) {
+	if m := a.members[inner]; inner != "" && (m == nil || !m.dir) {
+		return nil, fs.ErrorDirNotFound
+	}
+	dir := f.relPath(absDir)
+	for _, m := range a.dirs[inner] {
+		remote := path.Join(dir, path.Base(m.name))
+		if m.dir {
+			entries = append(entries, fs.NewDir(remote, m.modTime))
+		} else {
+			entries = append(entries, f.newMember(a, m, remote))
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	absPath := f.absPath(remote)
+	a, inner, err := f.findArchive(ctx, absPath)
+	if err != nil {
+		return nil, err
+	}
+	if a != nil {
+		m := a.members[inner]
+		if inner == "" || (m != nil && m.dir) {
+			return nil, fs.ErrorIsDir
+		}
+		if m == nil {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return f.newMember(a, m, remote), nil
+	}
+	o, err := f.base.NewObject(ctx, absPath)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o, remote), nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// This always fails as archive remotes are read only.
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, errorReadOnly
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// This always fails as archive remotes are read only.
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return errorReadOnly
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// This always fails as archive remotes are read only.
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return errorReadOnly
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.base
+}
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs {
+	return f.wrapper
+}
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) {
+	f.wrapper = wrapper
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	f.archives.Clear()
+	return nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs         = (*Fs)(nil)
+	_ fs.UnWrapper  = (*Fs)(nil)
+	_ fs.Wrapper    = (*Fs)(nil)
+	_ fs.Shutdowner = (*Fs)(nil)
+)
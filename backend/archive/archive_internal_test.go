@@ -0,0 +1,328 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testTime = time.Date(2024, 5, 6, 7, 8, 10, 0, time.UTC)
+	// large enough to need several reads to decompress
+	testLarge = strings.Repeat("The quick brown fox jumps over the lazy dog. ", 10000)
+)
+
+// test7z is a 7z archive containing "bar" and "foo" from the test data
+// of github.com/bodgit/sevenzip
+const test7z = "377abcaf271c0004a047a58808000000000000006600000000000000dd91" +
+	"f3f16261720a666f6f0a010406000209040400070b02000101000101000c" +
+	"040400080a01e9b3a204a865327e00000502190500000000001111006200" +
+	"61007200000066006f006f000000190200001412010000853373f263d601" +
+	"00580272f263d601150a01002080a4812080a4810000"
+
+// makeZip makes a zip archive with a stored and a compressed file
+func makeZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, err := zw.CreateHeader(&zip.FileHeader{Name: "empty/", Modified: testTime})
+	require.NoError(t, err)
+	for _, file := range []struct {
+		name    string
+		method  uint16
+		content string
+	}{
+		{"stored.txt", zip.Store, "stored contents"},
+		{"dir/sub/large.txt", zip.Deflate, testLarge},
+		{"../escape.txt", zip.Deflate, "escaped"},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: file.method, Modified: testTime})
+		require.NoError(t, err)
+		_, err = io.WriteString(w, file.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// makeTar makes a tar archive
+func makeTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755, ModTime: testTime}))
+	for _, file := range []struct {
+		name    string
+		content string
+	}{
+		{"dir/large.txt", testLarge},
+		{"small.txt", "small contents"},
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     file.name,
+			Mode:     0644,
+			Size:     int64(len(file.content)),
+			ModTime:  testTime,
+		}))
+		_, err := io.WriteString(tw, file.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "small.txt"}))
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// prepare makes a directory with archives in and returns an archive
+// Fs for it at root
+func prepare(t *testing.T, root string) (fs.Fs, error) {
+	dir := t.TempDir()
+	sevenZip, err := hex.DecodeString(test7z)
+	require.NoError(t, err)
+	for name, data := range map[string][]byte{
+		"test.zip":      makeZip(t),
+		"sub/test.tar":  makeTar(t),
+		"test.7z":       sevenZip,
+		"plain.txt":     []byte("plain"),
+		"broken.zip":    []byte("not a zip file"),
+		"dir.zip/x.txt": []byte("in a directory"),
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+		require.NoError(t, os.WriteFile(path, data, 0666))
+	}
+	return NewFs(context.Background(), "TestArchive", root, configmap.Simple{
+		"type":   "archive",
+		"remote": dir,
+	})
+}
+
+// listing returns the sorted entries of dir as "name/" for directories
+// and "name size" for files
+func listing(t *testing.T, f fs.Fs, dir string) []string {
+	entries, err := f.List(context.Background(), dir)
+	require.NoError(t, err)
+	var out []string
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Directory:
+			out = append(out, x.Remote()+"/")
+		case fs.Object:
+			out = append(out, fmt.Sprintf("%s %d", x.Remote(), x.Size()))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// read reads the object at remote with options
+func read(t *testing.T, f fs.Fs, remote string, options ...fs.OpenOption) string {
+	ctx := context.Background()
+	o, err := f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx, options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestList(t *testing.T) {
+	f, err := prepare(t, "")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	assert.Equal(t, []string{"broken.zip/", "dir.zip/", "plain.txt 5", "sub/", "test.7z/", "test.zip/"}, listing(t, f, ""))
+	assert.Equal(t, []string{"sub/test.tar/"}, listing(t, f, "sub"))
+	assert.Equal(t, []string{"dir.zip/x.txt 14"}, listing(t, f, "dir.zip"))
+
+	assert.Equal(t, []string{
+		"test.zip/dir/",
+		"test.zip/empty/",
+		"test.zip/escape.txt 7",
+		"test.zip/stored.txt 15",
+	}, listing(t, f, "test.zip"))
+	assert.Equal(t, []string{fmt.Sprintf("test.zip/dir/sub/large.txt %d", len(testLarge))}, listing(t, f, "test.zip/dir/sub"))
+	assert.Equal(t, []string(nil), listing(t, f, "test.zip/empty"))
+	assert.Equal(t, []string{
+		"sub/test.tar/dir/",
+		"sub/test.tar/small.txt 14",
+	}, listing(t, f, "sub/test.tar"))
+	assert.Equal(t, []string{"test.7z/bar 4", "test.7z/foo 4"}, listing(t, f, "test.7z"))
+
+	_, err = f.List(ctx, "test.zip/missing")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.List(ctx, "test.zip/stored.txt")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.List(ctx, "broken.zip")
+	assert.Error(t, err)
+
+	entries, err := f.List(ctx, "test.zip")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.Remote() == "test.zip/stored.txt" {
+			assert.True(t, testTime.Equal(entry.ModTime(ctx)), entry.ModTime(ctx))
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	f, err := prepare(t, "")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	assert.Equal(t, "plain", read(t, f, "plain.txt"))
+	assert.Equal(t, "stored contents", read(t, f, "test.zip/stored.txt"))
+	assert.Equal(t, "contents", read(t, f, "test.zip/stored.txt", &fs.SeekOption{Offset: 7}))
+	assert.Equal(t, "red c", read(t, f, "test.zip/stored.txt", &fs.RangeOption{Start: 3, End: 7}))
+	assert.Equal(t, "escaped", read(t, f, "test.zip/escape.txt"))
+	assert.Equal(t, testLarge, read(t, f, "test.zip/dir/sub/large.txt"))
+	assert.Equal(t, testLarge[100000:100010], read(t, f, "test.zip/dir/sub/large.txt", &fs.RangeOption{Start: 100000, End: 100009}))
+	assert.Equal(t, testLarge[len(testLarge)-5:], read(t, f, "test.zip/dir/sub/large.txt", &fs.RangeOption{Start: -1, End: 5}))
+	assert.Equal(t, "", read(t, f, "test.zip/stored.txt", &fs.SeekOption{Offset: 100}))
+
+	assert.Equal(t, testLarge, read(t, f, "sub/test.tar/dir/large.txt"))
+	assert.Equal(t, "small contents", read(t, f, "sub/test.tar/small.txt"))
+	assert.Equal(t, "contents", read(t, f, "sub/test.tar/small.txt", &fs.SeekOption{Offset: 6}))
+	assert.Equal(t, testLarge[1000:2000], read(t, f, "sub/test.tar/dir/large.txt", &fs.RangeOption{Start: 1000, End: 1999}))
+
+	assert.Equal(t, "foo\n", read(t, f, "test.7z/foo"))
+	assert.Equal(t, "ar\n", read(t, f, "test.7z/bar", &fs.SeekOption{Offset: 1}))
+
+	_, err = f.NewObject(ctx, "test.zip/missing.txt")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+	_, err = f.NewObject(ctx, "test.zip/dir")
+	assert.ErrorIs(t, err, fs.ErrorIsDir)
+	_, err = f.NewObject(ctx, "test.zip")
+	assert.ErrorIs(t, err, fs.ErrorIsDir)
+	_, err = f.NewObject(ctx, "sub/test.tar/link")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+}
+
+func TestHash(t *testing.T) {
+	f, err := prepare(t, "")
+	require.NoError(t, err)
+	ctx := context.Background()
+	assert.True(t, f.Hashes().Contains(hash.CRC32))
+
+	for remote, want := range map[string]string{
+		"test.zip/stored.txt":        fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("stored contents"))),
+		"test.zip/dir/sub/large.txt": fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(testLarge))),
+		"test.7z/foo":                fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("foo\n"))),
+		"sub/test.tar/small.txt":     "",
+	} {
+		o, err := f.NewObject(ctx, remote)
+		require.NoError(t, err)
+		got, err := o.Hash(ctx, hash.CRC32)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, remote)
+		got, err = o.Hash(ctx, hash.MD5)
+		require.NoError(t, err)
+		assert.Equal(t, "", got, remote)
+		_, err = o.Hash(ctx, hash.None)
+		assert.ErrorIs(t, err, hash.ErrUnsupported)
+	}
+}
+
+func TestRoot(t *testing.T) {
+	f, err := prepare(t, "test.zip/dir")
+	require.NoError(t, err)
+	assert.Equal(t, []string{fmt.Sprintf("sub/large.txt %d", len(testLarge))}, listing(t, f, "sub"))
+
+	f, err = prepare(t, "test.zip/stored.txt")
+	assert.Equal(t, fs.ErrorIsFile, err)
+	assert.Equal(t, "test.zip", f.Root())
+	assert.Equal(t, "stored contents", read(t, f, "stored.txt"))
+
+	f, err = prepare(t, "sub/test.tar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir/", "small.txt 14"}, listing(t, f, ""))
+
+	f, err = prepare(t, "plain.txt")
+	assert.Equal(t, fs.ErrorIsFile, err)
+	assert.Equal(t, "", f.Root())
+}
+
+func TestReadOnly(t *testing.T) {
+	f, err := prepare(t, "")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	src := object.NewStaticObjectInfo("new.txt", testTime, 3, true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewBufferString("new"), src)
+	assert.Equal(t, errorReadOnly, err)
+	assert.Equal(t, errorReadOnly, f.Mkdir(ctx, "test.zip/new"))
+	assert.Equal(t, errorReadOnly, f.Rmdir(ctx, "test.zip/empty"))
+
+	for _, remote := range []string{"plain.txt", "test.zip/stored.txt"} {
+		o, err := f.NewObject(ctx, remote)
+		require.NoError(t, err)
+		assert.Equal(t, errorReadOnly, o.Remove(ctx))
+		assert.Equal(t, errorReadOnly, o.SetModTime(ctx, testTime))
+		assert.Equal(t, errorReadOnly, o.Update(ctx, bytes.NewBufferString("new"), src))
+	}
+}
+
+// countingObject counts the calls to Open
+type countingObject struct {
+	fs.Object
+	opens int
+}
+
+func (o *countingObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	o.opens++
+	return o.Object.Open(ctx, options...)
+}
+
+func TestReaderAt(t *testing.T) {
+	f, err := prepare(t, "")
+	require.NoError(t, err)
+	ctx := context.Background()
+	o, err := f.(*Fs).base.NewObject(ctx, "test.zip")
+	require.NoError(t, err)
+	co := &countingObject{Object: o}
+	data := makeZip(t)
+	require.Equal(t, int64(len(data)), o.Size())
+
+	ra := newReaderAt(ctx, co)
+	defer ra.close()
+	buf := make([]byte, 100)
+
+	// sequential reads and short skips use the same stream
+	for _, off := range []int64{0, 100, 200, 1000} {
+		n, err := ra.ReadAt(buf, off)
+		require.NoError(t, err)
+		assert.Equal(t, data[off:off+int64(n)], buf[:n])
+	}
+	assert.Equal(t, 1, co.opens)
+
+	// going backwards needs a new stream
+	_, err = ra.ReadAt(buf, 50)
+	require.NoError(t, err)
+	assert.Equal(t, 2, co.opens)
+
+	// reading past the end returns io.EOF
+	n, err := ra.ReadAt(buf, int64(len(data)-10))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, data[len(data)-10:], buf[:n])
+	_, err = ra.ReadAt(buf, int64(len(data)))
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,174 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// format describes a supported archive format
+type format struct {
+	ext   string                                      // file extension, lower case
+	index func(ctx context.Context, a *archive) error // read the members of the archive
+}
+
+// formats are the supported archive formats
+var formats = []format{
+	{ext: ".zip", index: indexZip},
+	{ext: ".tar", index: indexTar},
+	{ext: ".7z", index: index7z},
+}
+
+// formatOf returns the format of the archive called name or nil if
+// it isn't an archive
+func formatOf(name string) *format {
+	lower := strings.ToLower(name)
+	for i := range formats {
+		if strings.HasSuffix(lower, formats[i].ext) && len(lower) > len(formats[i].ext) {
+			return &formats[i]
+		}
+	}
+	return nil
+}
+
+// member is a file or directory in an archive
+type member struct {
+	name    string    // cleaned path in the archive
+	dir     bool      // set if this is a directory
+	size    int64     // uncompressed size
+	modTime time.Time // modification time
+	crc     string    // CRC-32 in hex or "" if unknown
+
+	// dataOffset returns the offset of the data in the archive if it
+	// is stored uncompressed, otherwise it is nil
+	dataOffset func() (int64, error)
+
+	// open returns a reader for the data from the start
+	open func() (io.ReadCloser, error)
+}
+
+// archive is the index of an archive file
+type archive struct {
+	o       fs.Object            // the archive file
+	modTime time.Time            // modification time of the archive file
+	ra      *readerAt            // reads from the archive file
+	members map[string]*member   // files and directories by path
+	dirs    map[string][]*member // the contents of each directory
+}
+
+// newArchive reads the index of the archive o
+func newArchive(ctx context.Context, o fs.Object, ft *format) (*archive, error) {
+	a := &archive{
+		o:       o,
+		modTime: o.ModTime(ctx),
+		ra:      newReaderAt(ctx, o),
+		members: map[string]*member{},
+		dirs:    map[string][]*member{},
+	}
+	if err := ft.index(ctx, a); err != nil {
+		a.close()
+		return nil, fmt.Errorf("failed to read archive %q: %w", o.Remote(), err)
+	}
+	fs.Debugf(o, "Read archive with %d members", len(a.members))
+	return a, nil
+}
+
+// cleanName makes name into a relative path which can't escape the
+// archive. It returns "" if there is nothing left.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// add m to the archive creating any missing parent directories
+func (a *archive) add(m *member) {
+	m.name = cleanName(m.name)
+	if m.name == "" {
+		return
+	}
+	if m.modTime.IsZero() {
+		m.modTime = a.modTime
+	}
+	if existing := a.members[m.name]; existing != nil {
+		switch {
+		case existing.dir && m.dir:
+			// an explicit entry for a directory which was implied
+			existing.modTime = m.modTime
+		case !existing.dir && !m.dir:
+			// a later copy of a file replaces the earlier one
+			*existing = *m
+		default:
+			fs.Logf(a.o, "Ignoring %q in archive: a file and a directory have the same name", m.name)
+		}
+		return
+	}
+	parent := path.Dir(m.name)
+	if parent == "." {
+		parent = ""
+	} else if p := a.members[parent]; p == nil {
+		a.add(&member{name: parent, dir: true})
+	} else if !p.dir {
+		fs.Logf(a.o, "Ignoring %q in archive: its parent is a file", m.name)
+		return
+	}
+	a.members[m.name] = m
+	a.dirs[parent] = append(a.dirs[parent], m)
+}
+
+// close releases the resources held by the archive
+func (a *archive) close() {
+	a.ra.close()
+}
+
+// findArchive finds the archive absPath is in
+//
+// It returns the archive and the path within it or a nil archive if
+// absPath isn't in an archive.
+func (f *Fs) findArchive(ctx context.Context, absPath string) (a *archive, inner string, err error) {
+	if absPath == "" {
+		return nil, "", nil
+	}
+	parts := strings.Split(absPath, "/")
+	for i, part := range parts {
+		ft := formatOf(part)
+		if ft == nil {
+			continue
+		}
+		arcPath := strings.Join(parts[:i+1], "/")
+		o, err := f.base.NewObject(ctx, arcPath)
+		if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) || errors.Is(err, fs.ErrorNotAFile) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if o.Size() < 0 {
+			continue
+		}
+		a, err = f.getArchive(ctx, o, ft)
+		if err != nil {
+			return nil, "", err
+		}
+		return a, strings.Join(parts[i+1:], "/"), nil
+	}
+	return nil, "", nil
+}
+
+// getArchive returns the index of the archive o, reading it unless it
+// was read recently and hasn't changed since
+func (f *Fs) getArchive(ctx context.Context, o fs.Object, ft *format) (*archive, error) {
+	key := fmt.Sprintf("%s\x00%d\x00%d", o.Remote(), o.Size(), o.ModTime(ctx).UnixNano())
+	value, err := f.archives.Get(key, func(string) (interface{}, bool, error) {
+		a, err := newArchive(ctx, o, ft)
+		return a, false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*archive), nil
+}
@@ -0,0 +1,213 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+// Object is a file on the wrapped remote which isn't an archive
+type Object struct {
+	fs.Object
+	f      *Fs
+	remote string
+}
+
+// newObject wraps o which is at remote
+func (f *Fs) newObject(o fs.Object, remote string) *Object {
+	return &Object{
+		Object: o,
+		f:      f,
+		remote: remote,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// String returns a description of the Object
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// SetModTime sets the modification time of the file
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return errorReadOnly
+}
+
+// Update the object with the contents of the io.Reader
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return errorReadOnly
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	return errorReadOnly
+}
+
+// Hash returns the selected checksum of the file
+//
+// The wrapped remote may not support all the hashes of this remote.
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if !o.f.base.Hashes().Contains(ht) {
+		return o.f.unsupportedHash(ht)
+	}
+	return o.Object.Hash(ctx, ht)
+}
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object {
+	return o.Object
+}
+
+// Member is a file inside an archive
+type Member struct {
+	f      *Fs
+	remote string
+	a      *archive
+	m      *member
+}
+
+// newMember returns the Member for m in a which is at remote
+func (f *Fs) newMember(a *archive, m *member, remote string) *Member {
+	return &Member{
+		f:      f,
+		remote: remote,
+		a:      a,
+		m:      m,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Member) Fs() fs.Info {
+	return o.f
+}
+
+// String returns a description of the Object
+func (o *Member) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Member) Remote() string {
+	return o.remote
+}
+
+// Hash returns the selected checksum of the file
+//
+// Only CRC-32 is known and only for zip and 7z archives.
+func (o *Member) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht != hash.CRC32 {
+		return o.f.unsupportedHash(ht)
+	}
+	return o.m.crc, nil
+}
+
+// Size returns the size of the file
+func (o *Member) Size() int64 {
+	return o.m.size
+}
+
+// ModTime returns the modification time of the file
+func (o *Member) ModTime(ctx context.Context) time.Time {
+	return o.m.modTime
+}
+
+// SetModTime sets the modification time of the file
+func (o *Member) SetModTime(ctx context.Context, modTime time.Time) error {
+	return errorReadOnly
+}
+
+// Storable returns whether this object is storable
+func (o *Member) Storable() bool {
+	return true
+}
+
+// Open an object for read
+//
+// Files stored without compression are read with a ranged read of the
+// archive. Compressed files are decompressed from the start and the
+// data before the offset is discarded.
+func (o *Member) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.m.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	size := o.m.size
+	if limit < 0 || offset+limit > size {
+		limit = size - offset
+	}
+	if offset >= size || limit <= 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	if o.m.dataOffset != nil {
+		start, err := o.m.dataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find data of %q in archive: %w", o.m.name, err)
+		}
+		start += offset
+		return o.a.o.Open(ctx, &fs.RangeOption{Start: start, End: start + limit - 1})
+	}
+
+	in, err = o.m.open()
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err = io.CopyN(io.Discard, in, offset); err != nil {
+			_ = in.Close()
+			return nil, fmt.Errorf("failed to seek to %d: %w", offset, err)
+		}
+	}
+	if offset+limit == size {
+		// read to the end so the archive reader can check the CRC
+		return in, nil
+	}
+	return readers.NewLimitedReadCloser(in, limit), nil
+}
+
+// Update the object with the contents of the io.Reader
+func (o *Member) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return errorReadOnly
+}
+
+// Remove an object
+func (o *Member) Remove(ctx context.Context) error {
+	return errorReadOnly
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object          = (*Object)(nil)
+	_ fs.ObjectUnWrapper = (*Object)(nil)
+	_ fs.Object          = (*Member)(nil)
+)
@@ -0,0 +1,147 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Tuning constants for readerAt
+const (
+	maxSkip           = 256 * 1024       // skip forward this far in an open stream rather than opening a new one
+	maxIdleStreams    = 4                // max number of idle streams to keep open
+	streamIdleTimeout = 10 * time.Second // close streams idle for longer than this
+)
+
+// readerAt reads an object at any offset using ranged Open calls
+//
+// The archive readers make lots of small reads, mostly following on
+// from the previous one, so streams are kept open after a read and
+// reused by the next read starting at or just after where they
+// finished.
+type readerAt struct {
+	ctx    context.Context
+	o      fs.Object
+	size   int64
+	mu     sync.Mutex
+	closed bool
+	idle   []*stream // streams not in use
+}
+
+// stream is an open ranged read of the object
+type stream struct {
+	in   io.ReadCloser
+	pos  int64     // offset of the next byte read from in
+	used time.Time // when the stream was last used
+}
+
+// newReaderAt makes a readerAt for o
+//
+// The readerAt outlives the call which made it so it isn't cancelled
+// with ctx.
+func newReaderAt(ctx context.Context, o fs.Object) *readerAt {
+	return &readerAt{
+		ctx:  context.WithoutCancel(ctx),
+		o:    o,
+		size: o.Size(),
+	}
+}
+
+// get returns a stream positioned at off and whether it was reused
+func (r *readerAt) get(off int64) (s *stream, reused bool, err error) {
+	now := time.Now()
+	r.mu.Lock()
+	idle := r.idle[:0]
+	for _, x := range r.idle {
+		switch {
+		case now.Sub(x.used) > streamIdleTimeout:
+			_ = x.in.Close()
+		case s == nil && x.pos <= off && off-x.pos <= maxSkip:
+			s = x
+		default:
+			idle = append(idle, x)
+		}
+	}
+	r.idle = idle
+	r.mu.Unlock()
+	if s != nil {
+		_, err = io.CopyN(io.Discard, s.in, off-s.pos)
+		if err == nil {
+			s.pos = off
+			return s, true, nil
+		}
+		_ = s.in.Close()
+	}
+	in, err := r.o.Open(r.ctx, &fs.RangeOption{Start: off, End: -1})
+	if err != nil {
+		return nil, false, err
+	}
+	return &stream{in: in, pos: off}, false, nil
+}
+
+// put returns s to the idle streams
+func (r *readerAt) put(s *stream) {
+	s.used = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		_ = s.in.Close()
+		return
+	}
+	r.idle = append(r.idle, s)
+	if len(r.idle) > maxIdleStreams {
+		_ = r.idle[0].in.Close()
+		r.idle = r.idle[1:]
+	}
+}
+
+// ReadAt reads len(p) bytes from the object at offset off
+func (r *readerAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := p
+	if remaining := r.size - off; int64(len(want)) > remaining {
+		want = want[:remaining]
+	}
+	for try := 0; ; try++ {
+		s, reused, err := r.get(off)
+		if err != nil {
+			return 0, err
+		}
+		n, err = io.ReadFull(s.in, want)
+		s.pos += int64(n)
+		if err != nil {
+			_ = s.in.Close()
+			if reused && n == 0 && try == 0 {
+				// the idle stream may have been closed by the server
+				continue
+			}
+			return n, err
+		}
+		r.put(s)
+		break
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// close closes the idle streams and any returned later
+func (r *readerAt) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, s := range r.idle {
+		_ = s.in.Close()
+	}
+	r.idle = nil
+}
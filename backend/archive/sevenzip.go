@@ -0,0 +1,43 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/rclone/rclone/fs"
+)
+
+// index7z reads the header of a 7z archive
+//
+// Files in 7z archives are usually compressed together so reading a
+// file may need the files before it in the archive to be decompressed.
+func index7z(ctx context.Context, a *archive) error {
+	zr, err := sevenzip.NewReader(a.ra, a.o.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		info := zf.FileInfo()
+		if info.IsDir() {
+			a.add(&member{name: zf.Name, dir: true, modTime: zf.Modified})
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			fs.Debugf(a.o, "Ignoring %q in archive: not a regular file", zf.Name)
+			continue
+		}
+		m := &member{
+			name:    zf.Name,
+			size:    info.Size(),
+			modTime: zf.Modified,
+			open:    zf.Open,
+		}
+		// a zero CRC means none was stored
+		if zf.CRC32 != 0 {
+			m.crc = fmt.Sprintf("%08x", zf.CRC32)
+		}
+		a.add(m)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// indexTar reads the headers of a tar archive
+//
+// Tar archives don't have an index so this reads every header, but
+// the file data in between is skipped.
+func indexTar(ctx context.Context, a *archive) error {
+	sr := io.NewSectionReader(a.ra, 0, a.o.Size())
+	tr := tar.NewReader(sr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			a.add(&member{name: hdr.Name, dir: true, modTime: hdr.ModTime})
+		case tar.TypeReg:
+			if isSparse(hdr) {
+				fs.Debugf(a.o, "Ignoring %q in archive: sparse files are not supported", hdr.Name)
+				continue
+			}
+			// the data starts after the header just read
+			offset, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			a.add(&member{
+				name:    hdr.Name,
+				size:    hdr.Size,
+				modTime: hdr.ModTime,
+				dataOffset: func() (int64, error) {
+					return offset, nil
+				},
+			})
+		default:
+			fs.Debugf(a.o, "Ignoring %q in archive: not a regular file", hdr.Name)
+		}
+	}
+}
+
+// isSparse returns true if hdr is for a GNU sparse file
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package archive
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// errEncrypted is returned when opening encrypted files
+var errEncrypted = errors.New("encrypted files in archives are not supported")
+
+// indexZip reads the central directory of a zip archive
+func indexZip(ctx context.Context, a *archive) error {
+	zr, err := zip.NewReader(a.ra, a.o.Size())
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return err
+	}
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		if mode.IsDir() {
+			a.add(&member{name: zf.Name, dir: true, modTime: zf.Modified})
+			continue
+		}
+		if !mode.IsRegular() {
+			fs.Debugf(a.o, "Ignoring %q in archive: not a regular file", zf.Name)
+			continue
+		}
+		m := &member{
+			name:    zf.Name,
+			size:    int64(zf.UncompressedSize64),
+			modTime: zf.Modified,
+			crc:     fmt.Sprintf("%08x", zf.CRC32),
+			open:    zf.Open,
+		}
+		switch {
+		case zf.Flags&0x1 != 0:
+			m.open = func() (io.ReadCloser, error) {
+				return nil, errEncrypted
+			}
+		case zf.Method == zip.Store:
+			m.dataOffset = zf.DataOffset
+		}
+		a.add(m)
+	}
+	return nil
+}
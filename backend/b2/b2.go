@@ -300,13 +300,14 @@ type Fs struct {
 
 // Object describes a b2 object
 type Object struct {
-	fs       *Fs       // what this object is part of
-	remote   string    // The remote path
-	id       string    // b2 id of the file
-	modTime  time.Time // The modified time of the object if known
-	sha1     string    // SHA-1 hash if known
-	size     int64     // Size of the object
-	mimeType string    // Content-Type of the object
+	fs         *Fs       // what this object is part of
+	remote     string    // The remote path
+	id         string    // b2 id of the file
+	modTime    time.Time // The modified time of the object if known
+	sha1       string    // SHA-1 hash if known
+	size       int64     // Size of the object
+	mimeType   string    // Content-Type of the object
+	oldVersion bool      // set if this was listed as an old version of the file
 }
 
 // ------------------------------------------------------------
@@ -723,8 +724,11 @@ var errEndList = errors.New("end list")
 //
 // If hidden is set then it will list the hidden (deleted) files too.
 //
+// If versionAt is set then it will list the files as they were at
+// that time.
+//
 // if findFile is set it will look for files called (bucket, directory)
-func (f *Fs) list(ctx context.Context, bucket, directory, prefix string, addBucket bool, recurse bool, limit int, hidden bool, findFile bool, fn listFn) error {
+func (f *Fs) list(ctx context.Context, bucket, directory, prefix string, addBucket bool, recurse bool, limit int, hidden bool, versionAt fs.Time, findFile bool, fn listFn) error {
 	if !findFile {
 		if prefix != "" {
 			prefix += "/"
@@ -758,7 +762,7 @@ func (f *Fs) list(ctx context.Context, bucket, directory, prefix string, addBuck
 		Method: "POST",
 		Path:   "/b2_list_file_names",
 	}
-	if hidden || f.opt.VersionAt.IsSet() {
+	if hidden || versionAt.IsSet() {
 		opts.Path = "/b2_list_file_versions"
 	}
 
@@ -794,8 +798,8 @@ func (f *Fs) list(ctx context.Context, bucket, directory, prefix string, addBuck
 				remote = path.Join(bucket, remote)
 			}
 
-			if f.opt.VersionAt.IsSet() {
-				if time.Time(file.UploadTimestamp).After(time.Time(f.opt.VersionAt)) {
+			if versionAt.IsSet() {
+				if time.Time(file.UploadTimestamp).After(time.Time(versionAt)) {
 					// Ignore versions that were created after the specified time
 					continue
 				}
@@ -851,13 +855,18 @@ func (f *Fs) itemToDirEntry(ctx context.Context, remote string, object *api.File
 }
 
 // listDir lists a single directory
-func (f *Fs) listDir(ctx context.Context, bucket, directory, prefix string, addBucket bool) (entries fs.DirEntries, err error) {
+//
+// If versionAt is set the directory is listed as it was at that time.
+func (f *Fs) listDir(ctx context.Context, bucket, directory, prefix string, addBucket bool, versionAt fs.Time) (entries fs.DirEntries, err error) {
 	last := ""
-	err = f.list(ctx, bucket, directory, prefix, f.rootBucket == "", false, 0, f.opt.Versions, false, func(remote string, object *api.File, isDirectory bool) error {
+	err = f.list(ctx, bucket, directory, prefix, f.rootBucket == "", false, 0, f.opt.Versions && !versionAt.IsSet(), versionAt, false, func(remote string, object *api.File, isDirectory bool) error {
 		entry, err := f.itemToDirEntry(ctx, remote, object, isDirectory, &last)
 		if err != nil {
 			return err
 		}
+		if o, ok := entry.(*Object); ok && versionAt.IsSet() {
+			o.oldVersion = true
+		}
 		if entry != nil {
 			entries = append(entries, entry)
 		}
@@ -901,7 +910,20 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 		}
 		return f.listBuckets(ctx)
 	}
-	return f.listDir(ctx, bucket, directory, f.rootDirectory, f.rootBucket == "")
+	return f.listDir(ctx, bucket, directory, f.rootDirectory, f.rootBucket == "", f.opt.VersionAt)
+}
+
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using old versions of the objects.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	bucket, directory := f.split(dir)
+	if bucket == "" {
+		if directory != "" {
+			return nil, fs.ErrorListBucketRequired
+		}
+		return f.listBuckets(ctx)
+	}
+	return f.listDir(ctx, bucket, directory, f.rootDirectory, f.rootBucket == "", fs.Time(at))
 }
 
 // ListR lists the objects and directories of the Fs starting
@@ -925,7 +947,7 @@ func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (
 	list := walk.NewListRHelper(callback)
 	listR := func(bucket, directory, prefix string, addBucket bool) error {
 		last := ""
-		return f.list(ctx, bucket, directory, prefix, addBucket, true, 0, f.opt.Versions, false, func(remote string, object *api.File, isDirectory bool) error {
+		return f.list(ctx, bucket, directory, prefix, addBucket, true, 0, f.opt.Versions, f.opt.VersionAt, false, func(remote string, object *api.File, isDirectory bool) error {
 			entry, err := f.itemToDirEntry(ctx, remote, object, isDirectory, &last)
 			if err != nil {
 				return err
@@ -1307,7 +1329,7 @@ func (f *Fs) purge(ctx context.Context, dir string, oldOnly bool, deleteHidden b
 	}
 
 	last := ""
-	checkErr(f.list(ctx, bucket, directory, f.rootDirectory, f.rootBucket == "", true, 0, true, false, func(remote string, object *api.File, isDirectory bool) error {
+	checkErr(f.list(ctx, bucket, directory, f.rootDirectory, f.rootBucket == "", true, 0, true, f.opt.VersionAt, false, func(remote string, object *api.File, isDirectory bool) error {
 		if !isDirectory {
 			oi, err := f.newObjectWithInfo(ctx, object.Name, object)
 			if err != nil {
@@ -1502,7 +1524,7 @@ func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration,
 	}
 	_, err = f.NewObject(ctx, remote)
 	if err == fs.ErrorObjectNotFound || err == fs.ErrorNotAFile {
-		err2 := f.list(ctx, bucket, bucketPath, f.rootDirectory, f.rootBucket == "", false, 1, f.opt.Versions, false, func(remote string, object *api.File, isDirectory bool) error {
+		err2 := f.list(ctx, bucket, bucketPath, f.rootDirectory, f.rootBucket == "", false, 1, f.opt.Versions, f.opt.VersionAt, false, func(remote string, object *api.File, isDirectory bool) error {
 			err = nil
 			return nil
 		})
@@ -1644,7 +1666,7 @@ func (o *Object) getMetaDataListing(ctx context.Context) (info *api.File, err er
 		maxSearched = maxVersions
 	}
 
-	err = o.fs.list(ctx, bucket, bucketPath, "", false, true, maxSearched, o.fs.opt.Versions, true, func(remote string, object *api.File, isDirectory bool) error {
+	err = o.fs.list(ctx, bucket, bucketPath, "", false, true, maxSearched, o.fs.opt.Versions, o.fs.opt.VersionAt, true, func(remote string, object *api.File, isDirectory bool) error {
 		if isDirectory {
 			return nil
 		}
@@ -1828,16 +1850,20 @@ func (o *Object) getOrHead(ctx context.Context, method string, options []fs.Open
 		NoResponse: method == "HEAD",
 	}
 
-	// Use downloadUrl from backblaze if downloadUrl is not set
-	// otherwise use the custom downloadUrl
-	if o.fs.opt.DownloadURL == "" {
+	// Download by id if set and not using DownloadURL otherwise by
+	// name. Old versions must always be downloaded by id as the name
+	// gives the current version.
+	byID := o.id != "" && (o.fs.opt.DownloadURL == "" || o.oldVersion || o.fs.opt.VersionAt.IsSet())
+
+	// Use downloadUrl from backblaze if downloadUrl is not set or
+	// downloading by id otherwise use the custom downloadUrl
+	if o.fs.opt.DownloadURL == "" || byID {
 		opts.RootURL = o.fs.info.DownloadURL
 	} else {
 		opts.RootURL = o.fs.opt.DownloadURL
 	}
 
-	// Download by id if set and not using DownloadURL otherwise by name
-	if o.id != "" && o.fs.opt.DownloadURL == "" {
+	if byID {
 		opts.Path += "/b2api/v1/b2_download_file_by_id?fileId=" + urlEncode(o.id)
 	} else {
 		bucket, bucketPath := o.split()
@@ -2412,6 +2438,7 @@ var (
 	_ fs.PutStreamer     = &Fs{}
 	_ fs.CleanUpper      = &Fs{}
 	_ fs.ListRer         = &Fs{}
+	_ fs.ListAter        = &Fs{}
 	_ fs.PublicLinker    = &Fs{}
 	_ fs.OpenChunkWriter = &Fs{}
 	_ fs.Commander       = &Fs{}
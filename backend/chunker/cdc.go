@@ -0,0 +1,904 @@
+package chunker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/random"
+)
+
+// Content defined chunking
+//
+// In the "cdc" chunk mode chunker cuts files at positions chosen by a
+// rolling hash of the content (FastCDC with normalized chunking) rather
+// than at fixed offsets. Inserting or removing bytes only changes the
+// chunks around the edit, so the rest of the file keeps its chunks.
+//
+// Chunks are named after the SHA-256 of their contents and kept in a
+// chunk store shared by all the files on the wrapped remote, so
+// identical data is only stored once however many files contain it.
+//
+// A file written in this mode has a meta object in format version 3,
+// which is kept small like the other versions, and a control chunk of
+// type "cdc" holding the manifest: the list of chunks making up the
+// file.
+//
+// Chunks are reference counted with reference objects in the chunk
+// store, one for each chunk a manifest uses, named after the chunk and
+// a random ID kept in the manifest. Remotes have no atomic
+// read-modify-write, so a count kept in a single object would be
+// corrupted by concurrent uploads and removes, whereas each reference
+// object is only ever written or removed by the file it belongs to.
+// An upload writes the reference before it looks for the chunk, and
+// removing a file removes its references then any chunk which has
+// none left, so removing files frees the space of chunks nothing else
+// uses. The gc command reconciles the references with the manifests,
+// adding missing ones and removing ones left behind by failed uploads
+// or purged directories, and removes chunks no manifest refers to.
+// Uploads refresh the modification time of chunks they reuse so gc
+// doesn't remove them before the manifest referring to them is written.
+const (
+	cdcCtrlType        = "cdc"
+	cdcMetadataVersion = 3
+	cdcMinChunkSize    = 256
+	cdcMaxChunkSize    = 256 * 1024 * 1024
+	cdcHashLen         = 2 * sha256.Size
+	cdcRefLen          = 16
+	cdcRefDir          = "refs"
+	defaultGCMinAge    = time.Hour
+)
+
+// cdcGear is the table of random numbers driving the rolling hash.
+//
+// The chunk boundaries depend on it, so it must never change or
+// files uploaded later would not share chunks with earlier ones.
+var cdcGear [256]uint64
+
+func init() {
+	for i := range cdcGear {
+		sum := sha256.Sum256([]byte{byte(i)})
+		cdcGear[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+}
+
+// cdcSplitter cuts a stream into content defined chunks
+type cdcSplitter struct {
+	in      io.Reader
+	buf     []byte // holds up to a maximum size chunk
+	start   int    // start of unread data in buf
+	end     int    // end of unread data in buf
+	eof     bool   // set once the input is exhausted
+	minSize int    // no cut points are looked for before this size
+	avgSize int    // normal chunk size, cutting is harder before and easier after
+	maxSize int    // chunks are always cut at this size
+	maskS   uint64 // mask used before the normal size
+	maskL   uint64 // mask used after the normal size
+}
+
+// cdcSizes returns the minimum and maximum chunk sizes for the
+// average size given
+func cdcSizes(avgSize int) (minSize, maxSize int) {
+	return avgSize / 4, avgSize * 4
+}
+
+// cdcMask returns a mask with n bits set at the top of the word. The
+// top bits of the gear hash depend on the most bytes.
+func cdcMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+func newCDCSplitter(in io.Reader, avgSize int) *cdcSplitter {
+	minSize, maxSize := cdcSizes(avgSize)
+	avgBits := bits.Len(uint(avgSize)) - 1
+	return &cdcSplitter{
+		in:      in,
+		buf:     make([]byte, maxSize),
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+		maskS:   cdcMask(avgBits + 2),
+		maskL:   cdcMask(avgBits - 2),
+	}
+}
+
+// cut returns the length of the chunk at the start of data
+func (s *cdcSplitter) cut(data []byte) int {
+	n := len(data)
+	if n <= s.minSize {
+		return n
+	}
+	if n > s.maxSize {
+		n = s.maxSize
+	}
+	normal := s.avgSize
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := s.minSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&s.maskS == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&s.maskL == 0 {
+			return i
+		}
+	}
+	return n
+}
+
+// next returns the next chunk or io.EOF when the input is exhausted.
+//
+// The returned slice is only valid until the next call.
+func (s *cdcSplitter) next() ([]byte, error) {
+	if s.start > 0 {
+		copy(s.buf, s.buf[s.start:s.end])
+		s.end -= s.start
+		s.start = 0
+	}
+	if !s.eof && s.end < len(s.buf) {
+		n, err := io.ReadFull(s.in, s.buf[s.end:])
+		s.end += n
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			s.eof = true
+		default:
+			return nil, err
+		}
+	}
+	if s.end == 0 {
+		return nil, io.EOF
+	}
+	s.start = s.cut(s.buf[:s.end])
+	return s.buf[:s.start], nil
+}
+
+// cdcChunk describes a chunk in the chunk store
+type cdcChunk struct {
+	Hash string `json:"sha256"`
+	Size int64  `json:"size"`
+}
+
+// path returns the path of the chunk relative to the chunk store
+func (c cdcChunk) path() string {
+	return c.Hash[:2] + "/" + c.Hash
+}
+
+// refDir returns the directory of the references to the chunk
+// relative to the chunk store
+func (c cdcChunk) refDir() string {
+	return cdcRefDir + "/" + c.Hash
+}
+
+// refPath returns the path of the reference to the chunk with ID ref
+// relative to the chunk store
+func (c cdcChunk) refPath(ref string) string {
+	return c.refDir() + "/" + ref
+}
+
+// isRef returns true if name looks like a reference ID
+func isRef(name string) bool {
+	if len(name) != cdcRefLen {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueChunks returns chunks without duplicates
+func uniqueChunks(chunks []cdcChunk) []cdcChunk {
+	seen := make(map[string]bool, len(chunks))
+	out := make([]cdcChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if !seen[chunk.Hash] {
+			seen[chunk.Hash] = true
+			out = append(out, chunk)
+		}
+	}
+	return out
+}
+
+// isChunkHash returns true if name looks like the name of a chunk
+func isChunkHash(name string) bool {
+	if len(name) != cdcHashLen {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// Manifest of a file in cdc mode, kept in a control chunk
+type metaCDCManifest struct {
+	Version *int       `json:"ver"`
+	Ref     string     `json:"ref"`
+	Chunks  []cdcChunk `json:"chunks"`
+}
+
+func marshalManifest(chunks []cdcChunk, ref string) ([]byte, error) {
+	version := cdcMetadataVersion
+	if chunks == nil {
+		chunks = []cdcChunk{}
+	}
+	return json.Marshal(&metaCDCManifest{
+		Version: &version,
+		Ref:     ref,
+		Chunks:  chunks,
+	})
+}
+
+func unmarshalManifest(data []byte) (chunks []cdcChunk, ref string, err error) {
+	var manifest metaCDCManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version == nil {
+		return nil, "", errors.New("invalid manifest: missing version")
+	}
+	if *manifest.Version > cdcMetadataVersion {
+		return nil, "", ErrMetaUnknown
+	}
+	if !isRef(manifest.Ref) {
+		return nil, "", fmt.Errorf("invalid manifest: bad ref %q", manifest.Ref)
+	}
+	for _, chunk := range manifest.Chunks {
+		if !isChunkHash(chunk.Hash) || chunk.Size <= 0 {
+			return nil, "", fmt.Errorf("invalid manifest: bad chunk %q size %d", chunk.Hash, chunk.Size)
+		}
+	}
+	if manifest.Chunks == nil {
+		manifest.Chunks = []cdcChunk{}
+	}
+	return manifest.Chunks, manifest.Ref, nil
+}
+
+// readManifest reads the chunk list and reference ID from a manifest
+// object
+func readManifest(ctx context.Context, manifest fs.Object) ([]cdcChunk, string, error) {
+	reader, err := manifest.Open(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(reader)
+	_ = reader.Close() // ensure file handle is freed on windows
+	if err != nil {
+		return nil, "", err
+	}
+	return unmarshalManifest(data)
+}
+
+// setCDCStore works out where the chunk store lives.
+//
+// The store is at the root of the wrapped remote so that it is shared
+// by every directory beneath it. If the root of this Fs is above the
+// store, it is hidden from listings.
+func (f *Fs) setCDCStore(store string) error {
+	store = path.Clean(store)
+	if store == "." || store == ".." || strings.HasPrefix(store, "/") || strings.HasPrefix(store, "../") {
+		return fmt.Errorf("chunk store %q must be a relative path below the remote", store)
+	}
+	f.storePath = fspath.JoinRootPath(f.opt.Remote, store)
+	f.storeRel = store
+	root := strings.Trim(f.root, "/")
+	switch {
+	case root == "":
+		f.storeDir = store
+	case strings.HasPrefix(store, root+"/"):
+		f.storeDir = store[len(root)+1:]
+	default:
+		f.storeDir = ""
+	}
+	return nil
+}
+
+// chunkStore returns the Fs holding the chunks, making it if needed
+func (f *Fs) chunkStore(ctx context.Context) (fs.Fs, error) {
+	f.storeMu.Lock()
+	defer f.storeMu.Unlock()
+	if f.store != nil {
+		return f.store, nil
+	}
+	store, err := cache.Get(ctx, f.storePath)
+	if err == fs.ErrorIsFile {
+		return nil, fmt.Errorf("chunk store %q is a file", f.storePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to make chunk store %q: %w", f.storePath, err)
+	}
+	f.store = store
+	return store, nil
+}
+
+// storeChunk uploads data to the chunk store unless a chunk with the
+// same contents is there already
+func (f *Fs) storeChunk(ctx context.Context, store fs.Fs, data []byte, options []fs.OpenOption) (chunk cdcChunk, reused bool, err error) {
+	sum := sha256.Sum256(data)
+	chunk = cdcChunk{
+		Hash: hex.EncodeToString(sum[:]),
+		Size: int64(len(data)),
+	}
+	// A chunk of the wrong size is left over from a failed upload
+	if obj, err := store.NewObject(ctx, chunk.path()); err == nil && obj.Size() == chunk.Size {
+		// Refresh the modification time so gc keeps the chunk until
+		// the manifest which refers to it is written, uploading it
+		// again if the store can't set it
+		err = obj.SetModTime(ctx, time.Now())
+		if err == nil {
+			return chunk, true, nil
+		}
+		if !errors.Is(err, fs.ErrorCantSetModTime) && !errors.Is(err, fs.ErrorCantSetModTimeWithoutDelete) {
+			return chunk, false, fmt.Errorf("failed to refresh chunk %s: %w", chunk.Hash, err)
+		}
+	}
+	// Chunks get the current time so gc can tell recent uploads
+	info := object.NewStaticObjectInfo(chunk.path(), time.Now(), chunk.Size, true, nil, nil)
+	_, err = store.Put(ctx, bytes.NewReader(data), info, options...)
+	if err != nil {
+		return chunk, false, fmt.Errorf("failed to upload chunk %s: %w", chunk.Hash, err)
+	}
+	return chunk, false, nil
+}
+
+// addRef writes the reference with ID ref to chunk
+func addRef(ctx context.Context, store fs.Fs, chunk cdcChunk, ref string) error {
+	info := object.NewStaticObjectInfo(chunk.refPath(ref), time.Now(), 0, true, nil, nil)
+	_, err := store.Put(ctx, bytes.NewReader(nil), info)
+	if err != nil {
+		return fmt.Errorf("failed to add reference to chunk %s: %w", chunk.Hash, err)
+	}
+	return nil
+}
+
+// checkChunks checks the chunks are in the store.
+//
+// Removing the last other reference to a chunk can remove it after an
+// upload wrote its own reference and found the chunk, so this is run
+// on the chunks an upload or copy reuses before writing the manifest.
+func checkChunks(ctx context.Context, store fs.Fs, chunks []cdcChunk) error {
+	for _, chunk := range chunks {
+		obj, err := store.NewObject(ctx, chunk.path())
+		if err == nil && obj.Size() != chunk.Size {
+			err = fmt.Errorf("size %d, expecting %d", obj.Size(), chunk.Size)
+		}
+		if err != nil {
+			return fmt.Errorf("chunk %s removed during upload: %w", chunk.Hash, err)
+		}
+	}
+	return nil
+}
+
+// removeRefs removes the references with ID ref to chunks then
+// removes the chunks which have no references left
+func (f *Fs) removeRefs(ctx context.Context, chunks []cdcChunk, ref string) {
+	store, err := f.chunkStore(ctx)
+	if err != nil {
+		fs.Errorf(f, "Failed to remove chunk references: %v", err)
+		return
+	}
+	var freed int
+	for _, chunk := range uniqueChunks(chunks) {
+		refObj, err := store.NewObject(ctx, chunk.refPath(ref))
+		if err == nil {
+			err = refObj.Remove(ctx)
+		}
+		if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+			fs.Errorf(f, "Failed to remove reference to chunk %s: %v", chunk.Hash, err)
+			continue
+		}
+		entries, err := store.List(ctx, chunk.refDir())
+		if err != nil && !errors.Is(err, fs.ErrorDirNotFound) {
+			fs.Errorf(f, "Failed to list references to chunk %s: %v", chunk.Hash, err)
+			continue
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		_ = store.Rmdir(ctx, chunk.refDir())
+		obj, err := store.NewObject(ctx, chunk.path())
+		if err == nil {
+			err = obj.Remove(ctx)
+		}
+		if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+			fs.Errorf(f, "Failed to remove unreferenced chunk %s: %v", chunk.Hash, err)
+			continue
+		}
+		freed++
+	}
+	fs.Debugf(f, "Removed %d chunks with no references left", freed)
+}
+
+// putCDC implements put in cdc mode
+func (f *Fs) putCDC(ctx context.Context, in io.Reader, src fs.ObjectInfo, remote string, options []fs.OpenOption) (obj fs.Object, err error) {
+	store, err := f.chunkStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the whole input through the chunking reader for hashing
+	// and accounting, cutting it by content rather than by size
+	c := f.newChunkingReader(src)
+	c.chunkLimit = math.MaxInt64
+	c.expectSingle = false
+	wrapIn := c.wrapStream(ctx, in, src)
+
+	// Each chunk gets a reference before it is looked for in the store
+	// so removing another file using it can't remove it
+	var (
+		chunks []cdcChunk
+		reused []cdcChunk
+		refs   []cdcChunk
+		hasRef = make(map[string]bool)
+		ref    = random.String(cdcRefLen)
+	)
+	defer func() {
+		if err != nil && len(refs) > 0 {
+			f.removeRefs(ctx, refs, ref)
+		}
+	}()
+	splitter := newCDCSplitter(wrapIn, int(f.opt.CDCChunkSize))
+	for {
+		data, err := splitter.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if !hasRef[hash] {
+			refs = append(refs, cdcChunk{Hash: hash})
+			err = addRef(ctx, store, refs[len(refs)-1], ref)
+			if err != nil {
+				return nil, err
+			}
+		}
+		chunk, isReused, err := f.storeChunk(ctx, store, data, options)
+		if err != nil {
+			return nil, err
+		}
+		if isReused && !hasRef[hash] {
+			reused = append(reused, chunk)
+		}
+		hasRef[hash] = true
+		chunks = append(chunks, chunk)
+	}
+
+	// Validate uploaded size
+	if c.sizeTotal != -1 && c.readCount != c.sizeTotal {
+		return nil, fmt.Errorf("incorrect upload size %d != %d", c.readCount, c.sizeTotal)
+	}
+	c.updateHashes()
+	err = checkChunks(ctx, store, reused)
+	if err != nil {
+		return nil, err
+	}
+
+	// Upload the manifest with a temporary name. The references are
+	// removed on failure, which removes the chunks nothing else uses.
+	manifest, err := marshalManifest(chunks, ref)
+	if err != nil {
+		return nil, err
+	}
+	xactID, err := f.newXactID(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	tempRemote := f.makeChunkName(remote, -1, cdcCtrlType, xactID)
+	manifestObject, err := f.base.Put(ctx, bytes.NewReader(manifest), f.wrapInfo(src, tempRemote, int64(len(manifest))))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			silentlyRemove(ctx, manifestObject)
+		}
+	}()
+
+	// If previous object was chunked, remove its chunks
+	f.removeOldChunks(ctx, remote)
+
+	manifestMoved, err := f.baseMove(ctx, manifestObject, f.makeChunkName(remote, -1, cdcCtrlType, ""), delAlways)
+	if err != nil {
+		return nil, err
+	}
+	manifestObject = manifestMoved
+
+	metadata, err := marshalCDCJSON(ctx, c.readCount, len(chunks), c.md5, c.sha1)
+	if err != nil {
+		return nil, err
+	}
+	metaObject, err := f.base.Put(ctx, bytes.NewReader(metadata), f.wrapInfo(src, remote, int64(len(metadata))))
+	if err != nil {
+		return nil, err
+	}
+
+	o := f.newObject("", metaObject, nil)
+	o.manifest = manifestObject
+	o.cdc = chunks
+	o.nChunks = len(chunks)
+	o.size = c.readCount
+	o.md5 = c.md5
+	o.sha1 = c.sha1
+	o.isFull = true
+	o.cdcRef = ref
+	fs.Debugf(o, "Stored %d chunks, %d already in the chunk store", len(chunks), len(reused))
+	return o, nil
+}
+
+// readCDCManifest loads the chunk list of an object in cdc mode
+func (o *Object) readCDCManifest(ctx context.Context) error {
+	if o.cdc != nil {
+		return nil
+	}
+	chunks, ref, err := readManifest(ctx, o.manifest)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, chunk := range chunks {
+		size += chunk.Size
+	}
+	if size != o.size || len(chunks) != o.nChunks {
+		return errors.New("chunk manifest doesn't match metadata")
+	}
+	o.cdc = chunks
+	o.cdcRef = ref
+	return nil
+}
+
+// removeCDC removes the manifest of an object in cdc mode and its
+// references to the chunks, removing the chunks no other file uses
+func (o *Object) removeCDC(ctx context.Context) error {
+	// Without the manifest the references are left for gc
+	readErr := o.readCDCManifest(ctx)
+	if readErr != nil {
+		fs.Errorf(o, "Failed to read manifest so leaving chunks for gc: %v", readErr)
+	}
+	err := o.manifest.Remove(ctx)
+	if err != nil {
+		return err
+	}
+	if readErr == nil {
+		o.f.removeRefs(ctx, o.cdc, o.cdcRef)
+	}
+	return nil
+}
+
+// openCDCChunk opens a chunk from the chunk store
+func openCDCChunk(ctx context.Context, store fs.Fs, chunk cdcChunk, options []fs.OpenOption) (io.ReadCloser, error) {
+	obj, err := store.NewObject(ctx, chunk.path())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find chunk %s: %w", chunk.Hash, err)
+	}
+	if obj.Size() != chunk.Size {
+		return nil, fmt.Errorf("chunk %s has size %d, expecting %d", chunk.Hash, obj.Size(), chunk.Size)
+	}
+	return obj.Open(ctx, options...)
+}
+
+// copyManifestCDC writes a manifest for remote referring to the
+// chunks of o with new references
+func (f *Fs) copyManifestCDC(ctx context.Context, o *Object, remote string) (manifestObject fs.Object, ref string, err error) {
+	err = o.readCDCManifest(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	store, err := f.chunkStore(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	ref = random.String(cdcRefLen)
+	defer func() {
+		if err != nil {
+			f.removeRefs(ctx, o.cdc, ref)
+		}
+	}()
+	chunks := uniqueChunks(o.cdc)
+	for _, chunk := range chunks {
+		err = addRef(ctx, store, chunk, ref)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	err = checkChunks(ctx, store, chunks)
+	if err != nil {
+		return nil, "", err
+	}
+	manifest, err := marshalManifest(o.cdc, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	manifestRemote := f.makeChunkName(remote, -1, cdcCtrlType, "")
+	manifestObject, err = f.base.Put(ctx, bytes.NewReader(manifest), f.wrapInfo(o.manifest, manifestRemote, int64(len(manifest))))
+	if err != nil {
+		return nil, "", err
+	}
+	return manifestObject, ref, nil
+}
+
+// copyOrMoveCDC implements copy or move of a file in cdc mode.
+//
+// Only the manifest and meta object need to be moved as the chunks
+// stay where they are in the chunk store. A copy gets a new manifest
+// with its own references to the chunks.
+func (f *Fs) copyOrMoveCDC(ctx context.Context, o *Object, remote string, do copyMoveFn, opName string) (fs.Object, error) {
+	fs.Debugf(o, "%s manifest of %d stored chunks...", opName, o.nChunks)
+	var (
+		manifestObject fs.Object
+		ref            string
+		err            error
+	)
+	if opName == "copy" {
+		manifestObject, ref, err = f.copyManifestCDC(ctx, o, remote)
+	} else {
+		manifestObject, err = do(ctx, o.manifest, f.makeChunkName(remote, -1, cdcCtrlType, ""))
+		ref = o.cdcRef
+	}
+	if err != nil {
+		return nil, err
+	}
+	metaObject, err := do(ctx, o.main, remote)
+	if err != nil {
+		silentlyRemove(ctx, manifestObject)
+		if opName == "copy" {
+			f.removeRefs(ctx, o.cdc, ref)
+		}
+		return nil, err
+	}
+	newObj := f.newObject(remote, metaObject, nil)
+	newObj.manifest = manifestObject
+	newObj.cdc = o.cdc
+	newObj.cdcRef = ref
+	newObj.nChunks = o.nChunks
+	newObj.size = o.size
+	newObj.md5 = o.md5
+	newObj.sha1 = o.sha1
+	newObj.isFull = true
+	return newObj, nil
+}
+
+// Result counts returned by the gc command
+const (
+	gcManifests   = "manifests"
+	gcReferenced  = "referenced"
+	gcKept        = "kept"
+	gcRemoved     = "removed"
+	gcFreed       = "removed_bytes"
+	gcRefsAdded   = "added_refs"
+	gcRefsRemoved = "removed_refs"
+)
+
+// gc reconciles the references in the chunk store with the manifests
+// on the wrapped remote and removes the chunks which no manifest
+// refers to.
+//
+// References missing for a manifest are added and references which
+// no manifest has are removed. Chunks and references modified within
+// minAge are kept as they may belong to an upload whose manifest has
+// not been written yet.
+func (f *Fs) gc(ctx context.Context, minAge time.Duration) (map[string]int64, error) {
+	store, err := f.chunkStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The store is shared by the whole of the wrapped remote, so look
+	// for references from its root rather than from ours
+	root, err := cache.Get(ctx, f.opt.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make remote %q to scan: %w", f.opt.Remote, err)
+	}
+	counts := map[string]int64{
+		gcManifests:   0,
+		gcReferenced:  0,
+		gcKept:        0,
+		gcRemoved:     0,
+		gcFreed:       0,
+		gcRefsAdded:   0,
+		gcRefsRemoved: 0,
+	}
+
+	// Count the references to each chunk and note the reference
+	// objects each manifest should have. Temporary manifests count
+	// too as they belong to uploads in progress. Any manifest which
+	// can't be read stops gc as its chunks would be removed.
+	var mu sync.Mutex
+	refs := make(map[string]int)
+	missingRefs := make(map[string]cdcChunk)
+	err = walk.ListR(ctx, root, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			obj, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			remote := obj.Remote()
+			if remote == f.storeRel || strings.HasPrefix(remote, f.storeRel+"/") {
+				continue
+			}
+			if _, _, ctrlType, _ := f.parseChunkName(remote); ctrlType != cdcCtrlType {
+				continue
+			}
+			chunks, ref, err := readManifest(ctx, obj)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest %q: %w", remote, err)
+			}
+			mu.Lock()
+			counts[gcManifests]++
+			for _, chunk := range chunks {
+				refs[chunk.Hash]++
+				missingRefs[chunk.refPath(ref)] = chunk
+			}
+			mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Find unreferenced chunks and stale references
+	cutoff := time.Now().Add(-minAge)
+	var garbage, staleRefs []fs.Object
+	err = walk.ListR(ctx, store, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(func(obj fs.Object) {
+			remote := obj.Remote()
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.HasPrefix(remote, cdcRefDir+"/") {
+				if _, ok := missingRefs[remote]; ok {
+					delete(missingRefs, remote)
+				} else if !obj.ModTime(ctx).After(cutoff) {
+					staleRefs = append(staleRefs, obj)
+				}
+				return
+			}
+			if !isChunkHash(path.Base(remote)) {
+				return
+			}
+			switch {
+			case refs[path.Base(remote)] > 0:
+				counts[gcReferenced]++
+			case obj.ModTime(ctx).After(cutoff):
+				counts[gcKept]++
+			default:
+				garbage = append(garbage, obj)
+			}
+		})
+		return nil
+	})
+	if err != nil && err != fs.ErrorDirNotFound {
+		return nil, err
+	}
+
+	var errCount int
+	for _, obj := range staleRefs {
+		err := operations.DeleteFile(ctx, obj)
+		if err != nil {
+			errCount++
+			continue
+		}
+		_ = store.Rmdir(ctx, path.Dir(obj.Remote()))
+		counts[gcRefsRemoved]++
+	}
+	for _, obj := range garbage {
+		err := operations.DeleteFile(ctx, obj)
+		if err != nil {
+			errCount++
+			continue
+		}
+		counts[gcRemoved]++
+		counts[gcFreed] += obj.Size()
+	}
+	for refPath, chunk := range missingRefs {
+		if operations.SkipDestructive(ctx, refPath, "add reference") {
+			continue
+		}
+		err := addRef(ctx, store, chunk, path.Base(refPath))
+		if err != nil {
+			fs.Errorf(f, "%v", err)
+			errCount++
+			continue
+		}
+		counts[gcRefsAdded]++
+	}
+	if counts[gcKept] > 0 {
+		fs.Infof(f, "Kept %d unreferenced chunks younger than %v", counts[gcKept], minAge)
+	}
+	if errCount > 0 {
+		return counts, fmt.Errorf("failed to reconcile %d chunks or references", errCount)
+	}
+	return counts, nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "gc",
+	Short: "Remove chunks not used by any file from the chunk store.",
+	Long: `Files written with chunk_mode = cdc keep their data in a chunk store
+shared by all the files on the wrapped remote. Each file has a
+reference object in the store for each chunk it uses, and removing or
+overwriting a file removes its references and the chunks left without
+any. References can be left behind by failed uploads, or by purging
+or deleting files on the wrapped remote directly, which keeps their
+chunks in the store.
+
+This command counts the references to each chunk from the manifests
+of all the files on the wrapped remote, adds any missing reference
+objects, removes the ones no manifest has and removes the chunks which
+are not referenced.
+
+    rclone backend gc chunker:
+    rclone backend gc chunker: -o min-age=24h
+
+Unreferenced chunks and references modified within min-age are kept
+as they may belong to an upload in progress. Use the --dry-run flag to
+see what would be changed.
+
+It returns a map of counts like this
+
+    {
+        "added_refs": 0,
+        "kept": 0,
+        "manifests": 12,
+        "referenced": 341,
+        "removed": 27,
+        "removed_bytes": 28311552,
+        "removed_refs": 31
+    }
+`,
+	Opts: map[string]string{
+		"min-age": "Keep unreferenced chunks and references younger than this (default 1h).",
+	},
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "gc":
+		minAge := defaultGCMinAge
+		if s, ok := opt["min-age"]; ok {
+			minAge, err = fs.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad min-age: %w", err)
+			}
+		}
+		return f.gc(ctx, minAge)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
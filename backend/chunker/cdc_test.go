@@ -0,0 +1,107 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// split returns the chunks of data cut by a cdcSplitter
+func split(t *testing.T, data []byte, avgSize int) (chunks [][]byte) {
+	s := newCDCSplitter(bytes.NewReader(data), avgSize)
+	for {
+		chunk, err := s.next()
+		if err == io.EOF {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestCDCSplitter(t *testing.T) {
+	const avgSize = 4096
+	minSize, maxSize := cdcSizes(avgSize)
+	data := make([]byte, 1024*1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := split(t, data, avgSize)
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), maxSize)
+		if i < len(chunks)-1 {
+			assert.Greater(t, len(chunk), minSize)
+		}
+	}
+	n := len(chunks)
+	assert.True(t, n > len(data)/(2*avgSize) && n < 2*len(data)/avgSize, "unexpected number of chunks %d", n)
+
+	// Same input gives the same chunks
+	assert.Equal(t, chunks, split(t, data, avgSize))
+
+	// Edits only change the chunks around them
+	hashes := func(chunks [][]byte) map[[sha256.Size]byte]bool {
+		m := make(map[[sha256.Size]byte]bool)
+		for _, chunk := range chunks {
+			m[sha256.Sum256(chunk)] = true
+		}
+		return m
+	}
+	before := hashes(chunks)
+	edited := append([]byte("inserted at the start"), data...)
+	edited = append(edited[:len(edited)/2], edited[len(edited)/2+100:]...)
+	var shared int
+	for hash := range hashes(split(t, edited, avgSize)) {
+		if before[hash] {
+			shared++
+		}
+	}
+	assert.Greater(t, shared, len(before)-10)
+}
+
+func TestCDCSplitterEmpty(t *testing.T) {
+	assert.Nil(t, split(t, nil, 4096))
+	assert.Equal(t, [][]byte{[]byte("small")}, split(t, []byte("small"), 4096))
+}
+
+func TestCDCManifest(t *testing.T) {
+	chunks := []cdcChunk{{
+		Hash: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		Size: 4,
+	}}
+	const ref = "bacokuxa4sadecu7"
+	data, err := marshalManifest(chunks, ref)
+	require.NoError(t, err)
+	got, gotRef, err := unmarshalManifest(data)
+	require.NoError(t, err)
+	assert.Equal(t, chunks, got)
+	assert.Equal(t, ref, gotRef)
+	assert.Equal(t, "9f/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", got[0].path())
+	assert.Equal(t, "refs/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08/"+ref, got[0].refPath(ref))
+
+	data, err = marshalManifest(nil, ref)
+	require.NoError(t, err)
+	got, _, err = unmarshalManifest(data)
+	require.NoError(t, err)
+	assert.Equal(t, []cdcChunk{}, got)
+
+	for _, bad := range []string{
+		``,
+		`{"ref":"bacokuxa4sadecu7","chunks":[]}`,
+		`{"ver":3,"chunks":[]}`,
+		`{"ver":3,"ref":"../../etc/passwd","chunks":[]}`,
+		`{"ver":3,"ref":"bacokuxa4sadecu7","chunks":[{"sha256":"../../etc/passwd","size":4}]}`,
+		`{"ver":3,"ref":"bacokuxa4sadecu7","chunks":[{"sha256":"9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08","size":4}]}`,
+		`{"ver":3,"ref":"bacokuxa4sadecu7","chunks":[{"sha256":"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","size":0}]}`,
+	} {
+		_, _, err = unmarshalManifest([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+	_, _, err = unmarshalManifest([]byte(`{"ver":999,"chunks":[]}`))
+	assert.Equal(t, ErrMetaUnknown, err)
+}
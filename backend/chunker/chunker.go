@@ -108,7 +108,8 @@ const (
 )
 
 // Current/highest supported metadata format.
-const metadataVersion = 2
+// Version 3 is only written for files in cdc chunk mode.
+const metadataVersion = cdcMetadataVersion
 
 // optimizeFirstChunk enables the following optimization in the Put:
 // If a single chunk is expected, put the first chunk using the
@@ -146,6 +147,7 @@ func init() {
 		Name:        "chunker",
 		Description: "Transparently chunk/split large files",
 		NewFs:       NewFs,
+		CommandHelp: commandHelp,
 		Options: []fs.Option{{
 			Name:     "remote",
 			Required: true,
@@ -158,6 +160,41 @@ Normally should contain a ':' and a path, e.g. "myremote:path/to/dir",
 			Advanced: false,
 			Default:  fs.SizeSuffix(2147483648), // 2 GiB
 			Help:     `Files larger than chunk size will be split in chunks.`,
+		}, {
+			Name:     "chunk_mode",
+			Advanced: true,
+			Default:  "fixed",
+			Help: `How files are split in chunks.
+
+Files written in either mode can be read in both.`,
+			Examples: []fs.OptionExample{{
+				Value: "fixed",
+				Help:  `Split files larger than chunk size in chunks of that size.`,
+			}, {
+				Value: "cdc",
+				Help: `Split all files at points chosen by their content.
+
+Chunks are stored by hash in a chunk store shared by all files so
+identical data is only stored once, and are reference counted so
+deleting a file removes the chunks no other file uses. Use "rclone
+backend gc" to reconcile the references. Requires metadata.`,
+			}},
+		}, {
+			Name:     "cdc_chunk_size",
+			Advanced: true,
+			Default:  fs.SizeSuffix(1024 * 1024), // 1 MiB
+			Help: `Average chunk size in cdc chunk mode.
+
+Chunks are between a quarter of and four times this size.
+Smaller chunks find more duplicate data but need more objects.`,
+		}, {
+			Name:     "cdc_store",
+			Advanced: true,
+			Hide:     fs.OptionHideCommandLine,
+			Default:  ".rclone_cdc",
+			Help: `Directory of the chunk store in cdc chunk mode.
+
+This is relative to the remote being chunked and is hidden from listings.`,
 		}, {
 			Name:     "name_format",
 			Advanced: true,
@@ -313,6 +350,9 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	if err := f.configure(opt.NameFormat, opt.MetaFormat, opt.HashType, opt.Transactions); err != nil {
 		return nil, err
 	}
+	if err := f.setChunkMode(opt.ChunkMode); err != nil {
+		return nil, err
+	}
 
 	// Handle the tricky case detected by FsMkdir/FsPutFiles/FsIsFile
 	// when `rpath` points to a composite multi-chunk file without metadata,
@@ -336,6 +376,9 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 			f.root = ""
 		}
 	}
+	if storeErr := f.setCDCStore(opt.CDCStore); storeErr != nil {
+		return nil, storeErr
+	}
 
 	// Note 1: the features here are ones we could support, and they are
 	// ANDed with the ones from wrappedFs.
@@ -358,6 +401,12 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 
 	f.features.Disable("ListR") // Recursive listing may cause chunker skip files
 
+	// Streaming uploads don't need support from the wrapped remote
+	// in cdc mode as all the objects uploaded have known sizes.
+	if f.useCDC {
+		f.features.PutStream = f.PutStream
+	}
+
 	return f, err
 }
 
@@ -371,6 +420,9 @@ type Options struct {
 	HashType     string        `config:"hash_type"`
 	FailHard     bool          `config:"fail_hard"`
 	Transactions string        `config:"transactions"`
+	ChunkMode    string        `config:"chunk_mode"`
+	CDCChunkSize fs.SizeSuffix `config:"cdc_chunk_size"`
+	CDCStore     string        `config:"cdc_store"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -393,6 +445,12 @@ type Fs struct {
 	features     *fs.Features   // optional features
 	dirSort      bool           // reserved for future, ignored
 	useNoRename  bool           // can be set with the transactions option
+	useCDC       bool           // write files in cdc chunk mode
+	storePath    string         // remote path of the chunk store
+	storeRel     string         // path of the chunk store relative to the remote
+	storeDir     string         // path of the chunk store relative to root or "" if outside
+	store        fs.Fs          // chunk store, made when first needed
+	storeMu      sync.Mutex     // protects store
 }
 
 // configure sets up chunker for given name format, meta format and hash type.
@@ -470,6 +528,26 @@ func (f *Fs) setHashType(hashType string) error {
 	return nil
 }
 
+// setChunkMode
+// must be called *after* setMetaFormat.
+func (f *Fs) setChunkMode(chunkMode string) error {
+	switch chunkMode {
+	case "fixed":
+		f.useCDC = false
+	case "cdc":
+		if !f.useMeta {
+			return errors.New("cdc chunk mode requires metadata")
+		}
+		if f.opt.CDCChunkSize < cdcMinChunkSize || f.opt.CDCChunkSize > cdcMaxChunkSize {
+			return fmt.Errorf("cdc_chunk_size must be between %v and %v", fs.SizeSuffix(cdcMinChunkSize), fs.SizeSuffix(cdcMaxChunkSize))
+		}
+		f.useCDC = true
+	default:
+		return fmt.Errorf("unsupported chunk mode '%s'", chunkMode)
+	}
+	return nil
+}
+
 func (f *Fs) setTransactionMode(transactionMode string) error {
 	switch transactionMode {
 	case "rename":
@@ -729,6 +807,16 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 	return f.processEntries(ctx, entries, dir)
 }
 
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using old versions of the chunks.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	entries, err = f.base.Features().ListAt(ctx, dir, at)
+	if err != nil {
+		return nil, err
+	}
+	return f.processEntries(ctx, entries, dir)
+}
+
 // ListR lists the objects and directories of the Fs starting
 // from dir recursively into out.
 //
@@ -819,6 +907,9 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				// the `size` field caches metaobject size, if any
 				if f.useMeta && mainObject != nil && mainObject.size <= maxMetadataSize {
 					mainObject.unsure = true
+					if ctrlType == cdcCtrlType && xactID == "" {
+						mainObject.manifest = entry
+					}
 				}
 				break
 			}
@@ -829,6 +920,9 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				badEntry[mainRemote] = true
 			}
 		case fs.Directory:
+			if f.storeDir != "" && entry.Remote() == f.storeDir {
+				break // hide the chunk store
+			}
 			isSubdir[entry.Remote()] = true
 			wrapDir := fs.NewDirWrapper(entry.Remote(), entry)
 			tempEntries = append(tempEntries, wrapDir)
@@ -860,6 +954,16 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				fs.Debugf(f, "invalid chunks in object %q", remote)
 				continue
 			}
+			if object.manifest != nil {
+				// the size of a file in cdc mode is in its metadata
+				if err := object.readMetadata(ctx); err != nil {
+					if f.opt.FailHard {
+						return nil, err
+					}
+					fs.Debugf(f, "invalid metadata in object %q: %v", remote, err)
+					continue
+				}
+			}
 		}
 		newEntries = append(newEntries, entry)
 	}
@@ -978,6 +1082,9 @@ func (f *Fs) scanObject(ctx context.Context, remote string, quickScan bool) (fs.
 			if f.useMeta {
 				// temporary/control chunk calls for lazy metadata read
 				o.unsure = true
+				if ctrlType == cdcCtrlType && xactID == "" {
+					o.manifest = entry
+				}
 			}
 			continue
 		}
@@ -1016,6 +1123,12 @@ func (f *Fs) scanObject(ctx context.Context, remote string, quickScan bool) (fs.
 		if err := o.validate(); err != nil {
 			return nil, err
 		}
+		if o.manifest != nil {
+			// the size of a file in cdc mode is in its metadata
+			if err := o.readMetadata(ctx); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return o, nil
 }
@@ -1046,8 +1159,9 @@ func (o *Object) readMetadata(ctx context.Context) error {
 		if o.unsure {
 			// this is not metadata but a foreign object
 			o.unsure = false
-			o.chunks = nil  // make isComposite return false
-			o.isFull = true // cache results
+			o.chunks = nil   // make isComposite return false
+			o.manifest = nil // ditto
+			o.isFull = true  // cache results
 			return nil
 		}
 		return ErrMetaTooBig
@@ -1071,8 +1185,9 @@ func (o *Object) readMetadata(ctx context.Context) error {
 			o.unsure = false
 			if !madeByChunker {
 				// this is not metadata but a foreign object
-				o.chunks = nil  // make isComposite return false
-				o.isFull = true // cache results
+				o.chunks = nil   // make isComposite return false
+				o.manifest = nil // ditto
+				o.isFull = true  // cache results
 				return nil
 			}
 		}
@@ -1084,8 +1199,17 @@ func (o *Object) readMetadata(ctx context.Context) error {
 		default:
 			return fmt.Errorf("invalid metadata: %w", err)
 		}
-		if o.size != metaInfo.Size() || len(o.chunks) != metaInfo.nChunks {
-			return errors.New("metadata doesn't match file size")
+		if metaInfo.cdc {
+			if o.manifest == nil {
+				return errors.New("chunk manifest is missing")
+			}
+			o.size = metaInfo.Size()
+			o.nChunks = metaInfo.nChunks
+		} else {
+			o.manifest = nil // left over from a file overwritten in fixed mode
+			if o.size != metaInfo.Size() || len(o.chunks) != metaInfo.nChunks {
+				return errors.New("metadata doesn't match file size")
+			}
 		}
 		o.md5 = metaInfo.md5
 		o.sha1 = metaInfo.sha1
@@ -1165,6 +1289,10 @@ func (f *Fs) put(
 		}
 	}
 
+	if f.useCDC {
+		return f.putCDC(ctx, in, src, remote, options)
+	}
+
 	// Prepare to upload
 	c := f.newChunkingReader(src)
 	wrapIn := c.wrapStream(ctx, in, src)
@@ -1510,6 +1638,11 @@ func (f *Fs) removeOldChunks(ctx context.Context, remote string) {
 				fs.Errorf(chunk, "Failed to remove old chunk: %v", err)
 			}
 		}
+		if oldObject.manifest != nil {
+			if err := oldObject.removeCDC(ctx); err != nil {
+				fs.Errorf(oldObject.manifest, "Failed to remove old manifest: %v", err)
+			}
+		}
 	}
 }
 
@@ -1530,7 +1663,7 @@ func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, opt
 // Update in to the object with the modTime given of the given size
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
 	basePut := o.f.base.Put
-	if src.Size() < 0 {
+	if src.Size() < 0 && !o.f.useCDC {
 		basePut = o.f.base.Features().PutStream
 		if basePut == nil {
 			return errors.New("wrapped file system does not support streaming uploads")
@@ -1563,12 +1696,13 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 // Hashes returns the supported hash sets.
 // Chunker advertises a hash type if and only if it can be calculated
 // for files of any size, non-chunked or composite.
+// In cdc mode all files are composite.
 func (f *Fs) Hashes() hash.Set {
 	// composites AND no fallback AND (chunker OR wrapped Fs will hash all non-chunked's)
-	if f.useMD5 && !f.hashFallback && (f.hashAll || f.base.Hashes().Contains(hash.MD5)) {
+	if f.useMD5 && !f.hashFallback && (f.hashAll || f.useCDC || f.base.Hashes().Contains(hash.MD5)) {
 		return hash.NewHashSet(hash.MD5)
 	}
-	if f.useSHA1 && !f.hashFallback && (f.hashAll || f.base.Hashes().Contains(hash.SHA1)) {
+	if f.useSHA1 && !f.hashFallback && (f.hashAll || f.useCDC || f.base.Hashes().Contains(hash.SHA1)) {
 		return hash.NewHashSet(hash.SHA1)
 	}
 	return hash.NewHashSet() // can't provide strong guarantees
@@ -1675,6 +1809,15 @@ func (o *Object) Remove(ctx context.Context) (err error) {
 		}
 	}
 
+	// Chunks of a file in cdc mode may be shared with other files,
+	// so only the ones it was the last reference to are removed.
+	if o.manifest != nil {
+		manifestErr := o.removeCDC(ctx)
+		if err == nil {
+			err = manifestErr
+		}
+	}
+
 	// There are no known control chunks to remove atm.
 	return err
 }
@@ -1689,6 +1832,9 @@ func (f *Fs) copyOrMove(ctx context.Context, o *Object, remote string, do copyMo
 		// metadata format which might involve unsupported chunk types.
 		return nil, fmt.Errorf("can't %s this file: %w", opName, err)
 	}
+	if o.manifest != nil {
+		return f.copyOrMoveCDC(ctx, o, remote, do, opName)
+	}
 	if !o.isComposite() {
 		fs.Debugf(o, "%s non-chunked object...", opName)
 		oResult, err := do(ctx, o.mainChunk(), remote) // chain operation to a single wrapped chunk
@@ -1793,6 +1939,14 @@ func (f *Fs) okForServerSide(ctx context.Context, src fs.Object, opName string)
 		// ensure object is composite if need to re-read metadata
 		_ = obj.readMetadata(ctx)
 	}
+	if obj.manifest != nil {
+		// metadata is copied as is, but the chunks must stay reachable
+		ok = f.storePath == obj.f.storePath
+		if !ok {
+			fs.Debugf(src, "Can't %s - different chunk stores", opName)
+		}
+		return
+	}
 	requireMetaHash := obj.isComposite() && f.opt.MetaFormat == "simplejson"
 	if !requireMetaHash && !f.hashAll {
 		ok = true // hash is not required for metadata
@@ -2003,6 +2157,10 @@ type Object struct {
 	xIDCached bool        // true if xactID has been read
 	unsure    bool        // true if need to read metadata to detect object type
 	xactID    string      // transaction ID for "norename" or empty string for "renamed" chunks
+	manifest  fs.Object   // manifest control chunk if file was written in cdc mode
+	cdc       []cdcChunk  // chunks in the chunk store, read from manifest when needed
+	cdcRef    string      // ID of the references to the chunks, read with them
+	nChunks   int         // number of chunks in the chunk store according to metadata
 	md5       string
 	sha1      string
 	f         *Fs
@@ -2044,6 +2202,9 @@ func (o *Object) validate() error {
 		o.size = -1
 		return fmt.Errorf("%q metadata is too large", o.remote)
 	}
+	if o.chunks == nil {
+		return nil // data is in the chunk store, size will be read from metadata
+	}
 
 	var totalSize int64
 	for _, chunk := range o.chunks {
@@ -2090,7 +2251,7 @@ func (o *Object) mainChunk() fs.Object {
 }
 
 func (o *Object) isComposite() bool {
-	return o.chunks != nil
+	return o.chunks != nil || o.manifest != nil
 }
 
 // Fs returns read only access to the Fs that this object is part of
@@ -2226,6 +2387,8 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 type linearReader struct {
 	ctx     context.Context
 	chunks  []fs.Object
+	cdc     []cdcChunk // chunks to read from store if file was written in cdc mode
+	store   fs.Fs      // chunk store or nil
 	options []fs.OpenOption
 	limit   int64
 	count   int64
@@ -2241,6 +2404,17 @@ func (o *Object) newLinearReader(ctx context.Context, offset, limit int64, optio
 		options: options,
 		limit:   limit,
 	}
+	if o.manifest != nil {
+		if err := o.readCDCManifest(ctx); err != nil {
+			return nil, fmt.Errorf("can't open: %w", err)
+		}
+		store, err := o.f.chunkStore(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.cdc = o.cdc
+		r.store = store
+	}
 
 	// skip to chunk for given offset
 	err := io.EOF
@@ -2258,12 +2432,12 @@ func (r *linearReader) nextChunk(offset int64) (int64, error) {
 	if r.err != nil {
 		return -1, r.err
 	}
-	if r.pos >= len(r.chunks) || r.limit <= 0 || offset < 0 {
+	if r.pos >= r.numChunks() || r.limit <= 0 || offset < 0 {
 		return -1, io.EOF
 	}
 
-	chunk := r.chunks[r.pos]
-	count := chunk.Size()
+	chunkNo := r.pos
+	count := r.chunkSize(chunkNo)
 	r.pos++
 
 	if offset >= count {
@@ -2279,7 +2453,7 @@ func (r *linearReader) nextChunk(offset int64) (int64, error) {
 		return -1, err
 	}
 
-	reader, err := chunk.Open(r.ctx, options...)
+	reader, err := r.openChunk(chunkNo, options)
 	if err != nil {
 		return -1, err
 	}
@@ -2289,6 +2463,27 @@ func (r *linearReader) nextChunk(offset int64) (int64, error) {
 	return offset, nil
 }
 
+func (r *linearReader) numChunks() int {
+	if r.store != nil {
+		return len(r.cdc)
+	}
+	return len(r.chunks)
+}
+
+func (r *linearReader) chunkSize(chunkNo int) int64 {
+	if r.store != nil {
+		return r.cdc[chunkNo].Size
+	}
+	return r.chunks[chunkNo].Size()
+}
+
+func (r *linearReader) openChunk(chunkNo int, options []fs.OpenOption) (io.ReadCloser, error) {
+	if r.store != nil {
+		return openCDCChunk(r.ctx, r.store, r.cdc[chunkNo], options)
+	}
+	return r.chunks[chunkNo].Open(r.ctx, options...)
+}
+
 func (r *linearReader) Read(p []byte) (n int, err error) {
 	if r.err != nil {
 		return 0, r.err
@@ -2332,6 +2527,7 @@ type ObjectInfo struct {
 	src     fs.ObjectInfo
 	fs      *Fs
 	nChunks int    // number of data chunks
+	cdc     bool   // data chunks are in the chunk store
 	xactID  string // transaction ID for "norename" or empty string for "renamed" chunks
 	size    int64  // overrides source size by the total size of data chunks
 	remote  string // overrides remote name
@@ -2443,10 +2639,19 @@ type metaSimpleJSON struct {
 // - if file contents can be mistaken as meta object
 // - if consistent hashing is On but wrapped remote can't provide given hash
 func marshalSimpleJSON(ctx context.Context, size int64, nChunks int, md5, sha1, xactID string) ([]byte, error) {
-	version := metadataVersion
-	if xactID == "" && version == 2 {
+	version := 2
+	if xactID == "" {
 		version = 1
 	}
+	return encodeSimpleJSON(version, size, nChunks, md5, sha1, xactID)
+}
+
+// marshalCDCJSON creates metadata for a file in cdc chunk mode
+func marshalCDCJSON(ctx context.Context, size int64, nChunks int, md5, sha1 string) ([]byte, error) {
+	return encodeSimpleJSON(cdcMetadataVersion, size, nChunks, md5, sha1, "")
+}
+
+func encodeSimpleJSON(version int, size int64, nChunks int, md5, sha1, xactID string) ([]byte, error) {
 	metadata := metaSimpleJSON{
 		// required core fields
 		Version:  &version,
@@ -2518,8 +2723,8 @@ func unmarshalSimpleJSON(ctx context.Context, metaObject fs.Object, data []byte)
 			return nil, false, errors.New("wrong sha1 hash")
 		}
 	}
-	// ChunkNum is allowed to be 0 in future versions
-	if *metadata.ChunkNum < 1 && *metadata.Version <= metadataVersion {
+	// ChunkNum is allowed to be 0 for empty files in cdc mode
+	if *metadata.ChunkNum < 1 && *metadata.Version < cdcMetadataVersion {
 		return nil, false, errors.New("wrong number of chunks")
 	}
 	// Non-strict mode also accepts future metadata versions
@@ -2533,6 +2738,7 @@ func unmarshalSimpleJSON(ctx context.Context, metaObject fs.Object, data []byte)
 	info.md5 = metadata.MD5
 	info.sha1 = metadata.SHA1
 	info.xactID = metadata.XactID
+	info.cdc = *metadata.Version == cdcMetadataVersion
 	return info, true, nil
 }
 
@@ -2584,10 +2790,12 @@ var (
 	_ fs.CleanUpper      = (*Fs)(nil)
 	_ fs.UnWrapper       = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.ListAter        = (*Fs)(nil)
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.Wrapper         = (*Fs)(nil)
 	_ fs.ChangeNotifier  = (*Fs)(nil)
 	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
 	_ fs.ObjectInfo      = (*ObjectInfo)(nil)
 	_ fs.Object          = (*Object)(nil)
 	_ fs.ObjectUnWrapper = (*Object)(nil)
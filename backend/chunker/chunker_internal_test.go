@@ -3,6 +3,7 @@ package chunker
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"flag"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configmap"
@@ -17,6 +19,7 @@ import (
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/fstests"
 	"github.com/rclone/rclone/lib/random"
@@ -363,6 +366,9 @@ func testChunkNameFormat(t *testing.T, f *Fs) {
 }
 
 func testSmallFileInternals(t *testing.T, f *Fs) {
+	if f.useCDC {
+		t.Skip("small files are chunked in cdc mode")
+	}
 	const dir = "small"
 	ctx := context.Background()
 	saveOpt := f.opt
@@ -866,6 +872,7 @@ func testMD5AllSlow(t *testing.T, f *Fs) {
 	ctx := context.Background()
 	fsResult := deriveFs(ctx, t, f, "md5all", settings{
 		"chunk_size":   "1P",
+		"chunk_mode":   "fixed",
 		"name_format":  "*.#",
 		"hash_type":    "md5all",
 		"transactions": "rename",
@@ -899,6 +906,146 @@ func testMD5AllSlow(t *testing.T, f *Fs) {
 	require.NoError(t, operations.Purge(ctx, baseFs, ""))
 }
 
+// Test that files in cdc mode share chunks, removing a file removes
+// only the chunks nothing else refers to and gc reconciles references
+func testCDCDedup(t *testing.T, f *Fs) {
+	if !f.useCDC {
+		t.Skip("this test requires cdc chunk mode")
+	}
+	const dir = "cdc"
+	ctx := context.Background()
+	defer func() {
+		_ = operations.Purge(ctx, f.base, dir)
+	}()
+
+	store, err := f.chunkStore(ctx)
+	require.NoError(t, err)
+	_, err = f.gc(ctx, 0)
+	require.NoError(t, err)
+	countChunks := func() (n int) {
+		err := walk.ListR(ctx, store, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+			entries.ForObject(func(obj fs.Object) {
+				if isChunkHash(path.Base(obj.Remote())) {
+					n++
+				}
+			})
+			return nil
+		})
+		if err == fs.ErrorDirNotFound {
+			return 0
+		}
+		require.NoError(t, err)
+		return n
+	}
+	start := countChunks()
+
+	// The second file has the contents of the first with a prefix
+	chunkSize := int(f.opt.CDCChunkSize)
+	contents := random.String(32 * chunkSize)
+	obj1 := testPutFile(ctx, t, f, dir+"/file1", contents, "file1", true)
+	afterFirst := countChunks()
+	assert.Greater(t, afterFirst, start)
+	obj2 := testPutFile(ctx, t, f, dir+"/file2", "prefix"+contents, "file2", true)
+	afterSecond := countChunks()
+	assert.Less(t, afterSecond-afterFirst, (afterFirst-start)/2, "most chunks should be shared")
+
+	// Hash comes from metadata
+	if f.useMD5 {
+		sum, err := obj2.Hash(ctx, hash.MD5)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("prefix"+contents))), sum)
+	}
+
+	// Every chunk of a file has a reference
+	o2 := obj2.(*Object)
+	require.NoError(t, o2.readCDCManifest(ctx))
+	for _, chunk := range o2.cdc {
+		_, err := store.NewObject(ctx, chunk.refPath(o2.cdcRef))
+		require.NoError(t, err, chunk.Hash)
+	}
+
+	// Removing a file removes only the chunks it doesn't share
+	require.NoError(t, obj1.Remove(ctx))
+	afterRemove := countChunks()
+	assert.Less(t, afterRemove, afterSecond)
+	assert.Greater(t, afterRemove, start)
+	counts, err := f.gc(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counts[gcRemoved])
+	assert.Equal(t, int64(0), counts[gcRefsAdded])
+	assert.Equal(t, int64(0), counts[gcRefsRemoved])
+	ls, err := f.List(ctx, dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(ls))
+	assert.Equal(t, int64(len("prefix"+contents)), ls[0].Size())
+	buf, err := io.ReadAll(mustOpen(ctx, t, obj2))
+	require.NoError(t, err)
+	assert.Equal(t, "prefix"+contents, string(buf))
+
+	// A copy has its own references so outlives the original
+	if f.Features().Copy != nil {
+		obj3, err := f.Copy(ctx, obj2, dir+"/file3")
+		require.NoError(t, err)
+		require.NoError(t, obj2.Remove(ctx))
+		assert.Equal(t, afterRemove, countChunks())
+		buf, err = io.ReadAll(mustOpen(ctx, t, obj3))
+		require.NoError(t, err)
+		assert.Equal(t, "prefix"+contents, string(buf))
+		obj2 = obj3
+	}
+
+	// gc adds missing references and removes ones without a manifest
+	o2 = obj2.(*Object)
+	require.NoError(t, o2.readCDCManifest(ctx))
+	refObj, err := store.NewObject(ctx, o2.cdc[0].refPath(o2.cdcRef))
+	require.NoError(t, err)
+	require.NoError(t, refObj.Remove(ctx))
+	counts, err = f.gc(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counts[gcRefsAdded])
+	assert.Equal(t, int64(0), counts[gcRemoved])
+	require.NoError(t, o2.manifest.Remove(ctx))
+	require.NoError(t, o2.main.Remove(ctx))
+	counts, err = f.gc(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counts[gcRemoved])
+	assert.Equal(t, int64(0), counts[gcRefsRemoved])
+	assert.Greater(t, counts[gcKept], int64(0))
+	counts, err = f.gc(ctx, 0)
+	require.NoError(t, err)
+	assert.Greater(t, counts[gcRefsRemoved], int64(0))
+	assert.Equal(t, start, countChunks())
+
+	// Reusing an old chunk makes it young again
+	data := []byte(contents[:chunkSize])
+	chunk, _, err := f.storeChunk(ctx, store, data, nil)
+	require.NoError(t, err)
+	chunkObj, err := store.NewObject(ctx, chunk.path())
+	require.NoError(t, err)
+	err = chunkObj.SetModTime(ctx, time.Now().Add(-2*time.Hour))
+	if err == fs.ErrorCantSetModTime || err == fs.ErrorCantSetModTimeWithoutDelete {
+		t.Logf("can't age the chunk: %v", err)
+	} else {
+		require.NoError(t, err)
+	}
+	_, _, err = f.storeChunk(ctx, store, data, nil)
+	require.NoError(t, err)
+	counts, err = f.gc(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counts[gcRemoved])
+	assert.Equal(t, int64(1), counts[gcKept])
+	_, err = f.gc(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, start, countChunks())
+}
+
+func mustOpen(ctx context.Context, t *testing.T, obj fs.Object) io.Reader {
+	in, err := obj.Open(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = in.Close() })
+	return in
+}
+
 // InternalTest dispatches all internal tests
 func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("PutLarge", func(t *testing.T) {
@@ -934,6 +1081,9 @@ func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("MD5AllSlow", func(t *testing.T) {
 		testMD5AllSlow(t, f)
 	})
+	t.Run("CDCDedup", func(t *testing.T) {
+		testCDCDedup(t, f)
+	})
 }
 
 var _ fstests.InternalTester = (*Fs)(nil)
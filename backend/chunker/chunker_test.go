@@ -37,6 +37,7 @@ func TestIntegration(t *testing.T) {
 			"SetTier",
 			"Metadata",
 			"SetMetadata",
+			"RemoveMetadata",
 		},
 		UnimplementableFsMethods: []string{
 			"PublicLink",
@@ -60,3 +61,42 @@ func TestIntegration(t *testing.T) {
 	}
 	fstests.Run(t, &opt)
 }
+
+// TestIntegrationCDC runs integration tests in cdc chunk mode
+// overlaying a local temporary directory
+func TestIntegrationCDC(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	name := "TestChunkerCDC"
+	tempDir := filepath.Join(os.TempDir(), "rclone-chunker-test-cdc")
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:               name + ":",
+		NilObject:                (*chunker.Object)(nil),
+		SkipBadWindowsCharacters: !*UseBadChars,
+		UnimplementableObjectMethods: []string{
+			"MimeType",
+			"GetTier",
+			"SetTier",
+			"Metadata",
+			"SetMetadata",
+			"RemoveMetadata",
+		},
+		UnimplementableFsMethods: []string{
+			"PublicLink",
+			"OpenWriterAt",
+			"OpenChunkWriter",
+			"MergeDirs",
+			"DirCacheFlush",
+			"UserInfo",
+			"Disconnect",
+		},
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "chunker"},
+			{Name: name, Key: "remote", Value: tempDir},
+			{Name: name, Key: "chunk_mode", Value: "cdc"},
+			{Name: name, Key: "cdc_chunk_size", Value: "1k"},
+		},
+		QuickTestOK: true,
+	})
+}
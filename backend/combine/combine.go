@@ -1,10 +1,6 @@
 // Package combine implements a backend to combine multiple remotes in a directory tree
 package combine
 
-/*
-   Have API to add/remove branches in the combine
-*/
-
 import (
 	"context"
 	"errors"
@@ -31,6 +27,7 @@ func init() {
 		Name:        "combine",
 		Description: "Combine several remotes into one",
 		NewFs:       NewFs,
+		CommandHelp: commandHelp,
 		MetadataInfo: &fs.MetadataInfo{
 			Help: `Any metadata supported by the underlying remote is read and written.`,
 		},
@@ -49,9 +46,50 @@ Embedded spaces can be added using quotes
 
     "dir=remote:path with space" "dir2=remote2:path with space"
 
+This can be empty if root_upstreams or root_files is set.
+`,
+			Default: fs.SpaceSepList(nil),
+		}, {
+			Name: "root_upstreams",
+			Help: `Upstreams to create new top level directories on
+
+If set, directories can be created in the root of the combine remote.
+These should be in the form
+
+    glob=remote:path glob2=remote2:path
+
+A new top level directory is created as a subdirectory of the remote
+of a root upstream whose glob matches its name, chosen by root_policy.
+Use * as the glob to match any name.
+
+The subdirectories of these remotes are shown in the root along with
+the directories in upstreams.
 `,
-			Required: true,
-			Default:  fs.SpaceSepList(nil),
+			Default: fs.SpaceSepList(nil),
+		}, {
+			Name: "root_files",
+			Help: `Remote to store files in the root of the combine remote
+
+If set, files can be stored in the root of the combine remote, for
+example
+
+    drive:combined
+
+Only the files in this remote are shown in the root, so it can be the
+same remote as one of the root_upstreams.
+`,
+		}, {
+			Name:     "root_policy",
+			Help:     "Policy to choose the root upstream for a new top level directory.",
+			Default:  policyFirst,
+			Advanced: true,
+			Examples: []fs.OptionExample{{
+				Value: policyFirst,
+				Help:  "The first root upstream whose glob matches.",
+			}, {
+				Value: policyMfs,
+				Help:  "The matching root upstream with the most free space.",
+			}},
 		}},
 	}
 	fs.Register(fsi)
@@ -59,7 +97,10 @@ Embedded spaces can be added using quotes
 
 // Options defines the configuration for this backend
 type Options struct {
-	Upstreams fs.SpaceSepList `config:"upstreams"`
+	Upstreams     fs.SpaceSepList `config:"upstreams"`
+	RootUpstreams fs.SpaceSepList `config:"root_upstreams"`
+	RootFiles     string          `config:"root_files"`
+	RootPolicy    string          `config:"root_policy"`
 }
 
 // Fs represents a combine of upstreams
@@ -67,9 +108,13 @@ type Fs struct {
 	name      string               // name of this remote
 	features  *fs.Features         // optional features
 	opt       Options              // options for this Fs
+	m         configmap.Mapper     // config
 	root      string               // the path we are working on
 	hashSet   hash.Set             // common hashes
 	when      time.Time            // directory times
+	pools     []*pool              // root upstreams for new directories
+	rootFiles *upstream            // upstream for files in the root if set
+	mu        sync.RWMutex         // protects upstreams
 	upstreams map[string]*upstream // map of upstreams
 }
 
@@ -128,6 +173,7 @@ type upstream struct {
 	parent         *Fs
 	dir            string     // directory the upstream is mounted
 	pathAdjustment adjustment // how to fiddle with the path
+	placed         bool       // set if the directory is in a root upstream
 }
 
 // Create an upstream from the directory it is mounted on and the remote
@@ -161,14 +207,22 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 		return nil, err
 	}
 	// Backward compatible to old config
-	if len(opt.Upstreams) == 0 {
+	if len(opt.Upstreams) == 0 && len(opt.RootUpstreams) == 0 && opt.RootFiles == "" {
 		return nil, errors.New("combine can't point to an empty upstream - check the value of the upstreams setting")
 	}
-	for _, u := range opt.Upstreams {
+	for _, u := range append(append([]string{}, opt.Upstreams...), opt.RootUpstreams...) {
 		if strings.HasPrefix(u, name+":") {
 			return nil, errors.New("can't point combine remote at itself - check the value of the upstreams setting")
 		}
 	}
+	if strings.HasPrefix(opt.RootFiles, name+":") {
+		return nil, errors.New("can't point combine remote at itself - check the value of the root_files setting")
+	}
+	switch opt.RootPolicy {
+	case "", policyFirst, policyMfs:
+	default:
+		return nil, fmt.Errorf("unknown root_policy %q", opt.RootPolicy)
+	}
 	isDir := false
 	for strings.HasSuffix(root, "/") {
 		root = root[:len(root)-1]
@@ -179,6 +233,7 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 		name:      name,
 		root:      root,
 		opt:       *opt,
+		m:         m,
 		upstreams: make(map[string]*upstream, len(opt.Upstreams)),
 		when:      time.Now(),
 	}
@@ -188,19 +243,9 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 	for _, upstream := range opt.Upstreams {
 		upstream := upstream
 		g.Go(func() (err error) {
-			equal := strings.IndexRune(upstream, '=')
-			if equal < 0 {
-				return fmt.Errorf("no \"=\" in upstream definition %q", upstream)
-			}
-			dir, remote := upstream[:equal], upstream[equal+1:]
-			if dir == "" {
-				return fmt.Errorf("empty dir in upstream definition %q", upstream)
-			}
-			if remote == "" {
-				return fmt.Errorf("empty remote in upstream definition %q", upstream)
-			}
-			if strings.ContainsRune(dir, '/') {
-				return fmt.Errorf("dirs can't contain / (yet): %q", dir)
+			dir, remote, err := parseDefinition(upstream)
+			if err != nil {
+				return err
 			}
 			u, err := f.newUpstream(gCtx, dir, remote)
 			if err != nil {
@@ -216,10 +261,39 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 			return err
 		})
 	}
+	f.pools = make([]*pool, len(opt.RootUpstreams))
+	for i, def := range opt.RootUpstreams {
+		i, def := i, def
+		g.Go(func() (err error) {
+			f.pools[i], err = newPool(gCtx, def)
+			return err
+		})
+	}
+	if opt.RootFiles != "" {
+		g.Go(func() (err error) {
+			f.rootFiles, err = f.newUpstream(gCtx, "", opt.RootFiles)
+			return err
+		})
+	}
 	err = g.Wait()
 	if err != nil {
 		return nil, err
 	}
+	err = f.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// upstreams and root upstreams which the features must be common to
+	var uFss []fs.Fs
+	for _, u := range f.upstreams {
+		uFss = append(uFss, u.f)
+	}
+	for _, p := range f.pools {
+		uFss = append(uFss, p.f)
+	}
+	if f.rootFiles != nil {
+		uFss = append(uFss, f.rootFiles.f)
+	}
 	// check features
 	var features = (&fs.Features{
 		CaseInsensitive:          true,
@@ -241,9 +315,9 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 		PartialUploads:           true,
 	}).Fill(ctx, f)
 	canMove := true
-	for _, u := range f.upstreams {
-		features = features.Mask(ctx, u.f) // Mask all upstream fs
-		if !operations.CanServerSideMove(u.f) {
+	for _, uFs := range uFss {
+		features = features.Mask(ctx, uFs) // Mask all upstream fs
+		if !operations.CanServerSideMove(uFs) {
 			canMove = false
 		}
 	}
@@ -255,10 +329,10 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 	// Enable ListR when upstreams either support ListR or is local
 	// But not when all upstreams are local
 	if features.ListR == nil {
-		for _, u := range f.upstreams {
-			if u.f.Features().ListR != nil {
+		for _, uFs := range uFss {
+			if uFs.Features().ListR != nil {
 				features.ListR = f.ListR
-			} else if !u.f.Features().IsLocal {
+			} else if !uFs.Features().IsLocal {
 				features.ListR = nil
 				break
 			}
@@ -267,8 +341,8 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 
 	// Enable Purge when any upstreams support it
 	if features.Purge == nil {
-		for _, u := range f.upstreams {
-			if u.f.Features().Purge != nil {
+		for _, uFs := range uFss {
+			if uFs.Features().Purge != nil {
 				features.Purge = f.Purge
 				break
 			}
@@ -277,8 +351,8 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 
 	// Enable Shutdown when any upstreams support it
 	if features.Shutdown == nil {
-		for _, u := range f.upstreams {
-			if u.f.Features().Shutdown != nil {
+		for _, uFs := range uFss {
+			if uFs.Features().Shutdown != nil {
 				features.Shutdown = f.Shutdown
 				break
 			}
@@ -287,8 +361,8 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 
 	// Enable DirCacheFlush when any upstreams support it
 	if features.DirCacheFlush == nil {
-		for _, u := range f.upstreams {
-			if u.f.Features().DirCacheFlush != nil {
+		for _, uFs := range uFss {
+			if uFs.Features().DirCacheFlush != nil {
 				features.DirCacheFlush = f.DirCacheFlush
 				break
 			}
@@ -297,8 +371,8 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 
 	// Enable CleanUp when any upstreams support it
 	if features.CleanUp == nil {
-		for _, u := range f.upstreams {
-			if u.f.Features().CleanUp != nil {
+		for _, uFs := range uFss {
+			if uFs.Features().CleanUp != nil {
 				features.CleanUp = f.CleanUp
 				break
 			}
@@ -307,8 +381,8 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 
 	// Enable ChangeNotify when any upstreams support it
 	if features.ChangeNotify == nil {
-		for _, u := range f.upstreams {
-			if u.f.Features().ChangeNotify != nil {
+		for _, uFs := range uFss {
+			if uFs.Features().ChangeNotify != nil {
 				features.ChangeNotify = f.ChangeNotify
 				break
 			}
@@ -323,12 +397,12 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 	// Get common intersection of hashes
 	var hashSet hash.Set
 	var first = true
-	for _, u := range f.upstreams {
+	for _, uFs := range uFss {
 		if first {
-			hashSet = u.f.Hashes()
+			hashSet = uFs.Hashes()
 			first = false
 		} else {
-			hashSet = hashSet.Overlap(u.f.Hashes())
+			hashSet = hashSet.Overlap(uFs.Hashes())
 		}
 	}
 	f.hashSet = hashSet
@@ -341,6 +415,10 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 				// File doesn't exist or is a directory so return old f
 				return f, nil
 			}
+			if errors.Is(err, fs.ErrorDirNotFound) && len(f.pools) > 0 {
+				// Top level directory can be created in a root upstream
+				return f, nil
+			}
 			return nil, err
 		}
 
@@ -353,6 +431,9 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 		for _, u := range f.upstreams {
 			u.pathAdjustment = newAdjustment(f.root, u.dir)
 		}
+		if f.rootFiles != nil {
+			f.rootFiles.pathAdjustment = newAdjustment(f.root, "")
+		}
 		return f, fs.ErrorIsFile
 	}
 	return f, nil
@@ -361,7 +442,24 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (outFs fs
 // Run a function over all the upstreams in parallel
 func (f *Fs) multithread(ctx context.Context, fn func(context.Context, *upstream) error) error {
 	g, gCtx := errgroup.WithContext(ctx)
-	for _, u := range f.upstreams {
+	for _, u := range f.upstreamList() {
+		u := u
+		g.Go(func() (err error) {
+			return fn(gCtx, u)
+		})
+	}
+	return g.Wait()
+}
+
+// Run a function over all the upstreams and the root files upstream
+// in parallel
+func (f *Fs) multithreadAll(ctx context.Context, fn func(context.Context, *upstream) error) error {
+	g, gCtx := errgroup.WithContext(ctx)
+	upstreams := f.upstreamList()
+	if f.rootFiles != nil {
+		upstreams = append(upstreams, f.rootFiles)
+	}
+	for _, u := range upstreams {
 		u := u
 		g.Go(func() (err error) {
 			return fn(gCtx, u)
@@ -385,6 +483,8 @@ func join(elem ...string) string {
 // find the upstream for the remote passed in, returning the upstream and the adjusted path
 func (f *Fs) findUpstream(remote string) (u *upstream, uRemote string, err error) {
 	// defer log.Trace(remote, "")("f=%v, uRemote=%q, err=%v", &u, &uRemote, &err)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	for _, u := range f.upstreams {
 		uRemote, err = u.pathAdjustment.undo(remote)
 		if err == nil {
@@ -394,6 +494,35 @@ func (f *Fs) findUpstream(remote string) (u *upstream, uRemote string, err error
 	return nil, "", fmt.Errorf("combine for remote %q: %w", remote, fs.ErrorDirNotFound)
 }
 
+// findRootFile returns the root files upstream and the path in it if
+// remote is a file in the root of the combine remote which isn't the
+// name of an upstream
+func (f *Fs) findRootFile(remote string) (u *upstream, uRemote string, ok bool) {
+	if f.rootFiles == nil {
+		return nil, "", false
+	}
+	absPath := join(f.root, remote)
+	if absPath == "" || strings.ContainsRune(absPath, '/') {
+		return nil, "", false
+	}
+	f.mu.RLock()
+	_, found := f.upstreams[absPath]
+	f.mu.RUnlock()
+	if found {
+		return nil, "", false
+	}
+	return f.rootFiles, absPath, true
+}
+
+// findObjectUpstream finds the upstream for the object at remote
+// returning the upstream and the adjusted path
+func (f *Fs) findObjectUpstream(remote string) (u *upstream, uRemote string, err error) {
+	if u, uRemote, ok := f.findRootFile(remote); ok {
+		return u, uRemote, nil
+	}
+	return f.findUpstream(remote)
+}
+
 // Name of the remote (as passed into NewFs)
 func (f *Fs) Name() string {
 	return f.name
@@ -424,7 +553,11 @@ func (f *Fs) Rmdir(ctx context.Context, dir string) error {
 	if err != nil {
 		return err
 	}
-	return u.f.Rmdir(ctx, uRemote)
+	err = u.f.Rmdir(ctx, uRemote)
+	if err == nil && uRemote == "" {
+		f.forgetPlaced(u)
+	}
+	return err
 }
 
 // Hashes returns hash.HashNone to indicate remote hashing is unavailable
@@ -438,25 +571,31 @@ func (f *Fs) Mkdir(ctx context.Context, dir string) error {
 	if f.root == "" && dir == "" {
 		return nil
 	}
-	u, uRemote, err := f.findUpstream(dir)
+	u, uRemote, undo, err := f.findOrPlaceUpstream(ctx, dir)
 	if err != nil {
 		return err
 	}
-	return u.f.Mkdir(ctx, uRemote)
+	err = u.f.Mkdir(ctx, uRemote)
+	if err != nil {
+		undo()
+	}
+	return err
 }
 
 // MkdirMetadata makes the root directory of the Fs object
 func (f *Fs) MkdirMetadata(ctx context.Context, dir string, metadata fs.Metadata) (fs.Directory, error) {
-	u, uRemote, err := f.findUpstream(dir)
+	u, uRemote, undo, err := f.findOrPlaceUpstream(ctx, dir)
 	if err != nil {
 		return nil, err
 	}
 	do := u.f.Features().MkdirMetadata
 	if do == nil {
+		undo()
 		return nil, fs.ErrorNotImplemented
 	}
 	newDir, err := do(ctx, uRemote, metadata)
 	if err != nil {
+		undo()
 		return nil, err
 	}
 	entries := fs.DirEntries{newDir}
@@ -489,15 +628,27 @@ func (u *upstream) purge(ctx context.Context, dir string) (err error) {
 // Return an error if it doesn't exist
 func (f *Fs) Purge(ctx context.Context, dir string) error {
 	if f.root == "" && dir == "" {
-		return f.multithread(ctx, func(ctx context.Context, u *upstream) error {
-			return u.purge(ctx, "")
+		err := f.multithread(ctx, func(ctx context.Context, u *upstream) error {
+			err := u.purge(ctx, "")
+			if err == nil {
+				f.forgetPlaced(u)
+			}
+			return err
 		})
+		if err != nil || f.rootFiles == nil {
+			return err
+		}
+		return f.rootFiles.removeFiles(ctx)
 	}
 	u, uRemote, err := f.findUpstream(dir)
 	if err != nil {
 		return err
 	}
-	return u.purge(ctx, uRemote)
+	err = u.purge(ctx, uRemote)
+	if err == nil && uRemote == "" {
+		f.forgetPlaced(u)
+	}
+	return err
 }
 
 // Copy src to this remote using server-side copy operations.
@@ -516,18 +667,20 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 		return nil, fs.ErrorCantCopy
 	}
 
-	dstU, dstRemote, err := f.findUpstream(remote)
+	dstU, dstRemote, undo, err := f.findOrPlaceObjectUpstream(ctx, remote)
 	if err != nil {
 		return nil, err
 	}
 
 	do := dstU.f.Features().Copy
 	if do == nil {
+		undo()
 		return nil, fs.ErrorCantCopy
 	}
 
 	o, err := do(ctx, srcObj.Object, dstRemote)
 	if err != nil {
+		undo()
 		return nil, err
 	}
 
@@ -550,7 +703,7 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 		return nil, fs.ErrorCantMove
 	}
 
-	dstU, dstRemote, err := f.findUpstream(remote)
+	dstU, dstRemote, undo, err := f.findOrPlaceObjectUpstream(ctx, remote)
 	if err != nil {
 		return nil, err
 	}
@@ -560,6 +713,7 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if do == nil {
 		do = dstU.f.Features().Copy
 		if do == nil {
+			undo()
 			return nil, fs.ErrorCantMove
 		}
 		useCopy = true
@@ -567,6 +721,7 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 
 	o, err := do(ctx, srcObj.Object, dstRemote)
 	if err != nil {
+		undo()
 		return nil, err
 	}
 
@@ -597,23 +752,28 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 		return fs.ErrorCantDirMove
 	}
 
-	dstU, dstURemote, err := f.findUpstream(dstRemote)
+	srcU, srcURemote, err := srcFs.findUpstream(srcRemote)
 	if err != nil {
 		return err
 	}
 
-	srcU, srcURemote, err := srcFs.findUpstream(srcRemote)
+	dstU, dstURemote, undo, err := f.findOrPlaceUpstream(ctx, dstRemote)
 	if err != nil {
 		return err
 	}
 
 	do := dstU.f.Features().DirMove
 	if do == nil {
+		undo()
 		return fs.ErrorCantDirMove
 	}
 
 	fs.Logf(dstU.f, "srcU.f=%v, srcURemote=%q, dstURemote=%q", srcU.f, srcURemote, dstURemote)
-	return do(ctx, srcU.f, srcURemote, dstURemote)
+	err = do(ctx, srcU.f, srcURemote, dstURemote)
+	if err != nil {
+		undo()
+	}
+	return err
 }
 
 // ChangeNotify calls the passed function with a path
@@ -628,7 +788,11 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), ch <-chan time.Duration) {
 	var uChans []chan time.Duration
 
-	for _, u := range f.upstreams {
+	upstreams := f.upstreamList()
+	if f.rootFiles != nil {
+		upstreams = append(upstreams, f.rootFiles)
+	}
+	for _, u := range upstreams {
 		u := u
 		if do := u.f.Features().ChangeNotify; do != nil {
 			ch := make(chan time.Duration)
@@ -662,7 +826,7 @@ func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryT
 // as an optional interface
 func (f *Fs) DirCacheFlush() {
 	ctx := context.Background()
-	_ = f.multithread(ctx, func(ctx context.Context, u *upstream) error {
+	_ = f.multithreadAll(ctx, func(ctx context.Context, u *upstream) error {
 		if do := u.f.Features().DirCacheFlush; do != nil {
 			do()
 		}
@@ -672,7 +836,7 @@ func (f *Fs) DirCacheFlush() {
 
 func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, stream bool, options ...fs.OpenOption) (fs.Object, error) {
 	srcPath := src.Remote()
-	u, uRemote, err := f.findUpstream(srcPath)
+	u, uRemote, undo, err := f.findOrPlaceObjectUpstream(ctx, srcPath)
 	if err != nil {
 		return nil, err
 	}
@@ -684,6 +848,7 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, stream bo
 		o, err = u.f.Put(ctx, in, uSrc, options...)
 	}
 	if err != nil {
+		undo()
 		return nil, err
 	}
 	return u.newObject(o), nil
@@ -696,10 +861,10 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, stream bo
 // nil and the error
 func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	o, err := f.NewObject(ctx, src.Remote())
-	switch err {
-	case nil:
+	switch {
+	case err == nil:
 		return o, o.Update(ctx, in, src, options...)
-	case fs.ErrorObjectNotFound:
+	case err == fs.ErrorObjectNotFound || (errors.Is(err, fs.ErrorDirNotFound) && len(f.pools) > 0):
 		return f.put(ctx, in, src, false, options...)
 	default:
 		return nil, err
@@ -713,10 +878,10 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 // nil and the error
 func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	o, err := f.NewObject(ctx, src.Remote())
-	switch err {
-	case nil:
+	switch {
+	case err == nil:
 		return o, o.Update(ctx, in, src, options...)
-	case fs.ErrorObjectNotFound:
+	case err == fs.ErrorObjectNotFound || (errors.Is(err, fs.ErrorDirNotFound) && len(f.pools) > 0):
 		return f.put(ctx, in, src, true, options...)
 	default:
 		return nil, err
@@ -733,7 +898,7 @@ func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
 		Free:    new(int64),
 		Objects: new(int64),
 	}
-	for _, u := range f.upstreams {
+	for _, u := range f.upstreamList() {
 		doAbout := u.f.Features().About
 		if doAbout == nil {
 			continue
@@ -811,11 +976,24 @@ func (u *upstream) wrapEntries(ctx context.Context, entries fs.DirEntries) (fs.D
 func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
 	// defer log.Trace(f, "dir=%q", dir)("entries = %v, err=%v", &entries, &err)
 	if f.root == "" && dir == "" {
-		entries = make(fs.DirEntries, 0, len(f.upstreams))
-		for combineDir := range f.upstreams {
-			d := fs.NewLimitedDirWrapper(combineDir, fs.NewDir(combineDir, f.when))
+		// pick up directories made in the root upstreams elsewhere
+		err = f.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		upstreams := f.upstreamList()
+		entries = make(fs.DirEntries, 0, len(upstreams))
+		for _, u := range upstreams {
+			d := fs.NewLimitedDirWrapper(u.dir, fs.NewDir(u.dir, f.when))
 			entries = append(entries, d)
 		}
+		if f.rootFiles != nil {
+			files, err := f.rootFiles.listFiles(ctx)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, files...)
+		}
 		return entries, nil
 	}
 	u, uRemote, err := f.findUpstream(dir)
@@ -829,6 +1007,30 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 	return u.wrapEntries(ctx, entries)
 }
 
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using old versions of the objects.
+//
+// The root lists the upstreams as they are now.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	if f.root == "" && dir == "" {
+		return f.List(ctx, dir)
+	}
+	u, uRemote, err := f.findUpstream(dir)
+	if err != nil {
+		return nil, err
+	}
+	// upstreams may have been added since the features were set
+	listAt := u.f.Features().ListAt
+	if listAt == nil {
+		return nil, fmt.Errorf("%v can't list old versions: %w", u.f, fs.ErrorNotImplemented)
+	}
+	entries, err = listAt(ctx, uRemote, at)
+	if err != nil {
+		return nil, err
+	}
+	return u.wrapEntries(ctx, entries)
+}
+
 // ListR lists the objects and directories of the Fs starting
 // from dir recursively into out.
 //
@@ -894,7 +1096,7 @@ func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (
 
 // NewObject creates a new remote combine file object
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
-	u, uRemote, err := f.findUpstream(remote)
+	u, uRemote, err := f.findObjectUpstream(remote)
 	if err != nil {
 		return nil, err
 	}
@@ -911,7 +1113,11 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 // Precision is the greatest Precision of all upstreams
 func (f *Fs) Precision() time.Duration {
 	var greatestPrecision time.Duration
-	for _, u := range f.upstreams {
+	upstreams := f.upstreamList()
+	if f.rootFiles != nil {
+		upstreams = append(upstreams, f.rootFiles)
+	}
+	for _, u := range upstreams {
 		uPrecision := u.f.Precision()
 		if uPrecision > greatestPrecision {
 			greatestPrecision = uPrecision
@@ -923,7 +1129,7 @@ func (f *Fs) Precision() time.Duration {
 // Shutdown the backend, closing any background tasks and any
 // cached connections.
 func (f *Fs) Shutdown(ctx context.Context) error {
-	return f.multithread(ctx, func(ctx context.Context, u *upstream) error {
+	return f.multithreadAll(ctx, func(ctx context.Context, u *upstream) error {
 		if do := u.f.Features().Shutdown; do != nil {
 			return do(ctx)
 		}
@@ -933,7 +1139,7 @@ func (f *Fs) Shutdown(ctx context.Context) error {
 
 // PublicLink generates a public link to the remote path (usually readable by anyone)
 func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration, unlink bool) (string, error) {
-	u, uRemote, err := f.findUpstream(remote)
+	u, uRemote, err := f.findObjectUpstream(remote)
 	if err != nil {
 		return "", err
 	}
@@ -954,16 +1160,21 @@ func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration,
 // exists.
 func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	srcPath := src.Remote()
-	u, uRemote, err := f.findUpstream(srcPath)
+	u, uRemote, undo, err := f.findOrPlaceObjectUpstream(ctx, srcPath)
 	if err != nil {
 		return nil, err
 	}
 	do := u.f.Features().PutUnchecked
 	if do == nil {
+		undo()
 		return nil, fs.ErrorNotImplemented
 	}
 	uSrc := fs.NewOverrideRemote(src, uRemote)
-	return do(ctx, in, uSrc, options...)
+	o, err := do(ctx, in, uSrc, options...)
+	if err != nil {
+		undo()
+	}
+	return o, err
 }
 
 // MergeDirs merges the contents of all the directories passed
@@ -1016,7 +1227,7 @@ func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) e
 // Implement this if you have a way of emptying the trash or
 // otherwise cleaning up old versions of files.
 func (f *Fs) CleanUp(ctx context.Context) error {
-	return f.multithread(ctx, func(ctx context.Context, u *upstream) error {
+	return f.multithreadAll(ctx, func(ctx context.Context, u *upstream) error {
 		if do := u.f.Features().CleanUp; do != nil {
 			return do(ctx)
 		}
@@ -1030,15 +1241,20 @@ func (f *Fs) CleanUp(ctx context.Context) error {
 //
 // It truncates any existing object
 func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
-	u, uRemote, err := f.findUpstream(remote)
+	u, uRemote, undo, err := f.findOrPlaceObjectUpstream(ctx, remote)
 	if err != nil {
 		return nil, err
 	}
 	do := u.f.Features().OpenWriterAt
 	if do == nil {
+		undo()
 		return nil, fs.ErrorNotImplemented
 	}
-	return do(ctx, uRemote, size)
+	w, err := do(ctx, uRemote, size)
+	if err != nil {
+		undo()
+	}
+	return w, err
 }
 
 // Object describes a wrapped Object
@@ -1130,6 +1346,17 @@ func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
 	return do.SetMetadata(ctx, metadata)
 }
 
+// RemoveMetadata removes user metadata keys from an Object
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (o *Object) RemoveMetadata(ctx context.Context, keys []string) error {
+	do, ok := o.Object.(fs.RemoveMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.RemoveMetadata(ctx, keys)
+}
+
 // SetTier performs changing storage tier of the Object if
 // multiple storage classes supported
 func (o *Object) SetTier(tier string) error {
@@ -1152,6 +1379,7 @@ var (
 	_ fs.ChangeNotifier  = (*Fs)(nil)
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.ListAter        = (*Fs)(nil)
 	_ fs.Shutdowner      = (*Fs)(nil)
 	_ fs.PublicLinker    = (*Fs)(nil)
 	_ fs.PutUncheckeder  = (*Fs)(nil)
@@ -1160,5 +1388,6 @@ var (
 	_ fs.MkdirMetadataer = (*Fs)(nil)
 	_ fs.CleanUpper      = (*Fs)(nil)
 	_ fs.OpenWriterAter  = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
 	_ fs.FullObject      = (*Object)(nil)
 )
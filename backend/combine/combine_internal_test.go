@@ -1,10 +1,21 @@
 package combine
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAdjustmentDo(t *testing.T) {
@@ -92,3 +103,144 @@ func TestAdjustmentUndo(t *testing.T) {
 	}
 
 }
+
+func listRoot(ctx context.Context, t *testing.T, f fs.Fs) (dirs []string) {
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		dirs = append(dirs, entry.Remote())
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+func TestRootPlacement(t *testing.T) {
+	ctx := context.Background()
+	fixed, poolA, poolB := t.TempDir(), t.TempDir(), t.TempDir()
+	m := configmap.Simple{
+		"upstreams":      "fixed=" + fixed,
+		"root_upstreams": "a*=" + poolA + " *=" + poolB,
+	}
+	f, err := NewFs(ctx, "TestRootPlacement", "", m)
+	require.NoError(t, err)
+
+	// new top level directories go to the first matching root upstream
+	require.NoError(t, f.Mkdir(ctx, "apple"))
+	assert.DirExists(t, filepath.Join(poolA, "apple"))
+	src := object.NewStaticObjectInfo("banana/file.txt", fstest.Time("2001-02-03T04:05:06Z"), 5, true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewBufferString("hello"), src)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(poolB, "banana", "file.txt"))
+	assert.Equal(t, []string{"apple", "banana", "fixed"}, listRoot(ctx, t, f))
+
+	// directories made elsewhere are discovered
+	require.NoError(t, os.Mkdir(filepath.Join(poolB, "cherry"), 0777))
+	assert.Equal(t, []string{"apple", "banana", "cherry", "fixed"}, listRoot(ctx, t, f))
+
+	// removing a placed directory removes it from the root
+	require.NoError(t, f.Rmdir(ctx, "apple"))
+	assert.Equal(t, []string{"banana", "cherry", "fixed"}, listRoot(ctx, t, f))
+
+	// a new remote sees the existing directories
+	f2, err := NewFs(ctx, "TestRootPlacement2", "", m)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"banana", "cherry", "fixed"}, listRoot(ctx, t, f2))
+	o, err := f2.NewObject(ctx, "banana/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), o.Size())
+}
+
+func TestRootFiles(t *testing.T) {
+	ctx := context.Background()
+	pool := t.TempDir()
+	m := configmap.Simple{
+		"root_upstreams": "*=" + pool,
+		"root_files":     pool,
+	}
+	f, err := NewFs(ctx, "TestRootFiles", "", m)
+	require.NoError(t, err)
+
+	// files in the root go to root_files and directories to the root upstreams
+	src := object.NewStaticObjectInfo("file.txt", fstest.Time("2001-02-03T04:05:06Z"), 5, true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewBufferString("hello"), src)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(pool, "file.txt"))
+	require.NoError(t, f.Mkdir(ctx, "dir"))
+	assert.DirExists(t, filepath.Join(pool, "dir"))
+	assert.Equal(t, []string{"dir", "file.txt"}, listRoot(ctx, t, f))
+
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "file.txt", o.Remote())
+	assert.Equal(t, int64(5), o.Size())
+
+	// files can be moved in and out of the root
+	o, err = f.Features().Move(ctx, o, "dir/file.txt")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(pool, "dir", "file.txt"))
+	_, err = f.Features().Move(ctx, o, "moved.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir", "moved.txt"}, listRoot(ctx, t, f))
+
+	// a root pointing at a file in the root is found
+	_, err = NewFs(ctx, "TestRootFiles", "moved.txt", m)
+	assert.Equal(t, fs.ErrorIsFile, err)
+
+	// without root_files files can't be put in the root
+	delete(m, "root_files")
+	f, err = NewFs(ctx, "TestRootFilesUnset", "", m)
+	require.NoError(t, err)
+	_, err = f.Put(ctx, bytes.NewBufferString("hello"), src)
+	assert.ErrorContains(t, err, "without root_files")
+}
+
+func TestRootPolicyMfs(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m := configmap.Simple{
+		"root_upstreams": "*=" + filepath.Join(dir, "a") + " *=" + filepath.Join(dir, "b"),
+		"root_policy":    "mfs",
+	}
+	f, err := NewFs(ctx, "TestRootPolicyMfs", "", m)
+	require.NoError(t, err)
+	// both are on the same disk so either can be chosen
+	require.NoError(t, f.Mkdir(ctx, "new"))
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	m["root_policy"] = "bogus"
+	_, err = NewFs(ctx, "TestRootPolicyBogus", "", m)
+	assert.ErrorContains(t, err, "unknown root_policy")
+}
+
+func TestCommandAddRemove(t *testing.T) {
+	ctx := context.Background()
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	m := configmap.Simple{
+		"upstreams": "dir1=" + dir1,
+	}
+	fsys, err := NewFs(ctx, "TestCommandAddRemove", "", m)
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+
+	_, err = f.Command(ctx, "add", []string{"dir2=" + dir2}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir1", "dir2"}, listRoot(ctx, t, f))
+	assert.Equal(t, fs.SpaceSepList{"dir1=" + dir1, "dir2=" + dir2}.String(), m["upstreams"])
+
+	out, err := f.Command(ctx, "upstreams", nil, nil)
+	require.NoError(t, err)
+	assert.Len(t, out, 2)
+
+	_, err = f.Command(ctx, "add", []string{"dir2=" + dir1}, nil)
+	assert.ErrorContains(t, err, "duplicate")
+
+	_, err = f.Command(ctx, "remove", []string{"dir1"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir2"}, listRoot(ctx, t, f))
+	assert.Equal(t, fs.SpaceSepList{"dir2=" + dir2}.String(), m["upstreams"])
+
+	_, err = f.Command(ctx, "remove", []string{"dir1"}, nil)
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+}
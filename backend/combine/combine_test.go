@@ -82,6 +82,27 @@ func TestMixed(t *testing.T) {
 	})
 }
 
+func TestRootUpstreams(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	dirs := MakeTestDirs(t, 2)
+	upstreams := "dir1=" + dirs[0]
+	name := "TestCombineRootUpstreams"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":placed",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "combine"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "root_upstreams", Value: "*=" + dirs[1]},
+			{Name: name, Key: "root_files", Value: dirs[1]},
+		},
+		QuickTestOK:                  true,
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+	})
+}
+
 // MakeTestDirs makes directories in /tmp for testing
 func MakeTestDirs(t *testing.T, n int) (dirs []string) {
 	for i := 1; i <= n; i++ {
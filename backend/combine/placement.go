@@ -0,0 +1,417 @@
+package combine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+)
+
+// Placement policies for new top level directories
+const (
+	policyFirst = "first" // the first matching root upstream
+	policyMfs   = "mfs"   // the matching root upstream with the most free space
+)
+
+// pool is a root upstream which new top level directories can be
+// created on
+type pool struct {
+	glob   string // directory names this pool takes
+	remote string // remote:path the directories are created in
+	f      fs.Fs
+}
+
+// parseDefinition splits a "key=remote:path" definition
+func parseDefinition(def string) (key, remote string, err error) {
+	equal := strings.IndexRune(def, '=')
+	if equal < 0 {
+		return "", "", fmt.Errorf("no \"=\" in upstream definition %q", def)
+	}
+	key, remote = def[:equal], def[equal+1:]
+	if key == "" {
+		return "", "", fmt.Errorf("empty dir in upstream definition %q", def)
+	}
+	if remote == "" {
+		return "", "", fmt.Errorf("empty remote in upstream definition %q", def)
+	}
+	if strings.ContainsRune(key, '/') {
+		return "", "", fmt.Errorf("dirs can't contain / (yet): %q", key)
+	}
+	return key, remote, nil
+}
+
+// newPool makes a pool from a "glob=remote:path" definition
+func newPool(ctx context.Context, def string) (*pool, error) {
+	glob, remote, err := parseDefinition(def)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, fmt.Errorf("bad glob in root upstream definition %q: %w", def, err)
+	}
+	pFs, err := cache.Get(ctx, remote)
+	if err == fs.ErrorIsFile {
+		return nil, fmt.Errorf("root upstream must be a directory %q: %w", remote, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create root upstream %q: %w", remote, err)
+	}
+	p := &pool{
+		glob:   glob,
+		remote: remote,
+		f:      pFs,
+	}
+	cache.PinUntilFinalized(p.f, p)
+	return p, nil
+}
+
+// matches returns true if the pool takes directories called dir
+func (p *pool) matches(dir string) bool {
+	match, _ := path.Match(p.glob, dir)
+	return match
+}
+
+// upstreamList returns a snapshot of the upstreams
+func (f *Fs) upstreamList() []*upstream {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	upstreams := make([]*upstream, 0, len(f.upstreams))
+	for _, u := range f.upstreams {
+		upstreams = append(upstreams, u)
+	}
+	return upstreams
+}
+
+// topDir returns the top level directory remote is in
+func (f *Fs) topDir(remote string) string {
+	absPath := join(f.root, remote)
+	if i := strings.IndexRune(absPath, '/'); i >= 0 {
+		absPath = absPath[:i]
+	}
+	return absPath
+}
+
+// discover adds the directories found in the pools as upstreams
+func (f *Fs) discover(ctx context.Context) error {
+	for _, p := range f.pools {
+		entries, err := p.f.List(ctx, "")
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list root upstream %q: %w", p.remote, err)
+		}
+		for _, entry := range entries {
+			dir, ok := entry.(fs.Directory)
+			if !ok {
+				continue
+			}
+			name := path.Base(dir.Remote())
+			f.mu.RLock()
+			_, found := f.upstreams[name]
+			f.mu.RUnlock()
+			if found {
+				continue
+			}
+			if _, _, err = f.addPlaced(ctx, name, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addPlaced adds the upstream for the directory name on the pool p
+// unless it already exists
+//
+// It returns the upstream and whether it was added.
+func (f *Fs) addPlaced(ctx context.Context, name string, p *pool) (*upstream, bool, error) {
+	u, err := f.newUpstream(ctx, name, fspath.JoinRootPath(p.remote, name))
+	if err != nil {
+		return nil, false, err
+	}
+	u.placed = true
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if existing, found := f.upstreams[name]; found {
+		return existing, false, nil
+	}
+	f.upstreams[name] = u
+	return u, true, nil
+}
+
+// choosePool chooses the pool to create the directory name on
+func (f *Fs) choosePool(ctx context.Context, name string) (*pool, error) {
+	var candidates []*pool
+	for _, p := range f.pools {
+		if p.matches(name) {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("combine for directory %q: no upstream and no root upstream matches: %w", name, fs.ErrorDirNotFound)
+	}
+	if f.opt.RootPolicy != policyMfs || len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	var (
+		best     *pool
+		bestFree int64 = -1
+	)
+	for _, p := range candidates {
+		do := p.f.Features().About
+		if do == nil {
+			continue
+		}
+		usage, err := do(ctx)
+		if err != nil {
+			fs.Debugf(f, "Ignoring root upstream %q: failed to read free space: %v", p.remote, err)
+			continue
+		}
+		if usage.Free != nil && *usage.Free > bestFree {
+			best, bestFree = p, *usage.Free
+		}
+	}
+	if best == nil {
+		fs.Debugf(f, "No free space known for root upstreams - using the first")
+		return candidates[0], nil
+	}
+	return best, nil
+}
+
+// findOrPlaceUpstream finds the upstream for the remote passed in,
+// creating the top level directory on a pool if it doesn't exist.
+//
+// It returns the upstream, the adjusted path and a function to call if
+// the write to the new upstream fails.
+func (f *Fs) findOrPlaceUpstream(ctx context.Context, remote string) (u *upstream, uRemote string, undo func(), err error) {
+	undo = func() {}
+	u, uRemote, err = f.findUpstream(remote)
+	if err == nil || len(f.pools) == 0 {
+		return u, uRemote, undo, err
+	}
+	name := f.topDir(remote)
+	if name == "" {
+		return nil, "", undo, err
+	}
+	p, err := f.choosePool(ctx, name)
+	if err != nil {
+		return nil, "", undo, err
+	}
+	u, added, err := f.addPlaced(ctx, name, p)
+	if err != nil {
+		return nil, "", undo, err
+	}
+	if added {
+		fs.Infof(f, "Creating top level directory %q on %q", name, p.remote)
+		undo = func() {
+			f.forgetPlaced(u)
+		}
+	}
+	uRemote, err = u.pathAdjustment.undo(remote)
+	if err != nil {
+		undo()
+		return nil, "", func() {}, err
+	}
+	return u, uRemote, undo, nil
+}
+
+// findOrPlaceObjectUpstream is like findOrPlaceUpstream for the
+// object at remote, except that objects in the root go in the root
+// files upstream.
+func (f *Fs) findOrPlaceObjectUpstream(ctx context.Context, remote string) (u *upstream, uRemote string, undo func(), err error) {
+	if u, uRemote, ok := f.findRootFile(remote); ok {
+		return u, uRemote, func() {}, nil
+	}
+	if absPath := join(f.root, remote); absPath != "" && !strings.ContainsRune(absPath, '/') {
+		if _, _, err := f.findUpstream(remote); err != nil {
+			return nil, "", func() {}, fmt.Errorf("can't store file %q in the root without root_files set: %w", absPath, err)
+		}
+	}
+	return f.findOrPlaceUpstream(ctx, remote)
+}
+
+// listFiles lists the files in the root of the root files upstream u
+func (u *upstream) listFiles(ctx context.Context) (fs.DirEntries, error) {
+	entries, err := u.f.List(ctx, "")
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list root files %q: %w", fs.ConfigString(u.f), err)
+	}
+	files := make(fs.DirEntries, 0, len(entries))
+	for _, entry := range entries {
+		if o, ok := entry.(fs.Object); ok {
+			files = append(files, u.newObject(o))
+		}
+	}
+	return files, nil
+}
+
+// removeFiles removes the files in the root of the root files
+// upstream u leaving any directories alone as they may be in a root
+// upstream
+func (u *upstream) removeFiles(ctx context.Context) error {
+	files, err := u.listFiles(ctx)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := file.(fs.Object).Remove(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetPlaced removes u if it is a top level directory in a root
+// upstream which has been removed
+func (f *Fs) forgetPlaced(u *upstream) {
+	if !u.placed {
+		return
+	}
+	f.mu.Lock()
+	if f.upstreams[u.dir] == u {
+		delete(f.upstreams, u.dir)
+	}
+	f.mu.Unlock()
+}
+
+// addUpstream adds the upstream from a "dir=remote:path" definition
+// and saves it in the config
+func (f *Fs) addUpstream(ctx context.Context, def string) error {
+	dir, remote, err := parseDefinition(def)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(remote, f.name+":") {
+		return errors.New("can't point combine remote at itself")
+	}
+	u, err := f.newUpstream(ctx, dir, remote)
+	if err != nil {
+		return err
+	}
+	if common := f.hashSet.Overlap(u.f.Hashes()); common != f.hashSet {
+		fs.Logf(f, "Upstream %q doesn't support all the hashes of this remote", remote)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if existing, found := f.upstreams[dir]; found && !existing.placed {
+		return fmt.Errorf("duplicate directory name %q", dir)
+	}
+	f.upstreams[dir] = u
+	f.opt.Upstreams = append(f.opt.Upstreams, def)
+	f.m.Set("upstreams", f.opt.Upstreams.String())
+	return nil
+}
+
+// removeUpstream removes the upstream for dir and saves the change in
+// the config
+func (f *Fs) removeUpstream(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, found := f.upstreams[dir]
+	if !found {
+		return fmt.Errorf("no upstream for directory %q: %w", dir, fs.ErrorDirNotFound)
+	}
+	if u.placed {
+		return fmt.Errorf("directory %q is in a root upstream - remove the directory instead", dir)
+	}
+	delete(f.upstreams, dir)
+	var upstreams fs.SpaceSepList
+	for _, def := range f.opt.Upstreams {
+		if key, _, err := parseDefinition(def); err != nil || key != dir {
+			upstreams = append(upstreams, def)
+		}
+	}
+	f.opt.Upstreams = upstreams
+	f.m.Set("upstreams", f.opt.Upstreams.String())
+	return nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "upstreams",
+	Short: "List the upstreams",
+	Long: `This lists the top level directories and the remotes they are on.
+
+    rclone backend upstreams combine:
+
+Directories created in the root upstreams are marked with "placed".
+`,
+}, {
+	Name:  "add",
+	Short: "Add upstreams",
+	Long: `This adds upstreams in the same form as the upstreams option
+and saves them in the config.
+
+    rclone backend add combine: dir=remote:path "dir2=remote2:path with space"
+
+It doesn't create or copy any data. Upstreams added should support the
+same hashes and features as the others as the features of the combine
+remote aren't changed until it is next created.
+`,
+}, {
+	Name:  "remove",
+	Short: "Remove upstreams",
+	Long: `This removes the upstreams for the top level directories given
+and saves the change in the config.
+
+    rclone backend remove combine: dir dir2
+
+It doesn't delete any data.
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "upstreams":
+		var lines []string
+		for _, u := range f.upstreamList() {
+			line := u.dir + "=" + fs.ConfigString(u.f)
+			if u.placed {
+				line += " (placed)"
+			}
+			lines = append(lines, line)
+		}
+		sort.Strings(lines)
+		return lines, nil
+	case "add":
+		if len(arg) == 0 {
+			return nil, errors.New("need at least one dir=remote:path argument")
+		}
+		for _, def := range arg {
+			if err := f.addUpstream(ctx, def); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case "remove":
+		if len(arg) == 0 {
+			return nil, errors.New("need at least one directory argument")
+		}
+		for _, dir := range arg {
+			if err := f.removeUpstream(dir); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
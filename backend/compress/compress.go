@@ -45,6 +45,7 @@ const (
 	minCompressionRatio = 1.1
 
 	gzFileExt           = ".gz"
+	zstdFileExt         = ".zst"
 	metaFileExt         = ".json"
 	uncompressedFileExt = ".bin"
 )
@@ -53,6 +54,7 @@ const (
 const (
 	Uncompressed = 0
 	Gzip         = 2
+	Zstd         = 3
 )
 
 var nameRegexp = regexp.MustCompile(`^(.+?)\.([A-Za-z0-9-_]{11})$`)
@@ -64,6 +66,9 @@ func init() {
 		{ // Default compression mode options {
 			Value: "gzip",
 			Help:  "Standard gzip compression with fastest parameters.",
+		}, {
+			Value: "zstd",
+			Help:  "Zstandard compression which is faster and can be read efficiently from any position.",
 		},
 	}
 
@@ -86,15 +91,21 @@ func init() {
 			Examples: compressionModeOptions,
 		}, {
 			Name: "level",
-			Help: `GZIP compression level (-2 to 9).
+			Help: `Compression level.
+
+Generally -1 (default) is recommended for all modes.
 
-Generally -1 (default, equivalent to 5) is recommended.
+For gzip this is -2 to 9. -1 is equivalent to 5.
 Levels 1 to 9 increase compression at the cost of speed. Going past 6 
 generally offers very little return.
 
 Level -2 uses Huffman encoding only. Only use if you know what you
 are doing.
-Level 0 turns off compression.`,
+Level 0 turns off compression.
+
+For zstd this is 1 to 22 and -1 is equivalent to 3. The levels are
+mapped to the nearest of the 4 levels supported: 1 (fastest), 3,
+6 and 10 (best compression).`,
 			Default:  sgzip.DefaultCompression,
 			Advanced: true,
 		}, {
@@ -216,6 +227,8 @@ func compressionModeFromName(name string) int {
 	switch name {
 	case "gzip":
 		return Gzip
+	case "zstd":
+		return Zstd
 	default:
 		return Uncompressed
 	}
@@ -258,7 +271,7 @@ func processFileName(compressedFileName string) (origFileName string, extension
 	if err != nil {
 		return "", "", 0, errors.New("could not decode size")
 	}
-	return match[1], gzFileExt, size, nil
+	return match[1], extension, size, nil
 }
 
 // Generates the file name for a metadata file
@@ -283,10 +296,13 @@ func unwrapMetadataFile(filename string) (string, bool) {
 
 // makeDataName generates the file name for a data file with specified compression mode
 func makeDataName(remote string, size int64, mode int) (newRemote string) {
-	if mode != Uncompressed {
-		newRemote = remote + "." + int64ToBase64(size) + gzFileExt
-	} else {
+	switch mode {
+	case Uncompressed:
 		newRemote = remote + uncompressedFileExt
+	case Zstd:
+		newRemote = remote + "." + int64ToBase64(size) + zstdFileExt
+	default:
+		newRemote = remote + "." + int64ToBase64(size) + gzFileExt
 	}
 	return newRemote
 }
@@ -359,6 +375,34 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 	return f.processEntries(entries)
 }
 
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using old versions of the objects.
+//
+// The objects are given the versions of their metadata objects from
+// the same listing so the old data is decompressed correctly.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	entries, err = f.Fs.Features().ListAt(ctx, dir, at)
+	if err != nil {
+		return nil, err
+	}
+	metaObjects := make(map[string]fs.Object)
+	for _, entry := range entries {
+		if o, ok := entry.(fs.Object); ok && isMetadataFile(o.Remote()) {
+			metaObjects[o.Remote()] = o
+		}
+	}
+	entries, err = f.processEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if o, ok := entry.(*Object); ok {
+			o.mo = metaObjects[o.moName]
+		}
+	}
+	return entries, nil
+}
+
 // ListR lists the objects and directories of the Fs starting
 // from dir recursively into out.
 //
@@ -397,7 +441,7 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 		return nil, fmt.Errorf("error decoding metadata: %w", err)
 	}
 	// Create our Object
-	o, err := f.Fs.NewObject(ctx, makeDataName(remote, meta.CompressionMetadata.Size, meta.Mode))
+	o, err := f.Fs.NewObject(ctx, makeDataName(remote, meta.Size, meta.Mode))
 	if err != nil {
 		return nil, err
 	}
@@ -464,8 +508,37 @@ func (f *Fs) verifyObjectHash(ctx context.Context, o fs.Object, hasher *hash.Mul
 type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error)
 
 type compressionResult struct {
-	err  error
-	meta sgzip.GzipMetadata
+	err          error
+	size         int64 // size of the uncompressed data
+	meta         sgzip.GzipMetadata
+	seekableMeta *SeekableMetadata
+}
+
+// newCompressor makes a writer to compress to w in the mode of f
+//
+// Call result with the writer once it has been closed to read the
+// metadata.
+func (f *Fs) newCompressor(w io.Writer) (c io.WriteCloser, result func() compressionResult, err error) {
+	switch f.mode {
+	case Zstd:
+		z, err := newZstdWriter(w, f.opt.CompressionLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		return z, func() compressionResult {
+			meta := z.MetaData()
+			return compressionResult{size: meta.Size, seekableMeta: &meta}
+		}, nil
+	default:
+		gz, err := sgzip.NewWriterLevel(w, f.opt.CompressionLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() compressionResult {
+			meta := gz.MetaData()
+			return compressionResult{size: meta.Size, meta: meta}
+		}, nil
+	}
 }
 
 // replicating some of operations.Rcat functionality because we want to support remotes without streaming
@@ -528,9 +601,9 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	pipeReader, pipeWriter := io.Pipe()
 	results := make(chan compressionResult)
 	go func() {
-		gz, err := sgzip.NewWriterLevel(pipeWriter, f.opt.CompressionLevel)
+		gz, result, err := f.newCompressor(pipeWriter)
 		if err != nil {
-			results <- compressionResult{err: err, meta: sgzip.GzipMetadata{}}
+			results <- compressionResult{err: err}
 			return
 		}
 		_, err = io.Copy(gz, in)
@@ -548,7 +621,9 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 				err = closeErr
 			}
 		}
-		results <- compressionResult{err: err, meta: gz.MetaData()}
+		res := result()
+		res.err = err
+		results <- res
 	}()
 	wrappedIn := wrap(bufio.NewReaderSize(pipeReader, bufferSize)) // Probably no longer needed as sgzip has it's own buffering
 
@@ -595,7 +670,8 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	}
 
 	// Generate metadata
-	meta := newMetadata(result.meta.Size, f.mode, result.meta, hex.EncodeToString(metaHasher.Sum(nil)), mimeType)
+	meta := newMetadata(result.size, f.mode, result.meta, hex.EncodeToString(metaHasher.Sum(nil)), mimeType)
+	meta.SeekableMetadata = result.seekableMeta
 
 	// Check the hashes of the compressed data if we were comparing them
 	if ht != hash.None && hasher != nil {
@@ -1064,6 +1140,7 @@ type ObjectMetadata struct {
 	MD5                 string // MD5 hash of the file.
 	MimeType            string // Mime type of the file
 	CompressionMetadata sgzip.GzipMetadata
+	SeekableMetadata    *SeekableMetadata `json:",omitempty"` // Frame index if the mode is zstd
 }
 
 // Object with external metadata
@@ -1298,6 +1375,17 @@ func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
 	return do.SetMetadata(ctx, metadata)
 }
 
+// RemoveMetadata removes user metadata keys from an Object
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (o *Object) RemoveMetadata(ctx context.Context, keys []string) error {
+	do, ok := o.Object.(fs.RemoveMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.RemoveMetadata(ctx, keys)
+}
+
 // Hash returns the selected checksum of the file
 // If no checksum is available it returns ""
 func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
@@ -1364,6 +1452,10 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	}
 	// Get a chunkedreader for the wrapped object
 	chunkedReader := chunkedreader.New(ctx, o.Object, initialChunkSize, maxChunkSize, chunkStreams)
+	// Zstd objects are read from the frame containing offset
+	if o.meta.Mode == Zstd {
+		return newZstdReader(ctx, chunkedReader, o.meta.SeekableMetadata, offset, limit)
+	}
 	// Get file handle
 	var file io.Reader
 	if offset != 0 {
@@ -1536,6 +1628,7 @@ var (
 	_ fs.CleanUpper      = (*Fs)(nil)
 	_ fs.UnWrapper       = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.ListAter        = (*Fs)(nil)
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.Wrapper         = (*Fs)(nil)
 	_ fs.MergeDirser     = (*Fs)(nil)
@@ -0,0 +1,170 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs/chunkedreader"
+)
+
+// Uncompressed size of each independent zstd frame
+const zstdBlockSize = 1048576
+
+// SeekableMetadata describes data compressed as a series of
+// independent frames, so it can be decompressed starting from any
+// frame. It is stored in the metadata object.
+type SeekableMetadata struct {
+	BlockSize int      // Uncompressed size of each frame except the last
+	Size      int64    // Uncompressed size of the data
+	BlockData []uint32 // Compressed size of each frame
+}
+
+// zstdWriter compresses the data written to it into independent zstd
+// frames of zstdBlockSize bytes of input which are written to w.
+//
+// The concatenated frames are a valid zstd stream.
+type zstdWriter struct {
+	w    io.Writer
+	enc  *zstd.Encoder
+	buf  []byte // input waiting to be compressed
+	out  []byte // buffer for the compressed frame
+	meta SeekableMetadata
+}
+
+// newZstdWriter makes a writer which compresses to w at the level
+// given. A level < 0 uses the default level, otherwise the levels are
+// the zstd levels 1 to 22 which are mapped onto the nearest supported
+// level.
+func newZstdWriter(w io.Writer, level int) (*zstdWriter, error) {
+	encoderLevel := zstd.SpeedDefault
+	if level >= 0 {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(encoderLevel),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithZeroFrames(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{
+		w:    w,
+		enc:  enc,
+		buf:  make([]byte, 0, zstdBlockSize),
+		meta: SeekableMetadata{BlockSize: zstdBlockSize},
+	}, nil
+}
+
+// Write compresses p
+func (z *zstdWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := min(len(p), zstdBlockSize-len(z.buf))
+		z.buf = append(z.buf, p[:chunk]...)
+		p = p[chunk:]
+		n += chunk
+		if len(z.buf) == zstdBlockSize {
+			if err = z.writeFrame(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// writeFrame compresses the buffered input as a frame
+func (z *zstdWriter) writeFrame() error {
+	z.out = z.enc.EncodeAll(z.buf, z.out[:0])
+	if _, err := z.w.Write(z.out); err != nil {
+		return err
+	}
+	z.meta.Size += int64(len(z.buf))
+	z.meta.BlockData = append(z.meta.BlockData, uint32(len(z.out)))
+	z.buf = z.buf[:0]
+	return nil
+}
+
+// Close writes any remaining input as the last frame
+//
+// An empty input is written as an empty frame.
+func (z *zstdWriter) Close() error {
+	if len(z.buf) > 0 || len(z.meta.BlockData) == 0 {
+		if err := z.writeFrame(); err != nil {
+			return err
+		}
+	}
+	return z.enc.Close()
+}
+
+// MetaData returns the frame index of the compressed data. It is only
+// complete after Close.
+func (z *zstdWriter) MetaData() SeekableMetadata {
+	return z.meta
+}
+
+// zstdReader decompresses some of the frames of an object
+type zstdReader struct {
+	io.Reader
+	dec *zstd.Decoder
+	cr  chunkedreader.ChunkedReader
+}
+
+// newZstdReader returns a reader for the data of an object compressed
+// by zstdWriter from offset for limit bytes, or to the end if limit
+// is -1.
+//
+// Only the frames which contain that data are read from cr.
+func newZstdReader(ctx context.Context, cr chunkedreader.ChunkedReader, meta *SeekableMetadata, offset, limit int64) (rc io.ReadCloser, err error) {
+	if meta == nil || meta.BlockSize <= 0 {
+		return nil, errors.New("zstd object has no frame index in its metadata")
+	}
+	if offset > meta.Size {
+		offset = meta.Size
+	}
+	if limit < 0 || offset+limit > meta.Size {
+		limit = meta.Size - offset
+	}
+	if limit == 0 {
+		return ReadCloserWrapper{Reader: strings.NewReader(""), Closer: cr}, nil
+	}
+	blockSize := int64(meta.BlockSize)
+	first := offset / blockSize
+	last := (offset + limit - 1) / blockSize
+	if last >= int64(len(meta.BlockData)) {
+		return nil, errors.New("zstd object frame index is too short")
+	}
+	var start, length int64
+	for i := int64(0); i <= last; i++ {
+		if i < first {
+			start += int64(meta.BlockData[i])
+		} else {
+			length += int64(meta.BlockData[i])
+		}
+	}
+	if _, err = cr.RangeSeek(ctx, start, io.SeekStart, length); err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(cr, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	// Skip to offset in the first frame
+	if _, err = io.CopyN(io.Discard, dec, offset-first*blockSize); err != nil {
+		dec.Close()
+		return nil, err
+	}
+	return &zstdReader{
+		Reader: io.LimitReader(dec, limit),
+		dec:    dec,
+		cr:     cr,
+	}, nil
+}
+
+// Close the decoder and the underlying reader
+func (z *zstdReader) Close() error {
+	z.dec.Close()
+	return z.cr.Close()
+}
@@ -0,0 +1,147 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/chunkedreader"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putTestObject uploads data to a local remote as it has to support
+// RangeOption exactly for the chunked reader
+func putTestObject(t *testing.T, data []byte) fs.Object {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	src := object.NewStaticObjectInfo("test", time.Now(), int64(len(data)), true, nil, f)
+	o, err := f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+	return o
+}
+
+// zstdCompress compresses data with zstdWriter
+func zstdCompress(t *testing.T, data []byte) ([]byte, SeekableMetadata) {
+	var buf bytes.Buffer
+	z, err := newZstdWriter(&buf, -1)
+	require.NoError(t, err)
+	// write in odd sized pieces to check the framing
+	for in := data; len(in) > 0; {
+		n := min(len(in), 100000)
+		_, err = z.Write(in[:n])
+		require.NoError(t, err)
+		in = in[n:]
+	}
+	require.NoError(t, z.Close())
+	return buf.Bytes(), z.MetaData()
+}
+
+func TestZstdSeekable(t *testing.T) {
+	ctx := context.Background()
+	data := make([]byte, 2*zstdBlockSize+12345)
+	rng := rand.New(rand.NewSource(1))
+	for i := range data {
+		data[i] = "abcdefgh"[rng.Intn(8)]
+	}
+	compressed, meta := zstdCompress(t, data)
+	assert.Equal(t, int64(len(data)), meta.Size)
+	assert.Equal(t, zstdBlockSize, meta.BlockSize)
+	require.Len(t, meta.BlockData, 3)
+	var total int64
+	for _, n := range meta.BlockData {
+		total += int64(n)
+	}
+	assert.Equal(t, int64(len(compressed)), total)
+
+	// The frames can be read by any zstd decoder
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	decompressed, err := dec.DecodeAll(compressed, nil)
+	require.NoError(t, err)
+	dec.Close()
+	assert.Equal(t, data, decompressed)
+
+	o := putTestObject(t, compressed)
+	for _, test := range []struct {
+		offset int64
+		limit  int64
+	}{
+		{0, -1},
+		{0, 10},
+		{1, 1},
+		{zstdBlockSize - 5, 10},
+		{zstdBlockSize, -1},
+		{zstdBlockSize + 100, zstdBlockSize},
+		{2*zstdBlockSize + 12344, -1},
+		{2*zstdBlockSize + 12345, -1},
+		{3 * zstdBlockSize, 10},
+		{10, 0},
+	} {
+		t.Run(fmt.Sprintf("offset=%d,limit=%d", test.offset, test.limit), func(t *testing.T) {
+			cr := chunkedreader.New(ctx, o, initialChunkSize, maxChunkSize, chunkStreams)
+			rc, err := newZstdReader(ctx, cr, &meta, test.offset, test.limit)
+			require.NoError(t, err)
+			got, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			start := min(test.offset, int64(len(data)))
+			end := int64(len(data))
+			if test.limit >= 0 {
+				end = min(start+test.limit, end)
+			}
+			assert.Equal(t, data[start:end], got)
+		})
+	}
+
+	// A missing frame index is an error
+	cr := chunkedreader.New(ctx, o, initialChunkSize, maxChunkSize, chunkStreams)
+	_, err = newZstdReader(ctx, cr, nil, 0, -1)
+	assert.Error(t, err)
+}
+
+func TestZstdEmpty(t *testing.T) {
+	ctx := context.Background()
+	compressed, meta := zstdCompress(t, nil)
+	assert.Equal(t, int64(0), meta.Size)
+	require.Len(t, meta.BlockData, 1)
+	assert.NotEqual(t, 0, len(compressed))
+
+	o := putTestObject(t, compressed)
+	cr := chunkedreader.New(ctx, o, initialChunkSize, maxChunkSize, chunkStreams)
+	rc, err := newZstdReader(ctx, cr, &meta, 0, -1)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, 0, len(got))
+}
+
+func TestZstdNewObject(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, fmt.Sprintf(":compress,mode=zstd,remote='%s':", t.TempDir()))
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("compressible "), 1000)
+	src := object.NewStaticObjectInfo("a.txt", time.Now(), int64(len(data)), true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+
+	// The object must be found under the name it was stored with
+	o, err := f.NewObject(ctx, "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), o.Size())
+	rc, err := o.Open(ctx)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, data, got)
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/aes"
 	gocipher "crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
 	"encoding/base32"
 	"encoding/base64"
@@ -181,6 +182,10 @@ type Cipher struct {
 	dirNameEncrypt  bool
 	passBadBlocks   bool // if set passed bad blocks as zeroed blocks
 	encryptedSuffix string
+	recipients      []*ecdh.PublicKey // if set wrap a random data key for each file for these
+	identity        *ecdh.PrivateKey  // if set use this to unwrap the data keys
+	kek             gocipher.Block    // if set wrap a random secret for each file with this
+	oldKEK          gocipher.Block    // if set also unwrap secrets with this
 }
 
 // newCipher initialises the cipher.  If salt is "" then it uses a built in salt val
@@ -683,6 +688,8 @@ type encrypter struct {
 	in       io.Reader
 	c        *Cipher
 	nonce    nonce
+	fileKey  *fileKey  // data key in public key mode, nil otherwise
+	key      *[32]byte // key to encrypt the data with
 	buf      *[blockSize]byte
 	readBuf  *[blockSize]byte
 	bufIndex int
@@ -692,12 +699,38 @@ type encrypter struct {
 
 // newEncrypter creates a new file handle encrypting on the fly
 func (c *Cipher) newEncrypter(in io.Reader, nonce *nonce) (*encrypter, error) {
+	return c.newEncrypterWithKey(in, nonce, nil)
+}
+
+// newEncrypterWithKey creates a new file handle encrypting on the fly
+//
+// In public key mode fk is used as the data key if set, otherwise a
+// new one is made.
+func (c *Cipher) newEncrypterWithKey(in io.Reader, nonce *nonce, fk *fileKey) (*encrypter, error) {
 	fh := &encrypter{
 		in:      in,
 		c:       c,
+		key:     &c.dataKey,
 		buf:     c.getBlock(),
 		readBuf: c.getBlock(),
-		bufSize: fileHeaderSize,
+		bufSize: c.headerSize(),
+	}
+	// In envelope mode the nonce and data key come from the wrapped
+	// secret. Files with just a nonce are in the original format.
+	if c.envelopeMode() && (fk != nil || nonce == nil) {
+		if fk == nil {
+			var err error
+			fk, err = c.newEnvelopeKey()
+			if err != nil {
+				return nil, err
+			}
+		}
+		fh.fileKey = fk
+		fh.key = &fk.key
+		fh.nonce = fk.nonce
+		copy((*fh.buf)[:], envelopeMagicBytes)
+		copy((*fh.buf)[fileMagicSize:], fk.wrapped[:])
+		return fh, nil
 	}
 	// Initialise nonce
 	if nonce != nil {
@@ -709,9 +742,22 @@ func (c *Cipher) newEncrypter(in io.Reader, nonce *nonce) (*encrypter, error) {
 		}
 	}
 	// Copy magic into buffer
-	copy((*fh.buf)[:], fileMagicBytes)
+	copy((*fh.buf)[:], c.magic())
 	// Copy nonce into buffer
 	copy((*fh.buf)[fileMagicSize:], fh.nonce[:])
+	// Make the data key and copy the recipients into the buffer
+	if c.publicKeyMode() {
+		if fk == nil {
+			var err error
+			fk, err = c.newFileKey(&fh.nonce)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fh.fileKey = fk
+		fh.key = &fk.key
+		copy((*fh.buf)[fileHeaderSize:], fk.recipient[:])
+	}
 	return fh, nil
 }
 
@@ -734,7 +780,7 @@ func (fh *encrypter) Read(p []byte) (n int, err error) {
 		// possibly err != nil here, but we will process the
 		// data and the next call to ReadFill will return 0, err
 		// Encrypt the block using the nonce
-		secretbox.Seal((*fh.buf)[:0], readBuf[:n], fh.nonce.pointer(), &fh.c.dataKey)
+		secretbox.Seal((*fh.buf)[:0], readBuf[:n], fh.nonce.pointer(), fh.key)
 		fh.bufIndex = 0
 		fh.bufSize = blockHeaderSize + n
 		fh.nonce.increment()
@@ -779,6 +825,8 @@ type decrypter struct {
 	rc           io.ReadCloser
 	nonce        nonce
 	initialNonce nonce
+	fileKey      *fileKey  // data key in public key mode, nil otherwise
+	key          *[32]byte // key to decrypt the data with
 	c            *Cipher
 	buf          *[blockSize]byte
 	readBuf      *[blockSize]byte
@@ -791,34 +839,65 @@ type decrypter struct {
 
 // newDecrypter creates a new file handle decrypting on the fly
 func (c *Cipher) newDecrypter(rc io.ReadCloser) (*decrypter, error) {
+	return c.newDecrypterRekeyed(rc, nil)
+}
+
+// newDecrypterRekeyed creates a new file handle decrypting on the fly
+// using rekeyed, which may be nil, to find the file key if the file
+// was rekeyed
+func (c *Cipher) newDecrypterRekeyed(rc io.ReadCloser, rekeyed rekeyedFn) (*decrypter, error) {
 	fh := &decrypter{
 		rc:      rc,
 		c:       c,
+		key:     &c.dataKey,
 		buf:     c.getBlock(),
 		readBuf: c.getBlock(),
 		limit:   -1,
 	}
-	// Read file header (magic + nonce)
-	readBuf := (*fh.readBuf)[:fileHeaderSize]
+	// Read file header (magic + nonce [+ recipients])
+	headerSize := c.headerSize()
+	readBuf := (*fh.readBuf)[:headerSize]
 	n, err := readers.ReadFill(fh.rc, readBuf)
-	if n < fileHeaderSize && err == io.EOF {
+	if n < headerSize && err == io.EOF {
 		// This read from 0..fileHeaderSize-1 bytes
 		return nil, fh.finishAndClose(ErrorEncryptedFileTooShort)
 	} else if err != io.EOF && err != nil {
 		return nil, fh.finishAndClose(err)
 	}
-	// check the magic
-	if !bytes.Equal(readBuf[:fileMagicSize], fileMagicBytes) {
+	// check the magic and retrieve the nonce and data key
+	magic := readBuf[:fileMagicSize]
+	switch {
+	case c.publicKeyMode():
+		if !bytes.Equal(magic, publicKeyMagicBytes) {
+			return nil, fh.finishAndClose(ErrorEncryptedBadMagic)
+		}
+		fh.nonce.fromBuf(readBuf[fileMagicSize:fileHeaderSize])
+		fh.fileKey, err = c.openFileKey(&fh.nonce, readBuf[fileHeaderSize:])
+		if err != nil {
+			return nil, fh.finishAndClose(err)
+		}
+		fh.key = &fh.fileKey.key
+	case bytes.Equal(magic, fileMagicBytes):
+		fh.nonce.fromBuf(readBuf[fileMagicSize:fileHeaderSize])
+	case bytes.Equal(magic, envelopeMagicBytes):
+		fh.fileKey, err = c.openEnvelopeKey(readBuf[fileMagicSize:fileHeaderSize], rekeyed)
+		if err != nil {
+			return nil, fh.finishAndClose(err)
+		}
+		fh.key = &fh.fileKey.key
+		fh.nonce = fh.fileKey.nonce
+	default:
 		return nil, fh.finishAndClose(ErrorEncryptedBadMagic)
 	}
-	// retrieve the nonce
-	fh.nonce.fromBuf(readBuf[fileMagicSize:])
 	fh.initialNonce = fh.nonce
 	return fh, nil
 }
 
 // newDecrypterSeek creates a new file handle decrypting on the fly
-func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offset, limit int64) (fh *decrypter, err error) {
+//
+// rekeyed is used to find the file key if the file was rekeyed, it
+// may be nil.
+func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offset, limit int64, rekeyed rekeyedFn) (fh *decrypter, err error) {
 	var rc io.ReadCloser
 	doRangeSeek := false
 	setLimit := false
@@ -828,19 +907,19 @@ func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offse
 		rc, err = open(ctx, 0, -1)
 	} else if offset == 0 {
 		// If no offset open the header + limit worth of the file
-		_, underlyingLimit, _, _ := calculateUnderlying(offset, limit)
-		rc, err = open(ctx, 0, int64(fileHeaderSize)+underlyingLimit)
+		_, underlyingLimit, _, _ := calculateUnderlying(c.headerSize(), offset, limit)
+		rc, err = open(ctx, 0, int64(c.headerSize())+underlyingLimit)
 		setLimit = true
 	} else {
 		// Otherwise just read the header to start with
-		rc, err = open(ctx, 0, int64(fileHeaderSize))
+		rc, err = open(ctx, 0, int64(c.headerSize()))
 		doRangeSeek = true
 	}
 	if err != nil {
 		return nil, err
 	}
 	// Open the stream which fills in the nonce
-	fh, err = c.newDecrypter(rc)
+	fh, err = c.newDecrypterRekeyed(rc, rekeyed)
 	if err != nil {
 		return nil, err
 	}
@@ -877,7 +956,7 @@ func (fh *decrypter) fillBuffer() (err error) {
 		return ErrorEncryptedFileBadHeader
 	}
 	// Decrypt the block using the nonce
-	_, ok := secretbox.Open((*fh.buf)[:0], (*readBuf)[:n], fh.nonce.pointer(), &fh.c.dataKey)
+	_, ok := secretbox.Open((*fh.buf)[:0], (*readBuf)[:n], fh.nonce.pointer(), fh.key)
 	if !ok {
 		if err != nil && err != io.EOF {
 			return err // return pending error as it is likely more accurate
@@ -927,17 +1006,18 @@ func (fh *decrypter) Read(p []byte) (n int, err error) {
 }
 
 // calculateUnderlying converts an (offset, limit) in an encrypted file
-// into an (underlyingOffset, underlyingLimit) for the underlying file.
+// with a header of headerSize into an (underlyingOffset,
+// underlyingLimit) for the underlying file.
 //
 // It also returns number of bytes to discard after reading the first
 // block and number of blocks this is from the start so the nonce can
 // be incremented.
-func calculateUnderlying(offset, limit int64) (underlyingOffset, underlyingLimit, discard, blocks int64) {
+func calculateUnderlying(headerSize int, offset, limit int64) (underlyingOffset, underlyingLimit, discard, blocks int64) {
 	// blocks we need to seek, plus bytes we need to discard
 	blocks, discard = offset/blockDataSize, offset%blockDataSize
 
 	// Offset in underlying stream we need to seek
-	underlyingOffset = int64(fileHeaderSize) + blocks*(blockHeaderSize+blockDataSize)
+	underlyingOffset = int64(headerSize) + blocks*(blockHeaderSize+blockDataSize)
 
 	// work out how many blocks we need to read
 	underlyingLimit = int64(-1)
@@ -987,7 +1067,7 @@ func (fh *decrypter) RangeSeek(ctx context.Context, offset int64, whence int, li
 		return 0, fh.err
 	}
 
-	underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(offset, limit)
+	underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(fh.c.headerSize(), offset, limit)
 
 	// Move the nonce on the correct number of blocks from the start
 	fh.nonce = fh.initialNonce
@@ -1110,7 +1190,7 @@ func (c *Cipher) DecryptData(rc io.ReadCloser) (io.ReadCloser, error) {
 //
 // You must use this form of DecryptData if you might want to Seek the file handle
 func (c *Cipher) DecryptDataSeek(ctx context.Context, open OpenRangeSeek, offset, limit int64) (ReadSeekCloser, error) {
-	out, err := c.newDecrypterSeek(ctx, open, offset, limit)
+	out, err := c.newDecrypterSeek(ctx, open, offset, limit, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1120,7 +1200,7 @@ func (c *Cipher) DecryptDataSeek(ctx context.Context, open OpenRangeSeek, offset
 // EncryptedSize calculates the size of the data when encrypted
 func (c *Cipher) EncryptedSize(size int64) int64 {
 	blocks, residue := size/blockDataSize, size%blockDataSize
-	encryptedSize := int64(fileHeaderSize) + blocks*(blockHeaderSize+blockDataSize)
+	encryptedSize := int64(c.headerSize()) + blocks*(blockHeaderSize+blockDataSize)
 	if residue != 0 {
 		encryptedSize += blockHeaderSize + residue
 	}
@@ -1129,7 +1209,7 @@ func (c *Cipher) EncryptedSize(size int64) int64 {
 
 // DecryptedSize calculates the size of the data when decrypted
 func (c *Cipher) DecryptedSize(size int64) (int64, error) {
-	size -= int64(fileHeaderSize)
+	size -= int64(c.headerSize())
 	if size < 0 {
 		return 0, ErrorEncryptedFileTooShort
 	}
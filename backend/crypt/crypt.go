@@ -3,9 +3,12 @@ package crypt
 
 import (
 	"context"
+	"crypto/ecdh"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"path"
 	"strings"
 	"time"
@@ -76,6 +79,60 @@ NB If filename_encryption is "off" then this option will do nothing.`,
 			Name:       "password2",
 			Help:       "Password or pass phrase for salt.\n\nOptional but recommended.\nShould be different to the previous password.",
 			IsPassword: true,
+		}, {
+			Name: "recipients",
+			Help: `Public keys to encrypt the file data for.
+
+If this is set then each file is encrypted with its own random key
+which is stored in the file header wrapped for each of these X25519
+public keys. Only a remote configured with the matching identity can
+read the file data back, so hosts which only write, for example
+backup hosts, need only be given the public keys.
+
+The password and password2 are then only used to encrypt the file
+names so they must still be set, and must be the same everywhere.
+
+Up to 8 recipients may be given, separated with commas. Use
+"rclone backend keygen crypt:" to make a key pair.
+
+Files written in this mode can't be read without it, nor can files
+written without it be read in this mode.`,
+			Default:  fs.CommaSepList{},
+			Advanced: true,
+		}, {
+			Name: "identity",
+			Help: `Private key to decrypt the file data with.
+
+This is the key made with "rclone backend keygen crypt:" whose public
+key was given in recipients when the files were written.
+
+Setting this without recipients turns on the public key mode and
+encrypts new files for the public key of this identity.`,
+			IsPassword: true,
+			Advanced:   true,
+		}, {
+			Name: "key_password",
+			Help: `Password to wrap the key of each file with.
+
+If this is set then each new file is encrypted with its own random key
+which is stored in the file header wrapped with a key derived from this
+password (and password2 as salt). This means the key_password can be
+changed later without encrypting the data again, see the "rekey"
+backend command.
+
+The password and password2 are still used to encrypt the file names
+and files written without key_password set can still be read.`,
+			IsPassword: true,
+			Advanced:   true,
+		}, {
+			Name: "old_key_password",
+			Help: `Previous key_password to read files which haven't been rekeyed yet.
+
+Set this to the old key_password when changing the key_password so
+files can still be read until "rclone backend rekey" has rewrapped
+their keys with the new one.`,
+			IsPassword: true,
+			Advanced:   true,
 		}, {
 			Name:    "server_side_across_configs",
 			Default: false,
@@ -205,9 +262,73 @@ func newCipherForConfig(opt *Options) (*Cipher, error) {
 	}
 	cipher.setEncryptedSuffix(opt.Suffix)
 	cipher.setPassBadBlocks(opt.PassBadBlocks)
+	if len(opt.Recipients) > 0 || opt.Identity != "" {
+		err = setRecipientsForConfig(cipher, opt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opt.KeyPassword != "" || opt.OldKeyPassword != "" {
+		err = setKeyPasswordForConfig(cipher, opt, salt)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return cipher, nil
 }
 
+// setKeyPasswordForConfig puts the cipher into envelope mode from the config
+func setKeyPasswordForConfig(cipher *Cipher, opt *Options, salt string) error {
+	if opt.NoDataEncryption {
+		return errors.New("can't use key_password with no_data_encryption")
+	}
+	if cipher.publicKeyMode() {
+		return errors.New("can't use key_password with recipients or identity")
+	}
+	if opt.KeyPassword == "" {
+		return errors.New("old_key_password needs key_password to be set")
+	}
+	keyPassword, err := obscure.Reveal(opt.KeyPassword)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt key_password: %w", err)
+	}
+	var oldKeyPassword string
+	if opt.OldKeyPassword != "" {
+		oldKeyPassword, err = obscure.Reveal(opt.OldKeyPassword)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt old_key_password: %w", err)
+		}
+	}
+	return cipher.setKeyPassword(keyPassword, oldKeyPassword, salt)
+}
+
+// setRecipientsForConfig puts the cipher into public key mode from the config
+func setRecipientsForConfig(cipher *Cipher, opt *Options) error {
+	if opt.NoDataEncryption {
+		return errors.New("can't use recipients or identity with no_data_encryption")
+	}
+	var recipients []*ecdh.PublicKey
+	for _, s := range opt.Recipients {
+		recipient, err := ParseRecipient(s)
+		if err != nil {
+			return fmt.Errorf("bad recipient %q: %w", s, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	var identity *ecdh.PrivateKey
+	if opt.Identity != "" {
+		s, err := obscure.Reveal(opt.Identity)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt identity: %w", err)
+		}
+		identity, err = ParseIdentity(s)
+		if err != nil {
+			return fmt.Errorf("bad identity: %w", err)
+		}
+	}
+	return cipher.setRecipients(recipients, identity)
+}
+
 // NewCipher constructs a Cipher for the given config
 func NewCipher(m configmap.Mapper) (*Cipher, error) {
 	// Parse config into Options struct
@@ -298,18 +419,22 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 
 // Options defines the configuration for this backend
 type Options struct {
-	Remote                  string `config:"remote"`
-	FilenameEncryption      string `config:"filename_encryption"`
-	DirectoryNameEncryption bool   `config:"directory_name_encryption"`
-	NoDataEncryption        bool   `config:"no_data_encryption"`
-	Password                string `config:"password"`
-	Password2               string `config:"password2"`
-	ServerSideAcrossConfigs bool   `config:"server_side_across_configs"`
-	ShowMapping             bool   `config:"show_mapping"`
-	PassBadBlocks           bool   `config:"pass_bad_blocks"`
-	FilenameEncoding        string `config:"filename_encoding"`
-	Suffix                  string `config:"suffix"`
-	StrictNames             bool   `config:"strict_names"`
+	Remote                  string          `config:"remote"`
+	FilenameEncryption      string          `config:"filename_encryption"`
+	DirectoryNameEncryption bool            `config:"directory_name_encryption"`
+	NoDataEncryption        bool            `config:"no_data_encryption"`
+	Password                string          `config:"password"`
+	Password2               string          `config:"password2"`
+	Recipients              fs.CommaSepList `config:"recipients"`
+	Identity                string          `config:"identity"`
+	KeyPassword             string          `config:"key_password"`
+	OldKeyPassword          string          `config:"old_key_password"`
+	ServerSideAcrossConfigs bool            `config:"server_side_across_configs"`
+	ShowMapping             bool            `config:"show_mapping"`
+	PassBadBlocks           bool            `config:"pass_bad_blocks"`
+	FilenameEncoding        string          `config:"filename_encoding"`
+	Suffix                  string          `config:"suffix"`
+	StrictNames             bool            `config:"strict_names"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -423,6 +548,16 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 	return f.encryptEntries(ctx, entries)
 }
 
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using old versions of the objects.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	entries, err = f.Fs.Features().ListAt(ctx, f.cipher.EncryptDirName(dir), at)
+	if err != nil {
+		return nil, err
+	}
+	return f.encryptEntries(ctx, entries)
+}
+
 // ListR lists the objects and directories of the Fs starting
 // from dir recursively into out.
 //
@@ -465,7 +600,7 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	ci := fs.GetConfig(ctx)
 
 	if f.opt.NoDataEncryption {
-		o, err := put(ctx, in, f.newObjectInfo(src, nonce{}), options...)
+		o, err := put(ctx, in, f.newObjectInfo(src, nonce{}, nil), options...)
 		if err == nil && o != nil {
 			o = f.newObject(o)
 		}
@@ -500,7 +635,7 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	}
 
 	// Transfer the data
-	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce), options...)
+	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce, encrypter.fileKey), options...)
 	if err != nil {
 		return nil, err
 	}
@@ -691,7 +826,7 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 	if err != nil {
 		return nil, err
 	}
-	o, err := do(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce))
+	o, err := do(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce, encrypter.fileKey))
 	if err != nil {
 		return nil, err
 	}
@@ -747,8 +882,10 @@ func (f *Fs) DecryptFileName(encryptedFileName string) (string, error) {
 // computeHashWithNonce takes the nonce and encrypts the contents of
 // src with it, and calculates the hash given by HashType on the fly
 //
+// In public key mode fk must be the data key the object was written with.
+//
 // Note that we break lots of encapsulation in this function.
-func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Object, hashType hash.Type) (hashStr string, err error) {
+func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, fk *fileKey, src fs.Object, hashType hash.Type) (hashStr string, err error) {
 	// Open the src for input
 	in, err := src.Open(ctx)
 	if err != nil {
@@ -757,7 +894,7 @@ func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Objec
 	defer fs.CheckClose(in, &err)
 
 	// Now encrypt the src with the nonce
-	out, err := f.cipher.newEncrypter(in, &nonce)
+	out, err := f.cipher.newEncrypterWithKey(in, &nonce, fk)
 	if err != nil {
 		return "", fmt.Errorf("failed to make encrypter: %w", err)
 	}
@@ -786,16 +923,16 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 
 	// Read the nonce - opening the file is sufficient to read the nonce in
 	// use a limited read so we only read the header
-	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileHeaderSize) - 1})
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(f.cipher.headerSize()) - 1})
 	if err != nil {
 		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
-	d, err := f.cipher.newDecrypter(in)
+	d, err := f.cipher.newDecrypterRekeyed(in, o.rekeyed(ctx))
 	if err != nil {
 		_ = in.Close()
 		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
-	nonce := d.nonce
+	nonce, fk := d.nonce, d.fileKey
 	// fs.Debugf(o, "Read nonce % 2x", nonce)
 
 	// Check nonce isn't all zeros
@@ -815,7 +952,7 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 		return "", fmt.Errorf("failed to close nonce read: %w", err)
 	}
 
-	return f.computeHashWithNonce(ctx, nonce, src, hashType)
+	return f.computeHashWithNonce(ctx, nonce, fk, src, hashType)
 }
 
 // MergeDirs merges the contents of all the directories passed
@@ -913,6 +1050,76 @@ Usage Example:
     rclone rc backend/command command=decode fs=crypt: encryptedfile1 [encryptedfile2...]
 `,
 	},
+	{
+		Name:  "keygen",
+		Short: "Make a key pair for the recipients and identity options",
+		Long: `This makes a new X25519 key pair for encrypting file data with public
+keys and returns the private key as "identity" and the public key as
+"recipient".
+
+Put the recipient in the recipients option of the remotes which write
+the data and the identity in the identity option of the remotes which
+need to read it back. Keep the identity secret.
+
+Usage Example:
+
+    rclone backend keygen crypt:
+`,
+	},
+	{
+		Name:  "rekey",
+		Short: "Rewrap the file keys with the current key_password",
+		Long: `This rewraps the key in the header of each file which was written with
+the old_key_password so it uses the current key_password instead. Files
+already using the current key_password are left alone.
+
+The file data isn't decrypted or encrypted again. As backends can't
+rewrite just the start of an object, each file is uploaded again with
+the new header, streaming the encrypted data from the existing object.
+The upload goes to a temporary name ending in ".rclone-rekey-" and a
+random ID for the run, which is then renamed over the original, so
+the underlying remote must support server-side move or copy. The
+original is kept until it is replaced, so if rekey is interrupted the
+temporary objects it leaves behind on the underlying remote can be
+deleted once it has stopped. Rekey never touches objects it didn't
+make in the same run.
+
+With "-o metadata" the rewrapped key is stored in the "rclone_crypt_key"
+metadata of the underlying object instead if the remote can set
+metadata, or can copy objects server-side with new metadata, so the
+data isn't transferred. This leaves the header as it was, so beware:
+
+- The old_key_password can still decrypt these files, so don't use
+  this if the old key_password is no longer secret.
+- The files can't be read without the metadata once old_key_password
+  is removed, so copying or syncing the underlying remote without
+  --metadata/-M makes them unreadable.
+
+Running rekey again without "-o metadata" rewrites the headers of
+files rekeyed this way.
+
+Files written before key_password was set use a key derived from the
+password and can't be rekeyed. They are counted as "legacy" and must
+be uploaded again, for example by copying them through a crypt remote
+with key_password set.
+
+Files written in public key mode have their data key wrapped for the
+recipients rather than with the key_password so they are counted as
+"public_key" and left alone.
+
+It returns the number of files in each state. Use --dry-run to see
+which files would be rekeyed.
+
+Usage Example:
+
+    rclone backend rekey crypt:[path]
+    rclone backend rekey crypt:[path] -o metadata
+    rclone rc backend/command command=rekey fs=crypt:[path]
+`,
+		Opts: map[string]string{
+			"metadata": "Store the rewrapped keys in metadata rather than rewriting the headers",
+		},
+	},
 }
 
 // Command the backend to run a named command
@@ -943,6 +1150,18 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 			out = append(out, encryptedFileName)
 		}
 		return out, nil
+	case "rekey":
+		_, useMetadata := opt["metadata"]
+		return f.rekey(ctx, "", useMetadata)
+	case "keygen":
+		identity, recipient, err := GenerateIdentity(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make key pair: %w", err)
+		}
+		return map[string]string{
+			"identity":  identity,
+			"recipient": recipient,
+		}, nil
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
@@ -1030,7 +1249,7 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 			openOptions = append(openOptions, option)
 		}
 	}
-	rc, err = o.f.cipher.DecryptDataSeek(ctx, func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
+	rc, err = o.f.cipher.newDecrypterSeek(ctx, func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
 		if underlyingOffset == 0 && underlyingLimit < 0 {
 			// Open with no seek
 			return o.Object.Open(ctx, openOptions...)
@@ -1045,7 +1264,7 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		}
 		newOpenOptions := append(openOptions, &fs.RangeOption{Start: underlyingOffset, End: end})
 		return o.Object.Open(ctx, newOpenOptions...)
-	}, offset, limit)
+	}, offset, limit, o.rekeyed(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -1107,15 +1326,17 @@ func (f *Fs) Shutdown(ctx context.Context) error {
 // This encrypts the remote name and adjusts the size
 type ObjectInfo struct {
 	fs.ObjectInfo
-	f     *Fs
-	nonce nonce
+	f       *Fs
+	nonce   nonce
+	fileKey *fileKey
 }
 
-func (f *Fs) newObjectInfo(src fs.ObjectInfo, nonce nonce) *ObjectInfo {
+func (f *Fs) newObjectInfo(src fs.ObjectInfo, nonce nonce, fk *fileKey) *ObjectInfo {
 	return &ObjectInfo{
 		ObjectInfo: src,
 		f:          f,
 		nonce:      nonce,
+		fileKey:    fk,
 	}
 }
 
@@ -1160,7 +1381,7 @@ func (o *ObjectInfo) Hash(ctx context.Context, hash hash.Type) (string, error) {
 	if srcObj.Fs().Features().IsLocal {
 		// Read the data and encrypt it to calculate the hash
 		fs.Debugf(o, "Computing %v hash of encrypted source", hash)
-		return o.f.computeHashWithNonce(ctx, o.nonce, srcObj, hash)
+		return o.f.computeHashWithNonce(ctx, o.nonce, o.fileKey, srcObj, hash)
 	}
 	return "", nil
 }
@@ -1245,7 +1466,16 @@ func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
 	if !ok {
 		return nil, nil
 	}
-	return do.Metadata(ctx)
+	metadata, err := do.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Don't show the key stored by rekey
+	if _, found := metadata[rekeyMetadataKey]; found {
+		metadata = maps.Clone(metadata)
+		delete(metadata, rekeyMetadataKey)
+	}
+	return metadata, nil
 }
 
 // SetMetadata sets metadata for an Object
@@ -1259,6 +1489,17 @@ func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
 	return do.SetMetadata(ctx, metadata)
 }
 
+// RemoveMetadata removes user metadata keys from an Object
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (o *Object) RemoveMetadata(ctx context.Context, keys []string) error {
+	do, ok := o.Object.(fs.RemoveMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.RemoveMetadata(ctx, keys)
+}
+
 // MimeType returns the content type of the Object if
 // known, or "" if not
 //
@@ -1281,6 +1522,7 @@ var (
 	_ fs.CleanUpper      = (*Fs)(nil)
 	_ fs.UnWrapper       = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.ListAter        = (*Fs)(nil)
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.Wrapper         = (*Fs)(nil)
 	_ fs.MergeDirser     = (*Fs)(nil)
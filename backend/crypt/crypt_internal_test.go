@@ -73,7 +73,7 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 
 	// wrap the object in a crypt for upload using the nonce we
 	// saved from the encrypter
-	src := f.newObjectInfo(oi, nonce)
+	src := f.newObjectInfo(oi, nonce, enc.fileKey)
 
 	// Test ObjectInfo methods
 	if !f.opt.NoDataEncryption {
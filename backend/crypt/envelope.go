@@ -0,0 +1,550 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	gocipher "crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/lib/readers"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Envelope mode
+//
+// In envelope mode each file has a random 16 byte secret which is
+// stored in the header wrapped with AES key wrap (RFC 3394) using a
+// key derived from the key_password. The data key and the initial
+// nonce for the file are derived from the secret with HKDF.
+//
+// The wrapped secret is 24 bytes which takes the place of the nonce
+// in the header so files are the same size as in the original format
+// and both formats can be mixed on the same remote. Changing the
+// key_password only needs the secrets rewrapping, which rekey does by
+// uploading the header again with the data streamed from the existing
+// object. It can store them in the metadata of the underlying object
+// instead if asked, which leaves the old wrapped secret in the header.
+const (
+	envelopeMagic     = "RCLONE\x00\x02"
+	fileSecretSize    = 16
+	wrappedSecretSize = fileSecretSize + 8
+	envelopeKeyInfo   = "rclone crypt data key"
+)
+
+// Errors returned in envelope mode
+var (
+	ErrorNoKeyPassword = errors.New("file data key is wrapped - need key_password to decrypt it")
+	ErrorBadKeyWrap    = errors.New("failed to unwrap file data key - bad key_password?")
+)
+
+var (
+	envelopeMagicBytes = []byte(envelopeMagic)
+	keyWrapIV          = [8]byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+)
+
+// keyWrap wraps key with the block cipher as described in RFC 3394
+func keyWrap(block gocipher.Block, key *[fileSecretSize]byte) (wrapped [wrappedSecretSize]byte) {
+	const n = fileSecretSize / 8
+	var b [16]byte
+	a := keyWrapIV
+	r := wrapped[8:]
+	copy(r, key[:])
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(b[:8], a[:])
+			copy(b[8:], r[i*8:(i+1)*8])
+			block.Encrypt(b[:], b[:])
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a[:], binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[i*8:(i+1)*8], b[8:])
+		}
+	}
+	copy(wrapped[:8], a[:])
+	return wrapped
+}
+
+// keyUnwrap unwraps a key wrapped by keyWrap returning false if the
+// integrity check fails
+func keyUnwrap(block gocipher.Block, wrapped []byte) (key [fileSecretSize]byte, ok bool) {
+	const n = fileSecretSize / 8
+	if len(wrapped) != wrappedSecretSize {
+		return key, false
+	}
+	var a [8]byte
+	var b [16]byte
+	copy(a[:], wrapped[:8])
+	r := key[:]
+	copy(r, wrapped[8:])
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(a[:])^t)
+			copy(b[8:], r[i*8:(i+1)*8])
+			block.Decrypt(b[:], b[:])
+			copy(a[:], b[:8])
+			copy(r[i*8:(i+1)*8], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a[:], keyWrapIV[:]) != 1 {
+		return [fileSecretSize]byte{}, false
+	}
+	return key, true
+}
+
+// keyEncryptionKey derives the cipher used to wrap the file secrets
+// from the password using scrypt as in Key
+func keyEncryptionKey(password, salt string) (gocipher.Block, error) {
+	var saltBytes = defaultSalt
+	if salt != "" {
+		saltBytes = []byte(salt)
+	}
+	key, err := scrypt.Key([]byte(password), saltBytes, 16384, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	return aes.NewCipher(key)
+}
+
+// setKeyPassword puts the cipher into envelope mode
+//
+// New files have their secrets wrapped with a key derived from
+// password. If oldPassword is set then files wrapped with it can be
+// read too.
+func (c *Cipher) setKeyPassword(password, oldPassword, salt string) (err error) {
+	if password == "" {
+		return errors.New("key password can't be empty")
+	}
+	c.kek, err = keyEncryptionKey(password, salt)
+	if err != nil {
+		return err
+	}
+	if oldPassword != "" {
+		c.oldKEK, err = keyEncryptionKey(oldPassword, salt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envelopeMode returns true if new files are written with wrapped keys
+func (c *Cipher) envelopeMode() bool {
+	return c.kek != nil
+}
+
+// deriveFileKey makes the data key and nonce for the secret
+func deriveFileKey(fk *fileKey, secret *[fileSecretSize]byte) error {
+	kdf := hkdf.New(sha256.New, secret[:], nil, []byte(envelopeKeyInfo))
+	_, err := io.ReadFull(kdf, fk.key[:])
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(kdf, fk.nonce[:])
+	return err
+}
+
+// newEnvelopeKey makes a new random secret and wraps it
+func (c *Cipher) newEnvelopeKey() (*fileKey, error) {
+	var secret [fileSecretSize]byte
+	_, err := readers.ReadFill(c.cryptoRand, secret[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file secret: %w", err)
+	}
+	fk := &fileKey{
+		wrapped: keyWrap(c.kek, &secret),
+	}
+	err = deriveFileKey(fk, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive data key: %w", err)
+	}
+	return fk, nil
+}
+
+// unwrapSecret unwraps the secret trying the current key then the
+// old one. It returns current set if the secret was wrapped with the
+// current key.
+func (c *Cipher) unwrapSecret(wrapped []byte) (secret [fileSecretSize]byte, current bool, err error) {
+	if c.kek == nil {
+		return secret, false, ErrorNoKeyPassword
+	}
+	secret, ok := keyUnwrap(c.kek, wrapped)
+	if ok {
+		return secret, true, nil
+	}
+	if c.oldKEK != nil {
+		secret, ok = keyUnwrap(c.oldKEK, wrapped)
+		if ok {
+			return secret, false, nil
+		}
+	}
+	return secret, false, ErrorBadKeyWrap
+}
+
+// rekeyedFn returns the secret rewrapped by rekey for the secret
+// wrapped in the header or nil if it wasn't rekeyed
+type rekeyedFn func(wrapped []byte) ([]byte, error)
+
+// openEnvelopeKey unwraps the secret from the header and derives the
+// data key and nonce from it
+//
+// If the secret can't be unwrapped with the current or old key then
+// the one stored by rekey is tried if rekeyed is set.
+func (c *Cipher) openEnvelopeKey(wrapped []byte, rekeyed rekeyedFn) (*fileKey, error) {
+	secret, _, err := c.unwrapSecret(wrapped)
+	if err == ErrorBadKeyWrap && rekeyed != nil {
+		rewrapped, rekeyErr := rekeyed(wrapped)
+		if rekeyErr != nil {
+			return nil, rekeyErr
+		}
+		if rewrapped != nil {
+			secret, _, err = c.unwrapSecret(rewrapped)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fk := new(fileKey)
+	copy(fk.wrapped[:], wrapped)
+	err = deriveFileKey(fk, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive data key: %w", err)
+	}
+	return fk, nil
+}
+
+// rewrapSecret wraps the secret in wrapped with the current key
+//
+// It returns false if it was already wrapped with the current key.
+func (c *Cipher) rewrapSecret(wrapped []byte) (rewrapped [wrappedSecretSize]byte, changed bool, err error) {
+	secret, current, err := c.unwrapSecret(wrapped)
+	if err != nil || current {
+		return rewrapped, false, err
+	}
+	return keyWrap(c.kek, &secret), true, nil
+}
+
+// Results of rekeying an object
+const (
+	rekeyRekeyed   = "rekeyed"
+	rekeyCurrent   = "current"
+	rekeyLegacy    = "legacy"
+	rekeyPublicKey = "public_key"
+	rekeyFailed    = "failed"
+)
+
+// rekeyTmpPrefix starts the suffix of the temporary objects made by
+// rekey on the underlying remote. It is followed by a random ID for
+// each run so a run only ever touches the temporary objects it made.
+const rekeyTmpPrefix = ".rclone-rekey-"
+
+// rekeyMetadataKey is the metadata key on the underlying object
+// which stores the secret rewrapped by rekey.
+//
+// The value is the base64 of the wrapped secret from the header
+// followed by the rewrapped secret so it is ignored if the object is
+// uploaded again with a new header.
+const rekeyMetadataKey = "rclone_crypt_key"
+
+// encodeRekeyed makes the value for rekeyMetadataKey
+func encodeRekeyed(wrapped []byte, rewrapped *[wrappedSecretSize]byte) string {
+	buf := make([]byte, 0, 2*wrappedSecretSize)
+	buf = append(buf, wrapped...)
+	buf = append(buf, rewrapped[:]...)
+	return base64.RawStdEncoding.EncodeToString(buf)
+}
+
+// decodeRekeyed returns the rewrapped secret from the value of
+// rekeyMetadataKey or nil if it isn't for the wrapped secret given
+func decodeRekeyed(value string, wrapped []byte) []byte {
+	buf, err := base64.RawStdEncoding.DecodeString(value)
+	if err != nil || len(buf) != 2*wrappedSecretSize || !bytes.Equal(buf[:wrappedSecretSize], wrapped) {
+		return nil
+	}
+	return buf[wrappedSecretSize:]
+}
+
+// readRekeyed returns the secret stored by rekey in the metadata of
+// the underlying object o for the wrapped secret in its header, or
+// nil if there isn't one
+func readRekeyed(ctx context.Context, o fs.Object, wrapped []byte) ([]byte, error) {
+	do, ok := o.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	metadata, err := do.Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	value, ok := metadata[rekeyMetadataKey]
+	if !ok {
+		return nil, nil
+	}
+	return decodeRekeyed(value, wrapped), nil
+}
+
+// rekeyed returns the rekeyedFn for o
+func (o *Object) rekeyed(ctx context.Context) rekeyedFn {
+	return func(wrapped []byte) ([]byte, error) {
+		return readRekeyed(ctx, o.Object, wrapped)
+	}
+}
+
+// renameRekeyed renames the rekeyed object to remote on the wrapped Fs
+// replacing the object there
+//
+// If the rename fails the temporary object is removed as the
+// original is still in place.
+func (f *Fs) renameRekeyed(ctx context.Context, tmpObj fs.Object, remote string) (err error) {
+	defer func() {
+		if err != nil {
+			if removeErr := tmpObj.Remove(ctx); removeErr != nil {
+				fs.Errorf(tmpObj, "Failed to remove temporary object: %v", removeErr)
+			}
+		}
+	}()
+	if do := f.Fs.Features().Move; do != nil {
+		_, err = do(ctx, tmpObj, remote)
+		return err
+	}
+	if do := f.Fs.Features().Copy; do != nil {
+		_, err = do(ctx, tmpObj, remote)
+		if err != nil {
+			return err
+		}
+		// The original has been replaced so don't remove it on error
+		if err := tmpObj.Remove(ctx); err != nil {
+			fs.Errorf(tmpObj, "Failed to remove temporary object: %v", err)
+		}
+		return nil
+	}
+	return errors.New("backend can't move or copy objects")
+}
+
+// setRekeyed stores the rewrapped secret in the metadata of the
+// underlying object o without uploading the data again
+//
+// This uses SetMetadata if the backend has it or a server-side copy
+// with the new metadata renamed over the original. The metadata is
+// read back to check the backend kept it. It returns
+// fs.ErrorNotImplemented if the metadata can't be stored.
+//
+// tmpSuffix is added to the name of the temporary copy.
+func (f *Fs) setRekeyed(ctx context.Context, o fs.Object, wrapped []byte, rewrapped *[wrappedSecretSize]byte, tmpSuffix string) error {
+	value := encodeRekeyed(wrapped, rewrapped)
+	metadata := fs.Metadata{rekeyMetadataKey: value}
+	stored := func(o fs.Object) bool {
+		got, err := readRekeyed(ctx, o, wrapped)
+		return err == nil && bytes.Equal(got, rewrapped[:])
+	}
+	if do, ok := o.(fs.SetMetadataer); ok {
+		err := do.SetMetadata(ctx, metadata)
+		if err != nil && !errors.Is(err, fs.ErrorNotImplemented) {
+			return err
+		}
+		if err == nil && stored(o) {
+			return nil
+		}
+	}
+	features := f.Fs.Features()
+	if !features.UserMetadata || features.Copy == nil {
+		return fs.ErrorNotImplemented
+	}
+	// Copy the object server-side setting the metadata
+	copyCtx, ci := fs.AddConfig(ctx)
+	ci.Metadata = true
+	ci.MetadataSet = metadata
+	remote := o.Remote()
+	tmpObj, err := features.Copy(copyCtx, o, remote+tmpSuffix)
+	if errors.Is(err, fs.ErrorCantCopy) {
+		return fs.ErrorNotImplemented
+	} else if err != nil {
+		return fmt.Errorf("failed to copy with new metadata: %w", err)
+	}
+	if !stored(tmpObj) {
+		fs.Debugf(o, "Backend didn't keep the metadata when copying")
+		err = tmpObj.Remove(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to remove %q: %w", tmpObj.Remote(), err)
+		}
+		return fs.ErrorNotImplemented
+	}
+	err = f.renameRekeyed(copyCtx, tmpObj, remote)
+	if err != nil {
+		return fmt.Errorf("failed to rename %q to %q: %w", tmpObj.Remote(), remote, err)
+	}
+	return nil
+}
+
+// uploadRekeyed uploads the underlying object o again with the new
+// header followed by the encrypted data which is streamed from the
+// existing object without decrypting it
+//
+// This is uploaded to a temporary name ending in tmpSuffix, as some
+// backends write in place, then renamed over the original.
+func (f *Fs) uploadRekeyed(ctx context.Context, o fs.Object, header []byte, tmpSuffix string) error {
+	var in io.Reader = bytes.NewReader(header)
+	var rc io.ReadCloser
+	var err error
+	size := o.Size()
+	if size > int64(fileHeaderSize) {
+		rc, err = o.Open(ctx, &fs.RangeOption{Start: int64(fileHeaderSize), End: -1})
+		if err != nil {
+			return fmt.Errorf("failed to open data: %w", err)
+		}
+		in = io.MultiReader(in, rc)
+	}
+	remote := o.Remote()
+	src := object.NewStaticObjectInfo(remote+tmpSuffix, o.ModTime(ctx), size, true, nil, f.Fs)
+	tmpObj, err := f.Fs.Put(ctx, in, src)
+	if rc != nil {
+		_ = rc.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload with new header: %w", err)
+	}
+	err = f.renameRekeyed(ctx, tmpObj, remote)
+	if err != nil {
+		return fmt.Errorf("failed to rename %q to %q: %w", tmpObj.Remote(), remote, err)
+	}
+	return nil
+}
+
+// rekeyObject rewraps the secret in the header of o with the current
+// key_password if it isn't already
+//
+// The object is uploaded again with the new header. If useMetadata is
+// set the rewrapped secret is stored in the metadata of the
+// underlying object instead if the backend can do that without
+// uploading the data. Files rekeyed that way still have the old
+// wrapped secret in the header, so without useMetadata their header
+// is rewritten too.
+//
+// Files in public key mode have their data key wrapped for the
+// recipients rather than with the key_password so are skipped.
+func (f *Fs) rekeyObject(ctx context.Context, o *Object, tmpSuffix string, useMetadata bool) (result string, err error) {
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileHeaderSize) - 1})
+	if err != nil {
+		return "", fmt.Errorf("failed to open header: %w", err)
+	}
+	header := make([]byte, fileHeaderSize)
+	n, err := readers.ReadFill(in, header)
+	_ = in.Close()
+	if n < fileHeaderSize {
+		if err == nil || err == io.EOF {
+			err = ErrorEncryptedFileTooShort
+		}
+		return "", fmt.Errorf("failed to read header: %w", err)
+	}
+	switch magic := header[:fileMagicSize]; {
+	case bytes.Equal(magic, fileMagicBytes):
+		return rekeyLegacy, nil
+	case bytes.Equal(magic, publicKeyMagicBytes):
+		fs.Debugf(o, "Skipping rekey as the file is in public key mode")
+		return rekeyPublicKey, nil
+	case !bytes.Equal(magic, envelopeMagicBytes):
+		return "", ErrorEncryptedBadMagic
+	}
+	wrapped := header[fileMagicSize:]
+	current := wrapped
+	stored, err := readRekeyed(ctx, o.Object, wrapped)
+	if err != nil {
+		return "", err
+	}
+	if stored != nil {
+		current = stored
+	}
+	rewrapped, changed, err := f.cipher.rewrapSecret(current)
+	if err != nil {
+		return "", err
+	}
+	if !changed {
+		if stored == nil || useMetadata {
+			return rekeyCurrent, nil
+		}
+		// The header still has the secret wrapped with the old key
+		copy(rewrapped[:], stored)
+	}
+	if fs.GetConfig(ctx).DryRun {
+		fs.Logf(o, "Skipped rekey as --dry-run is set")
+		return rekeyRekeyed, nil
+	}
+	err = fs.ErrorNotImplemented
+	if useMetadata {
+		err = f.setRekeyed(ctx, o.Object, wrapped, &rewrapped, tmpSuffix)
+		if errors.Is(err, fs.ErrorNotImplemented) {
+			fs.Debugf(o, "Can't store the key in metadata so uploading again")
+		}
+	}
+	if errors.Is(err, fs.ErrorNotImplemented) {
+		copy(header[fileMagicSize:], rewrapped[:])
+		err = f.uploadRekeyed(ctx, o.Object, header, tmpSuffix)
+	}
+	if err != nil {
+		return "", err
+	}
+	fs.Infof(o, "Rekeyed")
+	return rekeyRekeyed, nil
+}
+
+// rekey rewraps the headers of all the files in dir with the current
+// key_password and returns the number of files with each result
+//
+// If useMetadata is set the rewrapped secrets are stored in the
+// metadata of the underlying objects where the backend can.
+func (f *Fs) rekey(ctx context.Context, dir string, useMetadata bool) (map[string]int, error) {
+	if !f.cipher.envelopeMode() {
+		return nil, errors.New("rekey needs key_password to be set")
+	}
+	tmpSuffix := rekeyTmpPrefix + random.String(8)
+	var mu sync.Mutex
+	counts := map[string]int{
+		rekeyRekeyed:   0,
+		rekeyCurrent:   0,
+		rekeyLegacy:    0,
+		rekeyPublicKey: 0,
+		rekeyFailed:    0,
+	}
+	err := walk.ListR(ctx, f, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(func(obj fs.Object) {
+			o, ok := obj.(*Object)
+			if !ok {
+				return
+			}
+			result, err := f.rekeyObject(ctx, o, tmpSuffix, useMetadata)
+			if err != nil {
+				fs.Errorf(o, "Failed to rekey: %v", err)
+				result = rekeyFailed
+			}
+			mu.Lock()
+			counts[result]++
+			mu.Unlock()
+		})
+		return nil
+	})
+	if err != nil {
+		return counts, err
+	}
+	if counts[rekeyLegacy] > 0 {
+		fs.Logf(f, "%d files use the key derived from the password and need uploading again to use the key_password", counts[rekeyLegacy])
+	}
+	if counts[rekeyPublicKey] > 0 {
+		fs.Logf(f, "%d files use public key mode so their keys are wrapped for the recipients and weren't rekeyed", counts[rekeyPublicKey])
+	}
+	if counts[rekeyFailed] > 0 {
+		return counts, fmt.Errorf("failed to rekey %d files", counts[rekeyFailed])
+	}
+	return counts, nil
+}
@@ -0,0 +1,399 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/xattr"
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyWrap(t *testing.T) {
+	// Test vectors from RFC 3394 section 4
+	for _, test := range []struct {
+		kek     string
+		key     string
+		wrapped string
+	}{
+		{
+			kek:     "000102030405060708090A0B0C0D0E0F",
+			key:     "00112233445566778899AABBCCDDEEFF",
+			wrapped: "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		}, {
+			kek:     "000102030405060708090A0B0C0D0E0F1011121314151617",
+			key:     "00112233445566778899AABBCCDDEEFF",
+			wrapped: "96778B25AE6CA435F92B5B97C050AED2468AB8A17AD84E5D",
+		}, {
+			kek:     "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			key:     "00112233445566778899AABBCCDDEEFF",
+			wrapped: "64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7",
+		},
+	} {
+		kek, err := hex.DecodeString(test.kek)
+		require.NoError(t, err)
+		block, err := aes.NewCipher(kek)
+		require.NoError(t, err)
+		var key [fileSecretSize]byte
+		_, err = hex.Decode(key[:], []byte(test.key))
+		require.NoError(t, err)
+		expected, err := hex.DecodeString(test.wrapped)
+		require.NoError(t, err)
+
+		wrapped := keyWrap(block, &key)
+		assert.Equal(t, expected, wrapped[:], test.kek)
+
+		unwrapped, ok := keyUnwrap(block, wrapped[:])
+		assert.True(t, ok)
+		assert.Equal(t, key, unwrapped)
+
+		// corrupt it
+		wrapped[10] ^= 1
+		_, ok = keyUnwrap(block, wrapped[:])
+		assert.False(t, ok)
+		_, ok = keyUnwrap(block, wrapped[:8])
+		assert.False(t, ok)
+	}
+}
+
+func newEnvelopeCipher(t *testing.T, password, oldPassword string) *Cipher {
+	c, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	if password != "" {
+		require.NoError(t, c.setKeyPassword(password, oldPassword, ""))
+	}
+	return c
+}
+
+func encryptAll(t *testing.T, c *Cipher, plaintext []byte) []byte {
+	in, err := c.EncryptData(bytes.NewBuffer(plaintext))
+	require.NoError(t, err)
+	ciphertext, err := io.ReadAll(in)
+	require.NoError(t, err)
+	return ciphertext
+}
+
+func decryptAll(c *Cipher, ciphertext []byte) ([]byte, error) {
+	out, err := c.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = out.Close() }()
+	return io.ReadAll(out)
+}
+
+func TestEnvelopeEncryptDecrypt(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("potato"), blockDataSize/3)
+	legacy := newEnvelopeCipher(t, "", "")
+	c := newEnvelopeCipher(t, "sausage", "")
+	assert.True(t, c.envelopeMode())
+	assert.False(t, legacy.envelopeMode())
+
+	ciphertext := encryptAll(t, c, plaintext)
+	assert.Equal(t, envelopeMagicBytes, ciphertext[:fileMagicSize])
+	// the size is the same as the original format
+	assert.Equal(t, legacy.EncryptedSize(int64(len(plaintext))), int64(len(ciphertext)))
+	// and each file has its own key
+	assert.NotEqual(t, ciphertext, encryptAll(t, c, plaintext))
+
+	got, err := decryptAll(c, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// Original format files can still be read
+	got, err = decryptAll(c, encryptAll(t, legacy, plaintext))
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// But wrapped files need the key_password
+	_, err = decryptAll(legacy, ciphertext)
+	assert.Equal(t, ErrorNoKeyPassword, err)
+	_, err = decryptAll(newEnvelopeCipher(t, "bacon", ""), ciphertext)
+	assert.Equal(t, ErrorBadKeyWrap, err)
+
+	// Or the old_key_password
+	rotated := newEnvelopeCipher(t, "bacon", "sausage")
+	got, err = decryptAll(rotated, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// Rewrap the header with the new key
+	rewrapped, changed, err := rotated.rewrapSecret(ciphertext[fileMagicSize:fileHeaderSize])
+	require.NoError(t, err)
+	assert.True(t, changed)
+	copy(ciphertext[fileMagicSize:], rewrapped[:])
+	got, err = decryptAll(newEnvelopeCipher(t, "bacon", ""), ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// Rewrapping again does nothing
+	_, changed, err = rotated.rewrapSecret(ciphertext[fileMagicSize:fileHeaderSize])
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestEnvelopeReuseFileKey(t *testing.T) {
+	c := newEnvelopeCipher(t, "sausage", "")
+	encrypt := func(n *nonce, fk *fileKey) []byte {
+		fh, err := c.newEncrypterWithKey(bytes.NewBufferString("potato"), n, fk)
+		require.NoError(t, err)
+		out, err := io.ReadAll(fh)
+		require.NoError(t, err)
+		return out
+	}
+	first := encrypt(nil, nil)
+
+	// Encrypting with the key read from the header gives the same
+	// file which is what the hash checks rely on
+	d, err := c.newDecrypter(io.NopCloser(bytes.NewBuffer(first)))
+	require.NoError(t, err)
+	second := encrypt(&d.initialNonce, d.fileKey)
+	require.NoError(t, d.Close())
+	assert.Equal(t, first, second)
+
+	// A nonce on its own makes a file in the original format
+	legacy := encrypt(&d.initialNonce, nil)
+	assert.Equal(t, fileMagicBytes, legacy[:fileMagicSize])
+	assert.Equal(t, d.initialNonce[:], legacy[fileMagicSize:fileHeaderSize])
+}
+
+// rekeyHeader reads the header of the underlying object for remote
+func rekeyHeader(t *testing.T, f *Fs, remote string) []byte {
+	ctx := context.Background()
+	o, err := f.Fs.NewObject(ctx, f.cipher.EncryptFileName(remote))
+	require.NoError(t, err)
+	in, err := o.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileHeaderSize) - 1})
+	require.NoError(t, err)
+	header, err := io.ReadAll(in)
+	require.NoError(t, in.Close())
+	require.NoError(t, err)
+	return header
+}
+
+func testRekey(t *testing.T, remote string, useMetadata bool) {
+	ctx := context.Background()
+	newFs := func(keyPassword, oldKeyPassword string) *Fs {
+		m := configmap.Simple{
+			"type":                      "crypt",
+			"remote":                    remote,
+			"password":                  obscure.MustObscure("potato"),
+			"filename_encryption":       "standard",
+			"filename_encoding":         "base32",
+			"directory_name_encryption": "true",
+			"suffix":                    ".bin",
+		}
+		if keyPassword != "" {
+			m["key_password"] = obscure.MustObscure(keyPassword)
+		}
+		if oldKeyPassword != "" {
+			m["old_key_password"] = obscure.MustObscure(oldKeyPassword)
+		}
+		f, err := NewFs(ctx, "TestCryptRekey", "", m)
+		require.NoError(t, err)
+		return f.(*Fs)
+	}
+	contents := map[string]string{
+		"legacy":         "legacy file",
+		"old":            "wrapped with the old key",
+		"dir/old":        "also wrapped with the old key",
+		"dir/empty":      "",
+		"dir/sub/latest": "wrapped with the new key",
+	}
+	put := func(f *Fs, remote string) {
+		uploadFile(t, f, remote, contents[remote])
+	}
+	put(newFs("", ""), "legacy")
+	oldFs := newFs("sausage", "")
+	put(oldFs, "old")
+	put(oldFs, "dir/old")
+	put(oldFs, "dir/empty")
+	put(newFs("bacon", ""), "dir/sub/latest")
+
+	check := func(f *Fs, remote string) {
+		o, err := f.NewObject(ctx, remote)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(contents[remote])), o.Size())
+		in, err := o.Open(ctx)
+		require.NoError(t, err)
+		got, err := io.ReadAll(in)
+		require.NoError(t, in.Close())
+		require.NoError(t, err)
+		assert.Equal(t, contents[remote], string(got), remote)
+	}
+	checkUnreadable := func(f *Fs, remote string) {
+		o, err := f.NewObject(ctx, remote)
+		require.NoError(t, err)
+		_, err = o.Open(ctx)
+		assert.ErrorIs(t, err, ErrorBadKeyWrap, remote)
+	}
+	var opt map[string]string
+	if useMetadata {
+		opt = map[string]string{"metadata": ""}
+	}
+
+	// Everything is readable during the migration
+	f := newFs("bacon", "sausage")
+	for remote := range contents {
+		check(f, remote)
+	}
+	oldHeader := rekeyHeader(t, f, "old")
+
+	// Rekey in dry run mode does nothing
+	dryCtx, ci := fs.AddConfig(ctx)
+	ci.DryRun = true
+	out, err := f.Command(dryCtx, "rekey", nil, opt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"rekeyed": 3, "current": 1, "legacy": 1, "public_key": 0, "failed": 0}, out)
+
+	out, err = f.Command(ctx, "rekey", nil, opt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"rekeyed": 3, "current": 1, "legacy": 1, "public_key": 0, "failed": 0}, out)
+
+	out, err = f.Command(ctx, "rekey", nil, opt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"rekeyed": 0, "current": 4, "legacy": 1, "public_key": 0, "failed": 0}, out)
+
+	// The key is stored in the metadata if asked, leaving the header
+	// so the old key can still read it, otherwise the object is
+	// uploaded again with a new header
+	if useMetadata {
+		assert.Equal(t, oldHeader, rekeyHeader(t, f, "old"))
+		o, err := f.NewObject(ctx, "old")
+		require.NoError(t, err)
+		metadata, err := o.(*Object).Metadata(ctx)
+		require.NoError(t, err)
+		assert.NotContains(t, metadata, rekeyMetadataKey)
+		check(newFs("sausage", ""), "old")
+	} else {
+		assert.NotEqual(t, oldHeader, rekeyHeader(t, f, "old"))
+		checkUnreadable(newFs("sausage", ""), "old")
+	}
+
+	// Now the old key isn't needed
+	f = newFs("bacon", "")
+	for remote := range contents {
+		check(f, remote)
+	}
+
+	// The key can be changed again
+	f = newFs("eggs", "bacon")
+	out, err = f.Command(ctx, "rekey", nil, opt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"rekeyed": 4, "current": 0, "legacy": 1, "public_key": 0, "failed": 0}, out)
+	f = newFs("eggs", "")
+	for remote := range contents {
+		check(f, remote)
+	}
+
+	if useMetadata {
+		// The stored key is ignored once the file is uploaded again
+		o, err := newFs("sausage", "").NewObject(ctx, "old")
+		require.NoError(t, err)
+		src := object.NewStaticObjectInfo("old", o.ModTime(ctx), int64(len(contents["old"])), true, nil, nil)
+		require.NoError(t, o.Update(ctx, strings.NewReader(contents["old"]), src))
+		f = newFs("eggs", "sausage")
+		check(f, "old")
+		out, err = f.Command(ctx, "rekey", nil, opt)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"rekeyed": 1, "current": 3, "legacy": 1, "public_key": 0, "failed": 0}, out)
+
+		// Rekeying without the metadata option rewrites the headers
+		f = newFs("eggs", "")
+		out, err = f.Command(ctx, "rekey", nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"rekeyed": 4, "current": 0, "legacy": 1, "public_key": 0, "failed": 0}, out)
+		out, err = f.Command(ctx, "rekey", nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"rekeyed": 0, "current": 4, "legacy": 1, "public_key": 0, "failed": 0}, out)
+		for remote := range contents {
+			check(f, remote)
+		}
+		checkUnreadable(newFs("sausage", ""), "old")
+		checkUnreadable(newFs("bacon", ""), "dir/old")
+	}
+
+	// A remote without key_password can't rekey
+	_, err = newFs("", "").Command(ctx, "rekey", nil, nil)
+	assert.ErrorContains(t, err, "needs key_password")
+}
+
+func TestRekey(t *testing.T) {
+	t.Run("Metadata", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := xattr.Set(dir, "user.test", []byte("test")); err != nil {
+			t.Skipf("xattrs not supported: %v", err)
+		}
+		testRekey(t, dir, true)
+	})
+	t.Run("Upload", func(t *testing.T) {
+		testRekey(t, ":memory:TestCryptRekey", false)
+	})
+}
+
+func TestRekeyNameClash(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := NewFs(ctx, "TestCryptRekeyNameClash", "", configmap.Simple{
+		"type":                "crypt",
+		"remote":              dir,
+		"password":            obscure.MustObscure("potato"),
+		"key_password":        obscure.MustObscure("bacon"),
+		"filename_encryption": "off",
+		"filename_encoding":   "base32",
+		"suffix":              "none",
+	})
+	require.NoError(t, err)
+	c := f.(*Fs)
+
+	// Files named like temporary objects are left alone
+	uploadFile(t, c, "file", "potato")
+	uploadFile(t, c, "file.rekey", "sausage")
+	uploadFile(t, c, "lost.rekey", "eggs")
+	uploadFile(t, c, "file"+rekeyTmpPrefix+"12345678", "beans")
+
+	out, err := c.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"rekeyed": 0, "current": 4, "legacy": 0, "public_key": 0, "failed": 0}, out)
+	for _, name := range []string{"file", "file.rekey", "lost.rekey", "file" + rekeyTmpPrefix + "12345678"} {
+		assert.FileExists(t, filepath.Join(dir, name))
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "lost"))
+}
+
+func TestRekeyPublicKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	identity, _, err := GenerateIdentity(rand.Reader)
+	require.NoError(t, err)
+	newFs := func(name string, m configmap.Simple) *Fs {
+		m["type"] = "crypt"
+		m["remote"] = dir
+		m["password"] = obscure.MustObscure("potato")
+		m["filename_encryption"] = "standard"
+		m["filename_encoding"] = "base32"
+		m["suffix"] = ".bin"
+		f, err := NewFs(ctx, name, "", m)
+		require.NoError(t, err)
+		return f.(*Fs)
+	}
+	pk := newFs("TestCryptRekeyPublicKey", configmap.Simple{"identity": obscure.MustObscure(identity)})
+	uploadFile(t, pk, "pk", "public key contents")
+	f := newFs("TestCryptRekeyPublicKeyEnvelope", configmap.Simple{"key_password": obscure.MustObscure("bacon")})
+	uploadFile(t, f, "envelope", "envelope contents")
+
+	out, err := f.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"rekeyed": 0, "current": 1, "legacy": 0, "public_key": 1, "failed": 0}, out)
+}
@@ -0,0 +1,237 @@
+package crypt
+
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rclone/rclone/lib/readers"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Public key mode
+//
+// In public key mode each file is encrypted with a random data key.
+// This is wrapped for each recipient by doing X25519 between a per
+// file ephemeral key and the recipient's public key, then sealing the
+// data key with a secretbox keyed from the shared secret.
+//
+// The header has room for a fixed number of recipients so that the
+// encrypted size of a file can be worked out without knowing how
+// many recipients it was written for. Unused slots are filled with
+// random data.
+const (
+	publicKeyMagic      = "RCLONE\x00\x01"
+	maxRecipients       = 8
+	recipientKeySize    = 32
+	wrappedKeySize      = recipientKeySize + secretbox.Overhead
+	recipientBlockSize  = recipientKeySize + maxRecipients*wrappedKeySize
+	recipientPrefix     = "rclone-pk-"
+	identityPrefix      = "rclone-sk-"
+	recipientWrapInfo   = "rclone crypt recipient"
+	publicKeyHeaderSize = fileHeaderSize + recipientBlockSize
+)
+
+// Errors returned in public key mode
+var (
+	ErrorNoIdentity        = errors.New("can't decrypt file data - no identity configured")
+	ErrorNotARecipient     = errors.New("failed to unwrap file key - file not encrypted for this identity?")
+	ErrorTooManyRecipients = fmt.Errorf("too many recipients - the maximum is %d", maxRecipients)
+)
+
+var (
+	publicKeyMagicBytes = []byte(publicKeyMagic)
+)
+
+// fileKey is the random data key for a single file in public key or
+// envelope mode along with the way it is stored in the header
+type fileKey struct {
+	key       [32]byte
+	recipient [recipientBlockSize]byte // public key mode: ephemeral public key followed by the wrapped keys
+	nonce     nonce                    // envelope mode: initial nonce derived from the secret
+	wrapped   [wrappedSecretSize]byte  // envelope mode: wrapped secret
+}
+
+// parseKey decodes a key with the prefix given
+func parseKey(s, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(strings.ToLower(s), prefix) {
+		return nil, fmt.Errorf("key should start with %q", prefix)
+	}
+	key, err := caseInsensitiveBase32Encoding{}.DecodeString(s[len(prefix):])
+	if err != nil {
+		return nil, fmt.Errorf("bad key encoding: %w", err)
+	}
+	return key, nil
+}
+
+// ParseRecipient parses a public key as made by GenerateIdentity
+func ParseRecipient(s string) (*ecdh.PublicKey, error) {
+	key, err := parseKey(s, recipientPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(key)
+}
+
+// ParseIdentity parses a private key as made by GenerateIdentity
+func ParseIdentity(s string) (*ecdh.PrivateKey, error) {
+	key, err := parseKey(s, identityPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(key)
+}
+
+// EncodeRecipient returns the text form of a public key
+func EncodeRecipient(pub *ecdh.PublicKey) string {
+	return recipientPrefix + caseInsensitiveBase32Encoding{}.EncodeToString(pub.Bytes())
+}
+
+// EncodeIdentity returns the text form of a private key
+func EncodeIdentity(priv *ecdh.PrivateKey) string {
+	return identityPrefix + caseInsensitiveBase32Encoding{}.EncodeToString(priv.Bytes())
+}
+
+// GenerateIdentity makes a new private key reading random numbers
+// from rand and returns it along with its public key in text form
+func GenerateIdentity(rand io.Reader) (identity, recipient string, err error) {
+	priv, err := ecdh.X25519().GenerateKey(rand)
+	if err != nil {
+		return "", "", err
+	}
+	return EncodeIdentity(priv), EncodeRecipient(priv.PublicKey()), nil
+}
+
+// setRecipients puts the cipher into public key mode
+//
+// File data is encrypted for the recipients passed in and decrypted
+// with identity. Either may be empty. If there are no recipients then
+// files are encrypted for the public key of the identity.
+func (c *Cipher) setRecipients(recipients []*ecdh.PublicKey, identity *ecdh.PrivateKey) error {
+	if len(recipients) == 0 && identity != nil {
+		recipients = []*ecdh.PublicKey{identity.PublicKey()}
+	}
+	if len(recipients) == 0 {
+		return errors.New("need at least one recipient or an identity")
+	}
+	// Remove duplicates as they would waste slots
+	var deduped []*ecdh.PublicKey
+outer:
+	for _, recipient := range recipients {
+		for _, seen := range deduped {
+			if recipient.Equal(seen) {
+				continue outer
+			}
+		}
+		deduped = append(deduped, recipient)
+	}
+	if len(deduped) > maxRecipients {
+		return ErrorTooManyRecipients
+	}
+	c.recipients = deduped
+	c.identity = identity
+	return nil
+}
+
+// publicKeyMode returns true if file data keys are wrapped for recipients
+func (c *Cipher) publicKeyMode() bool {
+	return c.recipients != nil
+}
+
+// headerSize returns the size of the header of each encrypted file
+func (c *Cipher) headerSize() int {
+	if c.publicKeyMode() {
+		return publicKeyHeaderSize
+	}
+	return fileHeaderSize
+}
+
+// magic returns the magic bytes at the start of each encrypted file
+// which has a nonce in the header
+func (c *Cipher) magic() []byte {
+	if c.publicKeyMode() {
+		return publicKeyMagicBytes
+	}
+	return fileMagicBytes
+}
+
+// wrappingKey derives the key used to seal the data key from the
+// shared secret of the ephemeral key and the recipient
+func wrappingKey(shared, ephemeral, recipient []byte) (key [32]byte, err error) {
+	salt := make([]byte, 0, len(ephemeral)+len(recipient))
+	salt = append(salt, ephemeral...)
+	salt = append(salt, recipient...)
+	_, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(recipientWrapInfo)), key[:])
+	return key, err
+}
+
+// newFileKey makes a new random data key and wraps it for each of the
+// recipients using the file nonce
+func (c *Cipher) newFileKey(nonce *nonce) (*fileKey, error) {
+	fk := new(fileKey)
+	// Fill the key and the unused slots with random data
+	_, err := readers.ReadFill(c.cryptoRand, fk.key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data key: %w", err)
+	}
+	_, err = readers.ReadFill(c.cryptoRand, fk.recipient[recipientKeySize:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recipient padding: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(c.cryptoRand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make ephemeral key: %w", err)
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	copy(fk.recipient[:], ephemeralPub)
+	for i, recipient := range c.recipients {
+		shared, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap data key: %w", err)
+		}
+		wrapKey, err := wrappingKey(shared, ephemeralPub, recipient.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+		}
+		slot := fk.recipient[recipientKeySize+i*wrappedKeySize:][:0]
+		secretbox.Seal(slot, fk.key[:], nonce.pointer(), &wrapKey)
+	}
+	return fk, nil
+}
+
+// openFileKey unwraps the data key from the recipient block using the
+// identity
+func (c *Cipher) openFileKey(nonce *nonce, recipient []byte) (*fileKey, error) {
+	if c.identity == nil {
+		return nil, ErrorNoIdentity
+	}
+	fk := new(fileKey)
+	copy(fk.recipient[:], recipient)
+	ephemeralPub := fk.recipient[:recipientKeySize]
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("bad ephemeral key: %w", err)
+	}
+	shared, err := c.identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrorNotARecipient
+	}
+	wrapKey, err := wrappingKey(shared, ephemeralPub, c.identity.PublicKey().Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	for i := 0; i < maxRecipients; i++ {
+		slot := fk.recipient[recipientKeySize+i*wrappedKeySize:][:wrappedKeySize]
+		key, ok := secretbox.Open(nil, slot, nonce.pointer(), &wrapKey)
+		if ok && len(key) == len(fk.key) {
+			copy(fk.key[:], key)
+			return fk, nil
+		}
+	}
+	return nil, ErrorNotARecipient
+}
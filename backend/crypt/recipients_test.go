@@ -0,0 +1,176 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/rclone/rclone/lib/readers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestIdentity(t *testing.T) *ecdh.PrivateKey {
+	identity, _, err := GenerateIdentity(rand.Reader)
+	require.NoError(t, err)
+	priv, err := ParseIdentity(identity)
+	require.NoError(t, err)
+	return priv
+}
+
+func newPublicKeyCipher(t *testing.T, recipients []*ecdh.PublicKey, identity *ecdh.PrivateKey) *Cipher {
+	c, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	require.NoError(t, c.setRecipients(recipients, identity))
+	return c
+}
+
+func TestParseKeys(t *testing.T) {
+	identity, recipient, err := GenerateIdentity(rand.Reader)
+	require.NoError(t, err)
+	assert.Contains(t, identity, identityPrefix)
+	assert.Contains(t, recipient, recipientPrefix)
+
+	priv, err := ParseIdentity(identity)
+	require.NoError(t, err)
+	pub, err := ParseRecipient(recipient)
+	require.NoError(t, err)
+	assert.True(t, priv.PublicKey().Equal(pub))
+	assert.Equal(t, identity, EncodeIdentity(priv))
+	assert.Equal(t, recipient, EncodeRecipient(pub))
+
+	// Round trips with space around it
+	_, err = ParseRecipient(" " + recipient + "\n")
+	assert.NoError(t, err)
+
+	// Mixing up the keys is an error
+	_, err = ParseRecipient(identity)
+	assert.ErrorContains(t, err, "should start with")
+	_, err = ParseIdentity(recipient)
+	assert.ErrorContains(t, err, "should start with")
+
+	// Bad encoding and lengths
+	_, err = ParseRecipient(recipientPrefix + "!!!")
+	assert.ErrorContains(t, err, "bad key encoding")
+	_, err = ParseRecipient(recipient[:len(recipient)-8])
+	assert.Error(t, err)
+}
+
+func TestSetRecipients(t *testing.T) {
+	c, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	assert.False(t, c.publicKeyMode())
+	assert.Equal(t, fileHeaderSize, c.headerSize())
+
+	assert.Error(t, c.setRecipients(nil, nil))
+
+	// identity on its own encrypts for itself
+	identity := newTestIdentity(t)
+	require.NoError(t, c.setRecipients(nil, identity))
+	assert.True(t, c.publicKeyMode())
+	assert.Equal(t, publicKeyHeaderSize, c.headerSize())
+	require.Len(t, c.recipients, 1)
+	assert.True(t, c.recipients[0].Equal(identity.PublicKey()))
+
+	// duplicates are removed
+	var recipients []*ecdh.PublicKey
+	for i := 0; i <= maxRecipients; i++ {
+		recipients = append(recipients, identity.PublicKey())
+	}
+	require.NoError(t, c.setRecipients(recipients, nil))
+	assert.Len(t, c.recipients, 1)
+
+	// too many
+	recipients = nil
+	for i := 0; i <= maxRecipients; i++ {
+		recipients = append(recipients, newTestIdentity(t).PublicKey())
+	}
+	assert.Equal(t, ErrorTooManyRecipients, c.setRecipients(recipients, nil))
+}
+
+func TestPublicKeyEncryptDecrypt(t *testing.T) {
+	alice, bob, eve := newTestIdentity(t), newTestIdentity(t), newTestIdentity(t)
+	writer := newPublicKeyCipher(t, []*ecdh.PublicKey{alice.PublicKey(), bob.PublicKey()}, nil)
+
+	plaintext := make([]byte, 3*blockDataSize+17)
+	_, err := readers.ReadFill(rand.Reader, plaintext)
+	require.NoError(t, err)
+
+	in, err := writer.EncryptData(bytes.NewBuffer(plaintext))
+	require.NoError(t, err)
+	ciphertext, err := io.ReadAll(in)
+	require.NoError(t, err)
+	assert.Equal(t, writer.EncryptedSize(int64(len(plaintext))), int64(len(ciphertext)))
+	assert.Equal(t, publicKeyMagicBytes, ciphertext[:fileMagicSize])
+	size, err := writer.DecryptedSize(int64(len(ciphertext)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(plaintext)), size)
+
+	// the writer can't read what it wrote
+	_, err = writer.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+	assert.Equal(t, ErrorNoIdentity, err)
+
+	// each recipient can
+	for _, identity := range []*ecdh.PrivateKey{alice, bob} {
+		reader := newPublicKeyCipher(t, nil, identity)
+		out, err := reader.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+		require.NoError(t, err)
+		got, err := io.ReadAll(out)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, got)
+		require.NoError(t, out.Close())
+
+		// check seeking works with the bigger header
+		open := func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
+			end := int64(len(ciphertext))
+			if underlyingLimit >= 0 && underlyingOffset+underlyingLimit < end {
+				end = underlyingOffset + underlyingLimit
+			}
+			return io.NopCloser(bytes.NewBuffer(ciphertext[underlyingOffset:end])), nil
+		}
+		offset := int64(blockDataSize + 100)
+		rc, err := reader.DecryptDataSeek(context.Background(), open, offset, 1000)
+		require.NoError(t, err)
+		got, err = io.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext[offset:offset+1000], got)
+		require.NoError(t, rc.Close())
+	}
+
+	// but nobody else can
+	reader := newPublicKeyCipher(t, nil, eve)
+	_, err = reader.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+	assert.Equal(t, ErrorNotARecipient, err)
+
+	// and a symmetric cipher doesn't recognise the file
+	c, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	_, err = c.DecryptData(io.NopCloser(bytes.NewBuffer(ciphertext)))
+	assert.Equal(t, ErrorEncryptedBadMagic, err)
+}
+
+func TestPublicKeyReuseFileKey(t *testing.T) {
+	identity := newTestIdentity(t)
+	c := newPublicKeyCipher(t, nil, identity)
+
+	encrypt := func(n *nonce, fk *fileKey) []byte {
+		fh, err := c.newEncrypterWithKey(bytes.NewBufferString("potato"), n, fk)
+		require.NoError(t, err)
+		out, err := io.ReadAll(fh)
+		require.NoError(t, err)
+		return out
+	}
+	first := encrypt(nil, nil)
+
+	// Reading the header recovers the nonce and data key and
+	// encrypting with them gives the same file which is what the
+	// hash checks rely on
+	d, err := c.newDecrypter(io.NopCloser(bytes.NewBuffer(first)))
+	require.NoError(t, err)
+	second := encrypt(&d.initialNonce, d.fileKey)
+	require.NoError(t, d.Close())
+	assert.Equal(t, first, second)
+}
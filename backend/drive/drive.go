@@ -2018,6 +2018,108 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 	return entries, nil
 }
 
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using the revisions of the files.
+//
+// Each file is listed as its latest revision made at or before at.
+// Files with no revision that old are left out. This reads the
+// revisions of every file in dir so needs one more API call per file.
+//
+// Files which have been deleted since and directories can't be seen
+// as they were, so they are listed as they are now. Google docs and
+// other files without revisions which can be downloaded are listed as
+// they are now too.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	entries, err = f.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	newEntries := entries[:0] // in place filter
+	for _, entry := range entries {
+		o, ok := entry.(*Object)
+		if !ok || o.mimeType == shortcutMimeTypeDangling {
+			newEntries = append(newEntries, entry)
+			continue
+		}
+		revision, err := o.revisionAt(ctx, at)
+		if err != nil {
+			return nil, err
+		}
+		if revision == nil {
+			fs.Debugf(o, "Not listing as it has no revision from before %v", at)
+			continue
+		}
+		newEntries = append(newEntries, revision)
+	}
+	return newEntries, nil
+}
+
+// revisionAt returns the object for the latest revision of o made at
+// or before at, or nil if there isn't one.
+func (o *Object) revisionAt(ctx context.Context, at time.Time) (*Object, error) {
+	f := o.fs
+	id := actualID(o.id)
+	var (
+		found     *drive.Revision
+		foundTime time.Time
+		latest    time.Time
+		pageToken string
+	)
+	for {
+		var revisions *drive.RevisionList
+		err := f.pacer.Call(func() (bool, error) {
+			var err error
+			revisions, err = f.svc.Revisions.List(id).
+				Fields("nextPageToken,revisions(id,modifiedTime,size,md5Checksum)").
+				PageToken(pageToken).
+				Context(ctx).Do()
+			return f.shouldRetry(ctx, err)
+		})
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusForbidden {
+			fs.Debugf(o, "Listing as it is now as can't read revisions: %v", err)
+			return o, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list revisions: %w", err)
+		}
+		for _, revision := range revisions.Revisions {
+			modTime, err := time.Parse(timeFormatIn, revision.ModifiedTime)
+			if err != nil {
+				fs.Debugf(o, "Ignoring revision %q with bad time %q: %v", revision.Id, revision.ModifiedTime, err)
+				continue
+			}
+			if modTime.After(latest) {
+				latest = modTime
+			}
+			if !modTime.After(at) && (found == nil || modTime.After(foundTime)) {
+				found, foundTime = revision, modTime
+			}
+		}
+		pageToken = revisions.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	if !foundTime.Before(latest) {
+		// the current version
+		return o, nil
+	}
+	revision := *o
+	revision.url = fmt.Sprintf("%sfiles/%s/revisions/%s?alt=media", f.svc.BasePath, id, found.Id)
+	revision.bytes = found.Size
+	revision.modifiedDate = found.ModifiedTime
+	revision.md5sum = strings.ToLower(found.Md5Checksum)
+	revision.sha1sum = ""
+	revision.sha256sum = ""
+	revision.v2Download = false
+	revision.metadata = nil
+	return &revision, nil
+}
+
 // listREntry is a task to be executed by a litRRunner
 type listREntry struct {
 	id, path string
@@ -4638,6 +4740,7 @@ var (
 	_ fs.PutUncheckeder  = (*Fs)(nil)
 	_ fs.PublicLinker    = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.ListAter        = (*Fs)(nil)
 	_ fs.MergeDirser     = (*Fs)(nil)
 	_ fs.DirSetModTimer  = (*Fs)(nil)
 	_ fs.MkdirMetadataer = (*Fs)(nil)
@@ -643,6 +643,52 @@ func (f *Fs) InternalTestAgeQuery(t *testing.T) {
 	assert.Contains(t, subFs.lastQuery, timeQuery)
 }
 
+func (f *Fs) InternalTestListAt(t *testing.T) {
+	ctx := context.Background()
+	dir := "listat-testdir"
+
+	// Upload a file then update it
+	contents1 := random.String(100)
+	file := fstest.NewItem(dir+"/file", contents1, time.Now())
+	_ = fstests.PutTestContents(ctx, t, f, &file, contents1, true)
+	time.Sleep(2 * time.Second)
+	at := time.Now()
+	time.Sleep(2 * time.Second)
+	contents2 := random.String(200)
+	_ = fstests.PutTestContents(ctx, t, f, &file, contents2, true)
+	defer func() {
+		require.NoError(t, f.Purge(ctx, dir))
+	}()
+
+	read := func(o fs.Object) string {
+		in, err := o.Open(ctx)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, in.Close()) }()
+		data, err := io.ReadAll(in)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// The old revision is listed at at
+	entries, err := f.ListAt(ctx, dir, at)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	o := entries[0].(fs.Object)
+	assert.Equal(t, int64(100), o.Size())
+	assert.Equal(t, contents1, read(o))
+
+	// The current version is listed now
+	entries, err = f.ListAt(ctx, dir, time.Now())
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, contents2, read(entries[0].(fs.Object)))
+
+	// Nothing is listed before the file was made
+	entries, err = f.ListAt(ctx, dir, at.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
 func (f *Fs) InternalTest(t *testing.T) {
 	// These tests all depend on each other so run them as nested tests
 	t.Run("DocumentImport", func(t *testing.T) {
@@ -663,6 +709,7 @@ func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("Query", f.InternalTestQuery)
 	t.Run("AgeQuery", f.InternalTestAgeQuery)
 	t.Run("ShouldRetry", f.InternalTestShouldRetry)
+	t.Run("ListAt", f.InternalTestListAt)
 }
 
 var _ fstests.InternalTester = (*Fs)(nil)
@@ -0,0 +1,966 @@
+// Package erasure implements a backend which spreads files across
+// several remotes using Reed-Solomon erasure coding
+package erasure
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "erasure",
+		Description: "Spread files across several remotes with erasure coding",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "upstreams",
+			Help: `List of space separated upstreams.
+
+Each file is split into one shard per upstream. The order of the
+upstreams must not be changed once files have been written.
+
+Can be 'remote1:dir remote2:dir remote3:dir', '"remote1:dir with space" remote2:dir', etc.`,
+			Required: true,
+			Default:  fs.SpaceSepList(nil),
+		}, {
+			Name: "parity_shards",
+			Help: `Number of upstreams which can be lost without losing data.
+
+Files are split into as many data shards as there are upstreams minus
+this, and this many shards of parity are added. Any of the shards can
+be lost and the file can still be read, at the cost of storing
+upstreams/(upstreams-parity_shards) times the data.
+
+This must not be changed once files have been written.`,
+			Default: 1,
+		}, {
+			Name: "write_quorum",
+			Help: `Number of parity shards which must be written for an upload to succeed.
+
+An upload succeeds if all but parity_shards-write_quorum of the shards
+are written. The shards which failed can be rebuilt later with the
+repair command. Set this to parity_shards to make uploads fail unless
+every shard is written.`,
+			Default:  1,
+			Advanced: true,
+		}, {
+			Name: "block_size",
+			Help: `Size of the blocks files are split into on each upstream.
+
+Files are read and written in stripes of one block from each data
+shard, so this is the granularity of seeks and the memory used for
+each open file is about this times the number of upstreams.
+
+This must not be changed once files have been written.`,
+			Default:  fs.SizeSuffix(defaultBlockSize),
+			Advanced: true,
+		}},
+	})
+}
+
+// Constants
+const (
+	defaultBlockSize = 1024 * 1024
+	maxBlockSize     = 64 * 1024 * 1024
+)
+
+// Options defines the configuration for this backend
+type Options struct {
+	Upstreams    fs.SpaceSepList `config:"upstreams"`
+	ParityShards int             `config:"parity_shards"`
+	WriteQuorum  int             `config:"write_quorum"`
+	BlockSize    fs.SizeSuffix   `config:"block_size"`
+}
+
+// Fs represents an erasure coded set of upstreams
+type Fs struct {
+	name      string       // name of this remote
+	root      string       // the path we are working on
+	opt       Options      // options for this Fs
+	features  *fs.Features // optional features
+	upstreams []fs.Fs      // the upstream for each shard
+}
+
+// Object describes an erasure coded file
+type Object struct {
+	f      *Fs
+	remote string
+	shards []fs.Object // shard on each upstream or nil if missing
+
+	mu     sync.Mutex
+	size   int64
+	header *shardHeader // header of the upload if loaded
+	bad    []bool       // shards which aren't part of the upload if header loaded
+	md5    string       // MD5 read from a shard if loaded
+}
+
+// NewFs constructs an Fs from the path.
+//
+// The returned Fs is the actual Fs, referenced by remote in the config
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	n := len(opt.Upstreams)
+	if n < 2 {
+		return nil, errors.New("erasure needs at least 2 upstreams - check the value of the upstreams setting")
+	}
+	if n > maxShards {
+		return nil, fmt.Errorf("erasure can have at most %d upstreams", maxShards)
+	}
+	if opt.ParityShards < 1 || opt.ParityShards >= n {
+		return nil, fmt.Errorf("parity_shards must be between 1 and %d", n-1)
+	}
+	if opt.WriteQuorum < 0 || opt.WriteQuorum > opt.ParityShards {
+		return nil, fmt.Errorf("write_quorum must be between 0 and %d", opt.ParityShards)
+	}
+	if opt.BlockSize < 1 || opt.BlockSize > maxBlockSize {
+		return nil, fmt.Errorf("block_size must be between 1 and %v", fs.SizeSuffix(maxBlockSize))
+	}
+	for _, u := range opt.Upstreams {
+		if strings.HasPrefix(u, name+":") {
+			return nil, errors.New("can't point erasure remote at itself - check the value of the upstreams setting")
+		}
+	}
+	root = strings.Trim(root, "/")
+	f, err := newFs(ctx, name, root, opt)
+	if err != nil || root == "" {
+		return f, err
+	}
+	// Check to see if the root is a file
+	parent, leaf := path.Split(root)
+	parentFs, err := newFs(ctx, name, strings.TrimSuffix(parent, "/"), opt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = parentFs.NewObject(ctx, leaf)
+	if err == nil {
+		return parentFs, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// newFs makes the Fs for root
+func newFs(ctx context.Context, name, root string, opt *Options) (*Fs, error) {
+	f := &Fs{
+		name:      name,
+		root:      root,
+		opt:       *opt,
+		upstreams: make([]fs.Fs, len(opt.Upstreams)),
+	}
+	errs := f.forEach(func(i int, _ fs.Fs) error {
+		remote := fspath.JoinRootPath(opt.Upstreams[i], root)
+		u, err := cache.Get(ctx, remote)
+		if err == fs.ErrorIsFile {
+			return fmt.Errorf("upstream %q is a file, not a shard", remote)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create upstream %q: %w", remote, err)
+		}
+		f.upstreams[i] = u
+		return nil
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	// Pin the upstreams into the cache until f is garbage collected
+	for _, u := range f.upstreams {
+		cache.Pin(u)
+	}
+	runtime.SetFinalizer(f, func(f *Fs) {
+		for _, u := range f.upstreams {
+			cache.Unpin(u)
+		}
+	})
+	f.features = (&fs.Features{
+		DuplicateFiles:          false,
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f)
+	for _, u := range f.upstreams {
+		f.features = f.features.Mask(ctx, u) // Mask all upstream fs
+	}
+	// Every upstream has a shard of each file, so these are set if
+	// they are set on any of the upstreams
+	for _, u := range f.upstreams {
+		f.features.CaseInsensitive = f.features.CaseInsensitive || u.Features().CaseInsensitive
+		f.features.BucketBased = f.features.BucketBased || u.Features().BucketBased
+	}
+	// Files are visible as soon as any of their shards are written
+	f.features.PartialUploads = true
+	// show that we wrap other backends
+	f.features.Overlay = true
+	return f, nil
+}
+
+// dataShards returns the number of data shards files are written with
+func (f *Fs) dataShards() int {
+	return len(f.upstreams) - f.opt.ParityShards
+}
+
+// forEach calls fn for each upstream in parallel returning the errors
+// indexed by upstream
+func (f *Fs) forEach(fn func(i int, u fs.Fs) error) []error {
+	errs := make([]error, len(f.upstreams))
+	var wg sync.WaitGroup
+	for i := range f.upstreams {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fn(i, f.upstreams[i])
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("erasure root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision is the greatest precision of all the upstreams
+func (f *Fs) Precision() time.Duration {
+	var greatestPrecision time.Duration
+	for _, u := range f.upstreams {
+		if u.Precision() > greatestPrecision {
+			greatestPrecision = u.Precision()
+		}
+	}
+	return greatestPrecision
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.MD5)
+}
+
+// listing is the merged listing of a directory on all the upstreams
+type listing struct {
+	dirs    map[string]fs.Directory
+	objects map[string]*Object
+	order   []string // remotes in the order found
+}
+
+// list the directory on all the upstreams, merging the results
+//
+// Up to parity_shards upstreams may fail without returning an error
+// as every file has a shard on each upstream.
+func (f *Fs) list(ctx context.Context, dir string) (*listing, error) {
+	var mu sync.Mutex
+	l := &listing{
+		dirs:    make(map[string]fs.Directory),
+		objects: make(map[string]*Object),
+	}
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		entries, err := u.List(ctx, dir)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case fs.Directory:
+				if _, found := l.dirs[x.Remote()]; !found {
+					l.dirs[x.Remote()] = fs.NewDirCopy(ctx, x)
+					l.order = append(l.order, x.Remote())
+				}
+			case fs.Object:
+				remote, ok := parseShardName(x.Remote())
+				if !ok {
+					fs.Debugf(x, "Ignoring file which isn't an erasure shard")
+					continue
+				}
+				o := l.objects[remote]
+				if o == nil {
+					o = f.newObject(remote)
+					l.objects[remote] = o
+					l.order = append(l.order, remote)
+				}
+				o.shards[i] = x
+			}
+		}
+		return nil
+	})
+	notFound, failed := 0, 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			notFound++
+		} else if err != nil {
+			fs.Errorf(f.upstreams[i], "Failed to list %q: %v", dir, err)
+			failed++
+		}
+	}
+	if failed > f.opt.ParityShards {
+		return nil, fmt.Errorf("failed to list %d upstreams: %w", failed, errors.Join(errs...))
+	}
+	if notFound+failed == len(f.upstreams) {
+		return nil, fs.ErrorDirNotFound
+	}
+	for remote, o := range l.objects {
+		if err := o.setSize(ctx); err != nil {
+			fs.Errorf(o, "Ignoring file as its size can't be read: %v", err)
+			delete(l.objects, remote)
+		}
+	}
+	return l, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	l, err := f.list(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, remote := range l.order {
+		if d, ok := l.dirs[remote]; ok {
+			entries = append(entries, d)
+			delete(l.dirs, remote)
+		}
+		if o, ok := l.objects[remote]; ok {
+			entries = append(entries, o)
+			delete(l.objects, remote)
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o := f.newObject(remote)
+	name := makeShardName(remote)
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		shard, err := u.NewObject(ctx, name)
+		if err != nil {
+			return err
+		}
+		o.shards[i] = shard
+		return nil
+	})
+	notFound, failed := 0, 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) || errors.Is(err, fs.ErrorNotAFile) {
+			notFound++
+		} else if err != nil {
+			fs.Errorf(f.upstreams[i], "Failed to find %q: %v", name, err)
+			failed++
+		}
+	}
+	if failed > f.opt.ParityShards {
+		return nil, fmt.Errorf("failed to find %d shards: %w", failed, errors.Join(errs...))
+	}
+	if notFound+failed == len(f.upstreams) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err := o.setSize(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read size: %w", err)
+	}
+	return o, nil
+}
+
+// newObject makes an object with no shards yet
+func (f *Fs) newObject(remote string) *Object {
+	return &Object{
+		f:      f,
+		remote: remote,
+		shards: make([]fs.Object, len(f.upstreams)),
+	}
+}
+
+// put uploads the data from in as new shards of remote
+//
+// The shards in old are updated if set, otherwise new shards are made.
+func (f *Fs) put(ctx context.Context, in io.Reader, remote string, src fs.ObjectInfo, old []fs.Object, options ...fs.OpenOption) (*Object, error) {
+	size := src.Size()
+	if size < 0 {
+		return nil, errors.New("can't upload files of unknown size")
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	h := &shardHeader{
+		dataShards:   f.dataShards(),
+		parityShards: f.opt.ParityShards,
+		blockSize:    int(f.opt.BlockSize),
+		size:         size,
+		id:           id,
+	}
+	o := f.newObject(remote)
+	err = f.writeShards(ctx, in, o, h, src.ModTime(ctx), nil, old, options...)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// writeShards encodes in writing the shards of o to the upstreams.
+//
+// If only is set then only the shards marked in it are written and
+// they must all succeed. Otherwise all the shards are written and
+// this succeeds if at least data shards + write_quorum of them are.
+// Shards which couldn't be written are left for the repair command.
+//
+// The shards in old are updated if set, otherwise new shards are made.
+func (f *Fs) writeShards(ctx context.Context, in io.Reader, o *Object, h *shardHeader, modTime time.Time, only []bool, old []fs.Object, options ...fs.OpenOption) error {
+	n := len(f.upstreams)
+	name := makeShardName(o.remote)
+	out := make([]io.Writer, n)
+	writers := make([]*io.PipeWriter, n)
+	shards := make([]fs.Object, n)
+	errs := make([]error, n)
+	need := 0
+	var wg sync.WaitGroup
+	for i, u := range f.upstreams {
+		if only != nil && !only[i] {
+			continue
+		}
+		need++
+		pr, pw := io.Pipe()
+		writers[i], out[i] = pw, pw
+		info := object.NewStaticObjectInfo(name, modTime, h.objectSize(i), true, nil, nil)
+		wg.Add(1)
+		go func(i int, u fs.Fs) {
+			defer wg.Done()
+			if old != nil && old[i] != nil {
+				errs[i] = old[i].Update(ctx, pr, info, options...)
+				shards[i] = old[i]
+			} else {
+				shards[i], errs[i] = u.Put(ctx, pr, info, options...)
+			}
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("failed to upload shard %d to %v: %w", i, u, errs[i])
+				// Stop the encoder writing to this shard
+				_ = pr.CloseWithError(errs[i])
+			}
+		}(i, u)
+	}
+	if only == nil {
+		need = min(n, h.dataShards+f.opt.WriteQuorum)
+	}
+	md5sum, err := encode(in, h, out, need)
+	for _, pw := range writers {
+		if pw != nil {
+			_ = pw.CloseWithError(err)
+		}
+	}
+	wg.Wait()
+	written := 0
+	var failed []error
+	for i := range out {
+		if out[i] == nil {
+			continue
+		}
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+			shards[i] = nil
+		} else {
+			written++
+		}
+	}
+	if err == nil && written < need {
+		err = fmt.Errorf("only %d of the %d shards needed were written: %w", written, need, errors.Join(failed...))
+	}
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		fs.Logf(o, "Failed to write %d shards - use the repair command to rebuild them: %v", len(failed), errors.Join(failed...))
+		// Remove the shards of the previous upload which weren't
+		// overwritten so they aren't mistaken for this one
+		var stale []fs.Object
+		for i := range out {
+			if errs[i] != nil && old != nil && old[i] != nil {
+				stale = append(stale, old[i])
+			}
+		}
+		if err := removeShards(ctx, stale); err != nil {
+			fs.Errorf(o, "Failed to remove shards of the previous upload: %v", err)
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.bad = make([]bool, n)
+	for i := range out {
+		if out[i] != nil {
+			o.shards[i] = shards[i]
+		}
+		o.bad[i] = o.shards[i] == nil
+	}
+	o.size = h.size
+	o.header = h
+	o.md5 = hex.EncodeToString(md5sum)
+	return nil
+}
+
+// removeShards removes the shards passed in
+func removeShards(ctx context.Context, shards []fs.Object) error {
+	var errs []error
+	for _, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		err := shard.Remove(ctx)
+		if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.put(ctx, in, src.Remote(), src, nil, options...)
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return errors.Join(f.forEach(func(i int, u fs.Fs) error {
+		return u.Mkdir(ctx, dir)
+	})...)
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		return u.Rmdir(ctx, dir)
+	})
+	notFound := 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			notFound++
+			errs[i] = nil
+		}
+	}
+	if notFound == len(errs) {
+		return fs.ErrorDirNotFound
+	}
+	return errors.Join(errs...)
+}
+
+// Purge all files in the directory specified
+//
+// Implement this if you have a way of deleting all the files
+// quicker than just running Remove() on the result of List()
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		return u.Features().Purge(ctx, dir)
+	})
+	notFound := 0
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrorDirNotFound) {
+			notFound++
+			errs[i] = nil
+		}
+	}
+	if notFound == len(errs) {
+		return fs.ErrorDirNotFound
+	}
+	return errors.Join(errs...)
+}
+
+// sameUpstreams checks src has the same upstreams in the same order
+func (f *Fs) sameUpstreams(src *Fs) bool {
+	if len(src.upstreams) != len(f.upstreams) {
+		return false
+	}
+	for i := range f.upstreams {
+		if src.upstreams[i].Name() != f.upstreams[i].Name() {
+			return false
+		}
+	}
+	return true
+}
+
+// copyOrMove does a server-side copy or move of each of the shards
+// of src
+func (f *Fs) copyOrMove(ctx context.Context, src fs.Object, remote string, move bool) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.sameUpstreams(srcObj.f) {
+		fs.Debugf(src, "Can't copy or move - not same remote type")
+		if move {
+			return nil, fs.ErrorCantMove
+		}
+		return nil, fs.ErrorCantCopy
+	}
+	o := f.newObject(remote)
+	name := makeShardName(remote)
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		shard := srcObj.shards[i]
+		if shard == nil {
+			return nil
+		}
+		var dst fs.Object
+		var err error
+		if move {
+			dst, err = u.Features().Move(ctx, shard, name)
+		} else {
+			dst, err = u.Features().Copy(ctx, shard, name)
+		}
+		if err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+		o.shards[i] = dst
+		return nil
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	srcObj.mu.Lock()
+	o.size, o.header, o.bad, o.md5 = srcObj.size, srcObj.header, srcObj.bad, srcObj.md5
+	srcObj.mu.Unlock()
+	return o, nil
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	return f.copyOrMove(ctx, src, remote, false)
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	return f.copyOrMove(ctx, src, remote, true)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.sameUpstreams(srcFs) {
+		fs.Debugf(src, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	errs := f.forEach(func(i int, u fs.Fs) error {
+		return u.Features().DirMove(ctx, srcFs.upstreams[i], srcRemote, dstRemote)
+	})
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	for _, err := range failed {
+		if err != failed[0] {
+			return errors.Join(failed...)
+		}
+	}
+	if len(failed) > 0 {
+		// Return errors like fs.ErrorDirExists as is
+		return failed[0]
+	}
+	return nil
+}
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size
+}
+
+// setSize works out the size of the object from the sizes of its
+// shards, reading the headers if that isn't possible
+func (o *Object) setSize(ctx context.Context) error {
+	sizes := make([]int64, len(o.shards))
+	for i, shard := range o.shards {
+		sizes[i] = -1
+		if shard != nil {
+			sizes[i] = shard.Size()
+		}
+	}
+	size, ok := sizeFromShards(o.f.dataShards(), int(o.f.opt.BlockSize), sizes)
+	if ok {
+		o.mu.Lock()
+		o.size = size
+		o.mu.Unlock()
+		return nil
+	}
+	_, err := o.loadHeader(ctx)
+	return err
+}
+
+// shardCount returns the number of shards which are present
+func (o *Object) shardCount() (n int) {
+	for _, shard := range o.shards {
+		if shard != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// presentShards returns the shards which are present
+func (o *Object) presentShards() (shards []fs.Object) {
+	for _, shard := range o.shards {
+		if shard != nil {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	for _, shard := range o.shards {
+		if shard != nil {
+			return shard.ModTime(ctx)
+		}
+	}
+	return time.Time{}
+}
+
+// SetModTime sets the modification time of all the shards
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return errors.Join(o.f.forEach(func(i int, _ fs.Fs) error {
+		if o.shards[i] == nil {
+			return nil
+		}
+		return o.shards[i].SetModTime(ctx, modTime)
+	})...)
+}
+
+// Storable returns whether the object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// loadHeader reads the headers of all the shards, returning the
+// header of the upload which most of them belong to
+//
+// The shards which can't be read or which belong to a different
+// upload, which happens if an upload is interrupted, are marked bad.
+func (o *Object) loadHeader(ctx context.Context) (*shardHeader, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.header != nil {
+		return o.header, nil
+	}
+	headers := make([]*shardHeader, len(o.shards))
+	errs := o.f.forEach(func(i int, _ fs.Fs) error {
+		shard := o.shards[i]
+		if shard == nil {
+			return nil
+		}
+		h, err := readHeader(ctx, shard)
+		if err == nil && (h.index != i || h.shards() != len(o.shards) || shard.Size() != h.objectSize(i)) {
+			err = ErrorBadShard
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", shard, err)
+		}
+		headers[i] = h
+		return nil
+	})
+	// Pick the upload with the most shards
+	counts := make(map[string]int)
+	var best *shardHeader
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		counts[h.id]++
+		if best == nil || counts[h.id] > counts[best.id] {
+			best = h
+		}
+	}
+	if best == nil {
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+		return nil, ErrorNotEnoughShards
+	}
+	o.bad = make([]bool, len(o.shards))
+	for i, h := range headers {
+		if h == nil || h.id != best.id {
+			if o.shards[i] != nil {
+				fs.Debugf(o, "Shard %d on %v isn't part of the upload: %v", i, o.f.upstreams[i], errs[i])
+			}
+			o.bad[i] = true
+		}
+	}
+	o.header = best
+	o.size = best.size
+	return best, nil
+}
+
+// Hash returns the MD5 of the file which is stored in each shard
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht != hash.MD5 {
+		return "", hash.ErrUnsupported
+	}
+	if _, err := o.loadHeader(ctx); err != nil {
+		return "", err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.md5 != "" {
+		return o.md5, nil
+	}
+	var err error
+	for i, shard := range o.shards {
+		if o.bad[i] {
+			continue
+		}
+		o.md5, err = readTrailer(ctx, shard)
+		if err == nil {
+			return o.md5, nil
+		}
+		fs.Debugf(shard, "Failed to read MD5: %v", err)
+	}
+	return "", err
+}
+
+// Open an object for read
+//
+// Blocks which fail their checksums are reconstructed from the parity
+// and if the whole file is read its MD5 is checked.
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	h, err := o.loadHeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	bad := append([]bool(nil), o.bad...)
+	o.mu.Unlock()
+	sr, err := newStripeReader(ctx, o, h, offset/h.stripeSize(), bad, false)
+	if err != nil {
+		return nil, err
+	}
+	return newObjectReader(sr, offset, limit), nil
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	newO, err := o.f.put(ctx, in, o.remote, src, o.shards, options...)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.size, o.shards, o.bad = newO.size, newO.shards, newO.bad
+	o.header, o.md5 = newO.header, newO.md5
+	return nil
+}
+
+// Remove all the shards of the object
+func (o *Object) Remove(ctx context.Context) error {
+	return removeShards(ctx, o.presentShards())
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs        = (*Fs)(nil)
+	_ fs.Purger    = (*Fs)(nil)
+	_ fs.Copier    = (*Fs)(nil)
+	_ fs.Mover     = (*Fs)(nil)
+	_ fs.DirMover  = (*Fs)(nil)
+	_ fs.Commander = (*Fs)(nil)
+	_ fs.Object    = (*Object)(nil)
+)
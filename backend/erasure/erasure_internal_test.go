@@ -0,0 +1,246 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFs makes an erasure Fs over n local directories
+func newTestFs(t *testing.T, n, parity int) *Fs {
+	upstreams := ""
+	for i := 0; i < n; i++ {
+		upstreams += " " + t.TempDir()
+	}
+	f, err := fs.NewFs(context.Background(), fmt.Sprintf(":erasure,block_size=1k,parity_shards=%d,upstreams='%s':", parity, upstreams[1:]))
+	require.NoError(t, err)
+	return f.(*Fs)
+}
+
+// putData uploads random data to remote
+func putData(ctx context.Context, t *testing.T, f *Fs, remote string, size int) (*Object, []byte) {
+	data := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(int64(size))).Read(data)
+	src := object.NewStaticObjectInfo(remote, fstest.Time("2001-02-03T04:05:06.499999999Z"), int64(size), true, nil, nil)
+	o, err := f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+	return o.(*Object), data
+}
+
+// readData reads the data of remote from f
+func readData(ctx context.Context, t *testing.T, f *Fs, remote string, options ...fs.OpenOption) ([]byte, error) {
+	o, err := f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { require.NoError(t, in.Close()) }()
+	return io.ReadAll(in)
+}
+
+// corruptShard flips the bits of the byte at offset in shard, counting
+// from the end if offset is negative
+func corruptShard(ctx context.Context, t *testing.T, shard fs.Object, offset int) {
+	in, err := shard.Open(ctx)
+	require.NoError(t, err)
+	buf, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	if offset < 0 {
+		offset += len(buf)
+	}
+	buf[offset] ^= 0xFF
+	src := object.NewStaticObjectInfo(shard.Remote(), time.Now(), int64(len(buf)), true, nil, nil)
+	require.NoError(t, shard.Update(ctx, bytes.NewReader(buf), src))
+}
+
+// failFs is an upstream which fails all uploads
+type failFs struct {
+	fs.Fs
+}
+
+var errUploadFailed = errors.New("upload failed")
+
+// Put fails the upload
+func (f failFs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, errUploadFailed
+}
+
+func TestInternalFeatures(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	f := newTestFs(t, 3, 1)
+	features := f.Features()
+	assert.Equal(t, f.upstreams[0].Features().CaseInsensitive, features.CaseInsensitive)
+	assert.False(t, features.BucketBased)
+	assert.True(t, features.PartialUploads)
+}
+
+func TestInternalReconstruct(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	f := newTestFs(t, 6, 2)
+	o, data := putData(ctx, t, f, "dir/file.bin", 10000)
+	assert.Equal(t, 6, o.shardCount())
+
+	check := func() {
+		got, err := readData(ctx, t, f, "dir/file.bin")
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+		got, err = readData(ctx, t, f, "dir/file.bin", &fs.RangeOption{Start: 4000, End: 8999})
+		require.NoError(t, err)
+		assert.Equal(t, data[4000:9000], got)
+	}
+	check()
+
+	// Lose a data shard and a parity shard
+	require.NoError(t, o.shards[1].Remove(ctx))
+	require.NoError(t, o.shards[5].Remove(ctx))
+	check()
+
+	// Lose a shard on an upstream that has gone away
+	require.NoError(t, o.shards[0].Remove(ctx))
+	_, err := readData(ctx, t, f, "dir/file.bin")
+	assert.ErrorIs(t, err, ErrorNotEnoughShards)
+}
+
+func TestInternalVerifyRepair(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	f := newTestFs(t, 6, 2)
+	missing, missingData := putData(ctx, t, f, "missing.bin", 10000)
+	corrupt, corruptData := putData(ctx, t, f, "dir/corrupt.bin", 5000)
+	_, _ = putData(ctx, t, f, "dir/ok.bin", 100)
+
+	res, err := f.Command(ctx, "verify", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &checkResult{Files: 3, OK: 3}, res)
+
+	// Remove a shard of one file and corrupt a data shard of another
+	require.NoError(t, missing.shards[0].Remove(ctx))
+	corruptShard(ctx, t, corrupt.shards[3], headerSize+700)
+
+	res, err = f.Command(ctx, "verify", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &checkResult{Files: 3, OK: 1, Degraded: 2}, res)
+
+	res, err = f.Command(ctx, "repair", []string{""}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &checkResult{Files: 3, OK: 1, Degraded: 2, Repaired: 2}, res)
+
+	res, err = f.Command(ctx, "verify", []string{"/"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &checkResult{Files: 3, OK: 3}, res)
+
+	// Check the files can be read from the repaired shards
+	for remote, data := range map[string][]byte{"missing.bin": missingData, "dir/corrupt.bin": corruptData} {
+		o, err := f.NewObject(ctx, remote)
+		require.NoError(t, err)
+		require.NoError(t, o.(*Object).shards[1].Remove(ctx))
+		require.NoError(t, o.(*Object).shards[2].Remove(ctx))
+		got, err := readData(ctx, t, f, remote)
+		require.NoError(t, err)
+		assert.Equal(t, data, got, remote)
+	}
+
+	res, err = f.Command(ctx, "verify", []string{"dir"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &checkResult{Files: 2, OK: 1, Degraded: 1}, res)
+}
+
+func TestInternalUpdate(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	f := newTestFs(t, 3, 1)
+	_, _ = putData(ctx, t, f, "file.bin", 100)
+	_, data := putData(ctx, t, f, "file.bin", 2000)
+
+	// The shards are overwritten in place
+	for _, u := range f.upstreams {
+		entries, err := u.List(ctx, "")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "file.bin.ec", entries[0].Remote())
+	}
+	got, err := readData(ctx, t, f, "file.bin")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestInternalReadCorrupt(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	f := newTestFs(t, 6, 2)
+	o, data := putData(ctx, t, f, "file.bin", 10000)
+
+	// Blocks which fail their checksums are rebuilt from the parity
+	corruptShard(ctx, t, o.shards[0], headerSize+10)
+	corruptShard(ctx, t, o.shards[2], headerSize+1030)
+	got, err := readData(ctx, t, f, "file.bin")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	got, err = readData(ctx, t, f, "file.bin", &fs.RangeOption{Start: 1000, End: 1999})
+	require.NoError(t, err)
+	assert.Equal(t, data[1000:2000], got)
+
+	// A file which doesn't match its MD5 gives an error
+	o, _ = putData(ctx, t, f, "file.bin", 10000)
+	corruptShard(ctx, t, o.shards[0], -1)
+	_, err = readData(ctx, t, f, "file.bin")
+	assert.ErrorIs(t, err, ErrorBadMD5)
+}
+
+func TestInternalWriteQuorum(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	f := newTestFs(t, 6, 2)
+	upstream := f.upstreams[1]
+
+	// With write_quorum 1 one shard can fail
+	f.upstreams[1] = failFs{upstream}
+	o, data := putData(ctx, t, f, "file.bin", 10000)
+	assert.Equal(t, 5, o.shardCount())
+	got, err := readData(ctx, t, f, "file.bin")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// But not two
+	f.upstreams[4] = failFs{f.upstreams[4]}
+	src := object.NewStaticObjectInfo("file2.bin", time.Now(), int64(len(data)), true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewReader(data), src)
+	assert.ErrorIs(t, err, errUploadFailed)
+	f.upstreams[4] = f.upstreams[4].(failFs).Fs
+
+	// The missing shard can be rebuilt when the upstream is back
+	f.upstreams[1] = upstream
+	res, err := f.Command(ctx, "repair", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.(*checkResult).Repaired)
+	res, err = f.Command(ctx, "verify", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &checkResult{Files: 1, OK: 1}, res)
+}
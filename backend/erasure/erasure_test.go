@@ -0,0 +1,72 @@
+// Test Erasure filesystem interface
+package erasure_test
+
+import (
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var (
+	unimplementableFsMethods = []string{"UnWrap", "WrapFs", "SetWrapper", "UserInfo", "Disconnect", "OpenChunkWriter", "ListAt",
+		"MkdirMetadata", "ChangeNotify", "DirCacheFlush", "PublicLink", "PutUnchecked", "PutStream", "MergeDirs",
+		"DirSetModTime", "CleanUp", "ListR", "About", "OpenWriterAt", "Shutdown"}
+	unimplementableObjectMethods    = []string{}
+	unimplementableDirectoryMethods = []string{"Metadata", "SetMetadata", "RemoveMetadata", "SetModTime"}
+)
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName == "" {
+		t.Skip("Skipping as -remote not set")
+	}
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:                      *fstest.RemoteName,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
+
+func TestLocal(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	upstreams := t.TempDir() + " " + t.TempDir() + " " + t.TempDir()
+	name := "TestErasureLocal"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "erasure"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "block_size", Value: "1k"},
+		},
+		QuickTestOK:                     true,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
+
+func TestMemory(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	upstreams := ":memory:shard1 :memory:shard2 :memory:shard3 :memory:shard4 :memory:shard5 :memory:shard6"
+	name := "TestErasureMemory"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":dir",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "erasure"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "parity_shards", Value: "2"},
+		},
+		QuickTestOK:                     true,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
@@ -0,0 +1,298 @@
+package erasure
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "verify",
+	Short: "Check the shards of each file are present and consistent.",
+	Long: `This reads all the shards of each file under the path given and checks
+that they are present, that the parity is consistent with the data
+and that the data matches the MD5 stored with it.
+
+    rclone backend verify erasure:
+    rclone backend verify erasure:path/to/dir
+
+It returns a summary like this
+
+    {
+        "files": 1042,
+        "ok": 1039,
+        "degraded": 3,
+        "repaired": 0,
+        "unrecoverable": 0
+    }
+
+Degraded files can still be read but have missing or corrupt shards,
+or shards left over from an upload which didn't write all of them. Unrecoverable files don't have enough good shards to be read.
+It returns an error if any files are unrecoverable.
+`,
+}, {
+	Name:  "repair",
+	Short: "Rebuild missing and corrupt shards.",
+	Long: `This verifies the shards of each file under the path given like the
+verify command, then rebuilds the shards which are missing or corrupt
+from the good ones.
+
+    rclone backend repair erasure:
+    rclone backend repair erasure:path/to/dir
+
+Use this after replacing an upstream which was lost or to fix the
+damage found by the verify command. Use the --dry-run flag to see what
+would be repaired.
+
+It returns the same summary as the verify command.
+`,
+}}
+
+// checkResult is the summary returned by the verify and repair commands
+type checkResult struct {
+	Files         int `json:"files"`
+	OK            int `json:"ok"`
+	Degraded      int `json:"degraded"`
+	Repaired      int `json:"repaired"`
+	Unrecoverable int `json:"unrecoverable"`
+}
+
+// findCorrupt finds the block which doesn't agree with the others in
+// a stripe where all the blocks have been read or reconstructed.
+//
+// It tries reconstructing each block which was read from the others in
+// turn, returning its index if that makes the stripe consistent or -1
+// if the corrupt block can't be found. The block found is left
+// reconstructed.
+func (r *stripeReader) findCorrupt() int {
+	nRead := 0
+	for _, read := range r.read {
+		if read {
+			nRead++
+		}
+	}
+	// Need a spare block to tell which one is wrong
+	if nRead <= r.h.dataShards+1 {
+		return -1
+	}
+	blockLen := r.h.blockLen(r.stripe - 1)
+	saved := make([]byte, blockLen)
+	for j := range r.blocks {
+		if !r.read[j] {
+			continue
+		}
+		copy(saved, r.blocks[j])
+		for i := range r.blocks {
+			if i == j || !r.read[i] {
+				r.blocks[i] = r.blocks[i][:0]
+			}
+		}
+		if r.enc.Reconstruct(r.blocks) == nil {
+			if ok, _ := r.enc.Verify(r.blocks); ok {
+				return j
+			}
+		}
+		r.blocks[j] = r.blocks[j][:blockLen]
+		copy(r.blocks[j], saved)
+	}
+	return -1
+}
+
+// checkObject reads all the shards of o, returning which ones are
+// missing or bad along with the header.
+//
+// It returns an error if the file can't be read.
+func (f *Fs) checkObject(ctx context.Context, o *Object) (bad []bool, h *shardHeader, err error) {
+	h, err = o.loadHeader(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	bad = make([]bool, len(o.shards))
+	for i, shard := range o.shards {
+		if shard == nil {
+			fs.Logf(o, "Shard %d missing from %v", i, f.upstreams[i])
+			bad[i] = true
+			continue
+		}
+		sh, err := readHeader(ctx, shard)
+		if err == nil {
+			want := *h
+			want.index = i
+			if *sh != want || shard.Size() != h.objectSize(i) {
+				err = ErrorBadShard
+			}
+		}
+		if err != nil {
+			fs.Logf(o, "Shard %d on %v is bad: %v", i, f.upstreams[i], err)
+			bad[i] = true
+		}
+	}
+	sr, err := newStripeReader(ctx, o, h, 0, bad, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = sr.Close() }()
+	hasher := md5.New()
+	for {
+		err = sr.readStripe()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok, err := sr.enc.Verify(sr.blocks); err != nil || !ok {
+			j := sr.findCorrupt()
+			if j < 0 {
+				return nil, nil, fmt.Errorf("shards are inconsistent in stripe %d and the corrupt one can't be found", sr.stripe-1)
+			}
+			sr.fail(j, fmt.Errorf("corrupt data in stripe %d", sr.stripe-1))
+		}
+		_, _ = hasher.Write(sr.stripeData())
+	}
+	// Check the MD5 in the trailer of each shard
+	md5sum := hex.EncodeToString(hasher.Sum(nil))
+	good := 0
+	for i, shard := range o.shards {
+		if sr.bad[i] {
+			continue
+		}
+		var trailer string
+		if sr.in[i] != nil {
+			buf := make([]byte, trailerSize)
+			_, err = io.ReadFull(sr.in[i], buf)
+			trailer = hex.EncodeToString(buf)
+		} else {
+			trailer, err = readTrailer(ctx, shard)
+		}
+		if err == nil && trailer != md5sum {
+			err = errors.New("MD5 doesn't match the data")
+		}
+		if err != nil {
+			sr.fail(i, err)
+			continue
+		}
+		good++
+	}
+	if good == 0 {
+		return nil, nil, errors.New("data doesn't match the MD5 on any shard")
+	}
+	return sr.bad, h, nil
+}
+
+// repairObject rewrites the shards of o which are marked bad from the
+// good ones
+func (f *Fs) repairObject(ctx context.Context, o *Object, h *shardHeader, bad []bool) error {
+	sr, err := newStripeReader(ctx, o, h, 0, bad, false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sr.Close() }()
+	return f.writeShards(ctx, newObjectReader(sr, 0, -1), o, h, o.ModTime(ctx), bad, o.shards)
+}
+
+// check verifies and optionally repairs all the files under dir
+func (f *Fs) check(ctx context.Context, dir string, repair bool) (*checkResult, error) {
+	var objects []*Object
+	err := walk.ListR(ctx, f, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(func(o fs.Object) {
+			objects = append(objects, o.(*Object))
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mu  sync.Mutex
+		res = checkResult{Files: len(objects)}
+	)
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Checkers)
+	for _, o := range objects {
+		o := o
+		g.Go(func() error {
+			bad, h, err := f.checkObject(gCtx, o)
+			if err != nil {
+				fs.Errorf(o, "Unrecoverable: %v", err)
+				mu.Lock()
+				res.Unrecoverable++
+				mu.Unlock()
+				return nil
+			}
+			nBad := 0
+			for _, isBad := range bad {
+				if isBad {
+					nBad++
+				}
+			}
+			if nBad == 0 {
+				mu.Lock()
+				res.OK++
+				mu.Unlock()
+				return nil
+			}
+			fs.Logf(o, "Degraded: %d bad shards", nBad)
+			mu.Lock()
+			res.Degraded++
+			mu.Unlock()
+			if !repair || operations.SkipDestructive(gCtx, o, "repair shards") {
+				return nil
+			}
+			err = f.repairObject(gCtx, o, h, bad)
+			if err != nil {
+				fs.Errorf(o, "Failed to repair: %v", err)
+				return nil
+			}
+			fs.Infof(o, "Repaired")
+			mu.Lock()
+			res.Repaired++
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if res.Unrecoverable > 0 {
+		return &res, fmt.Errorf("%d files are unrecoverable", res.Unrecoverable)
+	}
+	if repair && res.Repaired < res.Degraded && !fs.GetConfig(ctx).DryRun {
+		return &res, fmt.Errorf("failed to repair %d files", res.Degraded-res.Repaired)
+	}
+	return &res, nil
+}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	dir := ""
+	if len(arg) > 0 {
+		dir = strings.Trim(arg[0], "/")
+	}
+	switch name {
+	case "verify":
+		return f.check(ctx, dir, false)
+	case "repair":
+		return f.check(ctx, dir, true)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
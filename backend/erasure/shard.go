@@ -0,0 +1,278 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Shard format
+//
+// Each file is cut into stripes of data_shards * block_size bytes. Each
+// stripe is split into data_shards blocks and parity_shards blocks of
+// parity are made from them with Reed-Solomon coding. Block i of each
+// stripe is appended to shard i which is stored on upstream i, followed
+// by a CRC-32C of the block, the upload ID and its position so that
+// corrupt and misplaced blocks can be found when they are read.
+//
+// The last stripe may be short, in which case its blocks are shortened
+// to the smallest size which holds the data. The data is split between
+// the data blocks in order, so the last data blocks may hold less data
+// than the others or none at all. These are stored without padding so
+// the size of the file is the sum of the data in the data shards.
+// Parity blocks are always full length.
+//
+// Each shard starts with a fixed size header describing the layout so
+// that files can be read whatever the current config is, and ends with
+// a trailer holding the MD5 of the file contents.
+//
+// Shards are named after the file with a suffix added so they can be
+// found without listing the directory.
+const (
+	shardMagic   = "RCLONEEC"
+	shardVersion = 1
+	headerSize   = 32
+	checksumSize = 4  // CRC-32C after each block
+	trailerSize  = 16 // MD5
+	shardSuffix  = ".ec"
+	idSize       = 8
+	maxShards    = 256
+)
+
+// Errors returned when reading shards
+var (
+	ErrorBadShard        = errors.New("bad erasure shard header")
+	ErrorBadChecksum     = errors.New("erasure shard block checksum mismatch")
+	ErrorNotEnoughShards = errors.New("not enough erasure shards to read file")
+)
+
+// crcTable is used for the block checksums
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// makeShardName returns the name the shards of remote are stored under
+func makeShardName(remote string) string {
+	return remote + shardSuffix
+}
+
+// parseShardName returns the remote a shard name is for, returning ok
+// false if it isn't a shard name
+func parseShardName(name string) (remote string, ok bool) {
+	remote, ok = strings.CutSuffix(name, shardSuffix)
+	if !ok || remote == "" || strings.HasSuffix(remote, "/") {
+		return "", false
+	}
+	return remote, true
+}
+
+// newID makes a random upload ID
+func newID() (string, error) {
+	var id [idSize]byte
+	_, err := io.ReadFull(rand.Reader, id[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to make upload ID: %w", err)
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// shardHeader describes the layout of a file
+type shardHeader struct {
+	dataShards   int
+	parityShards int
+	index        int // index of this shard
+	blockSize    int
+	size         int64  // size of the file
+	id           string // upload ID
+}
+
+// marshal the header with the index given
+func (h *shardHeader) marshal(index int) []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, shardMagic)
+	buf[8] = shardVersion
+	buf[9] = byte(h.dataShards - 1)
+	buf[10] = byte(h.parityShards)
+	buf[11] = byte(index)
+	binary.BigEndian.PutUint32(buf[12:], uint32(h.blockSize))
+	binary.BigEndian.PutUint64(buf[16:], uint64(h.size))
+	id, _ := hex.DecodeString(h.id)
+	copy(buf[24:], id)
+	return buf
+}
+
+// unmarshalHeader decodes a header written by marshal
+func unmarshalHeader(buf []byte) (*shardHeader, error) {
+	if len(buf) != headerSize || !bytes.Equal(buf[:8], []byte(shardMagic)) {
+		return nil, ErrorBadShard
+	}
+	if buf[8] != shardVersion {
+		return nil, fmt.Errorf("unknown erasure shard version %d", buf[8])
+	}
+	h := &shardHeader{
+		dataShards:   int(buf[9]) + 1,
+		parityShards: int(buf[10]),
+		index:        int(buf[11]),
+		blockSize:    int(binary.BigEndian.Uint32(buf[12:])),
+		size:         int64(binary.BigEndian.Uint64(buf[16:])),
+		id:           hex.EncodeToString(buf[24:32]),
+	}
+	if h.blockSize <= 0 || h.size < 0 || h.index >= h.dataShards+h.parityShards || h.dataShards+h.parityShards > maxShards {
+		return nil, ErrorBadShard
+	}
+	return h, nil
+}
+
+// shards returns the total number of shards
+func (h *shardHeader) shards() int {
+	return h.dataShards + h.parityShards
+}
+
+// stripeSize returns the number of bytes of the file in each stripe
+func (h *shardHeader) stripeSize() int64 {
+	return int64(h.dataShards) * int64(h.blockSize)
+}
+
+// stripes returns the number of stripes in the file
+func (h *shardHeader) stripes() int64 {
+	return (h.size + h.stripeSize() - 1) / h.stripeSize()
+}
+
+// stripeData returns the number of bytes of the file in stripe
+func (h *shardHeader) stripeData(stripe int64) int64 {
+	return min(h.stripeSize(), h.size-stripe*h.stripeSize())
+}
+
+// blockLen returns the length of each block in stripe
+func (h *shardHeader) blockLen(stripe int64) int {
+	n := h.stripeData(stripe)
+	return int((n + int64(h.dataShards) - 1) / int64(h.dataShards))
+}
+
+// storedLen returns the number of bytes of block i of stripe which
+// are stored in the shard
+func (h *shardHeader) storedLen(i int, stripe int64) int {
+	blockLen := h.blockLen(stripe)
+	if i >= h.dataShards {
+		return blockLen
+	}
+	n := h.stripeData(stripe) - int64(i)*int64(blockLen)
+	return int(max(0, min(int64(blockLen), n)))
+}
+
+// stripeOffset returns the offset of stripe in each shard
+func (h *shardHeader) stripeOffset(stripe int64) int64 {
+	return headerSize + stripe*int64(h.blockSize+checksumSize)
+}
+
+// objectSize returns the size of the object for shard i
+func (h *shardHeader) objectSize(i int) int64 {
+	stripes := h.stripes()
+	if stripes == 0 {
+		return headerSize + trailerSize
+	}
+	return h.stripeOffset(stripes-1) + int64(h.storedLen(i, stripes-1)) + checksumSize + trailerSize
+}
+
+// checksum returns the checksum of block i of stripe
+func (h *shardHeader) checksum(i int, stripe int64, block []byte) []byte {
+	var prefix [idSize + 1 + 8]byte
+	_, _ = hex.Decode(prefix[:idSize], []byte(h.id))
+	prefix[idSize] = byte(i)
+	binary.BigEndian.PutUint64(prefix[idSize+1:], uint64(stripe))
+	crc := crc32.Update(crc32.Checksum(prefix[:], crcTable), crcTable, block)
+	return binary.BigEndian.AppendUint32(nil, crc)
+}
+
+// sizeFromShards works out the size of a file from the sizes of its
+// shard objects, or -1 if not present. It returns ok false if this
+// isn't possible, which happens if shards of the data are missing or
+// the shards are from different uploads.
+//
+// This assumes the file was written with the dataShards and blockSize
+// given.
+func sizeFromShards(dataShards, blockSize int, sizes []int64) (size int64, ok bool) {
+	const overhead = headerSize + trailerSize
+	stripes := int64(-1)
+	for _, objectSize := range sizes {
+		if objectSize < 0 {
+			continue
+		}
+		if objectSize < overhead {
+			return 0, false
+		}
+		n := objectSize - overhead
+		s := (n + int64(blockSize+checksumSize) - 1) / int64(blockSize+checksumSize)
+		if stripes >= 0 && s != stripes {
+			return 0, false
+		}
+		stripes = s
+	}
+	if stripes <= 0 {
+		return 0, stripes == 0
+	}
+	// Add up the data in the last stripe of the data shards
+	var last int64
+	for i := 0; i < dataShards; i++ {
+		if sizes[i] < 0 {
+			return 0, false
+		}
+		last += sizes[i] - overhead - (stripes-1)*int64(blockSize+checksumSize) - checksumSize
+	}
+	h := shardHeader{
+		dataShards: dataShards,
+		blockSize:  blockSize,
+		size:       (stripes-1)*int64(dataShards)*int64(blockSize) + last,
+	}
+	// Check the layout agrees with all the shards
+	if last <= 0 || h.stripes() != stripes {
+		return 0, false
+	}
+	for i, objectSize := range sizes {
+		if objectSize >= 0 && h.objectSize(i) != objectSize {
+			return 0, false
+		}
+	}
+	return h.size, true
+}
+
+// readHeader reads the header of the shard o
+func readHeader(ctx context.Context, o fs.Object) (*shardHeader, error) {
+	in, err := o.Open(ctx, &fs.RangeOption{Start: 0, End: headerSize - 1})
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	buf := make([]byte, headerSize)
+	_, err = io.ReadFull(in, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shard header: %w", err)
+	}
+	return unmarshalHeader(buf)
+}
+
+// readTrailer reads the MD5 from the end of the shard o
+func readTrailer(ctx context.Context, o fs.Object) (md5sum string, err error) {
+	size := o.Size()
+	if size < headerSize+trailerSize {
+		return "", ErrorBadShard
+	}
+	in, err := o.Open(ctx, &fs.RangeOption{Start: size - trailerSize, End: size - 1})
+	if err != nil {
+		return "", err
+	}
+	defer fs.CheckClose(in, &err)
+	buf := make([]byte, trailerSize)
+	_, err = io.ReadFull(in, buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read shard trailer: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
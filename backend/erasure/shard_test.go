@@ -0,0 +1,121 @@
+package erasure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardName(t *testing.T) {
+	name := makeShardName("dir/file.txt")
+	assert.Equal(t, "dir/file.txt.ec", name)
+	remote, ok := parseShardName(name)
+	assert.True(t, ok)
+	assert.Equal(t, "dir/file.txt", remote)
+
+	for _, bad := range []string{
+		"file.txt",
+		".ec",
+		"dir/.ec",
+		"file.txt.ec.bak",
+	} {
+		_, ok := parseShardName(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestShardHeader(t *testing.T) {
+	h := &shardHeader{
+		dataShards:   4,
+		parityShards: 2,
+		blockSize:    1024,
+		size:         10000,
+		id:           "0123456789abcdef",
+	}
+	buf := h.marshal(5)
+	assert.Len(t, buf, headerSize)
+	got, err := unmarshalHeader(buf)
+	require.NoError(t, err)
+	want := *h
+	want.index = 5
+	assert.Equal(t, &want, got)
+
+	// stripes of 4096 bytes, the last one with 1808 bytes in 452 byte blocks
+	assert.Equal(t, 6, h.shards())
+	assert.Equal(t, int64(4096), h.stripeSize())
+	assert.Equal(t, int64(3), h.stripes())
+	assert.Equal(t, 1024, h.blockLen(0))
+	assert.Equal(t, int64(1808), h.stripeData(2))
+	assert.Equal(t, 452, h.blockLen(2))
+	// the data of the last stripe isn't padded in the data shards
+	assert.Equal(t, 452, h.storedLen(0, 2))
+	assert.Equal(t, 452, h.storedLen(3, 2))
+	assert.Equal(t, 452, h.storedLen(5, 2))
+	assert.Equal(t, int64(headerSize+2*1028+452+checksumSize+trailerSize), h.objectSize(0))
+	assert.Equal(t, int64(headerSize+2*1028+452+checksumSize+trailerSize), h.objectSize(5))
+
+	// the last data block is shorter than the parity if the data
+	// doesn't divide evenly
+	h.size = 8999
+	assert.Equal(t, 202, h.blockLen(2))
+	assert.Equal(t, 202, h.storedLen(2, 2))
+	assert.Equal(t, 201, h.storedLen(3, 2))
+	assert.Equal(t, 202, h.storedLen(4, 2))
+
+	h.size = 0
+	assert.Equal(t, int64(0), h.stripes())
+	assert.Equal(t, int64(headerSize+trailerSize), h.objectSize(0))
+
+	_, err = unmarshalHeader(buf[1:])
+	assert.Equal(t, ErrorBadShard, err)
+	buf[0] = 'X'
+	_, err = unmarshalHeader(buf)
+	assert.Equal(t, ErrorBadShard, err)
+	buf = h.marshal(6)
+	_, err = unmarshalHeader(buf)
+	assert.Equal(t, ErrorBadShard, err)
+}
+
+func TestSizeFromShards(t *testing.T) {
+	h := &shardHeader{
+		dataShards:   4,
+		parityShards: 2,
+		blockSize:    1024,
+	}
+	sizes := func() []int64 {
+		sizes := make([]int64, h.shards())
+		for i := range sizes {
+			sizes[i] = h.objectSize(i)
+		}
+		return sizes
+	}
+	for _, size := range []int64{0, 1, 3, 4, 5, 1023, 1024, 4095, 4096, 4097, 8999, 10000} {
+		h.size = size
+		got, ok := sizeFromShards(h.dataShards, h.blockSize, sizes())
+		assert.True(t, ok, size)
+		assert.Equal(t, size, got)
+
+		// Missing parity shards don't matter
+		s := sizes()
+		s[5] = -1
+		got, ok = sizeFromShards(h.dataShards, h.blockSize, s)
+		assert.True(t, ok, size)
+		assert.Equal(t, size, got)
+
+		// Missing data shards do
+		if size > 0 {
+			s[1] = -1
+			_, ok = sizeFromShards(h.dataShards, h.blockSize, s)
+			assert.False(t, ok, size)
+		}
+	}
+
+	// Shards from uploads of different sizes
+	h.size = 10000
+	s := sizes()
+	h.size = 100
+	s[2] = h.objectSize(2)
+	_, ok := sizeFromShards(h.dataShards, h.blockSize, s)
+	assert.False(t, ok)
+}
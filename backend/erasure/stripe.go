@@ -0,0 +1,387 @@
+package erasure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/rclone/rclone/fs"
+)
+
+// ErrorBadMD5 is returned when a file doesn't match the MD5 stored in
+// its shards
+var ErrorBadMD5 = errors.New("erasure file data doesn't match its MD5")
+
+// stripeReader reads the stripes of an object from its shards,
+// reconstructing the blocks of shards which are missing or fail
+type stripeReader struct {
+	ctx    context.Context
+	o      *Object
+	h      *shardHeader
+	enc    reedsolomon.Encoder
+	all    bool            // read all the shards rather than just enough to decode the data
+	in     []io.ReadCloser // open shards or nil
+	bad    []bool          // set if the shard can't be used
+	read   []bool          // set if the block was read in the current stripe
+	blocks [][]byte        // blocks of the current stripe
+	stripe int64           // the next stripe to read
+	data   []byte          // buffer for the data of the current stripe
+	sum    [checksumSize]byte
+}
+
+// newStripeReader makes a reader for o starting at stripe
+//
+// Shards which are marked in bad won't be read. If all is set then
+// all the other shards are read, otherwise only the first ones which
+// are needed to decode the data.
+func newStripeReader(ctx context.Context, o *Object, h *shardHeader, stripe int64, bad []bool, all bool) (*stripeReader, error) {
+	n := h.shards()
+	if n != len(o.shards) {
+		return nil, fmt.Errorf("file has %d shards but there are %d upstreams", n, len(o.shards))
+	}
+	enc, err := reedsolomon.New(h.dataShards, h.parityShards)
+	if err != nil {
+		return nil, err
+	}
+	r := &stripeReader{
+		ctx:    ctx,
+		o:      o,
+		h:      h,
+		enc:    enc,
+		all:    all,
+		in:     make([]io.ReadCloser, n),
+		bad:    make([]bool, n),
+		read:   make([]bool, n),
+		blocks: make([][]byte, n),
+		stripe: stripe,
+		data:   make([]byte, h.stripeSize()),
+	}
+	for i := range r.blocks {
+		r.blocks[i] = make([]byte, h.blockSize)
+		r.bad[i] = o.shards[i] == nil || (bad != nil && bad[i])
+	}
+	return r, nil
+}
+
+// open shard i at the current stripe
+func (r *stripeReader) open(i int) error {
+	start := r.h.stripeOffset(r.stripe)
+	in, err := r.o.shards[i].Open(r.ctx, &fs.RangeOption{Start: start, End: -1})
+	if err != nil {
+		return err
+	}
+	r.in[i] = in
+	return nil
+}
+
+// fail marks shard i as bad
+func (r *stripeReader) fail(i int, err error) {
+	fs.Errorf(r.o, "Failed to read shard %d from %v: %v", i, r.o.f.upstreams[i], err)
+	r.bad[i] = true
+	if r.in[i] != nil {
+		_ = r.in[i].Close()
+		r.in[i] = nil
+	}
+}
+
+// readBlock reads block i of the current stripe into r.blocks[i],
+// padding it to blockLen with zeros and checking its checksum
+func (r *stripeReader) readBlock(i int, blockLen int) error {
+	n := r.h.storedLen(i, r.stripe)
+	block := r.blocks[i][:blockLen]
+	if _, err := io.ReadFull(r.in[i], block[:n]); err != nil {
+		return err
+	}
+	clear(block[n:])
+	if _, err := io.ReadFull(r.in[i], r.sum[:]); err != nil {
+		return err
+	}
+	if !bytes.Equal(r.sum[:], r.h.checksum(i, r.stripe, block[:n])) {
+		return fmt.Errorf("%w in stripe %d", ErrorBadChecksum, r.stripe)
+	}
+	r.blocks[i] = block
+	return nil
+}
+
+// readStripe reads the next stripe into r.blocks, reconstructing the
+// missing blocks
+//
+// Blocks which fail their checksum are treated as missing so they are
+// reconstructed from the parity.
+func (r *stripeReader) readStripe() error {
+	if r.stripe >= r.h.stripes() {
+		return io.EOF
+	}
+	blockLen := r.h.blockLen(r.stripe)
+	nRead := 0
+	for i := range r.blocks {
+		r.read[i] = false
+		r.blocks[i] = r.blocks[i][:0]
+		if r.bad[i] || (!r.all && nRead >= r.h.dataShards) {
+			continue
+		}
+		if r.in[i] == nil {
+			if err := r.open(i); err != nil {
+				r.fail(i, err)
+				continue
+			}
+		}
+		if err := r.readBlock(i, blockLen); err != nil {
+			r.fail(i, err)
+			continue
+		}
+		r.read[i] = true
+		nRead++
+	}
+	if nRead < r.h.dataShards {
+		return fmt.Errorf("%w: only %d of the %d shards needed could be read", ErrorNotEnoughShards, nRead, r.h.dataShards)
+	}
+	if nRead < len(r.blocks) {
+		var err error
+		if r.all {
+			err = r.enc.Reconstruct(r.blocks)
+		} else {
+			err = r.enc.ReconstructData(r.blocks)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to reconstruct stripe %d: %w", r.stripe, err)
+		}
+	}
+	r.stripe++
+	return nil
+}
+
+// stripeData returns the file data of the stripe just read
+func (r *stripeReader) stripeData() []byte {
+	data := r.data[:0]
+	for i := 0; i < r.h.dataShards; i++ {
+		data = append(data, r.blocks[i]...)
+	}
+	return data[:r.h.stripeData(r.stripe-1)]
+}
+
+// trailer reads the MD5 from the trailer of the first good shard
+// which was read to the end, returning "" if there isn't one
+func (r *stripeReader) trailer() (md5sum string, err error) {
+	for i, in := range r.in {
+		if in == nil || r.bad[i] {
+			continue
+		}
+		buf := make([]byte, trailerSize)
+		if _, err = io.ReadFull(in, buf); err != nil {
+			return "", fmt.Errorf("failed to read shard trailer: %w", err)
+		}
+		return hex.EncodeToString(buf), nil
+	}
+	return "", nil
+}
+
+// Close all the open shards
+func (r *stripeReader) Close() error {
+	for i, in := range r.in {
+		if in != nil {
+			_ = in.Close()
+			r.in[i] = nil
+		}
+	}
+	return nil
+}
+
+// objectReader reads the data of an object
+type objectReader struct {
+	sr        *stripeReader
+	buf       []byte      // data of the current stripe not yet returned
+	skip      int64       // bytes to skip at the start of the first stripe
+	remaining int64       // bytes left to return or -1 for all
+	hasher    gohash.Hash // if set the MD5 of the data is checked at the end
+}
+
+// checkMD5 checks the MD5 of the data read against the trailer
+func (r *objectReader) checkMD5() error {
+	want, err := r.sr.trailer()
+	if err != nil {
+		return err
+	}
+	got := hex.EncodeToString(r.hasher.Sum(nil))
+	r.hasher = nil
+	if want != "" && want != got {
+		return ErrorBadMD5
+	}
+	return nil
+}
+
+// Read data from the object
+func (r *objectReader) Read(p []byte) (n int, err error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	for len(r.buf) == 0 {
+		err = r.sr.readStripe()
+		if err == io.EOF && r.hasher != nil {
+			if md5Err := r.checkMD5(); md5Err != nil {
+				return 0, md5Err
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.buf = r.sr.stripeData()
+		if r.hasher != nil {
+			_, _ = r.hasher.Write(r.buf)
+		}
+		if r.skip > 0 {
+			r.buf = r.buf[min(r.skip, int64(len(r.buf))):]
+			r.skip = 0
+		}
+	}
+	buf := r.buf
+	if r.remaining >= 0 && int64(len(buf)) > r.remaining {
+		buf = buf[:r.remaining]
+	}
+	n = copy(p, buf)
+	r.buf = r.buf[n:]
+	if r.remaining >= 0 {
+		r.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+// Close the object
+func (r *objectReader) Close() error {
+	return r.sr.Close()
+}
+
+// newObjectReader makes a reader for the data of o from offset
+// returning limit bytes or all of them if limit is -1.
+//
+// If the whole file is read its MD5 is checked against the one stored
+// with it.
+func newObjectReader(sr *stripeReader, offset, limit int64) *objectReader {
+	r := &objectReader{
+		sr:        sr,
+		skip:      offset - sr.stripe*sr.h.stripeSize(),
+		remaining: limit,
+	}
+	if offset == 0 && (limit < 0 || limit >= sr.h.size) {
+		r.hasher = md5.New()
+	}
+	return r
+}
+
+// encode reads the file data from in, splitting it into shards which
+// are written to out.
+//
+// Only the shards with a non nil writer in out are written. If a
+// writer fails the others carry on as long as at least need of them
+// are still working. It returns the MD5 of the data which is written
+// in the trailer.
+func encode(in io.Reader, h *shardHeader, out []io.Writer, need int) (md5sum []byte, err error) {
+	enc, err := reedsolomon.New(h.dataShards, h.parityShards)
+	if err != nil {
+		return nil, err
+	}
+	out = append([]io.Writer(nil), out...)
+	live := 0
+	for _, w := range out {
+		if w != nil {
+			live++
+		}
+	}
+	var failed []error
+	// write the same number of blocks to each writer in parallel
+	// dropping the ones which fail
+	write := func(blocks [][]byte) error {
+		errs := make([]error, len(out))
+		var wg sync.WaitGroup
+		for i, w := range out {
+			if w == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, w io.Writer) {
+				defer wg.Done()
+				_, errs[i] = w.Write(blocks[i])
+			}(i, w)
+		}
+		wg.Wait()
+		for i, err := range errs {
+			if err != nil {
+				out[i] = nil
+				live--
+				failed = append(failed, err)
+			}
+		}
+		if live < need {
+			return fmt.Errorf("only %d of the %d shards needed can be written: %w", live, need, errors.Join(failed...))
+		}
+		return nil
+	}
+	headers := make([][]byte, len(out))
+	for i := range out {
+		headers[i] = h.marshal(i)
+	}
+	if err = write(headers); err != nil {
+		return nil, err
+	}
+	hasher := md5.New()
+	in = io.TeeReader(in, hasher)
+	data := make([]byte, h.stripeSize())
+	blocks := make([][]byte, h.shards())
+	stored := make([][]byte, h.shards())
+	sums := make([][]byte, h.shards())
+	for i := h.dataShards; i < len(blocks); i++ {
+		blocks[i] = make([]byte, h.blockSize)
+	}
+	var total int64
+	for stripe := int64(0); stripe < h.stripes(); stripe++ {
+		n, err := io.ReadFull(in, data[:h.stripeData(stripe)])
+		total += int64(n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file data after %d bytes: %w", total, err)
+		}
+		blockLen := h.blockLen(stripe)
+		// zero the padding in the last block
+		clear(data[n : blockLen*h.dataShards])
+		for i := range blocks {
+			if i < h.dataShards {
+				blocks[i] = data[i*blockLen : (i+1)*blockLen]
+			} else {
+				blocks[i] = blocks[i][:blockLen]
+			}
+		}
+		if err = enc.Encode(blocks); err != nil {
+			return nil, fmt.Errorf("failed to encode stripe %d: %w", stripe, err)
+		}
+		for i := range blocks {
+			stored[i] = blocks[i][:h.storedLen(i, stripe)]
+			sums[i] = h.checksum(i, stripe, stored[i])
+		}
+		if err = write(stored); err != nil {
+			return nil, err
+		}
+		if err = write(sums); err != nil {
+			return nil, err
+		}
+	}
+	// Check there is no more data
+	n, _ := io.ReadFull(in, data[:1])
+	if n != 0 || total != h.size {
+		return nil, errors.New("file data is not the size expected")
+	}
+	md5sum = hasher.Sum(nil)
+	trailers := make([][]byte, len(out))
+	for i := range out {
+		trailers[i] = md5sum
+	}
+	if err = write(trailers); err != nil {
+		return nil, err
+	}
+	return md5sum, nil
+}
@@ -35,7 +35,23 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 		if len(arg) != 2 {
 			return nil, errors.New("please provide checksum type and path to sum file")
 		}
+		if arg[0] == "json" {
+			return nil, f.jsonImport(ctx, arg[1], sticky)
+		}
 		return nil, f.dbImport(ctx, arg[0], arg[1], sticky)
+	case "export":
+		if len(arg) != 1 {
+			return nil, errors.New("please provide checksum type or json")
+		}
+		return f.dbExport(ctx, arg[0])
+	case "syncdb":
+		return nil, f.pushShared(ctx)
+	case "scrub":
+		bytesPerSecond, interval, err := f.scrubOptions(opt)
+		if err != nil {
+			return nil, err
+		}
+		return f.newScrubber(bytesPerSecond, interval).run(ctx, "")
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
@@ -62,6 +78,11 @@ Usage Example:
 	Long: `Amend hash cache from a SUM file and bind checksums to files by size/time.
 Usage Example:
     rclone backend import hasher:subdir md5 /path/to/sum.md5
+
+Use json instead of the checksum type to import a file made by the
+export command. Its checksums are only bound to files which still
+match the size, modification time and fingerprint recorded.
+    rclone backend import hasher:subdir json /path/to/sums.json
 `,
 }, {
 	Name:  "stickyimport",
@@ -70,6 +91,49 @@ Usage Example:
 Usage Example:
     rclone backend stickyimport hasher:subdir md5 remote:path/to/sum.md5
 `,
+}, {
+	Name:  "export",
+	Short: "Export checksums from the cache",
+	Long: `Export cache records covered by the current remote.
+
+Give a checksum type to output them in the format of a SUM file as
+made by md5sum or sha256sum, or json to output them as JSON with the
+size, modification time and fingerprint of each file so that they can
+be imported elsewhere.
+Usage Example:
+    rclone backend export hasher:subdir md5 > sum.md5
+    rclone backend export hasher:subdir json > sums.json
+`,
+}, {
+	Name:  "syncdb",
+	Short: "Synchronise the shared checksum database",
+	Long: `Merge the file set with shared_db into the cache and upload the
+result. This is done automatically when rclone exits but can be used
+to share the checksums of a long running process.
+Usage Example:
+    rclone backend syncdb hasher:
+`,
+}, {
+	Name:  "scrub",
+	Short: "Verify cached checksums and detect bitrot",
+	Long: `Re-read the files covered by the current remote and compare them
+with the checksums in the cache.
+
+Files whose fingerprint still matches but whose contents don't match
+the cached checksums are reported as bitrot and counted as errors.
+Files without cached checksums, or which changed since they were
+hashed, have the checksums read cached. The time each file was last
+verified is kept in the cache.
+
+The result is a JSON report of the counts of files in each state and
+the details of the files with bitrot.
+Usage Example:
+    rclone backend scrub hasher:subdir -o rate=10M -o interval=7d
+`,
+	Opts: map[string]string{
+		"rate":     "Maximum rate in bytes/s to read files at (default scrub_rate)",
+		"interval": "Skip files verified more recently than this",
+	},
 }}
 
 func (f *Fs) dbDump(ctx context.Context, full bool, root string) error {
@@ -118,24 +182,10 @@ func (f *Fs) dbImport(ctx context.Context, hashName, sumRemote string, sticky bo
 		return nil
 	}
 
-	_, sumPath, err := fspath.SplitFs(sumRemote)
+	sumObj, err := openSumFile(ctx, sumRemote)
 	if err != nil {
 		return err
 	}
-	sumFs, err := cache.Get(ctx, sumRemote)
-	switch err {
-	case fs.ErrorIsFile:
-		// ok
-	case nil:
-		return fmt.Errorf("not a file: %s", sumRemote)
-	default:
-		return err
-	}
-
-	sumObj, err := sumFs.NewObject(ctx, path.Base(sumPath))
-	if err != nil {
-		return fmt.Errorf("cannot open sum file: %w", err)
-	}
 	hashes, err := operations.ParseSumFile(ctx, sumObj)
 	if err != nil {
 		return fmt.Errorf("failed to parse sum file: %w", err)
@@ -186,3 +236,26 @@ func (f *Fs) dbImport(ctx context.Context, hashName, sumRemote string, sticky bo
 	fs.Infof(nil, "Summary: %d imported, %d skipped", doneCount, skipCount)
 	return err
 }
+
+// openSumFile finds the object holding a sum file
+func openSumFile(ctx context.Context, sumRemote string) (fs.Object, error) {
+	_, sumPath, err := fspath.SplitFs(sumRemote)
+	if err != nil {
+		return nil, err
+	}
+	sumFs, err := cache.Get(ctx, sumRemote)
+	switch err {
+	case fs.ErrorIsFile:
+		// ok
+	case nil:
+		return nil, fmt.Errorf("not a file: %s", sumRemote)
+	default:
+		return nil, err
+	}
+
+	sumObj, err := sumFs.NewObject(ctx, path.Base(sumPath))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open sum file: %w", err)
+	}
+	return sumObj, nil
+}
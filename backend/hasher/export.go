@@ -0,0 +1,344 @@
+package hasher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// exportRecord is a cache record as exported to JSON
+type exportRecord struct {
+	Path        string              `json:"path"`
+	Size        int64               `json:"size"`              // -1 if unknown
+	ModTime     string              `json:"modtime,omitempty"` // RFC3339 or empty if not in the fingerprint
+	Fingerprint string              `json:"fingerprint,omitempty"`
+	Created     time.Time           `json:"created"`
+	Hashes      operations.HashSums `json:"hashes"`
+}
+
+// newExportRecord converts a cache record for export
+func newExportRecord(remote string, r *hashRecord) exportRecord {
+	rec := exportRecord{
+		Path:        remote,
+		Size:        -1,
+		Fingerprint: r.Fp,
+		Created:     r.Created.UTC(),
+		Hashes:      r.Hashes,
+	}
+	// the fingerprint is "size,modtime,hash" with "-" for missing parts
+	parts := strings.SplitN(r.Fp, ",", 3)
+	if len(parts) == 3 {
+		if size, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+			rec.Size = size
+		}
+		if modTime, err := time.Parse(timeFormat, parts[1]); err == nil {
+			rec.ModTime = modTime.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if rec.Hashes == nil {
+		rec.Hashes = operations.HashSums{}
+	}
+	return rec
+}
+
+// record converts an imported record back into a cache record
+func (rec *exportRecord) record() *hashRecord {
+	fp := rec.Fingerprint
+	if fp == "" {
+		fp = anyFingerprint
+	}
+	return &hashRecord{
+		Fp:      fp,
+		Hashes:  rec.Hashes,
+		Created: rec.Created,
+	}
+}
+
+// matches returns true if the record belongs to the object with the
+// fingerprint fp.
+//
+// Records without a fingerprint are matched by size and, if present,
+// modification time.
+func (rec *exportRecord) matches(ctx context.Context, o *Object, fp string) bool {
+	switch {
+	case rec.Fingerprint == anyFingerprint:
+		return true
+	case rec.Fingerprint != "":
+		return rec.Fingerprint == fp
+	case rec.Size != o.Size():
+		return false
+	case rec.ModTime == "":
+		return true
+	}
+	modTime, err := time.Parse(time.RFC3339Nano, rec.ModTime)
+	if err != nil {
+		return false
+	}
+	dt := o.ModTime(ctx).Sub(modTime)
+	window := fs.GetModifyWindow(ctx, o.f)
+	return dt <= window && dt >= -window
+}
+
+// kvExport: read records under a root
+type kvExport struct {
+	root    string
+	records []exportRecord
+}
+
+func (op *kvExport) Do(ctx context.Context, b kv.Bucket) error {
+	prefix := op.root
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	cur := b.Cursor()
+	var bkey, data []byte
+	if op.root != "" {
+		bkey, data = cur.Seek([]byte(op.root))
+	} else {
+		bkey, data = cur.First()
+	}
+	for ; bkey != nil; bkey, data = cur.Next() {
+		key := string(bkey)
+		var remote string
+		switch {
+		case key == op.root:
+			remote = path.Base(key)
+		case strings.HasPrefix(key, prefix):
+			remote = key[len(prefix):]
+		case key < prefix:
+			// keys sorting between root and root+"/" such as "root.txt"
+			continue
+		default:
+			return nil
+		}
+		var r hashRecord
+		if err := r.decode(key, data); err != nil {
+			fs.Errorf(nil, "%s: invalid record: %v", key, err)
+			continue
+		}
+		op.records = append(op.records, newExportRecord(remote, &r))
+	}
+	return nil
+}
+
+// kvMerge: merge records into the database
+//
+// A record replaces the local one if it is newer and has a different
+// fingerprint. Records with the same fingerprint have their hashes
+// combined.
+type kvMerge struct {
+	records []exportRecord
+	age     time.Duration
+	count   int
+}
+
+func (op *kvMerge) Do(ctx context.Context, b kv.Bucket) error {
+	for i := range op.records {
+		rec := &op.records[i]
+		if rec.Path == "" || len(rec.Hashes) == 0 || time.Since(rec.Created) > op.age {
+			continue
+		}
+		key := rec.Path
+		in := rec.record()
+		var r hashRecord
+		data := b.Get([]byte(key))
+		switch {
+		case len(data) == 0 || r.decode(key, data) != nil:
+			r = *in
+		case r.Fp == in.Fp:
+			changed := false
+			for hashName, hashVal := range in.Hashes {
+				if r.Hashes == nil {
+					r.Hashes = operations.HashSums{}
+				}
+				if r.Hashes[hashName] == "" {
+					r.Hashes[hashName] = hashVal
+					changed = true
+				}
+			}
+			if in.Created.After(r.Created) {
+				r.Created = in.Created
+				changed = true
+			}
+			if !changed {
+				continue
+			}
+		case in.Created.After(r.Created):
+			r = *in
+		default:
+			continue
+		}
+		data, err := r.encode(key)
+		if err != nil {
+			return fmt.Errorf("marshal failed: %w", err)
+		}
+		if err = b.Put([]byte(key), data); err != nil {
+			return fmt.Errorf("put failed: %w", err)
+		}
+		op.count++
+	}
+	return nil
+}
+
+// exportRecords returns the records under root
+func (f *Fs) exportRecords(root string) ([]exportRecord, error) {
+	if f.db == nil {
+		return nil, kv.ErrInactive
+	}
+	op := &kvExport{root: root}
+	err := f.db.Do(false, op)
+	if err != nil && err != kv.ErrEmpty {
+		return nil, err
+	}
+	if op.records == nil {
+		op.records = []exportRecord{}
+	}
+	return op.records, nil
+}
+
+// dbExport exports the records covered by the current remote either
+// in the format of a SUM file for the hash given or as JSON
+func (f *Fs) dbExport(ctx context.Context, format string) (out interface{}, err error) {
+	if f.db == nil {
+		return nil, fmt.Errorf("db not found: %w", kv.ErrInactive)
+	}
+	var hashType hash.Type
+	if format != "json" {
+		if err := hashType.Set(format); err != nil {
+			return nil, err
+		}
+		if !f.keepHashes.Contains(hashType) {
+			return nil, fmt.Errorf("%v checksums are not kept in the cache", hashType)
+		}
+	}
+	records, err := f.exportRecords(f.Fs.Root())
+	if err != nil {
+		return nil, err
+	}
+	if format == "json" {
+		return records, nil
+	}
+	hashName := hashType.String()
+	lines := []string{}
+	for _, rec := range records {
+		if hashVal := rec.Hashes[hashName]; hashVal != "" {
+			lines = append(lines, hashVal+"  "+rec.Path)
+		}
+	}
+	return lines, nil
+}
+
+// keptHashes returns the hashes from sums which are kept in the cache
+func (f *Fs) keptHashes(sums operations.HashSums) operations.HashSums {
+	hashes := operations.HashSums{}
+	for hashName, hashVal := range sums {
+		var hashType hash.Type
+		if hashType.Set(hashName) == nil && f.keepHashes.Contains(hashType) && hashVal != "" {
+			hashes[hashName] = hashVal
+		}
+	}
+	return hashes
+}
+
+// readRecords reads exported records from in
+func readRecords(in io.Reader) (records []exportRecord, err error) {
+	if err = json.NewDecoder(in).Decode(&records); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return records, nil
+}
+
+// jsonImport imports records exported as JSON
+//
+// Unless sticky is set, records are only bound to the objects whose
+// fingerprint, or size and modification time, they match.
+func (f *Fs) jsonImport(ctx context.Context, sumRemote string, sticky bool) error {
+	if f.db == nil {
+		return fmt.Errorf("db not found: %w", kv.ErrInactive)
+	}
+	sumObj, err := openSumFile(ctx, sumRemote)
+	if err != nil {
+		return err
+	}
+	in, err := operations.Open(ctx, sumObj)
+	if err != nil {
+		return err
+	}
+	records, err := readRecords(in)
+	_ = in.Close()
+	if err != nil {
+		return err
+	}
+
+	rootPath := f.Fs.Root()
+	if sticky {
+		doneCount := 0
+		for _, rec := range records {
+			hashes := f.keptHashes(rec.Hashes)
+			if len(hashes) == 0 {
+				continue
+			}
+			fp := rec.Fingerprint
+			if fp == "" {
+				fp = anyFingerprint
+			}
+			if err := f.putRawHashes(ctx, path.Join(rootPath, rec.Path), fp, hashes); err != nil {
+				fs.Errorf(nil, "%s: failed to import: %v", rec.Path, err)
+				continue
+			}
+			doneCount++
+		}
+		fs.Infof(nil, "Summary: %d checksum record(s) imported", doneCount)
+		return nil
+	}
+
+	byPath := make(map[string]*exportRecord, len(records))
+	for i := range records {
+		byPath[records[i].Path] = &records[i]
+	}
+	doneCount, mismatchCount := 0, 0
+	err = operations.ListFn(ctx, f, func(obj fs.Object) {
+		remote := obj.Remote()
+		rec := byPath[remote]
+		o, ok := obj.(*Object)
+		if !ok || rec == nil {
+			return
+		}
+		delete(byPath, remote)
+		fp := o.fingerprint(ctx)
+		if fp == "" || !rec.matches(ctx, o, fp) {
+			fs.Infof(o, "Skip changed object")
+			mismatchCount++
+			return
+		}
+		hashes := f.keptHashes(rec.Hashes)
+		if len(hashes) == 0 {
+			return
+		}
+		err := f.putRawHashes(ctx, path.Join(rootPath, remote), fp, hashes)
+		if err != nil {
+			fs.Errorf(nil, "%s: failed to import: %v", remote, err)
+		}
+		accounting.Stats(ctx).NewCheckingTransfer(obj, "importing").Done(ctx, err)
+		doneCount++
+	})
+	if err != nil {
+		fs.Errorf(nil, "Import failed: %v", err)
+	}
+	for remote := range byPath {
+		fs.Infof(nil, "Skip vanished object: %s", remote)
+	}
+	fs.Infof(nil, "Summary: %d imported, %d changed, %d skipped", doneCount, mismatchCount, len(byPath))
+	return err
+}
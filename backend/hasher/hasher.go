@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"path"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -50,16 +51,35 @@ func init() {
 			Advanced: true,
 			Default:  fs.SizeSuffix(0),
 			Help:     "Auto-update checksum for files smaller than this size (disabled by default).",
+		}, {
+			Name:     "shared_db",
+			Advanced: true,
+			Help: `Remote file to share the checksum cache through (e.g. myRemote:hasher/db.json).
+
+If set, the checksums in this file are merged into the local cache
+when the remote is opened, and the local cache is merged back into it
+when rclone exits, so that several hosts can use the same checksums.
+
+It needs max_age to be other than 0.`,
+		}, {
+			Name:     "scrub_rate",
+			Advanced: true,
+			Default:  fs.SizeSuffix(0),
+			Help: `Maximum rate in bytes/s to read files at when scrubbing (0 = unlimited).
+
+This is the default for the rate option of the scrub command.`,
 		}},
 	})
 }
 
 // Options defines the configuration for this backend
 type Options struct {
-	Remote   string          `config:"remote"`
-	Hashes   fs.CommaSepList `config:"hashes"`
-	AutoSize fs.SizeSuffix   `config:"auto_size"`
-	MaxAge   fs.Duration     `config:"max_age"`
+	Remote    string          `config:"remote"`
+	Hashes    fs.CommaSepList `config:"hashes"`
+	AutoSize  fs.SizeSuffix   `config:"auto_size"`
+	MaxAge    fs.Duration     `config:"max_age"`
+	SharedDB  string          `config:"shared_db"`
+	ScrubRate fs.SizeSuffix   `config:"scrub_rate"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -71,6 +91,9 @@ type Fs struct {
 	features *fs.Features
 	opt      *Options
 	db       *kv.DB
+	// shared database
+	sharedFs   fs.Fs  // remote holding the shared database or nil
+	sharedLeaf string // name of the shared database in sharedFs
 	// fingerprinting
 	fpTime bool      // true if using time in fingerprints
 	fpHash hash.Type // hash type to use in fingerprints or None
@@ -163,6 +186,15 @@ func NewFs(ctx context.Context, fsname, rpath string, cmap configmap.Mapper) (fs
 		f.db = db
 	}
 
+	if f.opt.SharedDB != "" {
+		if err := f.setShared(ctx, f.opt.SharedDB); err != nil {
+			return nil, err
+		}
+		if _, _, err := f.pullShared(ctx); err != nil {
+			fs.Errorf(f, "Failed to read shared database: %v", err)
+		}
+	}
+
 	stubFeatures := &fs.Features{
 		CanHaveEmptyDirectories:  true,
 		IsLocal:                  true,
@@ -182,7 +214,16 @@ func NewFs(ctx context.Context, fsname, rpath string, cmap configmap.Mapper) (fs
 	}
 	f.features = stubFeatures.Fill(ctx, f).Mask(ctx, f.Fs).WrapsFs(f, f.Fs)
 
-	cache.PinUntilFinalized(f.Fs, f)
+	cache.Pin(f.Fs)
+	if f.sharedFs != nil {
+		cache.Pin(f.sharedFs)
+	}
+	runtime.SetFinalizer(f, func(f *Fs) {
+		cache.Unpin(f.Fs)
+		if f.sharedFs != nil {
+			cache.Unpin(f.sharedFs)
+		}
+	})
 	return f, err
 }
 
@@ -243,6 +284,18 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 	return f.wrapEntries(entries)
 }
 
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using old versions of the objects.
+//
+// Cached hashes are checked against the fingerprint of the version
+// read so the hashes of the current version aren't returned for it.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	if entries, err = f.Fs.Features().ListAt(ctx, dir, at); err != nil {
+		return nil, err
+	}
+	return f.wrapEntries(entries)
+}
+
 // ListR lists the objects and directories recursively into out.
 func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) (err error) {
 	return f.Fs.Features().ListR(ctx, dir, func(baseEntries fs.DirEntries) error {
@@ -440,7 +493,14 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 // Shutdown the backend, closing any background tasks and any cached connections.
 func (f *Fs) Shutdown(ctx context.Context) (err error) {
 	if f.db != nil && !f.db.IsStopped() {
-		err = f.db.Stop(false)
+		if f.sharedFs != nil {
+			if err = f.pushShared(ctx); err != nil {
+				fs.Errorf(f, "%v", err)
+			}
+		}
+		if err2 := f.db.Stop(false); err2 != nil {
+			err = err2
+		}
 	}
 	if do := f.Fs.Features().Shutdown; do != nil {
 		if err2 := do(ctx); err2 != nil {
@@ -546,6 +606,17 @@ func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
 	return do.SetMetadata(ctx, metadata)
 }
 
+// RemoveMetadata removes user metadata keys from an Object
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (o *Object) RemoveMetadata(ctx context.Context, keys []string) error {
+	do, ok := o.Object.(fs.RemoveMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.RemoveMetadata(ctx, keys)
+}
+
 // Check the interfaces are satisfied
 var (
 	_ fs.Fs              = (*Fs)(nil)
@@ -559,6 +630,7 @@ var (
 	_ fs.CleanUpper      = (*Fs)(nil)
 	_ fs.UnWrapper       = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.ListAter        = (*Fs)(nil)
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.Wrapper         = (*Fs)(nil)
 	_ fs.MergeDirser     = (*Fs)(nil)
@@ -1,13 +1,20 @@
 package hasher
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/fstests"
@@ -69,12 +76,220 @@ func (f *Fs) testUploadFromCrypt(t *testing.T) {
 	_ = operations.Purge(ctx, f, dirName)
 }
 
+func (f *Fs) testExportImport(t *testing.T) {
+	if f.opt.MaxAge == 0 {
+		t.Skip("cache is disabled")
+	}
+	ctx := context.Background()
+	const dirName = "export_1"
+	const fileName = dirName + "/file_export_1"
+	o := putFile(ctx, t, f, fileName, "export me")
+	defer func() {
+		_ = operations.Purge(ctx, f, dirName)
+	}()
+	md5sum, err := o.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	require.NotEmpty(t, md5sum)
+
+	// export as a SUM file
+	out, err := f.dbExport(ctx, "md5")
+	require.NoError(t, err)
+	assert.Contains(t, out, md5sum+"  "+fileName)
+
+	// export as JSON
+	out, err = f.dbExport(ctx, "json")
+	require.NoError(t, err)
+	records := out.([]exportRecord)
+	var rec *exportRecord
+	for i := range records {
+		if records[i].Path == fileName {
+			rec = &records[i]
+		}
+	}
+	require.NotNil(t, rec)
+	assert.Equal(t, md5sum, rec.Hashes["md5"])
+	assert.Equal(t, o.Size(), rec.Size)
+	assert.NotEmpty(t, rec.Fingerprint)
+
+	importJSON := func(records []exportRecord) {
+		data, err := json.Marshal(records)
+		require.NoError(t, err)
+		sumFile := filepath.Join(t.TempDir(), "sums.json")
+		require.NoError(t, os.WriteFile(sumFile, data, 0600))
+		require.NoError(t, f.pruneHash(fileName))
+		require.NoError(t, f.jsonImport(ctx, sumFile, false))
+	}
+	cached := func() string {
+		hashVal, _ := f.getRawHash(ctx, hash.MD5, fileName, anyFingerprint, fs.ModTimeNotSupported)
+		return hashVal
+	}
+
+	// records for changed files are ignored
+	changed := *rec
+	changed.Fingerprint = "1,-,-"
+	importJSON([]exportRecord{changed})
+	assert.Empty(t, cached())
+
+	// records without fingerprint are bound by size
+	bySize := *rec
+	bySize.Fingerprint = ""
+	importJSON([]exportRecord{bySize})
+	assert.Equal(t, md5sum, cached())
+
+	importJSON([]exportRecord{*rec})
+	assert.Equal(t, md5sum, cached())
+}
+
+func (f *Fs) testSharedDB(t *testing.T) {
+	if f.opt.MaxAge == 0 {
+		t.Skip("cache is disabled")
+	}
+	ctx := context.Background()
+	oldFs, oldLeaf := f.sharedFs, f.sharedLeaf
+	defer func() {
+		f.sharedFs, f.sharedLeaf = oldFs, oldLeaf
+	}()
+	sharedPath := filepath.Join(t.TempDir(), "db.json")
+	require.NoError(t, f.setShared(ctx, sharedPath))
+
+	const dirName = "shared_1"
+	const fileName = dirName + "/file_shared_1"
+	o := putFile(ctx, t, f, fileName, "share me")
+	defer func() {
+		_ = operations.Purge(ctx, f, dirName)
+	}()
+	md5sum, err := o.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+
+	// upload the local records
+	require.NoError(t, f.pushShared(ctx))
+	data, err := os.ReadFile(sharedPath)
+	require.NoError(t, err)
+	records, err := readRecords(bytes.NewReader(data))
+	require.NoError(t, err)
+	key := path.Join(f.Fs.Root(), fileName)
+	found := false
+	for _, rec := range records {
+		if rec.Path == key {
+			found = true
+			assert.Equal(t, md5sum, rec.Hashes["md5"])
+		}
+	}
+	assert.True(t, found)
+
+	// nothing changed so nothing is uploaded
+	fi, err := os.Stat(sharedPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(sharedPath, fi.ModTime(), fi.ModTime().Add(-time.Hour)))
+	require.NoError(t, f.pushShared(ctx))
+	fi2, err := os.Stat(sharedPath)
+	require.NoError(t, err)
+	assert.Equal(t, fi.ModTime().Add(-time.Hour), fi2.ModTime())
+
+	// records lost locally are restored from the shared database
+	require.NoError(t, f.pruneHash(fileName))
+	_, err = f.getRawHash(ctx, hash.MD5, fileName, anyFingerprint, fs.ModTimeNotSupported)
+	require.Error(t, err)
+	_, _, err = f.pullShared(ctx)
+	require.NoError(t, err)
+	hashVal, err := f.getRawHash(ctx, hash.MD5, fileName, anyFingerprint, fs.ModTimeNotSupported)
+	require.NoError(t, err)
+	assert.Equal(t, md5sum, hashVal)
+
+	// records uploaded by another host while merging aren't lost
+	otherKey := path.Join(f.Fs.Root(), dirName, "file_shared_other")
+	_, oldFingerprint, err := f.pullShared(ctx)
+	require.NoError(t, err)
+	other := append(records, exportRecord{
+		Path:    otherKey,
+		Size:    1,
+		Created: time.Now(),
+		Hashes:  operations.HashSums{"md5": "0cc175b9c0f1b6a831c399e269772661"},
+	})
+	otherData, err := json.Marshal(other)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(sharedPath, otherData, 0600))
+	require.NoError(t, os.Chtimes(sharedPath, fi.ModTime(), fi.ModTime().Add(time.Hour)))
+	assert.Equal(t, errSharedChanged, f.uploadShared(ctx, []byte("[]"), oldFingerprint))
+	require.NoError(t, f.pushShared(ctx))
+	data, err = os.ReadFile(sharedPath)
+	require.NoError(t, err)
+	records, err = readRecords(bytes.NewReader(data))
+	require.NoError(t, err)
+	found = false
+	for _, rec := range records {
+		if rec.Path == otherKey {
+			found = true
+		}
+	}
+	assert.True(t, found)
+	require.NoError(t, f.pruneHash(dirName+"/file_shared_other"))
+}
+
+func (f *Fs) testScrub(t *testing.T) {
+	if f.opt.MaxAge == 0 {
+		t.Skip("cache is disabled")
+	}
+	ctx := context.Background()
+	defer accounting.GlobalStats().ResetErrors()
+	const dirName = "scrub_1"
+	defer func() {
+		_ = operations.Purge(ctx, f, dirName)
+	}()
+	put := func(name, data string) *Object {
+		o := putFile(ctx, t, f, dirName+"/"+name, data).(*Object)
+		_, err := o.Hash(ctx, hash.MD5)
+		require.NoError(t, err)
+		return o
+	}
+	okObj := put("ok", "good data")
+	rotObj := put("rot", "rotten data")
+	newObj := put("new", "new data")
+	require.NoError(t, f.pruneHash(newObj.Remote()))
+
+	// simulate bitrot by changing the cached checksum
+	const badSum = "00000000000000000000000000000000"
+	goodSum, err := rotObj.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	rotKey := path.Join(f.Fs.Root(), rotObj.Remote())
+	require.NoError(t, f.putRawHashes(ctx, rotKey, rotObj.fingerprint(ctx), operations.HashSums{"md5": badSum}))
+
+	report, err := f.newScrubber(fs.SizeSuffix(1024*1024), 0).run(ctx, dirName)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Files)
+	assert.Equal(t, 1, report.Verified)
+	assert.Equal(t, 1, report.Hashed)
+	assert.Equal(t, 0, report.Failed)
+	assert.Equal(t, int64(len("good data")+len("rotten data")+len("new data")), report.Bytes)
+	require.Len(t, report.Bitrot, 1)
+	item := report.Bitrot[0]
+	assert.Equal(t, rotObj.Remote(), item.Path)
+	assert.Equal(t, map[string]string{"md5": badSum}, item.Expected)
+	assert.Equal(t, map[string]string{"md5": goodSum}, item.Actual)
+
+	// the verification time is recorded
+	get := &kvGetRecord{key: path.Join(f.Fs.Root(), okObj.Remote())}
+	require.NoError(t, f.db.Do(false, get))
+	require.NotNil(t, get.r)
+	assert.WithinDuration(t, time.Now(), get.r.Verified, time.Minute)
+
+	// files verified recently are skipped, bitrot is reported again
+	report, err = f.newScrubber(0, time.Hour).run(ctx, dirName)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Files)
+	assert.Equal(t, 2, report.Skipped)
+	assert.Len(t, report.Bitrot, 1)
+}
+
 // InternalTest dispatches all internal tests
 func (f *Fs) InternalTest(t *testing.T) {
 	if !kv.Supported() {
 		t.Skip("hasher is not supported on this OS")
 	}
 	t.Run("UploadFromCrypt", f.testUploadFromCrypt)
+	t.Run("ExportImport", f.testExportImport)
+	t.Run("SharedDB", f.testSharedDB)
+	t.Run("Scrub", f.testScrub)
 }
 
 var _ fstests.InternalTester = (*Fs)(nil)
@@ -23,9 +23,10 @@ const (
 type hashMap map[hash.Type]string
 
 type hashRecord struct {
-	Fp      string // fingerprint
-	Hashes  operations.HashSums
-	Created time.Time
+	Fp       string // fingerprint
+	Hashes   operations.HashSums
+	Created  time.Time
+	Verified time.Time // last time the hashes were verified by scrub
 }
 
 func (r *hashRecord) encode(key string) ([]byte, error) {
@@ -191,6 +192,7 @@ func (op *kvPut) Do(ctx context.Context, b kv.Bucket) (err error) {
 	}
 	if len(r.Hashes) == 0 {
 		r.Created = time.Now()
+		r.Verified = time.Time{}
 		r.Hashes = operations.HashSums{}
 		r.Fp = op.fp
 	}
@@ -207,6 +209,55 @@ func (op *kvPut) Do(ctx context.Context, b kv.Bucket) (err error) {
 	return err
 }
 
+// kvGetRecord: get the whole record for a key
+type kvGetRecord struct {
+	key string
+	r   *hashRecord
+}
+
+func (op *kvGetRecord) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get([]byte(op.key))
+	if len(data) == 0 {
+		return nil
+	}
+	var r hashRecord
+	if err := r.decode(op.key, data); err != nil {
+		return nil
+	}
+	op.r = &r
+	return nil
+}
+
+// kvVerified: set the verification time of a record
+type kvVerified struct {
+	key  string
+	fp   string
+	when time.Time
+}
+
+func (op *kvVerified) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get([]byte(op.key))
+	if len(data) == 0 {
+		return errors.New("no record")
+	}
+	var r hashRecord
+	if err := r.decode(op.key, data); err != nil {
+		return errors.New("invalid record")
+	}
+	if r.Fp != anyFingerprint && r.Fp != op.fp {
+		return errors.New("fingerprint changed")
+	}
+	r.Verified = op.when
+	data, err := r.encode(op.key)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	if err = b.Put([]byte(op.key), data); err != nil {
+		return fmt.Errorf("put failed: %w", err)
+	}
+	return nil
+}
+
 // kvDump: dump the database.
 // Note: long dump can cause concurrent operations to fail.
 type kvDump struct {
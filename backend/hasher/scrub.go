@@ -0,0 +1,322 @@
+package hasher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/kv"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "hasher/scrub",
+		Fn:    rcScrub,
+		Title: "Verify the cached checksums of a hasher remote",
+		Help: `This re-reads all the files of a hasher remote, compares them
+with the checksums in its cache and reports mismatches as bitrot.
+
+This takes the following parameters:
+
+- fs - a hasher remote name string e.g. "hasher:path"
+- rate - maximum rate to read files at e.g. "10M" (optional)
+- interval - skip files verified more recently than this e.g. "7d" (optional)
+
+This returns the report of the scrub command, see the hasher backend
+docs for details. Scrubbing can take a long time so it is best run as
+a job with _async=true.
+
+    rclone rc hasher/scrub fs=hasher:path rate=10M _async=true
+`,
+	})
+}
+
+// scrubReport is the result of a scrub
+type scrubReport struct {
+	Files    int          `json:"files"`    // files seen
+	Verified int          `json:"verified"` // files whose checksums matched
+	Skipped  int          `json:"skipped"`  // files verified within the interval
+	Hashed   int          `json:"hashed"`   // files without checksums which were hashed
+	Changed  int          `json:"changed"`  // files changed since they were hashed which were rehashed
+	Failed   int          `json:"failed"`   // files which couldn't be read
+	Bytes    int64        `json:"bytes"`    // bytes read
+	Bitrot   []bitrotItem `json:"bitrot"`   // files whose checksums didn't match
+}
+
+// bitrotItem describes a file whose contents no longer match its checksums
+type bitrotItem struct {
+	Path     string            `json:"path"`
+	Size     int64             `json:"size"`
+	ModTime  time.Time         `json:"modtime"`
+	Expected map[string]string `json:"expected"`
+	Actual   map[string]string `json:"actual"`
+	Hashed   time.Time         `json:"hashed"`             // when the checksums were cached
+	Verified *time.Time        `json:"verified,omitempty"` // when the checksums were last verified
+}
+
+// scrubber verifies the checksums of objects
+type scrubber struct {
+	f        *Fs
+	limiter  *rate.Limiter // nil for no limit
+	interval time.Duration
+	mu       sync.Mutex
+	report   scrubReport
+}
+
+// limitedReader reads from in no faster than the limiter allows
+type limitedReader struct {
+	ctx     context.Context
+	in      io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err = r.in.Read(p)
+	if n > 0 {
+		if errWait := r.limiter.WaitN(r.ctx, n); errWait != nil && err == nil {
+			err = errWait
+		}
+	}
+	return n, err
+}
+
+// newScrubber makes a scrubber reading at bytesPerSecond, or without
+// limit if it is not positive, and skipping objects verified within
+// interval
+func (f *Fs) newScrubber(bytesPerSecond fs.SizeSuffix, interval time.Duration) *scrubber {
+	s := &scrubber{
+		f:        f,
+		interval: interval,
+		report:   scrubReport{Bitrot: []bitrotItem{}},
+	}
+	if bytesPerSecond > 0 {
+		burst := min(int(bytesPerSecond), 1024*1024)
+		s.limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+	}
+	return s
+}
+
+// read the base object and return its checksums
+func (s *scrubber) read(ctx context.Context, o *Object) (sums hashMap, err error) {
+	tr := accounting.Stats(ctx).NewCheckingTransfer(o, "scrubbing")
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	hasher, err := hash.NewMultiHasherTypes(s.f.keepHashes)
+	if err != nil {
+		return nil, err
+	}
+	in, err := o.Object.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	acc := tr.Account(ctx, in)
+	defer fs.CheckClose(acc, &err)
+	var rd io.Reader = acc
+	if s.limiter != nil {
+		rd = &limitedReader{ctx: ctx, in: rd, limiter: s.limiter}
+	}
+	n, err := io.Copy(hasher, rd)
+	s.mu.Lock()
+	s.report.Bytes += n
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return hasher.Sums(), nil
+}
+
+// scrub a single object
+func (s *scrubber) scrub(ctx context.Context, o *Object) error {
+	f := s.f
+	key := path.Join(f.Fs.Root(), o.Remote())
+	get := &kvGetRecord{key: key}
+	if err := f.db.Do(false, get); err != nil && err != kv.ErrEmpty {
+		return err
+	}
+	r := get.r
+	fp := o.fingerprint(ctx)
+	if fp == "" {
+		return errors.New("fingerprint failed")
+	}
+	matched := r != nil && (r.Fp == fp || r.Fp == anyFingerprint) && len(f.keptHashes(r.Hashes)) > 0
+	if matched && s.interval > 0 && time.Since(r.Verified) < s.interval {
+		s.count(func(report *scrubReport) { report.Skipped++ })
+		return nil
+	}
+
+	sums, err := s.read(ctx, o)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	if !matched {
+		// Nothing to verify so cache the checksums just read
+		hashes := operations.HashSums{}
+		for hashType, hashVal := range sums {
+			hashes[hashType.String()] = hashVal
+		}
+		if err = f.putRawHashes(ctx, key, fp, hashes); err != nil {
+			return err
+		}
+		if r != nil && len(r.Hashes) > 0 && r.Fp != fp && r.Fp != anyFingerprint {
+			fs.Infof(o, "Changed since hashed - updated cached checksums")
+			s.count(func(report *scrubReport) { report.Changed++ })
+		} else {
+			fs.Debugf(o, "No cached checksums - cached the checksums read")
+			s.count(func(report *scrubReport) { report.Hashed++ })
+		}
+		return f.db.Do(true, &kvVerified{key: key, fp: fp, when: now})
+	}
+
+	expected, actual := map[string]string{}, map[string]string{}
+	for hashType, hashVal := range sums {
+		hashName := hashType.String()
+		cached := r.Hashes[hashName]
+		if cached != "" && !strings.EqualFold(cached, hashVal) {
+			expected[hashName] = cached
+			actual[hashName] = hashVal
+		}
+	}
+	if len(expected) == 0 {
+		fs.Debugf(o, "Checksums verified")
+		s.count(func(report *scrubReport) { report.Verified++ })
+		return f.db.Do(true, &kvVerified{key: key, fp: fp, when: now})
+	}
+
+	item := bitrotItem{
+		Path:     o.Remote(),
+		Size:     o.Size(),
+		ModTime:  o.ModTime(ctx),
+		Expected: expected,
+		Actual:   actual,
+		Hashed:   r.Created,
+	}
+	if !r.Verified.IsZero() {
+		verified := r.Verified
+		item.Verified = &verified
+	}
+	err = fmt.Errorf("bitrot detected: contents don't match cached checksums %v", expected)
+	fs.Errorf(o, "%v", err)
+	_ = fs.CountError(ctx, err)
+	s.count(func(report *scrubReport) { report.Bitrot = append(report.Bitrot, item) })
+	return nil
+}
+
+// count updates the report under the lock
+func (s *scrubber) count(fn func(report *scrubReport)) {
+	s.mu.Lock()
+	fn(&s.report)
+	s.mu.Unlock()
+}
+
+// run the scrubber over all the objects under dir
+func (s *scrubber) run(ctx context.Context, dir string) (*scrubReport, error) {
+	f := s.f
+	if f.db == nil {
+		return nil, fmt.Errorf("db not found: %w", kv.ErrInactive)
+	}
+	ci := fs.GetConfig(ctx)
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(ci.Checkers)
+	err := walk.ListR(ctx, f, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(func(obj fs.Object) {
+			o, ok := obj.(*Object)
+			if !ok {
+				return
+			}
+			s.count(func(report *scrubReport) { report.Files++ })
+			g.Go(func() error {
+				if err := s.scrub(gCtx, o); err != nil {
+					if gCtx.Err() != nil {
+						return gCtx.Err()
+					}
+					fs.Errorf(o, "Failed to scrub: %v", err)
+					_ = fs.CountError(ctx, err)
+					s.count(func(report *scrubReport) { report.Failed++ })
+				}
+				return nil
+			})
+		})
+		return nil
+	})
+	if errWait := g.Wait(); err == nil {
+		err = errWait
+	}
+	report := &s.report
+	sort.Slice(report.Bitrot, func(i, j int) bool {
+		return report.Bitrot[i].Path < report.Bitrot[j].Path
+	})
+	fs.Infof(f, "Scrub: %d files, %d verified, %d skipped, %d hashed, %d changed, %d failed, %d bitrot",
+		report.Files, report.Verified, report.Skipped, report.Hashed, report.Changed, report.Failed, len(report.Bitrot))
+	return report, err
+}
+
+// scrubOptions parses the options of the scrub command
+func (f *Fs) scrubOptions(opt map[string]string) (bytesPerSecond fs.SizeSuffix, interval time.Duration, err error) {
+	bytesPerSecond = f.opt.ScrubRate
+	if s, ok := opt["rate"]; ok {
+		if err = bytesPerSecond.Set(s); err != nil {
+			return 0, 0, fmt.Errorf("bad rate: %w", err)
+		}
+	}
+	if s, ok := opt["interval"]; ok {
+		var d fs.Duration
+		if err = d.Set(s); err != nil {
+			return 0, 0, fmt.Errorf("bad interval: %w", err)
+		}
+		interval = time.Duration(d)
+	}
+	return bytesPerSecond, interval, nil
+}
+
+// rcScrub runs the scrub command from the API
+func rcScrub(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	fsrc, err := rc.GetFsNamed(ctx, in, "fs")
+	if err != nil {
+		return nil, err
+	}
+	f, ok := fsrc.(*Fs)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a hasher remote", fsrc)
+	}
+	opt := map[string]string{}
+	for _, name := range []string{"rate", "interval"} {
+		value, err := in.GetString(name)
+		if rc.NotErrParamNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			opt[name] = value
+		}
+	}
+	bytesPerSecond, interval, err := f.scrubOptions(opt)
+	if err != nil {
+		return nil, err
+	}
+	report, err := f.newScrubber(bytesPerSecond, interval).run(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	out = rc.Params{}
+	err = rc.Reshape(&out, report)
+	return out, err
+}
@@ -0,0 +1,161 @@
+package hasher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// Shared database
+//
+// The shared database is an object holding all the records of the
+// local database in the JSON export format. It is merged into the
+// local database when the remote is created and the merged records
+// are written back when it is shut down, so hosts using the same
+// shared_db see each others checksums.
+
+// errNoSharedDB is returned if the shared database isn't configured
+var errNoSharedDB = errors.New("shared_db is not configured")
+
+// setShared sets up the shared database object at sharedRemote
+func (f *Fs) setShared(ctx context.Context, sharedRemote string) error {
+	if f.db == nil {
+		return errors.New("shared_db can't be used with max_age = 0")
+	}
+	parent, leaf, err := fspath.Split(sharedRemote)
+	if err != nil {
+		return fmt.Errorf("invalid shared_db %q: %w", sharedRemote, err)
+	}
+	if leaf == "" {
+		return fmt.Errorf("shared_db %q must be a file", sharedRemote)
+	}
+	sharedFs, err := cache.Get(ctx, parent)
+	if err != nil {
+		return fmt.Errorf("failed to make remote for shared_db %q: %w", sharedRemote, err)
+	}
+	f.sharedFs = sharedFs
+	f.sharedLeaf = leaf
+	return nil
+}
+
+// errSharedChanged is returned if the shared database was changed by
+// another host while it was being merged
+var errSharedChanged = errors.New("shared database changed while merging")
+
+// sharedRetries is the number of times to merge the shared database
+// if another host keeps changing it
+const sharedRetries = 5
+
+// sharedFingerprint returns the fingerprint of the shared database
+// or "" if it doesn't exist yet
+func (f *Fs) sharedFingerprint(ctx context.Context) (fs.Object, string, error) {
+	o, err := f.sharedFs.NewObject(ctx, f.sharedLeaf)
+	if err == fs.ErrorObjectNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return o, fs.Fingerprint(ctx, o, false), nil
+}
+
+// pullShared merges the shared database into the local one
+//
+// It returns the contents of the shared database and its fingerprint
+// or nil and "" if it doesn't exist yet.
+func (f *Fs) pullShared(ctx context.Context) (data []byte, fingerprint string, err error) {
+	if f.sharedFs == nil {
+		return nil, "", errNoSharedDB
+	}
+	o, fingerprint, err := f.sharedFingerprint(ctx)
+	if err != nil || o == nil {
+		return nil, "", err
+	}
+	in, err := operations.Open(ctx, o)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err = io.ReadAll(in)
+	_ = in.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	records, err := readRecords(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	op := &kvMerge{
+		records: records,
+		age:     time.Duration(f.opt.MaxAge),
+	}
+	if err = f.db.Do(true, op); err != nil {
+		return nil, "", err
+	}
+	fs.Debugf(f, "Merged %d of %d records from the shared database", op.count, len(records))
+	return data, fingerprint, nil
+}
+
+// uploadShared uploads data as the shared database unless it has
+// changed from the one with oldFingerprint, when it returns
+// errSharedChanged
+func (f *Fs) uploadShared(ctx context.Context, data []byte, oldFingerprint string) error {
+	_, fingerprint, err := f.sharedFingerprint(ctx)
+	if err != nil {
+		return err
+	}
+	if fingerprint != oldFingerprint {
+		return errSharedChanged
+	}
+	_, err = operations.Rcat(ctx, f.sharedFs, f.sharedLeaf, io.NopCloser(bytes.NewReader(data)), time.Now(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to upload shared database: %w", err)
+	}
+	return nil
+}
+
+// pushShared merges the shared database into the local one and
+// uploads the result if it differs from the shared database
+//
+// If another host changes the shared database in the meantime it is
+// merged again so its records aren't lost. There is no lock so two
+// hosts uploading at the same moment can still lose records until
+// the next sync.
+func (f *Fs) pushShared(ctx context.Context) error {
+	for try := 1; ; try++ {
+		oldData, oldFingerprint, err := f.pullShared(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read shared database: %w", err)
+		}
+		records, err := f.exportRecords("")
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, oldData) {
+			fs.Debugf(f, "Shared database is up to date")
+			return nil
+		}
+		err = f.uploadShared(ctx, data, oldFingerprint)
+		if err == errSharedChanged && try < sharedRetries {
+			fs.Debugf(f, "Shared database changed while merging - merging again")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		fs.Debugf(f, "Uploaded %d records to the shared database", len(records))
+		return nil
+	}
+}
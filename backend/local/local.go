@@ -1613,6 +1613,18 @@ func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
 	return o.lstat()
 }
 
+// RemoveMetadata removes user metadata keys from an Object or Directory
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (o *Object) RemoveMetadata(ctx context.Context, keys []string) error {
+	err := o.removeXattr(keys)
+	if err != nil {
+		return fmt.Errorf("RemoveMetadata failed: %w", err)
+	}
+	// Re-read info now we have finished removing stuff
+	return o.lstat()
+}
+
 func cleanRootPath(s string, noUNC bool, enc encoder.MultiEncoder) string {
 	var vol string
 	if runtime.GOOS == "windows" {
@@ -1692,18 +1704,20 @@ func (d *Directory) Hash() {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs              = &Fs{}
-	_ fs.PutStreamer     = &Fs{}
-	_ fs.Mover           = &Fs{}
-	_ fs.DirMover        = &Fs{}
-	_ fs.Commander       = &Fs{}
-	_ fs.OpenWriterAter  = &Fs{}
-	_ fs.DirSetModTimer  = &Fs{}
-	_ fs.MkdirMetadataer = &Fs{}
-	_ fs.Object          = &Object{}
-	_ fs.Metadataer      = &Object{}
-	_ fs.SetMetadataer   = &Object{}
-	_ fs.Directory       = &Directory{}
-	_ fs.SetModTimer     = &Directory{}
-	_ fs.SetMetadataer   = &Directory{}
+	_ fs.Fs               = &Fs{}
+	_ fs.PutStreamer      = &Fs{}
+	_ fs.Mover            = &Fs{}
+	_ fs.DirMover         = &Fs{}
+	_ fs.Commander        = &Fs{}
+	_ fs.OpenWriterAter   = &Fs{}
+	_ fs.DirSetModTimer   = &Fs{}
+	_ fs.MkdirMetadataer  = &Fs{}
+	_ fs.Object           = &Object{}
+	_ fs.Metadataer       = &Object{}
+	_ fs.SetMetadataer    = &Object{}
+	_ fs.RemoveMetadataer = &Object{}
+	_ fs.Directory        = &Directory{}
+	_ fs.SetModTimer      = &Directory{}
+	_ fs.SetMetadataer    = &Directory{}
+	_ fs.RemoveMetadataer = &Directory{}
 )
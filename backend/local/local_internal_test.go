@@ -365,6 +365,16 @@ func testMetadata(t *testing.T, r *fstest.Run, o *Object, when time.Time) {
 		require.NoError(t, err)
 		assert.NotNil(t, m)
 		assert.Equal(t, inM, m)
+
+		// Missing keys are ignored and system keys can't be removed
+		err = o.RemoveMetadata(ctx, []string{"cabbage", "missing"})
+		require.NoError(t, err)
+		err = o.RemoveMetadata(ctx, []string{"mtime"})
+		assert.ErrorIs(t, err, fs.ErrorNotImplemented)
+
+		m, err = o.getXattr()
+		require.NoError(t, err)
+		assert.Equal(t, fs.Metadata{"potato": "chips"}, m)
 	})
 
 	checkTime := func(m fs.Metadata, key string, when time.Time) {
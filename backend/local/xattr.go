@@ -3,6 +3,7 @@
 package local
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"syscall"
@@ -112,3 +113,38 @@ func (o *Object) setXattr(metadata fs.Metadata) (err error) {
 	}
 	return nil
 }
+
+// removeXattr removes the metadata keys from the file Xattrs
+//
+// It returns fs.ErrorNotImplemented for attributes owned by this
+// backend in metadataKeys as they can't be removed
+func (o *Object) removeXattr(keys []string) (err error) {
+	for _, k := range keys {
+		k = strings.ToLower(k)
+		if _, found := systemMetadataInfo[k]; found {
+			return fmt.Errorf("can't remove metadata key %q: %w", k, fs.ErrorNotImplemented)
+		}
+	}
+	if !xattrSupported || o.fs.xattrSupported.Load() == 0 {
+		return nil
+	}
+	for _, k := range keys {
+		k = xattrPrefix + strings.ToLower(k)
+		if o.fs.opt.FollowSymlinks {
+			err = xattr.Remove(o.path, k)
+		} else {
+			err = xattr.LRemove(o.path, k)
+		}
+		if err != nil {
+			var xattrErr *xattr.Error
+			if errors.As(err, &xattrErr) && xattrErr.Err == xattr.ENOATTR {
+				continue
+			}
+			if o.fs.xattrIsNotSupported(err) {
+				return nil
+			}
+			return fmt.Errorf("failed to remove xattr key %q: %w", k, err)
+		}
+	}
+	return nil
+}
@@ -19,3 +19,8 @@ func (o *Object) getXattr() (metadata fs.Metadata, err error) {
 func (o *Object) setXattr(metadata fs.Metadata) (err error) {
 	return nil
 }
+
+// removeXattr removes the metadata keys from the file Xattrs
+func (o *Object) removeXattr(keys []string) (err error) {
+	return nil
+}
@@ -4482,15 +4482,17 @@ func (f *Fs) itemToDirEntry(ctx context.Context, remote string, object *types.Ob
 }
 
 // listDir lists files and directories to out
-func (f *Fs) listDir(ctx context.Context, bucket, directory, prefix string, addBucket bool) (entries fs.DirEntries, err error) {
+//
+// If versionAt is set the directory is listed as it was at that time.
+func (f *Fs) listDir(ctx context.Context, bucket, directory, prefix string, addBucket bool, versionAt fs.Time) (entries fs.DirEntries, err error) {
 	// List the objects and directories
 	err = f.list(ctx, listOpt{
 		bucket:       bucket,
 		directory:    directory,
 		prefix:       prefix,
 		addBucket:    addBucket,
-		withVersions: f.opt.Versions,
-		versionAt:    f.opt.VersionAt,
+		withVersions: f.opt.Versions && !versionAt.IsSet(),
+		versionAt:    versionAt,
 		hidden:       f.opt.VersionDeleted,
 	}, func(remote string, object *types.Object, versionID *string, isDirectory bool) error {
 		entry, err := f.itemToDirEntry(ctx, remote, object, versionID, isDirectory)
@@ -4547,7 +4549,23 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 		}
 		return f.listBuckets(ctx)
 	}
-	return f.listDir(ctx, bucket, directory, f.rootDirectory, f.rootBucket == "")
+	return f.listDir(ctx, bucket, directory, f.rootDirectory, f.rootBucket == "", f.opt.VersionAt)
+}
+
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using old versions of the objects.
+//
+// This needs versioning to be enabled on the bucket to see old
+// versions.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	bucket, directory := f.split(dir)
+	if bucket == "" {
+		if directory != "" {
+			return nil, fs.ErrorListBucketRequired
+		}
+		return f.listBuckets(ctx)
+	}
+	return f.listDir(ctx, bucket, directory, f.rootDirectory, f.rootBucket == "", fs.Time(at))
 }
 
 // ListR lists the objects and directories of the Fs starting
@@ -6844,6 +6862,7 @@ var (
 	_ fs.Copier          = &Fs{}
 	_ fs.PutStreamer     = &Fs{}
 	_ fs.ListRer         = &Fs{}
+	_ fs.ListAter        = &Fs{}
 	_ fs.Commander       = &Fs{}
 	_ fs.CleanUpper      = &Fs{}
 	_ fs.OpenChunkWriter = &Fs{}
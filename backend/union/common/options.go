@@ -14,4 +14,6 @@ type Options struct {
 	SearchPolicy string          `config:"search_policy"`
 	CacheTime    int             `config:"cache_time"`
 	MinFreeSpace fs.SizeSuffix   `config:"min_free_space"`
+	Mirror       bool            `config:"mirror"`
+	MirrorQuorum int             `config:"mirror_quorum"`
 }
@@ -67,6 +67,14 @@ func (d *Directory) candidates() []upstream.Entry {
 // But for unknown-sized objects (indicated by src.Size() == -1), Upload should either
 // return an error or update the object properly (rather than e.g. calling panic).
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if o.fs.opt.Mirror {
+		newO, err := o.fs.mirrorPut(ctx, in, src, o.candidates(), false, options...)
+		if err != nil {
+			return err
+		}
+		o.update(newO)
+		return nil
+	}
 	entries, err := o.fs.actionEntries(o.candidates()...)
 	if err == fs.ErrorPermissionDenied {
 		// There are no candidates in this object which can be written to
@@ -200,6 +208,9 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadClo
 		o.Object = newObj
 		o.co = append(o.co, newObj) // FIXME should this append or overwrite or update?
 	}
+	if o.fs.opt.Mirror {
+		return o.openMirror(ctx, options...)
+	}
 	return o.Object.Object.Open(ctx, options...)
 }
 
@@ -252,6 +263,30 @@ func (d *Directory) SetMetadata(ctx context.Context, metadata fs.Metadata) error
 	return errs.Err()
 }
 
+// RemoveMetadata removes user metadata keys from an DirEntry
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (d *Directory) RemoveMetadata(ctx context.Context, keys []string) error {
+	entries, err := d.fs.actionEntries(d.candidates()...)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	errs := Errors(make([]error, len(entries)))
+	multithread(len(entries), func(i int) {
+		if d, ok := entries[i].(*upstream.Directory); ok {
+			err := d.RemoveMetadata(ctx, keys)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", d.UpstreamFs().Name(), err)
+			}
+		} else {
+			errs[i] = fs.ErrorIsFile
+		}
+	})
+	wg.Wait()
+	return errs.Err()
+}
+
 // SetModTime sets the metadata on the DirEntry to set the modification date
 //
 // If there is any other metadata it does not overwrite it.
@@ -0,0 +1,462 @@
+package union
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/backend/union/policy"
+	"github.com/rclone/rclone/backend/union/upstream"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// Mirror mode
+//
+// In mirror mode every file is written to all the writable upstreams
+// like RAID-1. A write succeeds if it succeeds on a quorum of them and
+// the copies which failed are left for the scrub command to repair.
+// Reads use the newest copy and fail over to the other copies with the
+// same size if an upstream returns an error.
+
+// setMirror puts the Fs into mirror mode, replacing the configured
+// policies
+func (f *Fs) setMirror() (err error) {
+	writable := 0
+	for _, u := range f.upstreams {
+		if u.IsCreatable() {
+			writable++
+		}
+	}
+	if writable == 0 {
+		return errors.New("mirror mode needs at least one upstream which can be written to")
+	}
+	if f.opt.MirrorQuorum < 0 || f.opt.MirrorQuorum > writable {
+		return fmt.Errorf("mirror_quorum must be between 0 and the %d upstreams which can be written to", writable)
+	}
+	f.actionPolicy, err = policy.Get("epall")
+	if err != nil {
+		return err
+	}
+	f.createPolicy, err = policy.Get("all")
+	if err != nil {
+		return err
+	}
+	f.searchPolicy, err = policy.Get("newest")
+	return err
+}
+
+// quorum returns how many of n copies must be written for a write to
+// succeed
+func (f *Fs) quorum(n int) int {
+	if f.opt.MirrorQuorum > 0 {
+		return f.opt.MirrorQuorum
+	}
+	return n/2 + 1
+}
+
+// findEntry returns the object in entries which is on u or nil
+func findEntry(entries []upstream.Entry, u *upstream.Fs) *upstream.Object {
+	for _, e := range entries {
+		if o, ok := e.(*upstream.Object); ok && o.UpstreamFs() == u {
+			return o
+		}
+	}
+	return nil
+}
+
+// mirrorPut writes in to all the upstreams, updating the copies in
+// existing and creating the missing ones
+func (f *Fs) mirrorPut(ctx context.Context, in io.Reader, src fs.ObjectInfo, existing []upstream.Entry, stream bool, options ...fs.OpenOption) (*Object, error) {
+	upstreams, err := f.create(ctx, src.Remote())
+	if err != nil {
+		return nil, err
+	}
+	// Copies on upstreams which can't create files are still updated
+	for _, e := range existing {
+		u := e.UpstreamFs()
+		if !u.IsCreatable() && u.IsWritable() {
+			upstreams = append(upstreams, u)
+		}
+	}
+	readers, errChan := multiReader(len(upstreams), in)
+	errs := Errors(make([]error, len(upstreams)))
+	objs := make([]upstream.Entry, len(upstreams))
+	multithread(len(upstreams), func(i int) {
+		u := upstreams[i]
+		var o fs.Object
+		var err error
+		if dst := findEntry(existing, u); dst != nil {
+			err = dst.Update(ctx, readers[i], src, options...)
+			o = dst.Object
+		} else if stream {
+			o, err = u.Features().PutStream(ctx, readers[i], src, options...)
+		} else {
+			o, err = u.Put(ctx, readers[i], src, options...)
+		}
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", u.Name(), err)
+			// Drain the input buffer to allow other uploads to continue
+			_, _ = io.Copy(io.Discard, readers[i])
+			return
+		}
+		objs[i] = u.WrapObject(o)
+	})
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	var written []upstream.Entry
+	for _, o := range objs {
+		if o != nil {
+			written = append(written, o)
+		}
+	}
+	quorum := f.quorum(len(upstreams))
+	if len(written) < quorum {
+		return nil, fmt.Errorf("wrote %d of %d mirrors but need %d: %w", len(written), len(upstreams), quorum, errs.Err())
+	}
+	if err := errs.Err(); err != nil {
+		fs.Errorf(src, "Wrote %d of %d mirrors - run the scrub command to repair: %v", len(written), len(upstreams), err)
+		// Remove the old copies which failed to update so they
+		// can't be read in place of the new contents
+		for i := range upstreams {
+			if errs[i] == nil {
+				continue
+			}
+			if dst := findEntry(existing, upstreams[i]); dst != nil {
+				if err := dst.Remove(ctx); err != nil {
+					fs.Errorf(dst, "Failed to remove stale copy: %v", err)
+				}
+			}
+		}
+	}
+	e, err := f.wrapEntries(written...)
+	if err != nil {
+		return nil, err
+	}
+	return e.(*Object), nil
+}
+
+// mirrorCandidates returns the copies of o which may be read in place
+// of it, starting with o itself
+//
+// Only the sizes are compared here as comparing hashes may need the
+// copies reading, so the others are checked with sameMirror before
+// failing over to them.
+func (o *Object) mirrorCandidates() []*upstream.Object {
+	candidates := []*upstream.Object{o.Object}
+	for _, e := range o.co {
+		if c, ok := e.(*upstream.Object); ok && c != o.Object && c.Size() == o.Object.Size() {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
+
+// failoverReader reads from the first copy of an object which works,
+// reopening the next copy at the current offset on errors
+type failoverReader struct {
+	ctx        context.Context
+	o          *Object
+	candidates []*upstream.Object
+	options    []fs.OpenOption // options other than ranges
+	offset     int64           // offset to read from next
+	end        int64           // end of the range to read or -1 for the end of the file
+	in         io.ReadCloser
+}
+
+// openMirror opens the object for read, failing over between copies
+func (o *Object) openMirror(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	r := &failoverReader{
+		ctx:        ctx,
+		o:          o,
+		candidates: o.mirrorCandidates(),
+		end:        -1,
+	}
+	size := o.Object.Size()
+	for _, option := range options {
+		switch opt := option.(type) {
+		case *fs.SeekOption:
+			r.offset = opt.Offset
+		case *fs.RangeOption:
+			var limit int64
+			r.offset, limit = opt.Decode(size)
+			if limit >= 0 {
+				r.end = r.offset + limit - 1
+			}
+		default:
+			r.options = append(r.options, option)
+		}
+	}
+	if err := r.next(nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// next opens the next working copy at the current offset
+func (r *failoverReader) next(lastErr error) error {
+	if r.in != nil {
+		_ = r.in.Close()
+		r.in = nil
+	}
+	for len(r.candidates) > 0 {
+		c := r.candidates[0]
+		r.candidates = r.candidates[1:]
+		if c != r.o.Object && !sameMirror(r.ctx, r.o.Object, c) {
+			fs.Errorf(r.o, "Not failing over to copy on %s as it differs - run the scrub command to repair", c.UpstreamFs().Name())
+			continue
+		}
+		options := r.options
+		if r.offset != 0 || r.end >= 0 {
+			options = append(options[:len(options):len(options)], &fs.RangeOption{Start: r.offset, End: r.end})
+		}
+		in, err := c.Object.Open(r.ctx, options...)
+		if err == nil {
+			r.in = in
+			return nil
+		}
+		fs.Errorf(r.o, "Failed to open copy on %s: %v", c.UpstreamFs().Name(), err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fs.ErrorObjectNotFound
+	}
+	return lastErr
+}
+
+// Read bytes from the current copy, failing over to the next on error
+func (r *failoverReader) Read(p []byte) (n int, err error) {
+	for {
+		if r.in == nil {
+			return 0, errors.New("read from closed file")
+		}
+		n, err = r.in.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF || n > 0 {
+			return n, err
+		}
+		fs.Errorf(r.o, "Failed to read, trying next copy: %v", err)
+		if nextErr := r.next(err); nextErr != nil {
+			return 0, nextErr
+		}
+	}
+}
+
+// Close the current copy
+func (r *failoverReader) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
+
+// Result counts returned by the scrub command
+const (
+	scrubFiles    = "files"
+	scrubOK       = "ok"
+	scrubMissing  = "missing"
+	scrubStale    = "stale"
+	scrubRepaired = "repaired"
+	scrubFailed   = "failed"
+)
+
+// sameCopy reports whether dst has the same contents as src, comparing
+// hashes if the upstreams have one in common, otherwise sizes and
+// modification times
+func sameCopy(ctx context.Context, src, dst fs.Object) (bool, error) {
+	if src.Size() != dst.Size() {
+		return false, nil
+	}
+	equal, ht, err := operations.CheckHashes(ctx, src, dst)
+	if err != nil {
+		return false, err
+	}
+	if ht != hash.None {
+		return equal, nil
+	}
+	return operations.Equal(ctx, src, dst), nil
+}
+
+// sameMirror reports whether the copy c has the same contents as o so
+// can be read in place of it. Like sameCopy it compares hashes if the
+// upstreams have one in common, but falls back to comparing the
+// modification times if o can't be hashed, for example as it has gone.
+func sameMirror(ctx context.Context, o, c fs.Object) bool {
+	if o.Size() != c.Size() {
+		return false
+	}
+	equal, ht, err := operations.CheckHashes(ctx, o, c)
+	if err == nil && ht != hash.None {
+		return equal
+	}
+	if err != nil {
+		fs.Debugf(o, "Comparing modification times as failed to compare hashes: %v", err)
+	}
+	dt := o.ModTime(ctx).Sub(c.ModTime(ctx))
+	if dt < 0 {
+		dt = -dt
+	}
+	return dt <= fs.GetModifyWindow(ctx, o.Fs(), c.Fs())
+}
+
+// scrub compares the copies of each file under dir on all the
+// upstreams and repairs the ones which are missing or stale from the
+// newest copy
+func (f *Fs) scrub(ctx context.Context, dir string) (map[string]int, error) {
+	// Find the copies of each file on each upstream
+	var mu sync.Mutex
+	copies := make(map[string][]fs.Object)
+	var remotes []string
+	errs := Errors(make([]error, len(f.upstreams)))
+	multithread(len(f.upstreams), func(i int) {
+		u := f.upstreams[i]
+		err := walk.ListR(ctx, u, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+			mu.Lock()
+			defer mu.Unlock()
+			entries.ForObject(func(o fs.Object) {
+				key := o.Remote()
+				if f.features.CaseInsensitive {
+					key = strings.ToLower(key)
+				}
+				if copies[key] == nil {
+					copies[key] = make([]fs.Object, len(f.upstreams))
+					remotes = append(remotes, key)
+				}
+				copies[key][i] = o
+			})
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrorDirNotFound) {
+			errs[i] = fmt.Errorf("%s: %w", u.Name(), err)
+		}
+	})
+	// Don't repair with an incomplete view of the upstreams
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{
+		scrubFiles:    0,
+		scrubOK:       0,
+		scrubMissing:  0,
+		scrubStale:    0,
+		scrubRepaired: 0,
+		scrubFailed:   0,
+	}
+	for _, key := range remotes {
+		counts[scrubFiles]++
+		objs := copies[key]
+		var newest fs.Object
+		for _, o := range objs {
+			if o != nil && (newest == nil || o.ModTime(ctx).After(newest.ModTime(ctx))) {
+				newest = o
+			}
+		}
+		fileOK := true
+		for i, u := range f.upstreams {
+			dst := objs[i]
+			if dst == newest {
+				continue
+			}
+			if dst == nil {
+				if !u.IsCreatable() {
+					continue
+				}
+				fs.Infof(newest, "Missing on %s", u.Name())
+				counts[scrubMissing]++
+			} else {
+				same, err := sameCopy(ctx, newest, dst)
+				if err != nil {
+					fs.Errorf(dst, "Failed to compare with copy on %s: %v", newest.Fs().Name(), err)
+					counts[scrubFailed]++
+					fileOK = false
+					continue
+				}
+				if same {
+					continue
+				}
+				if !u.IsWritable() {
+					fs.Logf(dst, "Stale copy on read only upstream %s", u.Name())
+					continue
+				}
+				fs.Infof(dst, "Stale on %s", u.Name())
+				counts[scrubStale]++
+			}
+			fileOK = false
+			_, err := operations.Copy(ctx, u, dst, newest.Remote(), newest)
+			if err != nil {
+				fs.Errorf(newest, "Failed to repair copy on %s: %v", u.Name(), err)
+				counts[scrubFailed]++
+				continue
+			}
+			counts[scrubRepaired]++
+		}
+		if fileOK {
+			counts[scrubOK]++
+		}
+	}
+	if counts[scrubFailed] > 0 {
+		return counts, fmt.Errorf("failed to repair %d copies", counts[scrubFailed])
+	}
+	return counts, nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "scrub",
+	Short: "Compare the copies of files on the upstreams and repair them.",
+	Long: `This compares the copies of each file on all the upstreams by hash,
+or by size and modification time if the upstreams have no hash in
+common. Copies which are missing or differ from the newest copy are
+replaced with the newest copy.
+
+This is intended for mirror mode, to repair the copies which failed to
+be written when an upstream was unavailable.
+
+    rclone backend scrub union:
+    rclone backend scrub union:path/to/dir
+
+Read only upstreams are compared but not repaired. Use the --dry-run
+flag to see what would be repaired.
+
+It returns a map of counts like this
+
+    {
+        "failed": 0,
+        "files": 1042,
+        "missing": 3,
+        "ok": 1038,
+        "repaired": 4,
+        "stale": 1
+    }
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "scrub":
+		dir := ""
+		if len(arg) > 0 {
+			dir = strings.Trim(arg[0], "/")
+		}
+		return f.scrub(ctx, dir)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
@@ -0,0 +1,147 @@
+package union
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMirror makes a union in mirror mode over n local directories
+func newMirror(t *testing.T, n int, quorum int) *Fs {
+	dirs := MakeTestDirs(t, n)
+	f, err := fs.NewFs(context.Background(), fmt.Sprintf(":union,mirror,mirror_quorum=%d,upstreams=\"%s\":", quorum, strings.Join(dirs, " ")))
+	require.NoError(t, err)
+	return f.(*Fs)
+}
+
+// readAll reads the contents of remote from f
+func readAll(ctx context.Context, t *testing.T, f fs.Fs, remote string) string {
+	o, err := f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestMirrorQuorum(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	f := newMirror(t, 3, 0)
+	assert.Equal(t, 2, f.quorum(3))
+	assert.Equal(t, 1, f.quorum(1))
+	f.opt.MirrorQuorum = 3
+	assert.Equal(t, 3, f.quorum(3))
+
+	dirs := MakeTestDirs(t, 2)
+	_, err := fs.NewFs(context.Background(), fmt.Sprintf(":union,mirror,mirror_quorum=3,upstreams=\"%s\":", strings.Join(dirs, " ")))
+	assert.ErrorContains(t, err, "mirror_quorum")
+}
+
+func TestMirrorFailover(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	f := newMirror(t, 3, 0)
+
+	contents := random.String(100)
+	file := fstest.NewItem("dir/file.txt", contents, time.Now())
+	_ = fstests.PutTestContents(ctx, t, f, &file, contents, true)
+
+	// Check the file was written to all the upstreams
+	for _, u := range f.upstreams {
+		assert.Equal(t, contents, readAll(ctx, t, u, file.Path), u.Name())
+	}
+
+	// Remove the copy which the union would read from
+	obj, err := f.NewObject(ctx, file.Path)
+	require.NoError(t, err)
+	o := obj.(*Object)
+	require.NoError(t, o.UnWrapUpstream().Object.Remove(ctx))
+
+	// Check reads fail over to another copy
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, contents, string(data))
+
+	// And with a range
+	in, err = o.Open(ctx, &fs.RangeOption{Start: 10, End: 19})
+	require.NoError(t, err)
+	data, err = io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, contents[10:20], string(data))
+
+	// Copies which differ aren't read in place of it
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	stale := random.String(len(contents))
+	for _, u := range f.upstreams {
+		if u == o.UnWrapUpstream().UpstreamFs() {
+			continue
+		}
+		item := fstest.NewItem(file.Path, stale, t1)
+		_ = fstests.PutTestContents(ctx, t, u, &item, stale, true)
+	}
+	_, err = o.Open(ctx)
+	assert.Error(t, err)
+}
+
+func TestMirrorScrub(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	ctx := context.Background()
+	f := newMirror(t, 3, 0)
+
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	t2 := fstest.Time("2011-12-25T12:59:59.123456789Z")
+	contents := random.String(100)
+	file1 := fstest.NewItem("dir/file1.txt", contents, t2)
+	_ = fstests.PutTestContents(ctx, t, f, &file1, contents, true)
+	file2 := fstest.NewItem("file2.txt", "ok", t2)
+	_ = fstests.PutTestContents(ctx, t, f, &file2, "ok", true)
+
+	// Remove file1 from one upstream and make it stale on another
+	o, err := f.upstreams[0].NewObject(ctx, file1.Path)
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	stale := fstest.NewItem(file1.Path, "stale", t1)
+	_ = fstests.PutTestContents(ctx, t, f.upstreams[1], &stale, "stale", true)
+
+	out, err := f.Command(ctx, "scrub", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"files":    2,
+		"ok":       1,
+		"missing":  1,
+		"stale":    1,
+		"repaired": 2,
+		"failed":   0,
+	}, out)
+	for _, u := range f.upstreams {
+		assert.Equal(t, contents, readAll(ctx, t, u, file1.Path), u.Name())
+	}
+
+	// Nothing left to repair
+	out, err = f.Command(ctx, "scrub", []string{"dir"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, out.(map[string]int)["ok"])
+	assert.Equal(t, 0, out.(map[string]int)["repaired"])
+}
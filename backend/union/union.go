@@ -61,7 +61,27 @@ If a remote has less than this much free space then it won't be
 considered for use in lfs or eplfs policies.`,
 			Advanced: true,
 			Default:  fs.Gibi,
+		}, {
+			Name: "mirror",
+			Help: `Write every file to all the upstreams like RAID-1.
+
+In mirror mode files are written to all the upstreams which can be
+written to and read from any of them which works, failing over to
+another copy on errors. The policies are ignored.
+
+Use the scrub backend command to repair copies which failed to be
+written.`,
+			Advanced: true,
+			Default:  false,
+		}, {
+			Name: "mirror_quorum",
+			Help: `Number of upstreams a write must succeed on in mirror mode.
+
+If set to 0 then a write must succeed on a majority of the upstreams.`,
+			Advanced: true,
+			Default:  0,
 		}},
+		CommandHelp: commandHelp,
 	}
 	fs.Register(fsi)
 }
@@ -277,6 +297,11 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 		fs.Debugf(src, "Can't copy - not same remote type")
 		return nil, fs.ErrorCantCopy
 	}
+	if f.opt.Mirror {
+		// Copying to a single upstream would leave the other mirrors out
+		fs.Debugf(src, "Can't copy - mirror mode")
+		return nil, fs.ErrorCantCopy
+	}
 	o := srcObj.UnWrapUpstream()
 	su := o.UpstreamFs()
 	if su.Features().Copy == nil {
@@ -531,6 +556,9 @@ func multiReader(n int, in io.Reader) ([]io.Reader, <-chan error) {
 }
 
 func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, stream bool, options ...fs.OpenOption) (fs.Object, error) {
+	if f.opt.Mirror {
+		return f.mirrorPut(ctx, in, src, nil, stream, options...)
+	}
 	srcPath := src.Remote()
 	upstreams, err := f.create(ctx, srcPath)
 	if err == fs.ErrorObjectNotFound {
@@ -682,11 +710,28 @@ func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
 // This should return ErrDirNotFound if the directory isn't
 // found.
 func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	return f.list(func(u *upstream.Fs) (fs.DirEntries, error) {
+		return u.List(ctx, dir)
+	})
+}
+
+// ListAt lists the objects and directories in dir as they were at
+// the time at, using old versions of the objects.
+//
+// The old versions from all the upstreams are merged as List does.
+func (f *Fs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	return f.list(func(u *upstream.Fs) (fs.DirEntries, error) {
+		return u.Features().ListAt(ctx, dir, at)
+	})
+}
+
+// list merges the entries listed by listFn from each upstream
+func (f *Fs) list(listFn func(u *upstream.Fs) (fs.DirEntries, error)) (entries fs.DirEntries, err error) {
 	entriesList := make([][]upstream.Entry, len(f.upstreams))
 	errs := Errors(make([]error, len(f.upstreams)))
 	multithread(len(f.upstreams), func(i int) {
 		u := f.upstreams[i]
-		entries, err := u.List(ctx, dir)
+		entries, err := listFn(u)
 		if err != nil {
 			errs[i] = fmt.Errorf("%s: %w", u.Name(), err)
 			return
@@ -801,6 +846,13 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	if err != nil {
 		return nil, err
 	}
+	if f.opt.Mirror {
+		// The other copies can be read instead
+		if err := errs.Err(); err != nil {
+			fs.Errorf(remote, "Failed to read some mirrors: %v", err)
+		}
+		return e.(*Object), nil
+	}
 	return e.(*Object), errs.Err()
 }
 
@@ -971,6 +1023,12 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	if err != nil {
 		return nil, err
 	}
+	if opt.Mirror {
+		err = f.setMirror()
+		if err != nil {
+			return nil, err
+		}
+	}
 	fs.Debugf(f, "actionPolicy = %T, createPolicy = %T, searchPolicy = %T", f.actionPolicy, f.createPolicy, f.searchPolicy)
 	var features = (&fs.Features{
 		CaseInsensitive:          true,
@@ -1070,6 +1128,8 @@ var (
 	_ fs.ChangeNotifier  = (*Fs)(nil)
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.ListAter        = (*Fs)(nil)
 	_ fs.Shutdowner      = (*Fs)(nil)
 	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
 )
@@ -159,3 +159,23 @@ func TestPolicy3(t *testing.T) {
 		QuickTestOK:                  true,
 	})
 }
+
+func TestMirror(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	dirs := union.MakeTestDirs(t, 3)
+	upstreams := dirs[0] + " " + dirs[1] + " " + dirs[2]
+	name := "TestUnionMirror"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "union"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "mirror", Value: "true"},
+		},
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+		QuickTestOK:                  true,
+	})
+}
@@ -333,6 +333,17 @@ func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
 	return do.SetMetadata(ctx, metadata)
 }
 
+// RemoveMetadata removes user metadata keys from an Object
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (o *Object) RemoveMetadata(ctx context.Context, keys []string) error {
+	do, ok := o.Object.(fs.RemoveMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.RemoveMetadata(ctx, keys)
+}
+
 // Metadata returns metadata for an DirEntry
 //
 // It should return nil if there is no Metadata
@@ -355,6 +366,17 @@ func (e *Directory) SetMetadata(ctx context.Context, metadata fs.Metadata) error
 	return do.SetMetadata(ctx, metadata)
 }
 
+// RemoveMetadata removes user metadata keys from an DirEntry
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (e *Directory) RemoveMetadata(ctx context.Context, keys []string) error {
+	do, ok := e.Directory.(fs.RemoveMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.RemoveMetadata(ctx, keys)
+}
+
 // SetModTime sets the metadata on the DirEntry to set the modification date
 //
 // If there is any other metadata it does not overwrite it.
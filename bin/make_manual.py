@@ -32,6 +32,7 @@ docs = [
     "fichier.md",
     "alias.md",
     "s3.md",
+    "archive.md",
     "b2.md",
     "box.md",
     "cache.md",
@@ -42,6 +43,7 @@ docs = [
     "compress.md",
     "combine.md",
     "dropbox.md",
+    "erasure.md",
     "filefabric.md",
     "filescom.md",
     "ftp.md",
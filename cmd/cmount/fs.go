@@ -145,8 +145,7 @@ func (fsys *FS) stat(node vfs.Node, stat *fuse.Stat_t) (errc int) {
 	stat.Ino = node.Inode() // FIXME do we need to set the inode number?
 	stat.Mode = getMode(node)
 	stat.Nlink = 1
-	stat.Uid = fsys.VFS.Opt.UID
-	stat.Gid = fsys.VFS.Opt.GID
+	stat.Uid, stat.Gid = node.Owner()
 	//stat.Rdev
 	stat.Size = int64(Size)
 	t := fuse.NewTimespec(modTime)
@@ -502,17 +501,28 @@ func (fsys *FS) Readlink(path string) (errc int, linkPath string) {
 }
 
 // Chmod changes the permission bits of a file.
+//
+// This is a no-op unless --vfs-metadata-perms is set.
 func (fsys *FS) Chmod(path string, mode uint32) (errc int) {
 	defer log.Trace(path, "mode=0%o", mode)("errc=%d", &errc)
-	// This is a no-op for rclone
-	return 0
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc
+	}
+	return translateError(node.Chmod(os.FileMode(mode) & os.ModePerm))
 }
 
 // Chown changes the owner and group of a file.
+//
+// This is a no-op unless --vfs-metadata-perms is set.
 func (fsys *FS) Chown(path string, uid uint32, gid uint32) (errc int) {
 	defer log.Trace(path, "uid=%d, gid=%d", uid, gid)("errc=%d", &errc)
-	// This is a no-op for rclone
-	return 0
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc
+	}
+	// An id of ^uint32(0) means leave it unchanged
+	return translateError(node.Chown(int(int32(uid)), int(int32(gid))))
 }
 
 // Access checks file access permissions.
@@ -532,25 +542,54 @@ func (fsys *FS) Fsyncdir(path string, datasync bool, fh uint64) (errc int) {
 // Setxattr sets extended attributes.
 func (fsys *FS) Setxattr(path string, name string, value []byte, flags int) (errc int) {
 	defer log.Trace(path, "name=%q, value=%q, flags=%d", name, value, flags)("errc=%d", &errc)
-	return -fuse.ENOSYS
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc
+	}
+	return translateError(node.SetXattr(name, value, flags))
 }
 
 // Getxattr gets extended attributes.
 func (fsys *FS) Getxattr(path string, name string) (errc int, value []byte) {
 	defer log.Trace(path, "name=%q", name)("errc=%d, value=%q", &errc, &value)
-	return -fuse.ENOSYS, nil
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc, nil
+	}
+	value, err := node.GetXattr(name)
+	if err != nil {
+		return translateError(err), nil
+	}
+	return 0, value
 }
 
 // Removexattr removes extended attributes.
 func (fsys *FS) Removexattr(path string, name string) (errc int) {
 	defer log.Trace(path, "name=%q", name)("errc=%d", &errc)
-	return -fuse.ENOSYS
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc
+	}
+	return translateError(node.RemoveXattr(name))
 }
 
 // Listxattr lists extended attributes.
 func (fsys *FS) Listxattr(path string, fill func(name string) bool) (errc int) {
 	defer log.Trace(path, "fill=%p", fill)("errc=%d", &errc)
-	return -fuse.ENOSYS
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc
+	}
+	names, err := node.ListXattr()
+	if err != nil {
+		return translateError(err)
+	}
+	for _, name := range names {
+		if !fill(name) {
+			return -fuse.ERANGE
+		}
+	}
+	return 0
 }
 
 // Getpath allows a case-insensitive file system to report the correct case of
@@ -599,6 +638,12 @@ func translateError(err error) (errc int) {
 		return -fuse.EINVAL
 	case vfs.ELOOP:
 		return -fuse.ELOOP
+	case vfs.ENOATTR:
+		return -fuse.ENOATTR
+	case vfs.ENOTSUP:
+		return -fuse.ENOTSUP
+	case vfs.EAGAIN:
+		return -fuse.EAGAIN
 	}
 	fs.Errorf(nil, "IO error: %v", err)
 	return -fuse.EIO
@@ -140,6 +140,12 @@ func mount(VFS *vfs.VFS, mountPath string, opt *mountlib.Options) (<-chan error,
 		host.SetCapCaseInsensitive(f.Features().CaseInsensitive)
 	}
 
+	// cgofuse doesn't pass lock requests to the file system so the
+	// OS handles locks locally and rclone never sees them
+	if VFS.Opt.Locks {
+		return nil, nil, errors.New("--vfs-locks is not supported by cmount as it can't receive lock requests - use mount2 instead")
+	}
+
 	// Create options
 	options := mountOptions(VFS, opt.DeviceName, mountpoint, opt)
 	fs.Debugf(f, "Mounting with options: %q", options)
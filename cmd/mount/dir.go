@@ -32,8 +32,7 @@ var _ fusefs.Node = (*Dir)(nil)
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 	defer log.Trace(d, "")("attr=%+v, err=%v", a, &err)
 	a.Valid = time.Duration(d.fsys.opt.AttrTimeout)
-	a.Gid = d.VFS().Opt.GID
-	a.Uid = d.VFS().Opt.UID
+	a.Uid, a.Gid = d.Owner()
 	a.Mode = d.Mode()
 	modTime := d.ModTime()
 	a.Atime = modTime
@@ -47,9 +46,13 @@ func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 // Check interface satisfied
 var _ fusefs.NodeSetattrer = (*Dir)(nil)
 
-// Setattr handles attribute changes from FUSE. Currently supports ModTime only.
+// Setattr handles attribute changes from FUSE. Currently supports
+// ModTime, and mode and owner with --vfs-metadata-perms.
 func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) (err error) {
 	defer log.Trace(d, "stat=%+v", req)("err=%v", &err)
+	if err = setPerms(d.Dir, req); err != nil {
+		return translateError(err)
+	}
 	if d.VFS().Opt.NoModTime {
 		return nil
 	}
@@ -30,8 +30,7 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 	modTime := f.File.ModTime()
 	Size := uint64(f.File.Size())
 	Blocks := (Size + 511) / 512
-	a.Gid = f.VFS().Opt.GID
-	a.Uid = f.VFS().Opt.UID
+	a.Uid, a.Gid = f.Owner()
 	a.Mode = f.File.Mode() &^ os.ModeAppend
 	a.Size = Size
 	a.Atime = modTime
@@ -44,9 +43,13 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 // Check interface satisfied
 var _ fusefs.NodeSetattrer = (*File)(nil)
 
-// Setattr handles attribute changes from FUSE. Currently supports ModTime and Size only
+// Setattr handles attribute changes from FUSE. Currently supports
+// ModTime and Size, and mode and owner with --vfs-metadata-perms.
 func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) (err error) {
 	defer log.Trace(f, "a=%+v", req)("err=%v", &err)
+	if err = setPerms(f.File, req); err != nil {
+		return translateError(err)
+	}
 	if !f.VFS().Opt.NoModTime {
 		if req.Valid.Mtime() {
 			err = f.File.SetModTime(req.Mtime)
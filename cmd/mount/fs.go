@@ -6,6 +6,7 @@ package mount
 
 import (
 	"context"
+	"os"
 	"syscall"
 
 	"bazil.org/fuse"
@@ -71,6 +72,28 @@ func (f *FS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.Sta
 	return nil
 }
 
+// setPerms applies any mode, uid or gid changes in req to node
+func setPerms(node vfs.Node, req *fuse.SetattrRequest) error {
+	if req.Valid.Mode() {
+		if err := node.Chmod(req.Mode & os.ModePerm); err != nil {
+			return err
+		}
+	}
+	if req.Valid.Uid() || req.Valid.Gid() {
+		uid, gid := -1, -1
+		if req.Valid.Uid() {
+			uid = int(req.Uid)
+		}
+		if req.Valid.Gid() {
+			gid = int(req.Gid)
+		}
+		if err := node.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Translate errors from mountlib
 func translateError(err error) error {
 	if err == nil {
@@ -102,6 +125,12 @@ func translateError(err error) error {
 		return fuse.Errno(syscall.EINVAL)
 	case vfs.ELOOP:
 		return fuse.Errno(syscall.ELOOP)
+	case vfs.ENOATTR:
+		return fuse.ErrNoXattr
+	case vfs.ENOTSUP:
+		return fuse.Errno(syscall.ENOTSUP)
+	case vfs.EAGAIN:
+		return fuse.Errno(syscall.EAGAIN)
 	}
 	fs.Errorf(nil, "IO error: %v", err)
 	return err
@@ -5,6 +5,8 @@ package mount
 import (
 	"context"
 	"io"
+	"math"
+	"syscall"
 
 	"bazil.org/fuse"
 	fusefs "bazil.org/fuse/fs"
@@ -67,6 +69,8 @@ var _ fusefs.HandleFlusher = (*FileHandle)(nil)
 // some writes, or that if will be called at all.
 func (fh *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	// POSIX locks are released on any close of the file
+	fh.releaseLocks(req.LockOwner)
 	return translateError(fh.Handle.Flush())
 }
 
@@ -78,5 +82,117 @@ var _ fusefs.HandleReleaser = (*FileHandle)(nil)
 // the kernel
 func (fh *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	// flock locks are released on the last close of the file
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock != 0 {
+		fh.releaseLocks(req.LockOwner)
+	}
 	return translateError(fh.Handle.Release())
 }
+
+// toVFSLock converts a lock from FUSE into a vfs.FileLock
+func toVFSLock(owner fuse.LockOwner, lk fuse.FileLock, flags fuse.LockFlags) (vfsLock vfs.FileLock, err error) {
+	vfsLock = vfs.FileLock{
+		Start: lk.Start,
+		End:   lk.End,
+		Owner: uint64(owner),
+		Pid:   uint32(lk.PID),
+		Flock: flags&fuse.LockFlock != 0,
+	}
+	// The kernel uses OFFSET_MAX for the end of the file
+	if vfsLock.End >= math.MaxInt64 {
+		vfsLock.End = vfs.LockEOF
+	}
+	switch lk.Type {
+	case fuse.LockRead:
+		vfsLock.Type = vfs.LockRead
+	case fuse.LockWrite:
+		vfsLock.Type = vfs.LockWrite
+	case fuse.LockUnlock:
+		vfsLock.Type = vfs.LockUnlock
+	default:
+		return vfsLock, vfs.EINVAL
+	}
+	return vfsLock, nil
+}
+
+// fromVFSLock converts a vfs.FileLock into a lock for FUSE
+func fromVFSLock(vfsLock vfs.FileLock) (lk fuse.FileLock) {
+	lk.Start = vfsLock.Start
+	lk.End = vfsLock.End
+	if lk.End == vfs.LockEOF {
+		lk.End = math.MaxInt64
+	}
+	lk.PID = int32(vfsLock.Pid)
+	switch vfsLock.Type {
+	case vfs.LockRead:
+		lk.Type = fuse.LockRead
+	case vfs.LockWrite:
+		lk.Type = fuse.LockWrite
+	default:
+		lk.Type = fuse.LockUnlock
+	}
+	return lk
+}
+
+// setLock takes, changes or releases a lock, waiting if wait is set
+func (fh *FileHandle) setLock(ctx context.Context, req *fuse.LockRequest, wait bool) error {
+	file, ok := fh.Node().(*vfs.File)
+	if !ok {
+		return fuse.Errno(syscall.EBADF)
+	}
+	vfsLock, err := toVFSLock(req.LockOwner, req.Lock, req.LockFlags)
+	if err != nil {
+		return translateError(err)
+	}
+	err = file.SetLock(ctx, vfsLock, wait)
+	if ctx.Err() != nil {
+		return fuse.Errno(syscall.EINTR)
+	}
+	return translateError(err)
+}
+
+// releaseLocks releases any locks held by owner on the file
+func (fh *FileHandle) releaseLocks(owner fuse.LockOwner) {
+	if file, ok := fh.Node().(*vfs.File); ok {
+		file.ReleaseLocks(uint64(owner))
+	}
+}
+
+var _ fusefs.HandlePOSIXLocker = (*FileHandle)(nil)
+var _ fusefs.HandleFlockLocker = (*FileHandle)(nil)
+
+// Lock tries to acquire a lock on a byte range of the file without
+// waiting
+func (fh *FileHandle) Lock(ctx context.Context, req *fuse.LockRequest) (err error) {
+	defer log.Trace(fh, "req=%v", req)("err=%v", &err)
+	return fh.setLock(ctx, req, false)
+}
+
+// LockWait acquires a lock on a byte range of the file, waiting
+// until it can be obtained
+func (fh *FileHandle) LockWait(ctx context.Context, req *fuse.LockWaitRequest) (err error) {
+	defer log.Trace(fh, "req=%v", req)("err=%v", &err)
+	return fh.setLock(ctx, (*fuse.LockRequest)(req), true)
+}
+
+// Unlock releases the lock on a byte range of the file
+func (fh *FileHandle) Unlock(ctx context.Context, req *fuse.UnlockRequest) (err error) {
+	defer log.Trace(fh, "req=%v", req)("err=%v", &err)
+	return fh.setLock(ctx, (*fuse.LockRequest)(req), false)
+}
+
+// QueryLock returns a lock which would stop the lock in req being
+// taken, or a lock of type LockUnlock if there isn't one
+func (fh *FileHandle) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) (err error) {
+	defer log.Trace(fh, "req=%v", req)("resp=%v, err=%v", resp, &err)
+	file, ok := fh.Node().(*vfs.File)
+	if !ok {
+		return fuse.Errno(syscall.EBADF)
+	}
+	vfsLock, err := toVFSLock(req.LockOwner, req.Lock, req.LockFlags)
+	if err != nil {
+		return translateError(err)
+	}
+	resp.Lock = fromVFSLock(file.GetLock(vfsLock))
+	return nil
+}
@@ -49,6 +49,9 @@ func mountOptions(VFS *vfs.VFS, device string, opt *mountlib.Options) (options [
 	if opt.WritebackCache {
 		options = append(options, fuse.WritebackCache())
 	}
+	if VFS.Opt.Locks {
+		options = append(options, fuse.LockingFlock(), fuse.LockingPOSIX())
+	}
 	if opt.DaemonTimeout != 0 {
 		options = append(options, fuse.DaemonTimeout(fmt.Sprint(int(time.Duration(opt.DaemonTimeout).Seconds()))))
 	}
@@ -6,6 +6,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
+	"sync"
 	"syscall"
 
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
@@ -35,6 +37,9 @@ import (
 type FileHandle struct {
 	h    vfs.Handle
 	fsys *FS
+
+	mu     sync.Mutex
+	owners map[uint64]struct{} // lock owners which have used this handle
 }
 
 // Create a new FileHandle
@@ -95,6 +100,7 @@ var _ fusefs.FileFlusher = (*FileHandle)(nil)
 // so any cleanup that requires specific synchronization or
 // could fail with I/O errors should happen in Flush instead.
 func (f *FileHandle) Release(ctx context.Context) syscall.Errno {
+	f.releaseLocks()
 	return translateError(f.h.Release())
 }
 
@@ -149,3 +155,131 @@ func (f *FileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.
 }
 
 var _ fusefs.FileSetattrer = (*FileHandle)(nil)
+
+// file returns the vfs.File the handle is open on
+func (f *FileHandle) file() (*vfs.File, bool) {
+	file, ok := f.h.Node().(*vfs.File)
+	return file, ok
+}
+
+// toVFSLock converts a lock from FUSE into a vfs.FileLock
+func toVFSLock(owner uint64, lk *fuse.FileLock, flags uint32) (vfsLock vfs.FileLock, err error) {
+	vfsLock = vfs.FileLock{
+		Start: lk.Start,
+		End:   lk.End,
+		Owner: owner,
+		Pid:   lk.Pid,
+		Flock: flags&fuse.FUSE_LK_FLOCK != 0,
+	}
+	// The kernel uses OFFSET_MAX for the end of the file
+	if vfsLock.End >= math.MaxInt64 {
+		vfsLock.End = vfs.LockEOF
+	}
+	switch lk.Typ {
+	case syscall.F_RDLCK:
+		vfsLock.Type = vfs.LockRead
+	case syscall.F_WRLCK:
+		vfsLock.Type = vfs.LockWrite
+	case syscall.F_UNLCK:
+		vfsLock.Type = vfs.LockUnlock
+	default:
+		return vfsLock, vfs.EINVAL
+	}
+	return vfsLock, nil
+}
+
+// fromVFSLock converts a vfs.FileLock into a lock for FUSE
+func fromVFSLock(vfsLock vfs.FileLock, lk *fuse.FileLock) {
+	lk.Start = vfsLock.Start
+	lk.End = vfsLock.End
+	if lk.End == vfs.LockEOF {
+		lk.End = math.MaxInt64
+	}
+	lk.Pid = vfsLock.Pid
+	switch vfsLock.Type {
+	case vfs.LockRead:
+		lk.Typ = syscall.F_RDLCK
+	case vfs.LockWrite:
+		lk.Typ = syscall.F_WRLCK
+	default:
+		lk.Typ = syscall.F_UNLCK
+	}
+}
+
+// Getlk returns locks that would conflict with the given input
+// lock. If no locks conflict, the output has type L_UNLCK.
+func (f *FileHandle) Getlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=0x%x, lk=%+v, flags=0x%x", owner, lk, flags)("out=%+v, errno=%v", out, &errno)
+	file, ok := f.file()
+	if !ok {
+		return syscall.EBADF
+	}
+	vfsLock, err := toVFSLock(owner, lk, flags)
+	if err != nil {
+		return translateError(err)
+	}
+	fromVFSLock(file.GetLock(vfsLock), out)
+	return 0
+}
+
+var _ fusefs.FileGetlker = (*FileHandle)(nil)
+
+// setLock takes, changes or releases a lock, waiting if wait is set
+func (f *FileHandle) setLock(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, wait bool) syscall.Errno {
+	file, ok := f.file()
+	if !ok {
+		return syscall.EBADF
+	}
+	vfsLock, err := toVFSLock(owner, lk, flags)
+	if err != nil {
+		return translateError(err)
+	}
+	err = file.SetLock(ctx, vfsLock, wait)
+	if err == nil && vfsLock.Type != vfs.LockUnlock {
+		f.mu.Lock()
+		if f.owners == nil {
+			f.owners = make(map[uint64]struct{})
+		}
+		f.owners[owner] = struct{}{}
+		f.mu.Unlock()
+	}
+	if ctx.Err() != nil {
+		return syscall.EINTR
+	}
+	return translateError(err)
+}
+
+// Setlk obtains a lock on a file, or fail if the lock could not
+// obtained.
+func (f *FileHandle) Setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=0x%x, lk=%+v, flags=0x%x", owner, lk, flags)("errno=%v", &errno)
+	return f.setLock(ctx, owner, lk, flags, false)
+}
+
+var _ fusefs.FileSetlker = (*FileHandle)(nil)
+
+// Setlkw obtains a lock on a file, waiting if necessary.
+func (f *FileHandle) Setlkw(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=0x%x, lk=%+v, flags=0x%x", owner, lk, flags)("errno=%v", &errno)
+	return f.setLock(ctx, owner, lk, flags, true)
+}
+
+var _ fusefs.FileSetlkwer = (*FileHandle)(nil)
+
+// releaseLocks releases any locks taken through this handle
+//
+// The kernel normally does this itself when the file is closed but
+// this makes sure none are left behind.
+func (f *FileHandle) releaseLocks() {
+	file, ok := f.file()
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	owners := f.owners
+	f.owners = nil
+	f.mu.Unlock()
+	for owner := range owners {
+		file.ReleaseLocks(owner)
+	}
+}
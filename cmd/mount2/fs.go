@@ -72,9 +72,7 @@ func setAttr(node vfs.Node, attr *fuse.Attr) {
 	Blocks := (Size + BlockSize - 1) / BlockSize
 	modTime := node.ModTime()
 	// set attributes
-	vfs := node.VFS()
-	attr.Owner.Gid = vfs.Opt.GID
-	attr.Owner.Uid = vfs.Opt.UID
+	attr.Owner.Uid, attr.Owner.Gid = node.Owner()
 	attr.Mode = getMode(node)
 	attr.Size = Size
 	attr.Nlink = 1
@@ -135,6 +133,12 @@ func translateError(err error) syscall.Errno {
 		return syscall.EINVAL
 	case vfs.ELOOP:
 		return syscall.ELOOP
+	case vfs.ENOATTR:
+		return syscall.Errno(fuse.ENOATTR)
+	case vfs.ENOTSUP:
+		return syscall.ENOTSUP
+	case vfs.EAGAIN:
+		return syscall.EAGAIN
 	}
 	fs.Errorf(nil, "IO error: %v", err)
 	return syscall.EIO
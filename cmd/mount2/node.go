@@ -138,6 +138,30 @@ func (n *Node) Setattr(ctx context.Context, f fusefs.FileHandle, in *fuse.SetAtt
 		out.Attr.Mtime = uint64(mtime.Unix())
 		out.Attr.Mtimensec = uint32(mtime.Nanosecond())
 	}
+	mode, ok := in.GetMode()
+	if ok {
+		err = n.node.Chmod(os.FileMode(mode) & os.ModePerm)
+		if err != nil {
+			return translateError(err)
+		}
+		out.Attr.Mode = getMode(n.node)
+	}
+	uid, uidOK := in.GetUID()
+	gid, gidOK := in.GetGID()
+	if uidOK || gidOK {
+		newUID, newGID := -1, -1
+		if uidOK {
+			newUID = int(uid)
+		}
+		if gidOK {
+			newGID = int(gid)
+		}
+		err = n.node.Chown(newUID, newGID)
+		if err != nil {
+			return translateError(err)
+		}
+		out.Attr.Owner.Uid, out.Attr.Owner.Gid = n.node.Owner()
+	}
 	return 0
 }
 
@@ -426,8 +450,13 @@ var _ = (fusefs.NodeRenamer)((*Node)(nil))
 // `dest` and return the number of bytes. If `dest` is too
 // small, it should return ERANGE and the size of the attribute.
 // If not defined, Getxattr will return ENOATTR.
-func (n *Node) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
-	return 0, syscall.ENOSYS // we never implement this
+func (n *Node) Getxattr(ctx context.Context, attr string, dest []byte) (size uint32, errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("size=%d, errno=%v", &size, &errno)
+	value, err := n.node.GetXattr(attr)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return copyXattr(dest, value)
 }
 
 var _ fusefs.NodeGetxattrer = (*Node)(nil)
@@ -435,16 +464,18 @@ var _ fusefs.NodeGetxattrer = (*Node)(nil)
 // Setxattr should store data for the given attribute.  See
 // setxattr(2) for information about flags.
 // If not defined, Setxattr will return ENOATTR.
-func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
-	return syscall.ENOSYS // we never implement this
+func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q, flags=%d", attr, flags)("errno=%v", &errno)
+	return translateError(n.node.SetXattr(attr, data, int(flags)))
 }
 
 var _ fusefs.NodeSetxattrer = (*Node)(nil)
 
 // Removexattr should delete the given attribute.
 // If not defined, Removexattr will return ENOATTR.
-func (n *Node) Removexattr(ctx context.Context, attr string) syscall.Errno {
-	return syscall.ENOSYS // we never implement this
+func (n *Node) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("errno=%v", &errno)
+	return translateError(n.node.RemoveXattr(attr))
 }
 
 var _ fusefs.NodeRemovexattrer = (*Node)(nil)
@@ -453,8 +484,31 @@ var _ fusefs.NodeRemovexattrer = (*Node)(nil)
 // `dest`. If the `dest` buffer is too small, it should return ERANGE
 // and the correct size.  If not defined, return an empty list and
 // success.
-func (n *Node) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
-	return 0, syscall.ENOSYS // we never implement this
+func (n *Node) Listxattr(ctx context.Context, dest []byte) (size uint32, errno syscall.Errno) {
+	defer log.Trace(n, "")("size=%d, errno=%v", &size, &errno)
+	names, err := n.node.ListXattr()
+	if err != nil {
+		return 0, translateError(err)
+	}
+	var list []byte
+	for _, name := range names {
+		list = append(list, name...)
+		list = append(list, 0)
+	}
+	return copyXattr(dest, list)
+}
+
+// copyXattr copies value into dest returning ERANGE if it doesn't fit
+//
+// If dest is empty then the caller is asking for the size only.
+func copyXattr(dest, value []byte) (uint32, syscall.Errno) {
+	if len(dest) == 0 {
+		return uint32(len(value)), 0
+	}
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
 }
 
 var _ fusefs.NodeListxattrer = (*Node)(nil)
@@ -369,7 +369,10 @@ func (m *MountPoint) Mount() (mountDaemon *os.Process, err error) {
 		}
 	}
 
-	m.VFS = vfs.New(m.Fs, &m.VFSOpt)
+	m.VFS, err = vfs.NewE(m.Fs, &m.VFSOpt)
+	if err != nil {
+		return nil, err
+	}
 
 	m.ErrChan, m.UnmountFn, err = m.MountFn(m.VFS, m.MountPoint, &m.MountOpt)
 	if err != nil {
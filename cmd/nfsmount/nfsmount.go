@@ -46,9 +46,9 @@ func mount(VFS *vfs.VFS, mountpoint string, opt *mountlib.Options) (asyncerrors
 	}()
 	// The port is always picked at random after the NFS server has started
 	// we need to query the server for the port number so we can mount it
-	_, port, err := net.SplitHostPort(s.Addr().String())
+	_, port, err := net.SplitHostPort(s.Addr())
 	if err != nil {
-		err = fmt.Errorf("cannot find port number in %s", s.Addr().String())
+		err = fmt.Errorf("cannot find port number in %s", s.Addr())
 		return
 	}
 
@@ -3,7 +3,9 @@ package dlna
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -11,6 +13,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	dms_dlna "github.com/anacrolix/dms/dlna"
@@ -19,10 +22,12 @@ import (
 	"github.com/anacrolix/dms/upnp"
 	"github.com/anacrolix/log"
 	"github.com/rclone/rclone/cmd"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/dlna/data"
 	"github.com/rclone/rclone/cmd/serve/dlna/dlnaflags"
+	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/fs"
-	"github.com/rclone/rclone/lib/systemd"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
@@ -32,6 +37,18 @@ import (
 func init() {
 	dlnaflags.AddFlags(Command.Flags())
 	vfsflags.AddFlags(Command.Flags())
+	cmdserve.Command.AddCommand(Command)
+	cmdserve.Register("dlna", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (cmdserve.Protocol, error) {
+		if proxyOpt.AuthProxy != "" {
+			return nil, errors.New("auth proxy not supported as DLNA has no authentication so there are no credentials to pass to the proxy")
+		}
+		opt := dlnaflags.Opt
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		return newServer(f, &opt, vfsOpt)
+	})
 }
 
 // Command definition for cobra.
@@ -53,6 +70,10 @@ Rclone will add external subtitle files (.srt) to videos if they have the same
 filename as the video file itself (except the extension), either in the same
 directory as the video, or in a "Subs" subdirectory.
 
+DLNA has no authentication so this server can't be used with the auth
+proxy. Starting it with the ` + "`serve/start`" + ` rc call and ` + "`proxyOpt`" + `
+set will return an error.
+
 ` + dlnaflags.Help + vfs.Help(),
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.46",
@@ -63,16 +84,11 @@ directory as the video, or in a "Subs" subdirectory.
 		f := cmd.NewFsSrc(args)
 
 		cmd.Run(false, false, command, func() error {
-			s, err := newServer(f, &dlnaflags.Opt)
+			s, err := newServer(f, &dlnaflags.Opt, &vfscommon.Opt)
 			if err != nil {
 				return err
 			}
-			if err := s.Serve(); err != nil {
-				return err
-			}
-			defer systemd.Notify()()
-			s.Wait()
-			return nil
+			return cmdserve.Run("dlna", s)
 		})
 	},
 }
@@ -99,7 +115,8 @@ type server struct {
 	FriendlyName string
 
 	// For waiting on the listener to close
-	waitChan chan struct{}
+	waitChan     chan struct{}
+	shutdownOnce sync.Once
 
 	// Time interval between SSPD announces
 	AnnounceInterval time.Duration
@@ -108,7 +125,10 @@ type server struct {
 	vfs *vfs.VFS
 }
 
-func newServer(f fs.Fs, opt *dlnaflags.Options) (*server, error) {
+// check interface
+var _ cmdserve.Protocol = (*server)(nil)
+
+func newServer(f fs.Fs, opt *dlnaflags.Options, vfsOpt *vfscommon.Options) (*server, error) {
 	friendlyName := opt.FriendlyName
 	if friendlyName == "" {
 		friendlyName = makeDefaultFriendlyName()
@@ -129,6 +149,11 @@ func newServer(f fs.Fs, opt *dlnaflags.Options) (*server, error) {
 		interfaces = listInterfaces()
 	}
 
+	VFS, err := vfs.NewE(f, vfsOpt)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &server{
 		AnnounceInterval: time.Duration(opt.AnnounceInterval),
 		FriendlyName:     friendlyName,
@@ -137,7 +162,7 @@ func newServer(f fs.Fs, opt *dlnaflags.Options) (*server, error) {
 		waitChan:         make(chan struct{}),
 		httpListenAddr:   opt.ListenAddr,
 		f:                f,
-		vfs:              vfs.New(f, &vfscommon.Opt),
+		vfs:              VFS,
 	}
 
 	s.services = map[string]UPnPService{
@@ -168,6 +193,18 @@ func newServer(f fs.Fs, opt *dlnaflags.Options) (*server, error) {
 			http.FileServer(data.Assets))))
 	s.handler = logging(withHeader("Server", serverField, r))
 
+	// Currently, the SSDP server only listens on an IPv4 multicast address.
+	// Differentiate between two INADDR_ANY addresses,
+	// so that 0.0.0.0 can only listen on IPv4 addresses.
+	network := "tcp4"
+	if strings.Count(s.httpListenAddr, ":") > 1 {
+		network = "tcp"
+	}
+	s.HTTPConn, err = net.Listen(network, s.httpListenAddr)
+	if err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
@@ -288,24 +325,9 @@ func (s *server) resourceHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, remotePath, node.ModTime(), in)
 }
 
-// Serve runs the server - returns the error only if
-// the listener was not started; does not block, so
+// serve runs the server in the background; it does not block, so
 // use s.Wait() to block on the listener indefinitely.
-func (s *server) Serve() (err error) {
-	if s.HTTPConn == nil {
-		// Currently, the SSDP server only listens on an IPv4 multicast address.
-		// Differentiate between two INADDR_ANY addresses,
-		// so that 0.0.0.0 can only listen on IPv4 addresses.
-		network := "tcp4"
-		if strings.Count(s.httpListenAddr, ":") > 1 {
-			network = "tcp"
-		}
-		s.HTTPConn, err = net.Listen(network, s.httpListenAddr)
-		if err != nil {
-			return
-		}
-	}
-
+func (s *server) serve() {
 	go func() {
 		s.startSSDP()
 	}()
@@ -318,22 +340,34 @@ func (s *server) Serve() (err error) {
 			fs.Logf(s.f, "Error on serving HTTP server: %v", err)
 		}
 	}()
+}
 
+// Serve runs the server - it doesn't return until Shutdown is called
+func (s *server) Serve() error {
+	s.serve()
+	s.Wait()
 	return nil
 }
 
+// Addr returns the address the server is listening on
+func (s *server) Addr() string {
+	return s.HTTPConn.Addr().String()
+}
+
 // Wait blocks while the listener is open.
 func (s *server) Wait() {
 	<-s.waitChan
 }
 
-func (s *server) Close() {
-	err := s.HTTPConn.Close()
-	if err != nil {
-		fs.Errorf(s.f, "Error closing HTTP server: %v", err)
-		return
-	}
-	close(s.waitChan)
+// Shutdown stops the server
+//
+// It is safe to call more than once.
+func (s *server) Shutdown() (err error) {
+	s.shutdownOnce.Do(func() {
+		err = s.HTTPConn.Close()
+		close(s.waitChan)
+	})
+	return err
 }
 
 // Run SSDP (multicast for server discovery) on all interfaces.
@@ -15,6 +15,7 @@ import (
 
 	"github.com/rclone/rclone/fs/config/configfile"
 	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
 
 	_ "github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/cmd/serve/dlna/dlnaflags"
@@ -36,9 +37,9 @@ func startServer(t *testing.T, f fs.Fs) {
 	opt := dlnaflags.Opt
 	opt.ListenAddr = testBindAddress
 	var err error
-	dlnaServer, err = newServer(f, &opt)
+	dlnaServer, err = newServer(f, &opt, &vfscommon.Opt)
 	assert.NoError(t, err)
-	assert.NoError(t, dlnaServer.Serve())
+	dlnaServer.serve()
 	baseURL = "http://" + dlnaServer.HTTPConn.Addr().String()
 }
 
@@ -271,3 +272,16 @@ func TestContentDirectoryBrowseDirectChildren(t *testing.T) {
 
 	}
 }
+
+func TestShutdownTwice(t *testing.T) {
+	f, err := fs.NewFs(context.Background(), "testdata/files")
+	require.NoError(t, err)
+	opt := dlnaflags.Opt
+	opt.ListenAddr = testBindAddress
+	s, err := newServer(f, &opt, &vfscommon.Opt)
+	require.NoError(t, err)
+	s.serve()
+	require.NoError(t, s.Shutdown())
+	s.Wait()
+	assert.NoError(t, s.Shutdown())
+}
@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/rclone/rclone/cmd"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
@@ -25,6 +26,7 @@ import (
 	"github.com/rclone/rclone/fs/config/flags"
 	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
@@ -88,6 +90,15 @@ func init() {
 	vfsflags.AddFlags(Command.Flags())
 	proxyflags.AddFlags(Command.Flags())
 	AddFlags(Command.Flags())
+	cmdserve.Command.AddCommand(Command)
+	cmdserve.Register("ftp", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (cmdserve.Protocol, error) {
+		opt := Opt
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		return newServer(ctx, f, &opt, vfsOpt, proxyOpt)
+	})
 }
 
 // Command definition for cobra
@@ -128,11 +139,11 @@ You can set a single username and password with the --user and --pass flags.
 			cmd.CheckArgs(0, 0, command, args)
 		}
 		cmd.Run(false, false, command, func() error {
-			s, err := newServer(context.Background(), f, &Opt)
+			s, err := newServer(context.Background(), f, &Opt, &vfscommon.Opt, &proxyflags.Opt)
 			if err != nil {
 				return err
 			}
-			return s.serve()
+			return cmdserve.Run("ftp", s)
 		})
 	},
 }
@@ -150,6 +161,9 @@ type driver struct {
 	userPass   map[string]string // cache of username => password when using vfs proxy
 }
 
+// check interface
+var _ cmdserve.Protocol = (*driver)(nil)
+
 func init() {
 	fs.RegisterGlobalOptions(fs.OptionsInfo{Name: "ftp", Opt: &Opt, Options: OptionsInfo})
 }
@@ -157,7 +171,7 @@ func init() {
 var passivePortsRe = regexp.MustCompile(`^\s*\d+\s*-\s*\d+\s*$`)
 
 // Make a new FTP to serve the remote
-func newServer(ctx context.Context, f fs.Fs, opt *Options) (*driver, error) {
+func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (*driver, error) {
 	host, port, err := net.SplitHostPort(opt.ListenAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse host:port from %q", opt.ListenAddr)
@@ -172,11 +186,14 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options) (*driver, error) {
 		ctx: ctx,
 		opt: *opt,
 	}
-	if proxyflags.Opt.AuthProxy != "" {
-		d.proxy = proxy.New(ctx, &proxyflags.Opt)
+	if proxyOpt.AuthProxy != "" {
+		d.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		d.userPass = make(map[string]string, 16)
 	} else {
-		d.globalVFS = vfs.New(f, &vfscommon.Opt)
+		d.globalVFS, err = vfs.NewE(f, vfsOpt)
+		if err != nil {
+			return nil, err
+		}
 	}
 	d.useTLS = d.opt.TLSKey != ""
 
@@ -215,13 +232,30 @@ func (d *driver) serve() error {
 }
 
 // close stops the ftp server
-//
-//lint:ignore U1000 unused when not building linux
 func (d *driver) close() error {
 	fs.Logf(d.f, "Stopping FTP on %s", d.srv.Hostname+":"+strconv.Itoa(d.srv.Port))
 	return d.srv.Shutdown()
 }
 
+// Serve runs the ftp server - it doesn't return until Shutdown is called
+func (d *driver) Serve() error {
+	err := d.serve()
+	if err == ftp.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Addr returns the address the server is listening on
+func (d *driver) Addr() string {
+	return net.JoinHostPort(d.srv.Hostname, strconv.Itoa(d.srv.Port))
+}
+
+// Shutdown stops the ftp server
+func (d *driver) Shutdown() error {
+	return d.close()
+}
+
 // Logger ftp logger output formatted message
 type Logger struct{}
 
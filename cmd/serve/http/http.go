@@ -15,13 +15,15 @@ import (
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rclone/rclone/cmd"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
 	libhttp "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/http/serve"
-	"github.com/rclone/rclone/lib/systemd"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
@@ -30,9 +32,10 @@ import (
 
 // Options required for http server
 type Options struct {
-	Auth     libhttp.AuthConfig
-	HTTP     libhttp.Config
-	Template libhttp.TemplateConfig
+	Auth      libhttp.AuthConfig
+	HTTP      libhttp.Config
+	Template  libhttp.TemplateConfig
+	ReadWrite bool
 }
 
 // DefaultOpt is the default values used for Options
@@ -54,8 +57,18 @@ func init() {
 	libhttp.AddAuthFlagsPrefix(flagSet, flagPrefix, &Opt.Auth)
 	libhttp.AddHTTPFlagsPrefix(flagSet, flagPrefix, &Opt.HTTP)
 	libhttp.AddTemplateFlagsPrefix(flagSet, flagPrefix, &Opt.Template)
+	flags.BoolVarP(flagSet, &Opt.ReadWrite, "read-write", "", Opt.ReadWrite, "Allow uploading, renaming and deleting files from the web interface", "")
 	vfsflags.AddFlags(flagSet)
 	proxyflags.AddFlags(flagSet)
+	cmdserve.Command.AddCommand(Command)
+	cmdserve.Register("http", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (cmdserve.Protocol, error) {
+		opt := Opt
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		return newServer(ctx, f, &opt, vfsOpt, proxyOpt)
+	})
 }
 
 // Command definition for cobra
@@ -71,6 +84,21 @@ is served.
 
 The server will log errors.  Use ` + "`-v`" + ` to see access logs.
 
+Directories can be downloaded as an archive by adding ` + "`?download=zip`" + ` or
+` + "`?download=tar.gz`" + ` to the URL of the directory. The archive is streamed
+directly from the remote without being stored on disk. Files excluded
+by the filters (e.g. ` + "`--max-size`" + `) are left out of the archive.
+
+By default the server is read only. If ` + "`--read-write`" + ` is set then the
+directory listings will have forms to upload files, create folders
+and rename and delete files and folders. Uploads are streamed to the
+remote via the VFS so see the VFS docs below for the effect of
+` + "`--vfs-cache-mode`" + `. You should use this with one of the
+authentication methods below as anyone who can reach the server will
+be able to modify the files otherwise. The forms are protected against
+cross site request forgery. If you use a custom template it will need
+to include the .CSRFToken in any forms as described below.
+
 ` + "`--bwlimit`" + ` will be respected for file transfers.  Use ` + "`--stats`" + ` to
 control the stats printing.
 
@@ -89,28 +117,30 @@ control the stats printing.
 		}
 
 		cmd.Run(false, true, command, func() error {
-			s, err := run(context.Background(), f, Opt)
+			s, err := newServer(context.Background(), f, &Opt, &vfscommon.Opt, &proxyflags.Opt)
 			if err != nil {
 				fs.Fatal(nil, fmt.Sprint(err))
 			}
-
-			defer systemd.Notify()()
-			s.server.Wait()
-			return nil
+			return cmdserve.Run("http", s)
 		})
 	},
 }
 
 // HTTP contains everything to run the server
 type HTTP struct {
-	f      fs.Fs
-	_vfs   *vfs.VFS // don't use directly, use getVFS
-	server *libhttp.Server
-	opt    Options
-	proxy  *proxy.Proxy
-	ctx    context.Context // for global config
+	f       fs.Fs
+	_vfs    *vfs.VFS // don't use directly, use getVFS
+	server  *libhttp.Server
+	opt     Options
+	vfsOpt  vfscommon.Options
+	proxy   *proxy.Proxy
+	ctx     context.Context // for global config
+	csrfKey []byte          // secret for making CSRF tokens
 }
 
+// check interface
+var _ cmdserve.Protocol = (*HTTP)(nil)
+
 // Gets the VFS in use for this request
 func (s *HTTP) getVFS(ctx context.Context) (VFS *vfs.VFS, err error) {
 	if s._vfs != nil {
@@ -136,19 +166,26 @@ func (s *HTTP) auth(user, pass string) (value interface{}, err error) {
 	return VFS, err
 }
 
-func run(ctx context.Context, f fs.Fs, opt Options) (s *HTTP, err error) {
+// newServer makes a new HTTP server to serve f
+//
+// Call Serve to start serving
+func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (s *HTTP, err error) {
 	s = &HTTP{
-		f:   f,
-		ctx: ctx,
-		opt: opt,
+		f:      f,
+		ctx:    ctx,
+		opt:    *opt,
+		vfsOpt: *vfsOpt,
 	}
 
-	if proxyflags.Opt.AuthProxy != "" {
-		s.proxy = proxy.New(ctx, &proxyflags.Opt)
+	if proxyOpt.AuthProxy != "" {
+		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		// override auth
 		s.opt.Auth.CustomAuthFn = s.auth
 	} else {
-		s._vfs = vfs.New(f, &vfscommon.Opt)
+		s._vfs, err = vfs.NewE(f, vfsOpt)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	s.server, err = libhttp.NewServer(ctx,
@@ -167,17 +204,53 @@ func run(ctx context.Context, f fs.Fs, opt Options) (s *HTTP, err error) {
 	)
 	router.Get("/*", s.handler)
 	router.Head("/*", s.handler)
+	if s.opt.ReadWrite {
+		s.csrfKey, err = newCSRFKey()
+		if err != nil {
+			return nil, err
+		}
+		if !s.server.UsingAuth() && s.proxy == nil {
+			fs.Logf(f, "Serving read-write without authentication - anyone who can connect can modify files")
+		}
+		router.Post("/*", s.handler)
+	}
+
+	return s, nil
+}
+
+// Addr returns the first address the server is listening on
+func (s *HTTP) Addr() string {
+	urls := s.server.URLs()
+	if len(urls) == 0 {
+		return ""
+	}
+	return urls[0]
+}
 
+// Serve runs the server - it doesn't return until Shutdown is called
+func (s *HTTP) Serve() error {
 	s.server.Serve()
+	fs.Logf(s.f, "HTTP Server started on %s", s.server.URLs())
+	s.server.Wait()
+	return nil
+}
 
-	return s, nil
+// Shutdown the server, waiting for in flight requests to finish
+func (s *HTTP) Shutdown() error {
+	return s.server.Shutdown()
 }
 
 // handler reads incoming requests and dispatches them
 func (s *HTTP) handler(w http.ResponseWriter, r *http.Request) {
 	isDir := strings.HasSuffix(r.URL.Path, "/")
 	remote := strings.Trim(r.URL.Path, "/")
-	if isDir {
+	if r.Method == "POST" {
+		if !isDir {
+			http.Error(w, "Can only POST to a directory", http.StatusMethodNotAllowed)
+			return
+		}
+		s.servePost(w, r, remote)
+	} else if isDir {
 		s.serveDir(w, r, remote)
 	} else {
 		s.serveFile(w, r, remote)
@@ -207,6 +280,10 @@ func (s *HTTP) serveDir(w http.ResponseWriter, r *http.Request, dirRemote string
 		return
 	}
 	dir := node.(*vfs.Dir)
+	if format := serve.ArchiveFormat(r); format != "" {
+		serve.ServeArchive(w, r, dir, format)
+		return
+	}
 	dirEntries, err := dir.ReadDirAll()
 	if err != nil {
 		serve.Error(ctx, dirRemote, w, "Failed to list directory", err)
@@ -215,8 +292,12 @@ func (s *HTTP) serveDir(w http.ResponseWriter, r *http.Request, dirRemote string
 
 	// Make the entries for display
 	directory := serve.NewDirectory(dirRemote, s.server.HTMLTemplate())
+	if s.opt.ReadWrite && !VFS.Opt.ReadOnly {
+		directory.ReadWrite = true
+		directory.CSRFToken = s.csrfToken(r)
+	}
 	for _, node := range dirEntries {
-		if vfscommon.Opt.NoModTime {
+		if s.vfsOpt.NoModTime {
 			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), time.Time{})
 		} else {
 			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), node.ModTime().UTC())
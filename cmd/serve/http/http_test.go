@@ -1,12 +1,16 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -16,6 +20,7 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/filter"
 	libhttp "github.com/rclone/rclone/lib/http"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -44,8 +49,11 @@ func start(ctx context.Context, t *testing.T, f fs.Fs) (s *HTTP, testURL string)
 		opts.Auth.BasicPass = testPass
 	}
 
-	s, err := run(ctx, f, opts)
+	s, err := newServer(ctx, f, &opts, &vfscommon.Opt, &proxyflags.Opt)
 	require.NoError(t, err, "failed to start server")
+	go func() {
+		assert.NoError(t, s.Serve())
+	}()
 
 	urls := s.server.URLs()
 	require.Len(t, urls, 1, "expected one URL")
@@ -130,7 +138,7 @@ func testGET(t *testing.T, useProxy bool) {
 
 	s, testURL := start(ctx, t, f)
 	defer func() {
-		assert.NoError(t, s.server.Shutdown())
+		assert.NoError(t, s.Shutdown())
 	}()
 
 	for _, test := range []struct {
@@ -267,3 +275,112 @@ func TestGET(t *testing.T) {
 func TestAuthProxy(t *testing.T) {
 	testGET(t, true)
 }
+
+func TestReadWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old.txt"), []byte("old"), 0666))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	opts := DefaultOpt
+	opts.ReadWrite = true
+	opts.HTTP.ListenAddr = []string{testBindAddress}
+	opts.Auth.BasicUser = testUser
+	opts.Auth.BasicPass = testPass
+	s, err := newServer(ctx, f, &opts, &vfscommon.Opt, &proxyflags.Opt)
+	require.NoError(t, err)
+	go func() {
+		assert.NoError(t, s.Serve())
+	}()
+	defer func() {
+		assert.NoError(t, s.Shutdown())
+	}()
+	testURL := s.Addr()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(req *http.Request) (int, string) {
+		req.SetBasicAuth(testUser, testPass)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(body)
+	}
+	postForm := func(values url.Values) int {
+		req, err := http.NewRequest("POST", testURL, strings.NewReader(values.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		status, _ := do(req)
+		return status
+	}
+	upload := func(csrf, name, contents string) int {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		require.NoError(t, mw.WriteField("csrf", csrf))
+		require.NoError(t, mw.WriteField("action", "upload"))
+		fw, err := mw.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = fw.Write([]byte(contents))
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+		req, err := http.NewRequest("POST", testURL, &buf)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		status, _ := do(req)
+		return status
+	}
+
+	// Read the CSRF token from the directory listing
+	req, err := http.NewRequest("GET", testURL, nil)
+	require.NoError(t, err)
+	status, body := do(req)
+	require.Equal(t, http.StatusOK, status)
+	match := regexp.MustCompile(`name="csrf" value="([^"]+)"`).FindStringSubmatch(body)
+	require.NotNil(t, match, "CSRF token not found in listing")
+	csrf := match[1]
+	assert.Contains(t, body, `value="old.txt"`)
+
+	// Check the CSRF token and origin are enforced
+	assert.Equal(t, http.StatusForbidden, upload("potato", "new.txt", "new"))
+	assert.Equal(t, http.StatusForbidden, postForm(url.Values{"csrf": {"potato"}, "action": {"mkdir"}, "name": {"dir"}}))
+	req, err = http.NewRequest("POST", testURL, strings.NewReader(url.Values{"csrf": {csrf}, "action": {"mkdir"}, "name": {"dir"}}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.com")
+	status, _ = do(req)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.NoDirExists(t, filepath.Join(dir, "dir"))
+
+	// Check bad names are rejected
+	assert.Equal(t, http.StatusBadRequest, postForm(url.Values{"csrf": {csrf}, "action": {"mkdir"}, "name": {"../dir"}}))
+	assert.Equal(t, http.StatusBadRequest, postForm(url.Values{"csrf": {csrf}, "action": {"potato"}, "name": {"dir"}}))
+
+	// Upload
+	assert.Equal(t, http.StatusSeeOther, upload(csrf, "new.txt", "new contents"))
+	data, err := os.ReadFile(filepath.Join(dir, "new.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new contents", string(data))
+
+	// Mkdir
+	assert.Equal(t, http.StatusSeeOther, postForm(url.Values{"csrf": {csrf}, "action": {"mkdir"}, "name": {"dir"}}))
+	assert.DirExists(t, filepath.Join(dir, "dir"))
+
+	// Rename
+	assert.Equal(t, http.StatusConflict, postForm(url.Values{"csrf": {csrf}, "action": {"rename"}, "name": {"old.txt"}, "new": {"new.txt"}}))
+	assert.Equal(t, http.StatusSeeOther, postForm(url.Values{"csrf": {csrf}, "action": {"rename"}, "name": {"old.txt"}, "new": {"renamed.txt"}}))
+	assert.NoFileExists(t, filepath.Join(dir, "old.txt"))
+	assert.FileExists(t, filepath.Join(dir, "renamed.txt"))
+
+	// Delete
+	assert.Equal(t, http.StatusNotFound, postForm(url.Values{"csrf": {csrf}, "action": {"delete"}, "name": {"potato"}}))
+	assert.Equal(t, http.StatusSeeOther, postForm(url.Values{"csrf": {csrf}, "action": {"delete"}, "name": {"renamed.txt"}}))
+	assert.Equal(t, http.StatusSeeOther, postForm(url.Values{"csrf": {csrf}, "action": {"delete"}, "name": {"dir"}}))
+	assert.NoFileExists(t, filepath.Join(dir, "renamed.txt"))
+	assert.NoDirExists(t, filepath.Join(dir, "dir"))
+}
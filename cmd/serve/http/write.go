@@ -0,0 +1,279 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	libhttp "github.com/rclone/rclone/lib/http"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/vfs"
+)
+
+const (
+	// maxFormSize is the maximum size of the non file form fields
+	maxFormSize = 64 * 1024
+)
+
+// newCSRFKey makes the secret key used for generating CSRF tokens
+func newCSRFKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make CSRF key: %w", err)
+	}
+	return key, nil
+}
+
+// csrfToken returns the CSRF token for the user making the request
+//
+// This is a keyed hash of the user name so it can't be forged by
+// another site and tokens can't be shared between users.
+func (s *HTTP) csrfToken(r *http.Request) string {
+	user, _ := libhttp.CtxGetUser(r.Context())
+	mac := hmac.New(sha256.New, s.csrfKey)
+	_, _ = mac.Write([]byte(user))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkCSRF checks the token sent with the form is valid for the user
+func (s *HTTP) checkCSRF(r *http.Request, token string) bool {
+	return hmac.Equal([]byte(token), []byte(s.csrfToken(r)))
+}
+
+// checkOrigin returns false if the request came from a page on a
+// different site.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+// checkLeaf checks that name is a valid name for an entry in a directory
+func checkLeaf(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	return nil
+}
+
+// writeError writes an HTTP error for a failed modification
+func writeError(ctx context.Context, w http.ResponseWriter, what string, text string, err error) {
+	switch {
+	case errors.Is(err, vfs.ENOENT):
+		http.Error(w, text+": not found", http.StatusNotFound)
+	case errors.Is(err, vfs.EEXIST):
+		http.Error(w, text+": already exists", http.StatusConflict)
+	case errors.Is(err, vfs.ENOTEMPTY):
+		http.Error(w, text+": directory not empty", http.StatusConflict)
+	case errors.Is(err, vfs.EPERM), errors.Is(err, vfs.EROFS):
+		http.Error(w, text+": permission denied", http.StatusForbidden)
+	default:
+		serve.Error(ctx, what, w, text, err)
+	}
+}
+
+// redirectToDir sends the browser back to the directory listing
+func redirectToDir(w http.ResponseWriter, r *http.Request) {
+	location := "./"
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// servePost handles the forms posted to the directory dirRemote
+//
+// Uploads are sent as multipart/form-data and streamed to the VFS,
+// everything else is sent as an ordinary form.
+func (s *HTTP) servePost(w http.ResponseWriter, r *http.Request, dirRemote string) {
+	ctx := r.Context()
+	if !checkOrigin(r) {
+		http.Error(w, "Cross origin request denied", http.StatusForbidden)
+		return
+	}
+	VFS, err := s.getVFS(ctx)
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to serve directory: %v", err)
+		return
+	}
+	node, err := VFS.Stat(dirRemote)
+	if err == vfs.ENOENT {
+		http.Error(w, "Directory not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		serve.Error(ctx, dirRemote, w, "Failed to find directory", err)
+		return
+	}
+	if !node.IsDir() {
+		http.Error(w, "Not a directory", http.StatusNotFound)
+		return
+	}
+	dir := node.(*vfs.Dir)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		s.upload(w, r, dir)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	if !s.checkCSRF(r, r.PostForm.Get("csrf")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	name := r.PostForm.Get("name")
+	if err := checkLeaf(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	what := path.Join(dirRemote, name)
+	switch action := r.PostForm.Get("action"); action {
+	case "mkdir":
+		fs.Infof(what, "%s: Creating directory", r.RemoteAddr)
+		if _, err := dir.Mkdir(name); err != nil {
+			writeError(ctx, w, what, "Failed to create directory", err)
+			return
+		}
+	case "rename":
+		newName := r.PostForm.Get("new")
+		if err := checkLeaf(newName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if newName == name {
+			break
+		}
+		if _, err := dir.Stat(newName); err == nil {
+			writeError(ctx, w, what, "Failed to rename", vfs.EEXIST)
+			return
+		}
+		fs.Infof(what, "%s: Renaming to %q", r.RemoteAddr, newName)
+		if err := dir.Rename(name, newName, dir); err != nil {
+			writeError(ctx, w, what, "Failed to rename", err)
+			return
+		}
+	case "delete":
+		node, err := dir.Stat(name)
+		if err != nil {
+			writeError(ctx, w, what, "Failed to delete", err)
+			return
+		}
+		fs.Infof(what, "%s: Deleting", r.RemoteAddr)
+		if err := node.RemoveAll(); err != nil {
+			writeError(ctx, w, what, "Failed to delete", err)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown action %q", action), http.StatusBadRequest)
+		return
+	}
+	redirectToDir(w, r)
+}
+
+// upload streams the files in a multipart/form-data request into dir
+//
+// The csrf field must come before any files.
+func (s *HTTP) upload(w http.ResponseWriter, r *http.Request, dir *vfs.Dir) {
+	ctx := r.Context()
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Failed to read form", http.StatusBadRequest)
+		return
+	}
+	var (
+		fields   = map[string]string{}
+		uploaded = 0
+	)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, "Failed to read form", http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormSize))
+			if err != nil {
+				http.Error(w, "Failed to read form", http.StatusBadRequest)
+				return
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+		if !s.checkCSRF(r, fields["csrf"]) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		if fields["action"] != "upload" || part.FormName() != "file" {
+			http.Error(w, "Unexpected file in form", http.StatusBadRequest)
+			return
+		}
+		// Some browsers send the full path of the file
+		leaf := path.Base(strings.ReplaceAll(part.FileName(), "\\", "/"))
+		if err := checkLeaf(leaf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		remote := path.Join(dir.Path(), leaf)
+		fs.Infof(remote, "%s: Uploading file", r.RemoteAddr)
+		err = uploadFile(dir.VFS(), remote, part)
+		if err != nil {
+			writeError(ctx, w, remote, "Failed to upload file", err)
+			return
+		}
+		uploaded++
+	}
+	if uploaded == 0 {
+		if !s.checkCSRF(r, fields["csrf"]) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "No files uploaded", http.StatusBadRequest)
+		return
+	}
+	redirectToDir(w, r)
+}
+
+// uploadFile writes the contents of in to remote in VFS
+func uploadFile(VFS *vfs.VFS, remote string, in io.Reader) (err error) {
+	fd, err := VFS.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fd, in)
+	closeErr := fd.Close()
+	if err != nil {
+		// Don't leave a partially uploaded file behind
+		if removeErr := VFS.Remove(remote); removeErr != nil {
+			fs.Debugf(remote, "Failed to remove partial upload: %v", removeErr)
+		}
+		return err
+	}
+	return closeErr
+}
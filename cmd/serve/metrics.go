@@ -0,0 +1,47 @@
+package serve
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics for the servers of all the protocols
+//
+// These are served with the other rclone metrics by the rc and
+// metrics servers.
+var (
+	serversRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "rclone",
+		Subsystem: "serve",
+		Name:      "servers_running",
+		Help:      "Number of servers running.",
+	}, []string{"protocol"})
+	serversStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rclone",
+		Subsystem: "serve",
+		Name:      "servers_started_total",
+		Help:      "Number of servers started.",
+	}, []string{"protocol"})
+	serversFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rclone",
+		Subsystem: "serve",
+		Name:      "servers_failed_total",
+		Help:      "Number of servers which stopped with an error.",
+	}, []string{"protocol"})
+)
+
+func init() {
+	prometheus.MustRegister(serversRunning, serversStarted, serversFailed)
+}
+
+// serveWithMetrics runs s recording it in the metrics for protocol
+func serveWithMetrics(protocol string, s Protocol) error {
+	serversStarted.WithLabelValues(protocol).Inc()
+	running := serversRunning.WithLabelValues(protocol)
+	running.Inc()
+	defer running.Dec()
+	err := s.Serve()
+	if err != nil {
+		serversFailed.WithLabelValues(protocol).Inc()
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package serve
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// failServer is a Protocol which fails as soon as it is served
+type failServer struct {
+	dummyServer
+}
+
+func (f *failServer) Serve() error {
+	return errors.New("failed")
+}
+
+func TestServeWithMetrics(t *testing.T) {
+	const protocol = "metrics-test"
+	running := serversRunning.WithLabelValues(protocol)
+	started := serversStarted.WithLabelValues(protocol)
+	failed := serversFailed.WithLabelValues(protocol)
+
+	d := &dummyServer{addr: "addr", shutdown: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		done <- serveWithMetrics(protocol, d)
+	}()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(running) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1.0, testutil.ToFloat64(started))
+
+	assert.NoError(t, d.Shutdown())
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0.0, testutil.ToFloat64(running))
+	assert.Equal(t, 0.0, testutil.ToFloat64(failed))
+
+	err := serveWithMetrics(protocol, &failServer{})
+	assert.Error(t, err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(running))
+	assert.Equal(t, 2.0, testutil.ToFloat64(started))
+	assert.Equal(t, 1.0, testutil.ToFloat64(failed))
+}
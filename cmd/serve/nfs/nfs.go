@@ -11,11 +11,15 @@ package nfs
 
 import (
 	"context"
+	"errors"
 	"strings"
 
 	"github.com/rclone/rclone/cmd"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
+	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
@@ -83,6 +87,22 @@ func AddFlags(flagSet *pflag.FlagSet) {
 func init() {
 	vfsflags.AddFlags(Command.Flags())
 	AddFlags(Command.Flags())
+	cmdserve.Command.AddCommand(Command)
+	cmdserve.Register("nfs", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (cmdserve.Protocol, error) {
+		if proxyOpt.AuthProxy != "" {
+			return nil, errors.New("auth proxy not supported as NFS has no authentication so there are no credentials to pass to the proxy")
+		}
+		opt := Opt
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		VFS, err := vfs.NewE(f, vfsOpt)
+		if err != nil {
+			return nil, err
+		}
+		return NewServer(ctx, VFS, &opt)
+	})
 }
 
 // Run the command
@@ -91,11 +111,15 @@ func Run(command *cobra.Command, args []string) {
 	cmd.CheckArgs(1, 1, command, args)
 	f = cmd.NewFsSrc(args)
 	cmd.Run(false, true, command, func() error {
-		s, err := NewServer(context.Background(), vfs.New(f, &vfscommon.Opt), &Opt)
+		VFS, err := vfs.NewE(f, &vfscommon.Opt)
 		if err != nil {
 			return err
 		}
-		return s.Serve()
+		s, err := NewServer(context.Background(), VFS, &Opt)
+		if err != nil {
+			return err
+		}
+		return cmdserve.Run("nfs", s)
 	})
 }
 
@@ -116,6 +140,10 @@ able to access the data. To limit access, you can use |serve nfs| on
 the loopback address or rely on secure tunnels (such as SSH) or use
 firewalling.
 
+As there are no credentials, this server can't be used with the auth
+proxy. Starting it with the |serve/start| rc call and |proxyOpt| set
+will return an error.
+
 For this reason, by default, a random TCP port is chosen and the
 loopback interface is used for the listening address by default;
 meaning that it is only available to the local machine. If you want
@@ -4,11 +4,13 @@ package nfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 
 	nfs "github.com/willscott/go-nfs"
 
+	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
@@ -23,6 +25,9 @@ type Server struct {
 	UnmountedExternally bool
 }
 
+// check interface
+var _ cmdserve.Protocol = (*Server)(nil)
+
 // NewServer creates a new server
 func NewServer(ctx context.Context, vfs *vfs.VFS, opt *Options) (s *Server, err error) {
 	if vfs.Opt.CacheMode == vfscommon.CacheModeOff {
@@ -49,8 +54,8 @@ func NewServer(ctx context.Context, vfs *vfs.VFS, opt *Options) (s *Server, err
 }
 
 // Addr returns the listening address of the server
-func (s *Server) Addr() net.Addr {
-	return s.listener.Addr()
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
 }
 
 // Shutdown stops the server
@@ -58,8 +63,12 @@ func (s *Server) Shutdown() error {
 	return s.listener.Close()
 }
 
-// Serve starts the server
+// Serve starts the server - it doesn't return until Shutdown is called
 func (s *Server) Serve() (err error) {
 	fs.Logf(nil, "NFS Server running at %s\n", s.listener.Addr())
-	return nfs.Serve(s.listener, s.handler)
+	err = nfs.Serve(s.listener, s.handler)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,93 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/rclone/rclone/lib/systemd"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// Protocol describes a running server for one of the serve protocols
+//
+// All the serve protocols implement this so they can be started and
+// stopped in the same way from the command line and the rc.
+type Protocol interface {
+	// Addr returns the address the server is listening on
+	Addr() string
+
+	// Serve runs the server - it doesn't return until Shutdown is
+	// called or the server fails.
+	Serve() error
+
+	// Shutdown stops the server accepting new connections and waits
+	// for the existing ones to drain before returning.
+	Shutdown() error
+}
+
+// NewFn makes a new server for a protocol serving f.
+//
+// vfsOpt and proxyOpt are the options shared by all protocols.
+// Protocol specific options should be read from the "opt" parameter
+// of in. If proxyOpt.AuthProxy is set then f will be nil.
+type NewFn func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (Protocol, error)
+
+var (
+	protocolsMu sync.Mutex
+	protocols   = map[string]NewFn{}
+)
+
+// Register registers a protocol so it can be started with the rc.
+//
+// It should be called from an init function in the protocol's package.
+func Register(name string, fn NewFn) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	if _, found := protocols[name]; found {
+		panic(fmt.Sprintf("serve: protocol %q registered twice", name))
+	}
+	protocols[name] = fn
+}
+
+// getProtocol returns the NewFn for the protocol called name or nil
+// if not found.
+func getProtocol(name string) NewFn {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	return protocols[name]
+}
+
+// Protocols returns the sorted names of the registered protocols
+func Protocols() []string {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run serves s, a server for protocol, from the command line.
+//
+// It notifies systemd that the server is ready, records the server in
+// the metrics and arranges for the server to be shut down gracefully
+// when rclone exits. It doesn't return until the server has been shut
+// down.
+func Run(protocol string, s Protocol) error {
+	handle := atexit.Register(func() {
+		if err := s.Shutdown(); err != nil {
+			fs.Errorf(nil, "serve: failed to shut down server on %s: %v", s.Addr(), err)
+		}
+	})
+	defer atexit.Unregister(handle)
+	defer systemd.Notify()()
+	return serveWithMetrics(protocol, s)
+}
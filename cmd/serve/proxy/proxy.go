@@ -108,7 +108,7 @@ backend that rclone supports.
 
 // Options is options for creating the proxy
 type Options struct {
-	AuthProxy string
+	AuthProxy string `config:"auth_proxy"`
 }
 
 // DefaultOpt is the default values uses for Opt
@@ -122,6 +122,7 @@ type Proxy struct {
 	vfsCache *libcache.Cache
 	ctx      context.Context // for global config
 	Opt      Options
+	vfsOpt   vfscommon.Options
 }
 
 // cacheEntry is what is stored in the vfsCache
@@ -131,10 +132,17 @@ type cacheEntry struct {
 }
 
 // New creates a new proxy with the Options passed in
-func New(ctx context.Context, opt *Options) *Proxy {
+//
+// Any VFS created by the proxy will use vfsOpt. If vfsOpt is nil then
+// the global VFS options will be used.
+func New(ctx context.Context, opt *Options, vfsOpt *vfscommon.Options) *Proxy {
+	if vfsOpt == nil {
+		vfsOpt = &vfscommon.Opt
+	}
 	return &Proxy{
 		ctx:      ctx,
 		Opt:      *opt,
+		vfsOpt:   *vfsOpt,
 		cmdLine:  strings.Fields(opt.AuthProxy),
 		vfsCache: libcache.New(),
 	}
@@ -241,8 +249,12 @@ func (p *Proxy) call(user, auth string, isPublicKey bool) (value interface{}, er
 		// We hash the auth here so we don't copy the auth more than we
 		// need to in memory. An attacker would find it easier to go
 		// after the unencrypted password in memory most likely.
+		VFS, err := vfs.NewE(f, &p.vfsOpt)
+		if err != nil {
+			return nil, false, err
+		}
 		entry := cacheEntry{
-			vfs:    vfs.New(f, &vfscommon.Opt),
+			vfs:    VFS,
 			pwHash: sha256.Sum256([]byte(auth)),
 		}
 		return entry, true, nil
@@ -22,7 +22,7 @@ func TestRun(t *testing.T) {
 	opt := DefaultOpt
 	cmd := "go run proxy_code.go"
 	opt.AuthProxy = cmd
-	p := New(context.Background(), &opt)
+	p := New(context.Background(), &opt, nil)
 
 	t.Run("Normal", func(t *testing.T) {
 		config, err := p.run(map[string]string{
@@ -0,0 +1,298 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// server is a Protocol started via the rc
+type server struct {
+	id       string
+	protocol string
+	fs       string
+	s        Protocol
+	done     chan struct{} // closed when Serve returns
+}
+
+var (
+	// mutex to protect all the variables in this block
+	serversMu sync.Mutex
+	// Map of id => running server
+	servers = map[string]*server{}
+)
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "serve/start",
+		AuthRequired: true,
+		Fn:           startRc,
+		Title:        "Create a new server",
+		Help: `Create a new server with the specified parameters.
+
+This takes the following parameters:
+
+- type - type of server: see serve/types for the list
+- fs - remote path to serve - not needed if using the auth proxy
+- opt - a JSON object with the options for the server type.
+- vfsOpt - a JSON object with VFS options in.
+- proxyOpt - a JSON object with the auth proxy options in.
+
+The auth proxy is supported by every server type which authenticates
+its users. The dlna and nfs servers have no authentication so there
+are no credentials to pass to the proxy and they will return an error
+if proxyOpt.AuthProxy is set.
+
+Example:
+
+    rclone rc serve/start type=webdav fs=remote: opt='{"HTTP": {"ListenAddr": [":8081"]}}'
+    rclone rc serve/start type=sftp fs=remote: opt='{"ListenAddr": ":2022", "NoAuth": true}' vfsOpt='{"CacheMode": 2}'
+
+The vfsOpt are as described in options/get and can be seen in the
+"vfs" section when running:
+
+    rclone rc options/get
+
+This returns
+
+- id - an ID for the server which can be passed to serve/stop
+- addr - the address the server is listening on
+
+The number of servers of each type running, started and failed are
+recorded in the rclone_serve_servers_running,
+rclone_serve_servers_started_total and
+rclone_serve_servers_failed_total metrics served with --metrics-addr
+or --rc-enable-metrics.
+`,
+	})
+}
+
+// startRc allows the serve command to be run from rc
+func startRc(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	protocol, err := in.GetString("type")
+	if err != nil {
+		return nil, err
+	}
+	newFn := getProtocol(protocol)
+	if newFn == nil {
+		return nil, fmt.Errorf("serve type %q not found - see serve/types", protocol)
+	}
+
+	vfsOpt := vfscommon.Opt
+	err = in.GetStructMissingOK("vfsOpt", &vfsOpt)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyOpt := proxy.DefaultOpt
+	err = in.GetStructMissingOK("proxyOpt", &proxyOpt)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		f        fs.Fs
+		fsString string
+	)
+	if proxyOpt.AuthProxy == "" {
+		f, err = rc.GetFs(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		fsString = fs.ConfigString(f)
+	}
+
+	// Servers outlive the rc call so don't use its context
+	s, err := newFn(context.Background(), f, &vfsOpt, &proxyOpt, in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s server: %w", protocol, err)
+	}
+
+	srv := &server{
+		id:       protocol + "-" + random.String(8),
+		protocol: protocol,
+		fs:       fsString,
+		s:        s,
+		done:     make(chan struct{}),
+	}
+	serversMu.Lock()
+	servers[srv.id] = srv
+	serversMu.Unlock()
+
+	go func() {
+		err := serveWithMetrics(protocol, s)
+		if err != nil {
+			fs.Errorf(nil, "serve %s: server on %s failed: %v", protocol, s.Addr(), err)
+		}
+		serversMu.Lock()
+		delete(servers, srv.id)
+		serversMu.Unlock()
+		close(srv.done)
+	}()
+
+	fs.Debugf(nil, "Started %s server %s on %s", protocol, srv.id, s.Addr())
+	return rc.Params{
+		"id":   srv.id,
+		"addr": s.Addr(),
+	}, nil
+}
+
+// stop shuts the server down and waits for Serve to return
+func (srv *server) stop() error {
+	err := srv.s.Shutdown()
+	<-srv.done
+	return err
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "serve/stop",
+		AuthRequired: true,
+		Fn:           stopRc,
+		Title:        "Unserve selected active serve",
+		Help: `Stops a running serve instance by ID.
+
+This takes the following parameters:
+
+- id: as returned by serve/start
+
+This will give an error if the server can't be found.
+
+Example:
+
+    rclone rc serve/stop id=sftp-abcdefgh
+`,
+	})
+}
+
+// stopRc stops the server with the id passed in
+func stopRc(_ context.Context, in rc.Params) (out rc.Params, err error) {
+	id, err := in.GetString("id")
+	if err != nil {
+		return nil, err
+	}
+	serversMu.Lock()
+	srv, found := servers[id]
+	serversMu.Unlock()
+	if !found {
+		return nil, fmt.Errorf("server with id=%q not found", id)
+	}
+	return nil, srv.stop()
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "serve/stopall",
+		AuthRequired: true,
+		Fn:           stopAllRc,
+		Title:        "Stop all active servers",
+		Help: `Stop all active servers.
+
+This will stop all active servers.
+
+    rclone rc serve/stopall
+`,
+	})
+}
+
+// stopAllRc stops all the running servers
+func stopAllRc(_ context.Context, in rc.Params) (out rc.Params, err error) {
+	serversMu.Lock()
+	var srvs []*server
+	for _, srv := range servers {
+		srvs = append(srvs, srv)
+	}
+	serversMu.Unlock()
+	var errs []error
+	for _, srv := range srvs {
+		if err := srv.stop(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", srv.id, err))
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "serve/list",
+		AuthRequired: true,
+		Fn:           listRc,
+		Title:        "Show running servers",
+		Help: `Show running servers with IDs.
+
+This takes no parameters and returns
+
+- list: list of running serve commands
+
+Each list element will have
+
+- id: ID of the server
+- addr: address the server is running on
+- type: type of the server
+- fs: the remote being served - blank if using the auth proxy
+
+Example:
+
+    rclone rc serve/list
+`,
+	})
+}
+
+// listRc returns a list of the running servers
+func listRc(_ context.Context, in rc.Params) (out rc.Params, err error) {
+	serversMu.Lock()
+	list := make([]rc.Params, 0, len(servers))
+	for _, srv := range servers {
+		list = append(list, rc.Params{
+			"id":   srv.id,
+			"addr": srv.s.Addr(),
+			"type": srv.protocol,
+			"fs":   srv.fs,
+		})
+	}
+	serversMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Compare(list[i]["id"].(string), list[j]["id"].(string)) < 0
+	})
+	return rc.Params{
+		"list": list,
+	}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "serve/types",
+		AuthRequired: true,
+		Fn:           typesRc,
+		Title:        "Show all possible serve types",
+		Help: `This shows all possible serve types and returns them as a list.
+
+This takes no parameters and returns
+
+- types: list of serve types, e.g. "nfs", "sftp", etc
+
+The serve types are strings like "http", "sftp", "webdav" and can
+be passed to serve/start as the type parameter.
+
+Example:
+
+    rclone rc serve/types
+`,
+	})
+}
+
+// typesRc returns a list of the available protocols
+func typesRc(_ context.Context, in rc.Params) (out rc.Params, err error) {
+	return rc.Params{
+		"types": Protocols(),
+	}, nil
+}
@@ -0,0 +1,138 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dummyServer is a Protocol which does nothing
+type dummyServer struct {
+	addr     string
+	mu       sync.Mutex
+	shutdown chan struct{}
+	vfsOpt   vfscommon.Options
+}
+
+func (d *dummyServer) Addr() string {
+	return d.addr
+}
+
+func (d *dummyServer) Serve() error {
+	<-d.shutdown
+	return nil
+}
+
+func (d *dummyServer) Shutdown() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.shutdown:
+		return errors.New("already shut down")
+	default:
+	}
+	close(d.shutdown)
+	return nil
+}
+
+func init() {
+	Register("dummy", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (Protocol, error) {
+		var opt struct {
+			Addr string
+		}
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		if opt.Addr == "" {
+			return nil, errors.New("need Addr")
+		}
+		return &dummyServer{
+			addr:     opt.Addr,
+			shutdown: make(chan struct{}),
+			vfsOpt:   *vfsOpt,
+		}, nil
+	})
+}
+
+func TestRegisterTwice(t *testing.T) {
+	assert.Panics(t, func() {
+		Register("dummy", nil)
+	})
+}
+
+func TestRc(t *testing.T) {
+	ctx := context.Background()
+	call := func(path string, in rc.Params) (rc.Params, error) {
+		c := rc.Calls.Get(path)
+		require.NotNil(t, c, path)
+		return c.Fn(ctx, in)
+	}
+
+	out, err := call("serve/types", nil)
+	require.NoError(t, err)
+	assert.Contains(t, out["types"], "dummy")
+
+	// Errors
+	_, err = call("serve/start", rc.Params{"type": "potato", "fs": t.TempDir()})
+	assert.ErrorContains(t, err, "not found")
+	_, err = call("serve/start", rc.Params{"type": "dummy", "fs": t.TempDir()})
+	assert.ErrorContains(t, err, "need Addr")
+	_, err = call("serve/stop", rc.Params{"id": "potato"})
+	assert.ErrorContains(t, err, "not found")
+
+	// Start two servers
+	out, err = call("serve/start", rc.Params{
+		"type":   "dummy",
+		"fs":     t.TempDir(),
+		"opt":    rc.Params{"Addr": "addr1"},
+		"vfsOpt": rc.Params{"ReadOnly": true},
+	})
+	require.NoError(t, err)
+	id1 := out["id"].(string)
+	assert.Equal(t, "addr1", out["addr"])
+
+	out, err = call("serve/start", rc.Params{
+		"type": "dummy",
+		"fs":   t.TempDir(),
+		"opt":  rc.Params{"Addr": "addr2"},
+	})
+	require.NoError(t, err)
+	id2 := out["id"].(string)
+	assert.NotEqual(t, id1, id2)
+
+	serversMu.Lock()
+	assert.True(t, servers[id1].s.(*dummyServer).vfsOpt.ReadOnly)
+	assert.False(t, servers[id2].s.(*dummyServer).vfsOpt.ReadOnly)
+	serversMu.Unlock()
+
+	out, err = call("serve/list", nil)
+	require.NoError(t, err)
+	list := out["list"].([]rc.Params)
+	require.Len(t, list, 2)
+	assert.Equal(t, "dummy", list[0]["type"])
+
+	// Stop one then the rest
+	_, err = call("serve/stop", rc.Params{"id": id1})
+	require.NoError(t, err)
+	out, err = call("serve/list", nil)
+	require.NoError(t, err)
+	list = out["list"].([]rc.Params)
+	require.Len(t, list, 1)
+	assert.Equal(t, id2, list[0]["id"])
+
+	_, err = call("serve/stopall", nil)
+	require.NoError(t, err)
+	out, err = call("serve/list", nil)
+	require.NoError(t, err)
+	assert.Len(t, out["list"], 0)
+}
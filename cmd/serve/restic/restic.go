@@ -16,15 +16,20 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rclone/rclone/cmd"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/config/flags"
 	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fs/walk"
 	libhttp "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/http/serve"
-	"github.com/rclone/rclone/lib/systemd"
 	"github.com/rclone/rclone/lib/terminal"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/spf13/cobra"
 	"golang.org/x/net/http2"
 )
@@ -60,6 +65,19 @@ func init() {
 	flags.BoolVarP(flagSet, &Opt.AppendOnly, "append-only", "", false, "Disallow deletion of repository data", "")
 	flags.BoolVarP(flagSet, &Opt.PrivateRepos, "private-repos", "", false, "Users can only access their private repo", "")
 	flags.BoolVarP(flagSet, &Opt.CacheObjects, "cache-objects", "", true, "Cache listed objects", "")
+	proxyflags.AddFlags(flagSet)
+	cmdserve.Command.AddCommand(Command)
+	cmdserve.Register("restic", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (cmdserve.Protocol, error) {
+		opt := Opt
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		if opt.Stdio {
+			return nil, errors.New("stdio not supported over the API")
+		}
+		return newServer(ctx, f, &opt, vfsOpt, proxyOpt)
+	})
 }
 
 // Command definition for cobra
@@ -148,16 +166,24 @@ these **must** end with /.  Eg
 The` + "`--private-repos`" + ` flag can be used to limit users to repositories starting
 with a path of ` + "`/<username>/`" + `.
 
-` + libhttp.Help(flagPrefix) + libhttp.AuthHelp(flagPrefix),
+When ` + "`--auth-proxy`" + ` is used each user is served the remote
+returned by the proxy, and ` + "`--cache-objects`" + ` is ignored.
+
+` + libhttp.Help(flagPrefix) + libhttp.AuthHelp(flagPrefix) + proxy.Help,
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.40",
 	},
 	Run: func(command *cobra.Command, args []string) {
 		ctx := context.Background()
-		cmd.CheckArgs(1, 1, command, args)
-		f := cmd.NewFsSrc(args)
+		var f fs.Fs
+		if proxyflags.Opt.AuthProxy == "" {
+			cmd.CheckArgs(1, 1, command, args)
+			f = cmd.NewFsSrc(args)
+		} else {
+			cmd.CheckArgs(0, 0, command, args)
+		}
 		cmd.Run(false, true, command, func() error {
-			s, err := newServer(ctx, f, &Opt)
+			s, err := newServer(ctx, f, &Opt, &vfscommon.Opt, &proxyflags.Opt)
 			if err != nil {
 				return err
 			}
@@ -178,12 +204,7 @@ with a path of ` + "`/<username>/`" + `.
 				httpSrv.ServeConn(conn, opts)
 				return nil
 			}
-			fs.Logf(s.f, "Serving restic REST API on %s", s.URLs())
-
-			defer systemd.Notify()()
-			s.Wait()
-
-			return nil
+			return cmdserve.Run("restic", s)
 		})
 	},
 }
@@ -240,34 +261,89 @@ func checkPrivate(next http.Handler) http.Handler {
 // server contains everything to run the server
 type server struct {
 	*libhttp.Server
-	f     fs.Fs
+	f     fs.Fs // don't use directly, use getFs
 	cache *cache
 	opt   Options
+	proxy *proxy.Proxy
 }
 
-func newServer(ctx context.Context, f fs.Fs, opt *Options) (s *server, err error) {
+// check interface
+var _ cmdserve.Protocol = (*server)(nil)
+
+func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (s *server, err error) {
 	s = &server{
 		f:     f,
 		cache: newCache(opt.CacheObjects),
 		opt:   *opt,
 	}
+	if proxyOpt.AuthProxy != "" {
+		if opt.Stdio {
+			return nil, errors.New("can't use --auth-proxy with --stdio as there is no authentication")
+		}
+		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
+		// override auth
+		s.opt.Auth.CustomAuthFn = s.auth
+		// the cache is shared between the users so don't use it
+		s.cache = newCache(false)
+	}
 	// Don't bind any HTTP listeners if running with --stdio
 	if opt.Stdio {
-		opt.HTTP.ListenAddr = nil
+		s.opt.HTTP.ListenAddr = nil
 	}
 	s.Server, err = libhttp.NewServer(ctx,
-		libhttp.WithConfig(opt.HTTP),
-		libhttp.WithAuth(opt.Auth),
+		libhttp.WithConfig(s.opt.HTTP),
+		libhttp.WithAuth(s.opt.Auth),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init server: %w", err)
 	}
 	router := s.Router()
 	s.Bind(router)
-	s.Server.Serve()
 	return s, nil
 }
 
+// auth does proxy authorization
+func (s *server) auth(user, pass string) (value interface{}, err error) {
+	VFS, _, err := s.proxy.Call(user, pass, false)
+	if err != nil {
+		return nil, err
+	}
+	return VFS, err
+}
+
+// getFs returns the Fs to use for this request
+func (s *server) getFs(ctx context.Context) (fs.Fs, error) {
+	if s.proxy == nil {
+		return s.f, nil
+	}
+	value := libhttp.CtxGetAuth(ctx)
+	if value == nil {
+		return nil, errors.New("no VFS found in context")
+	}
+	VFS, ok := value.(*vfs.VFS)
+	if !ok {
+		return nil, fmt.Errorf("context value is not VFS: %#v", value)
+	}
+	return VFS.Fs(), nil
+}
+
+// Serve runs the server - it doesn't return until Shutdown is called
+func (s *server) Serve() error {
+	s.Server.Serve()
+	fs.Logf(s.f, "Serving restic REST API on %s", s.URLs())
+	s.Wait()
+	return nil
+}
+
+// Addr returns the first address the server is listening on
+func (s *server) Addr() string {
+	urls := s.URLs()
+	if len(urls) == 0 {
+		return ""
+	}
+	return urls[0]
+}
+
 // bind helper for main Bind method
 func (s *server) bind(router chi.Router) {
 	router.MethodFunc("GET", "/*", func(w http.ResponseWriter, r *http.Request) {
@@ -324,7 +400,11 @@ func (s *server) newObject(ctx context.Context, remote string) (fs.Object, error
 	if o != nil {
 		return o, nil
 	}
-	o, err := s.f.NewObject(ctx, remote)
+	f, err := s.getFs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o, err = f.NewObject(ctx, remote)
 	if err != nil {
 		return o, err
 	}
@@ -370,7 +450,13 @@ func (s *server) postObject(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	o, err := operations.RcatSize(r.Context(), s.f, remote, r.Body, r.ContentLength, time.Now(), nil)
+	f, err := s.getFs(r.Context())
+	if err != nil {
+		fs.Errorf(remote, "Post request error: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	o, err := operations.RcatSize(r.Context(), f, remote, r.Body, r.ContentLength, time.Now(), nil)
 	if err != nil {
 		err = accounting.Stats(r.Context()).Error(err)
 		fs.Errorf(remote, "Post request rcat error: %v", err)
@@ -458,8 +544,15 @@ func (s *server) listObjects(w http.ResponseWriter, r *http.Request) {
 	// Remove all existing values from the cache
 	s.cache.removePrefix(remote)
 
+	f, err := s.getFs(r.Context())
+	if err != nil {
+		fs.Errorf(remote, "list failed: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
 	// if remote supports ListR use that directly, otherwise use recursive Walk
-	err := walk.ListR(r.Context(), s.f, remote, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+	err = walk.ListR(r.Context(), f, remote, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
 		for _, entry := range entries {
 			if o, ok := entry.(fs.Object); ok {
 				ls.add(o)
@@ -502,7 +595,13 @@ func (s *server) createRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := s.f.Mkdir(r.Context(), remote)
+	f, err := s.getFs(r.Context())
+	if err != nil {
+		fs.Errorf(remote, "Create repo failed: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	err = f.Mkdir(r.Context(), remote)
 	if err != nil {
 		fs.Errorf(remote, "Create repo failed to Mkdir: %v", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -511,7 +610,7 @@ func (s *server) createRepo(w http.ResponseWriter, r *http.Request) {
 
 	for _, name := range []string{"data", "index", "keys", "locks", "snapshots"} {
 		dirRemote := path.Join(remote, name)
-		err := s.f.Mkdir(r.Context(), dirRemote)
+		err := f.Mkdir(r.Context(), dirRemote)
 		if err != nil {
 			fs.Errorf(dirRemote, "Create repo failed to Mkdir: %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -9,6 +9,8 @@ import (
 	"testing"
 
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/require"
 )
 
@@ -39,7 +41,7 @@ func TestResticPrivateRepositories(t *testing.T) {
 
 	// make a new file system in the temp dir
 	f := cmd.NewFsSrc([]string{tempdir})
-	s, err := newServer(ctx, f, &opt)
+	s, err := newServer(ctx, f, &opt, &vfscommon.Opt, &proxyflags.Opt)
 	require.NoError(t, err)
 	router := s.Server.Router()
 
@@ -0,0 +1,58 @@
+package restic
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestResticProxy runs tests on the restic handler code with an auth proxy
+func TestResticProxy(t *testing.T) {
+	ctx := context.Background()
+
+	// the backend config will be made by the proxy
+	tempdir := t.TempDir()
+	prog, err := filepath.Abs("../servetest/proxy_code.go")
+	require.NoError(t, err)
+	proxyOpt := proxy.DefaultOpt
+	proxyOpt.AuthProxy = "go run " + prog + " " + tempdir
+
+	opt := newOpt()
+
+	// the auth proxy needs credentials so can't be used with --stdio
+	opt.Stdio = true
+	_, err = newServer(ctx, nil, &opt, &vfscommon.Opt, &proxyOpt)
+	assert.ErrorContains(t, err, "--stdio")
+	opt.Stdio = false
+
+	s, err := newServer(ctx, nil, &opt, &vfscommon.Opt, &proxyOpt)
+	require.NoError(t, err)
+	router := s.Server.Router()
+
+	// Requests with credentials should be served from the proxied remote
+	reqs := []*http.Request{
+		newAuthenticatedRequest(t, "POST", "/repo/?create=true", nil, "user", "pass"),
+		newAuthenticatedRequest(t, "POST", "/repo/config", strings.NewReader("foobar test config"), "user", "pass"),
+	}
+	for _, req := range reqs {
+		checkRequest(t, router.ServeHTTP, req, []wantFunc{wantCode(http.StatusOK)})
+	}
+	req := newAuthenticatedRequest(t, "GET", "/repo/config", nil, "user", "pass")
+	checkRequest(t, router.ServeHTTP, req, []wantFunc{wantCode(http.StatusOK), wantBody("foobar test config")})
+
+	data, err := os.ReadFile(filepath.Join(tempdir, "repo", "config"))
+	require.NoError(t, err)
+	assert.Equal(t, "foobar test config", string(data))
+
+	// Requests without credentials should be unauthorised
+	req = newRequest(t, "GET", "/repo/config", nil)
+	checkRequest(t, router.ServeHTTP, req, []wantFunc{wantCode(http.StatusUnauthorized)})
+}
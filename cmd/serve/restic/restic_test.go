@@ -14,8 +14,10 @@ import (
 
 	_ "github.com/rclone/rclone/backend/all"
 	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -54,8 +56,9 @@ func TestResticIntegration(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Start the server
-	s, err := newServer(ctx, fremote, &opt)
+	s, err := newServer(ctx, fremote, &opt, &vfscommon.Opt, &proxyflags.Opt)
 	require.NoError(t, err)
+	s.Server.Serve()
 	testURL := s.Server.URLs()[0]
 	defer func() {
 		_ = s.Shutdown()
@@ -134,7 +137,7 @@ func TestListErrors(t *testing.T) {
 
 	// make a new file system in the temp dir
 	f := &listErrorFs{Fs: cmd.NewFsSrc([]string{tempdir})}
-	s, err := newServer(ctx, f, &opt)
+	s, err := newServer(ctx, f, &opt, &vfscommon.Opt, &proxyflags.Opt)
 	require.NoError(t, err)
 	router := s.Server.Router()
 
@@ -159,7 +162,7 @@ func TestServeErrors(t *testing.T) {
 
 	// make a new file system in the temp dir
 	f := &newObjectErrorFs{Fs: cmd.NewFsSrc([]string{tempdir})}
-	s, err := newServer(ctx, f, &opt)
+	s, err := newServer(ctx, f, &opt, &vfscommon.Opt, &proxyflags.Opt)
 	require.NoError(t, err)
 	router := s.Server.Router()
 
@@ -135,7 +135,7 @@ func (b *s3Backend) HeadObject(ctx context.Context, bucketName, objectName strin
 
 	fobj := entry.(fs.Object)
 	size := node.Size()
-	hash := getFileHashByte(fobj)
+	hash := getFileHashByte(fobj, b.opt.HashType)
 
 	meta := map[string]string{
 		"Last-Modified": formatHeaderTime(node.ModTime()),
@@ -188,7 +188,7 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 	file := node.(*vfs.File)
 
 	size := node.Size()
-	hash := getFileHashByte(fobj)
+	hash := getFileHashByte(fobj, b.opt.HashType)
 
 	in, err := file.Open(os.O_RDONLY)
 	if err != nil {
@@ -6,22 +6,26 @@ import (
 	"strings"
 
 	"github.com/rclone/rclone/cmd"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
+	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/flags"
 	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/rc"
 	httplib "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
 	"github.com/spf13/cobra"
 )
 
 // DefaultOpt is the default values used for Options
 var DefaultOpt = Options{
-	pathBucketMode: true,
-	hashName:       "MD5",
-	hashType:       hash.MD5,
-	noCleanup:      false,
+	PathBucketMode: true,
+	HashName:       "MD5",
+	HashType:       hash.MD5,
+	NoCleanup:      false,
 	Auth:           httplib.DefaultAuthCfg(),
 	HTTP:           httplib.DefaultCfg(),
 }
@@ -37,10 +41,19 @@ func init() {
 	httplib.AddHTTPFlagsPrefix(flagSet, flagPrefix, &Opt.HTTP)
 	vfsflags.AddFlags(flagSet)
 	proxyflags.AddFlags(flagSet)
-	flags.BoolVarP(flagSet, &Opt.pathBucketMode, "force-path-style", "", Opt.pathBucketMode, "If true use path style access if false use virtual hosted style (default true)", "")
-	flags.StringVarP(flagSet, &Opt.hashName, "etag-hash", "", Opt.hashName, "Which hash to use for the ETag, or auto or blank for off", "")
-	flags.StringArrayVarP(flagSet, &Opt.authPair, "auth-key", "", Opt.authPair, "Set key pair for v4 authorization: access_key_id,secret_access_key", "")
-	flags.BoolVarP(flagSet, &Opt.noCleanup, "no-cleanup", "", Opt.noCleanup, "Not to cleanup empty folder after object is deleted", "")
+	flags.BoolVarP(flagSet, &Opt.PathBucketMode, "force-path-style", "", Opt.PathBucketMode, "If true use path style access if false use virtual hosted style (default true)", "")
+	flags.StringVarP(flagSet, &Opt.HashName, "etag-hash", "", Opt.HashName, "Which hash to use for the ETag, or auto or blank for off", "")
+	flags.StringArrayVarP(flagSet, &Opt.AuthPair, "auth-key", "", Opt.AuthPair, "Set key pair for v4 authorization: access_key_id,secret_access_key", "")
+	flags.BoolVarP(flagSet, &Opt.NoCleanup, "no-cleanup", "", Opt.NoCleanup, "Not to cleanup empty folder after object is deleted", "")
+	cmdserve.Command.AddCommand(Command)
+	cmdserve.Register("s3", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (cmdserve.Protocol, error) {
+		opt := Opt
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		return newServer(ctx, f, &opt, vfsOpt, proxyOpt)
+	})
 }
 
 //go:embed serve_s3.md
@@ -70,27 +83,12 @@ var Command = &cobra.Command{
 			cmd.CheckArgs(0, 0, command, args)
 		}
 
-		if Opt.hashName == "auto" {
-			Opt.hashType = f.Hashes().GetOne()
-		} else if Opt.hashName != "" {
-			err := Opt.hashType.Set(Opt.hashName)
-			if err != nil {
-				return err
-			}
-		}
 		cmd.Run(false, false, command, func() error {
-			s, err := newServer(context.Background(), f, &Opt)
-			if err != nil {
-				return err
-			}
-			router := s.server.Router()
-			s.Bind(router)
-			err = s.Serve()
+			s, err := newServer(context.Background(), f, &Opt, &vfscommon.Opt, &proxyflags.Opt)
 			if err != nil {
 				return err
 			}
-			s.server.Wait()
-			return nil
+			return cmdserve.Run("s3", s)
 		})
 		return nil
 	},
@@ -27,6 +27,7 @@ import (
 	"github.com/rclone/rclone/fstest"
 	httplib "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -39,20 +40,19 @@ const (
 func serveS3(f fs.Fs) (testURL string, keyid string, keysec string, w *Server) {
 	keyid = random.String(16)
 	keysec = random.String(16)
+	// The ETag hash used to be read from the global Opt which is
+	// MD5, so set it here as the tests need ETags.
 	serveropt := &Options{
 		HTTP:           httplib.DefaultCfg(),
-		pathBucketMode: true,
-		hashName:       "",
-		hashType:       hash.None,
-		authPair:       []string{fmt.Sprintf("%s,%s", keyid, keysec)},
+		PathBucketMode: true,
+		HashName:       "MD5",
+		HashType:       hash.MD5,
+		AuthPair:       []string{fmt.Sprintf("%s,%s", keyid, keysec)},
 	}
 
 	serveropt.HTTP.ListenAddr = []string{endpoint}
-	w, _ = newServer(context.Background(), f, serveropt)
-	router := w.server.Router()
-
-	w.Bind(router)
-	_ = w.Serve()
+	w, _ = newServer(context.Background(), f, serveropt, &vfscommon.Opt, &proxyflags.Opt)
+	_ = w.serve()
 	testURL = w.server.URLs()[0]
 
 	return
@@ -273,6 +273,21 @@ func TestListBuckets(t *testing.T) {
 	testListBuckets(t, cases, false)
 }
 
+func TestJWKSExclusive(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	opt := &Options{
+		HTTP:     httplib.DefaultCfg(),
+		Auth:     httplib.DefaultAuthCfg(),
+		AuthPair: []string{"key,secret"},
+	}
+	opt.HTTP.ListenAddr = []string{endpoint}
+	opt.Auth.JWKS = filepath.Join(t.TempDir(), "jwks.json")
+	_, err = newServer(ctx, f, opt, &vfscommon.Opt, &proxyflags.Opt)
+	assert.ErrorContains(t, err, "can't use --jwks with --auth-key")
+}
+
 func TestListBucketsAuthProxy(t *testing.T) {
 	var cases = []TestCase{
 		{
@@ -14,8 +14,8 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/rclone/gofakes3"
 	"github.com/rclone/gofakes3/signature"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/proxy"
-	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/hash"
 	httplib "github.com/rclone/rclone/lib/http"
@@ -32,11 +32,11 @@ const (
 // Options contains options for the http Server
 type Options struct {
 	//TODO add more options
-	pathBucketMode bool
-	hashName       string
-	hashType       hash.Type
-	authPair       []string
-	noCleanup      bool
+	PathBucketMode bool
+	HashName       string
+	HashType       hash.Type
+	AuthPair       []string
+	NoCleanup      bool
 	Auth           httplib.AuthConfig
 	HTTP           httplib.Config
 }
@@ -53,43 +53,69 @@ type Server struct {
 	s3Secret string
 }
 
+// check interface
+var _ cmdserve.Protocol = (*Server)(nil)
+
 // Make a new S3 Server to serve the remote
-func newServer(ctx context.Context, f fs.Fs, opt *Options) (s *Server, err error) {
+func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (s *Server, err error) {
 	w := &Server{
 		f:   f,
 		ctx: ctx,
 	}
 
-	if len(opt.authPair) == 0 {
+	if opt.HashName == "auto" {
+		if f == nil {
+			return nil, errors.New("can't use --etag-hash auto with --auth-proxy")
+		}
+		opt.HashType = f.Hashes().GetOne()
+	} else if opt.HashName != "" {
+		err := opt.HashType.Set(opt.HashName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// S3 clients sign requests with their keys rather than sending a
+	// bearer token so these can't be mixed
+	if opt.Auth.JWKS != "" && (len(opt.AuthPair) > 0 || proxyOpt.AuthProxy != "") {
+		return nil, errors.New("can't use --jwks with --auth-key or --auth-proxy as requests are authenticated with either a bearer token or an S3 signature")
+	}
+
+	if opt.Auth.JWKS != "" {
+		fs.Logf("serve s3", "Using bearer token authentication so S3 signatures aren't checked")
+	} else if len(opt.AuthPair) == 0 {
 		fs.Logf("serve s3", "No auth provided so allowing anonymous access")
 	} else {
-		w.s3Secret = getAuthSecret(opt.authPair)
+		w.s3Secret = getAuthSecret(opt.AuthPair)
 	}
 
 	var newLogger logger
 	w.faker = gofakes3.New(
 		newBackend(w, opt),
-		gofakes3.WithHostBucket(!opt.pathBucketMode),
+		gofakes3.WithHostBucket(!opt.PathBucketMode),
 		gofakes3.WithLogger(newLogger),
 		gofakes3.WithRequestID(rand.Uint64()),
 		gofakes3.WithoutVersioning(),
-		gofakes3.WithV4Auth(authlistResolver(opt.authPair)),
+		gofakes3.WithV4Auth(authlistResolver(opt.AuthPair)),
 		gofakes3.WithIntegrityCheck(true), // Check Content-MD5 if supplied
 	)
 
 	w.handler = http.NewServeMux()
 	w.handler = w.faker.Server()
 
-	if proxyflags.Opt.AuthProxy != "" {
-		w.proxy = proxy.New(ctx, &proxyflags.Opt)
+	if proxyOpt.AuthProxy != "" {
+		w.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		// proxy auth middleware
 		w.handler = proxyAuthMiddleware(w.handler, w)
 		w.handler = authPairMiddleware(w.handler, w)
 	} else {
-		w._vfs = vfs.New(f, &vfscommon.Opt)
+		w._vfs, err = vfs.NewE(f, vfsOpt)
+		if err != nil {
+			return nil, err
+		}
 
-		if len(opt.authPair) > 0 {
-			w.faker.AddAuthKeys(authlistResolver(opt.authPair))
+		if len(opt.AuthPair) > 0 {
+			w.faker.AddAuthKeys(authlistResolver(opt.AuthPair))
 		}
 	}
 
@@ -100,6 +126,7 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options) (s *Server, err error
 	if err != nil {
 		return nil, fmt.Errorf("failed to init server: %w", err)
 	}
+	w.Bind(w.server.Router())
 
 	return w, nil
 }
@@ -135,13 +162,37 @@ func (w *Server) Bind(router chi.Router) {
 	router.Handle("/*", w.handler)
 }
 
-// Serve serves the s3 server
-func (w *Server) Serve() error {
+// serve starts the s3 server in the background
+func (w *Server) serve() error {
 	w.server.Serve()
 	fs.Logf(w.f, "Starting s3 server on %s", w.server.URLs())
 	return nil
 }
 
+// Serve serves the s3 server - it doesn't return until Shutdown is called
+func (w *Server) Serve() error {
+	err := w.serve()
+	if err != nil {
+		return err
+	}
+	w.server.Wait()
+	return nil
+}
+
+// Addr returns the first address the server is listening on
+func (w *Server) Addr() string {
+	urls := w.server.URLs()
+	if len(urls) == 0 {
+		return ""
+	}
+	return urls[0]
+}
+
+// Shutdown the server, waiting for in flight requests to finish
+func (w *Server) Shutdown() error {
+	return w.server.Shutdown()
+}
+
 func authPairMiddleware(next http.Handler, ws *Server) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		accessKey, _ := parseAccessKeyID(r)
@@ -5,44 +5,10 @@ import (
 	"errors"
 
 	"github.com/rclone/rclone/cmd"
-	"github.com/rclone/rclone/cmd/serve/dlna"
-	"github.com/rclone/rclone/cmd/serve/docker"
-	"github.com/rclone/rclone/cmd/serve/ftp"
-	"github.com/rclone/rclone/cmd/serve/http"
-	"github.com/rclone/rclone/cmd/serve/nfs"
-	"github.com/rclone/rclone/cmd/serve/restic"
-	"github.com/rclone/rclone/cmd/serve/s3"
-	"github.com/rclone/rclone/cmd/serve/sftp"
-	"github.com/rclone/rclone/cmd/serve/webdav"
 	"github.com/spf13/cobra"
 )
 
 func init() {
-	Command.AddCommand(http.Command)
-	if webdav.Command != nil {
-		Command.AddCommand(webdav.Command)
-	}
-	if restic.Command != nil {
-		Command.AddCommand(restic.Command)
-	}
-	if dlna.Command != nil {
-		Command.AddCommand(dlna.Command)
-	}
-	if ftp.Command != nil {
-		Command.AddCommand(ftp.Command)
-	}
-	if sftp.Command != nil {
-		Command.AddCommand(sftp.Command)
-	}
-	if docker.Command != nil {
-		Command.AddCommand(docker.Command)
-	}
-	if nfs.Command != nil {
-		Command.AddCommand(nfs.Command)
-	}
-	if s3.Command != nil {
-		Command.AddCommand(s3.Command)
-	}
 	cmd.Root.AddCommand(Command)
 }
 
@@ -56,6 +22,9 @@ subcommand to specify the protocol, e.g.
     rclone serve http remote:
 
 Each subcommand has its own options which you can see in their help.
+
+Servers can also be started and stopped with the remote control using
+the serve/start and serve/stop calls.
 `,
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.39",
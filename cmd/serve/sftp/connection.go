@@ -299,7 +299,7 @@ func serveChannel(rwc io.ReadWriteCloser, h sftp.Handlers, what string) error {
 	return nil
 }
 
-func serveStdio(f fs.Fs) error {
+func serveStdio(f fs.Fs, vfsOpt *vfscommon.Options) error {
 	if terminal.IsTerminal(int(os.Stdout.Fd())) {
 		return errors.New("refusing to run SFTP server directly on a terminal. Please let sshd start rclone, by connecting with sftp or sshfs")
 	}
@@ -307,7 +307,11 @@ func serveStdio(f fs.Fs) error {
 		stdin:  os.Stdin,
 		stdout: os.Stdout,
 	}
-	handlers := newVFSHandler(vfs.New(f, &vfscommon.Opt))
+	VFS, err := vfs.NewE(f, vfsOpt)
+	if err != nil {
+		return err
+	}
+	handlers := newVFSHandler(VFS)
 	return serveChannel(sshChannel, handlers, "stdio")
 }
 
@@ -21,8 +21,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/proxy"
-	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/lib/env"
@@ -43,21 +43,30 @@ type server struct {
 	listener net.Listener
 	waitChan chan struct{} // for waiting on the listener to close
 	proxy    *proxy.Proxy
+	proxyOpt proxy.Options
 }
 
-func newServer(ctx context.Context, f fs.Fs, opt *Options) *server {
+// check interface
+var _ cmdserve.Protocol = (*server)(nil)
+
+func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (*server, error) {
 	s := &server{
 		f:        f,
 		ctx:      ctx,
 		opt:      *opt,
 		waitChan: make(chan struct{}),
+		proxyOpt: *proxyOpt,
 	}
-	if proxyflags.Opt.AuthProxy != "" {
-		s.proxy = proxy.New(ctx, &proxyflags.Opt)
+	if proxyOpt.AuthProxy != "" {
+		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 	} else {
-		s.vfs = vfs.New(f, &vfscommon.Opt)
+		var err error
+		s.vfs, err = vfs.NewE(f, vfsOpt)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return s
+	return s, nil
 }
 
 // getVFS gets the vfs from s or the proxy
@@ -134,12 +143,12 @@ func (s *server) serve() (err error) {
 	var authorizedKeysMap map[string]struct{}
 
 	// ensure the user isn't trying to use conflicting flags
-	if proxyflags.Opt.AuthProxy != "" && s.opt.AuthorizedKeys != "" && s.opt.AuthorizedKeys != Opt.AuthorizedKeys {
+	if s.proxyOpt.AuthProxy != "" && s.opt.AuthorizedKeys != "" && s.opt.AuthorizedKeys != Opt.AuthorizedKeys {
 		return errors.New("--auth-proxy and --authorized-keys cannot be used at the same time")
 	}
 
 	// Load the authorized keys
-	if s.opt.AuthorizedKeys != "" && proxyflags.Opt.AuthProxy == "" {
+	if s.opt.AuthorizedKeys != "" && s.proxyOpt.AuthProxy == "" {
 		authKeysFile := env.ShellExpand(s.opt.AuthorizedKeys)
 		authorizedKeysMap, err = loadAuthorizedKeys(authKeysFile)
 		// If user set the flag away from the default then report an error
@@ -305,14 +314,13 @@ func (s *server) Addr() string {
 	return s.listener.Addr().String()
 }
 
-// Serve runs the sftp server in the background.
-//
-// Use s.Close() and s.Wait() to shutdown server
+// Serve runs the sftp server - it doesn't return until Shutdown is called
 func (s *server) Serve() error {
 	err := s.serve()
 	if err != nil {
 		return err
 	}
+	s.Wait()
 	return nil
 }
 
@@ -323,12 +331,20 @@ func (s *server) Wait() {
 
 // Close shuts the running server down
 func (s *server) Close() {
-	err := s.listener.Close()
+	err := s.Shutdown()
 	if err != nil {
 		fs.Errorf(nil, "Error on closing SFTP server: %v", err)
-		return
+	}
+}
+
+// Shutdown stops the server accepting new connections
+func (s *server) Shutdown() error {
+	err := s.listener.Close()
+	if err != nil {
+		return err
 	}
 	close(s.waitChan)
+	return nil
 }
 
 func loadPrivateKey(keyPath string) (ssh.Signer, error) {
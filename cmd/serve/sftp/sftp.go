@@ -5,14 +5,17 @@ package sftp
 
 import (
 	"context"
+	"errors"
 
 	"github.com/rclone/rclone/cmd"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/flags"
-	"github.com/rclone/rclone/lib/systemd"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -76,6 +79,18 @@ func init() {
 	vfsflags.AddFlags(Command.Flags())
 	proxyflags.AddFlags(Command.Flags())
 	AddFlags(Command.Flags(), &Opt)
+	cmdserve.Command.AddCommand(Command)
+	cmdserve.Register("sftp", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (cmdserve.Protocol, error) {
+		opt := Opt
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		if opt.Stdio {
+			return nil, errors.New("stdio not supported over the API")
+		}
+		return newServer(ctx, f, &opt, vfsOpt, proxyOpt)
+	})
 }
 
 // Command definition for cobra
@@ -160,16 +175,13 @@ provided by OpenSSH in this case.
 		}
 		cmd.Run(false, true, command, func() error {
 			if Opt.Stdio {
-				return serveStdio(f)
+				return serveStdio(f, &vfscommon.Opt)
 			}
-			s := newServer(context.Background(), f, &Opt)
-			err := s.Serve()
+			s, err := newServer(context.Background(), f, &Opt, &vfscommon.Opt, &proxyflags.Opt)
 			if err != nil {
 				return err
 			}
-			defer systemd.Notify()()
-			s.Wait()
-			return nil
+			return cmdserve.Run("sftp", s)
 		})
 	},
 }
@@ -0,0 +1,345 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+	"golang.org/x/net/webdav"
+)
+
+// lockFacility is the name of the kv database the locks are stored in
+const lockFacility = "webdav-locks"
+
+// lockSystem is a webdav.LockSystem which persists the locks in a kv
+// database so they survive a restart of the server.
+//
+// The semantics are the same as the in memory lock system provided
+// by the webdav library.
+type lockSystem struct {
+	mu    sync.Mutex
+	db    *kv.DB
+	fs    string           // config string of the remote so servers can share the db
+	locks map[string]*lock // locks indexed by token
+}
+
+// lock is a single lock as stored in the database
+type lock struct {
+	Token   string
+	Fs      string
+	Details webdav.LockDetails
+	Expiry  time.Time // zero for no expiry
+	held    bool      // set if the lock is currently confirmed
+	saved   bool      // set if the lock is in the database
+}
+
+// newLockSystem opens the lock database for f and reads the locks
+// which belong to it.
+//
+// f may be nil if the auth proxy is in use.
+func newLockSystem(ctx context.Context, f fs.Fs) (*lockSystem, error) {
+	db, err := kv.Start(ctx, lockFacility, f)
+	if err != nil {
+		return nil, err
+	}
+	ls := &lockSystem{
+		db:    db,
+		locks: make(map[string]*lock),
+	}
+	if f != nil {
+		ls.fs = fs.ConfigString(f)
+	}
+	err = db.Do(false, &lockLoad{ls: ls})
+	if err != nil && err != kv.ErrEmpty {
+		_ = db.Stop(false)
+		return nil, fmt.Errorf("failed to read locks: %w", err)
+	}
+	if len(ls.locks) > 0 {
+		fs.Infof(f, "Loaded %d WebDAV locks from %q", len(ls.locks), db.Path())
+	}
+	return ls, nil
+}
+
+// close the lock database
+func (ls *lockSystem) close() error {
+	return ls.db.Stop(false)
+}
+
+// lockLoad reads the locks for ls.fs from the database
+type lockLoad struct {
+	ls *lockSystem
+}
+
+func (op *lockLoad) Do(ctx context.Context, b kv.Bucket) error {
+	return b.ForEach(func(bkey, data []byte) error {
+		var l lock
+		if err := json.Unmarshal(data, &l); err != nil {
+			fs.Debugf(nil, "webdav: ignoring corrupt lock %q: %v", bkey, err)
+			return nil
+		}
+		if l.Fs == op.ls.fs {
+			l.saved = true
+			op.ls.locks[l.Token] = &l
+		}
+		return nil
+	})
+}
+
+// lockPut writes a lock to the database
+type lockPut struct {
+	l *lock
+}
+
+func (op *lockPut) Do(ctx context.Context, b kv.Bucket) error {
+	data, err := json.Marshal(op.l)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(op.l.Token), data)
+}
+
+// lockDelete removes locks from the database
+type lockDelete struct {
+	tokens []string
+}
+
+func (op *lockDelete) Do(ctx context.Context, b kv.Bucket) error {
+	for _, token := range op.tokens {
+		if err := b.Delete([]byte(token)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistent returns true if the lock should be written to the
+// database.
+//
+// The webdav handler makes a temporary lock with no owner and no
+// timeout for each modifying request made without an If header.
+// These are not saved - if the server stops while one is held it
+// would otherwise lock the resource forever.
+func (l *lock) persistent() bool {
+	return l.Details.Duration >= 0 || l.Details.OwnerXML != ""
+}
+
+// save the lock to the database if required - call with lock held
+func (ls *lockSystem) save(l *lock) error {
+	if !l.saved && !l.persistent() {
+		return nil
+	}
+	err := ls.db.Do(true, &lockPut{l: l})
+	if err != nil {
+		return err
+	}
+	l.saved = true
+	return nil
+}
+
+// remove the locks - call with lock held
+func (ls *lockSystem) remove(locks ...*lock) error {
+	var tokens []string
+	for _, l := range locks {
+		delete(ls.locks, l.Token)
+		if l.saved {
+			tokens = append(tokens, l.Token)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	return ls.db.Do(true, &lockDelete{tokens: tokens})
+}
+
+// collectExpired removes any expired locks - call with lock held
+func (ls *lockSystem) collectExpired(now time.Time) {
+	var expired []*lock
+	for _, l := range ls.locks {
+		if !l.held && !l.Expiry.IsZero() && !now.Before(l.Expiry) {
+			expired = append(expired, l)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	if err := ls.remove(expired...); err != nil {
+		fs.Errorf(nil, "webdav: failed to remove expired locks: %v", err)
+	}
+}
+
+// slashClean is equivalent to but slightly more efficient than
+// path.Clean("/" + name).
+func slashClean(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+// isDescendant returns true if name is below root
+func isDescendant(name, root string) bool {
+	return root == "/" || strings.HasPrefix(name, root+"/")
+}
+
+// lookup returns the lock that locks the named resource, provided that
+// it matches at least one of the given conditions and that lock isn't
+// held by another party. Otherwise, it returns nil.
+//
+// The lock returned may be on a parent of the named resource if it is
+// an infinite depth lock.
+func (ls *lockSystem) lookup(name string, conditions ...webdav.Condition) *lock {
+	for _, c := range conditions {
+		l := ls.locks[c.Token]
+		if l == nil || l.held {
+			continue
+		}
+		if name == l.Details.Root {
+			return l
+		}
+		if l.Details.ZeroDepth {
+			continue
+		}
+		if isDescendant(name, l.Details.Root) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Confirm confirms that the caller can claim all of the locks
+// specified by the given conditions.
+func (ls *lockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	var l0, l1 *lock
+	if name0 != "" {
+		if l0 = ls.lookup(slashClean(name0), conditions...); l0 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if l1 = ls.lookup(slashClean(name1), conditions...); l1 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+
+	// Don't hold the same lock twice.
+	if l1 == l0 {
+		l1 = nil
+	}
+	if l0 != nil {
+		l0.held = true
+	}
+	if l1 != nil {
+		l1.held = true
+	}
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		if l1 != nil {
+			l1.held = false
+		}
+		if l0 != nil {
+			l0.held = false
+		}
+	}, nil
+}
+
+// canCreate returns true if a lock can be created on name - call
+// with lock held
+func (ls *lockSystem) canCreate(name string, zeroDepth bool) bool {
+	for _, l := range ls.locks {
+		root := l.Details.Root
+		switch {
+		case root == name:
+			// The target is already locked.
+			return false
+		case !zeroDepth && isDescendant(root, name):
+			// The requested lock depth is infinite and a
+			// descendant of the target is locked.
+			return false
+		case !l.Details.ZeroDepth && isDescendant(name, root):
+			// An ancestor of the target is locked with
+			// infinite depth.
+			return false
+		}
+	}
+	return true
+}
+
+// Create creates a lock with the given details
+func (ls *lockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+	details.Root = slashClean(details.Root)
+
+	if !ls.canCreate(details.Root, details.ZeroDepth) {
+		return "", webdav.ErrLocked
+	}
+	l := &lock{
+		Token:   "opaquelocktoken:" + uuid.New().String(),
+		Fs:      ls.fs,
+		Details: details,
+	}
+	if details.Duration >= 0 {
+		l.Expiry = now.Add(details.Duration)
+	}
+	if err := ls.save(l); err != nil {
+		return "", fmt.Errorf("failed to save lock: %w", err)
+	}
+	ls.locks[l.Token] = l
+	return l.Token, nil
+}
+
+// Refresh refreshes the lock with the given token.
+func (ls *lockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	l := ls.locks[token]
+	if l == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if l.held {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	l.Details.Duration = duration
+	l.Expiry = time.Time{}
+	if duration >= 0 {
+		l.Expiry = now.Add(duration)
+	}
+	if err := ls.save(l); err != nil {
+		return webdav.LockDetails{}, fmt.Errorf("failed to save lock: %w", err)
+	}
+	return l.Details, nil
+}
+
+// Unlock unlocks the lock with the given token.
+func (ls *lockSystem) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.collectExpired(now)
+
+	l := ls.locks[token]
+	if l == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if l.held {
+		return webdav.ErrLocked
+	}
+	return ls.remove(l)
+}
+
+// check interface
+var _ webdav.LockSystem = (*lockSystem)(nil)
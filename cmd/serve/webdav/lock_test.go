@@ -0,0 +1,229 @@
+//go:build !plan9 && !js
+
+package webdav
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func newTestLockSystem(t *testing.T) (*lockSystem, fs.Fs) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	ls, err := newLockSystem(ctx, f)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, ls.close())
+	})
+	return ls, f
+}
+
+func TestLockSystemCreate(t *testing.T) {
+	ls, _ := newTestLockSystem(t)
+	now := time.Now()
+
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/dir", Duration: time.Minute})
+	require.NoError(t, err)
+	assert.Contains(t, token, "opaquelocktoken:")
+
+	for _, test := range []struct {
+		root      string
+		zeroDepth bool
+		wantErr   error
+	}{
+		{"/dir", true, webdav.ErrLocked},
+		{"dir/", true, webdav.ErrLocked},
+		{"/dir/file", true, webdav.ErrLocked},
+		{"/", false, webdav.ErrLocked},
+		{"/", true, nil},
+		{"/dir2", false, nil},
+		{"/dirx/file", false, nil},
+	} {
+		what := test.root
+		token, err := ls.Create(now, webdav.LockDetails{Root: test.root, ZeroDepth: test.zeroDepth, Duration: time.Minute})
+		if test.wantErr != nil {
+			assert.Equal(t, test.wantErr, err, what)
+			continue
+		}
+		require.NoError(t, err, what)
+		require.NoError(t, ls.Unlock(now, token), what)
+	}
+
+	// A zero depth lock doesn't lock the children
+	token, err = ls.Create(now, webdav.LockDetails{Root: "/zero", ZeroDepth: true, Duration: time.Minute})
+	require.NoError(t, err)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/zero/file", ZeroDepth: true, Duration: time.Minute})
+	require.NoError(t, err)
+	require.NoError(t, ls.Unlock(now, token))
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(now, "potato"))
+}
+
+func TestLockSystemConfirm(t *testing.T) {
+	ls, _ := newTestLockSystem(t)
+	now := time.Now()
+
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/dir", Duration: time.Minute})
+	require.NoError(t, err)
+
+	_, err = ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: "potato"})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	_, err = ls.Confirm(now, "/other", "", webdav.Condition{Token: token})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+
+	release, err := ls.Confirm(now, "/dir/file", "/dir", webdav.Condition{Token: token})
+	require.NoError(t, err)
+
+	// Can't confirm, refresh or unlock a held lock
+	_, err = ls.Confirm(now, "/dir", "", webdav.Condition{Token: token})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	_, err = ls.Refresh(now, token, time.Minute)
+	assert.Equal(t, webdav.ErrLocked, err)
+	assert.Equal(t, webdav.ErrLocked, ls.Unlock(now, token))
+
+	// Held locks don't expire
+	_, err = ls.Create(now.Add(time.Hour), webdav.LockDetails{Root: "/dir", Duration: time.Minute})
+	assert.Equal(t, webdav.ErrLocked, err)
+
+	release()
+	details, err := ls.Refresh(now, token, 2*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "/dir", details.Root)
+	assert.Equal(t, 2*time.Minute, details.Duration)
+	require.NoError(t, ls.Unlock(now, token))
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(now, token))
+	_, err = ls.Refresh(now, token, time.Minute)
+	assert.Equal(t, webdav.ErrNoSuchLock, err)
+}
+
+func TestLockSystemExpiry(t *testing.T) {
+	ls, _ := newTestLockSystem(t)
+	now := time.Now()
+
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/file", ZeroDepth: true, Duration: time.Second})
+	require.NoError(t, err)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/file", ZeroDepth: true, Duration: time.Second})
+	assert.Equal(t, webdav.ErrLocked, err)
+
+	// After expiry the lock has gone
+	later := now.Add(2 * time.Second)
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(later, token))
+	_, err = ls.Create(later, webdav.LockDetails{Root: "/file", ZeroDepth: true, Duration: time.Second})
+	require.NoError(t, err)
+}
+
+func TestLockSystemPersist(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	ls, err := newLockSystem(ctx, f)
+	require.NoError(t, err)
+
+	// Keep a reference to the database so it isn't removed when
+	// the lock system is closed, as happens when run under test.
+	db := kv.Get(lockFacility, f)
+	require.NotNil(t, db)
+	defer func() {
+		assert.NoError(t, db.Stop(false))
+	}()
+
+	details := webdav.LockDetails{
+		Root:      "/dir/file.txt",
+		Duration:  time.Hour,
+		OwnerXML:  "<D:href>me</D:href>",
+		ZeroDepth: true,
+	}
+	token, err := ls.Create(now, details)
+	require.NoError(t, err)
+
+	// A temporary lock as made by the webdav handler isn't saved
+	tempToken, err := ls.Create(now, webdav.LockDetails{Root: "/temp", Duration: -1, ZeroDepth: true})
+	require.NoError(t, err)
+
+	// A lock for a different remote sharing the database isn't loaded
+	other, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	otherLs, err := newLockSystem(ctx, other)
+	require.NoError(t, err)
+	_, err = otherLs.Create(now, webdav.LockDetails{Root: "/other", Duration: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, otherLs.close())
+
+	// Simulate a restart of the server
+	require.NoError(t, ls.close())
+	ls, err = newLockSystem(ctx, f)
+	require.NoError(t, err)
+
+	assert.Len(t, ls.locks, 1)
+	got, err := ls.Refresh(now, token, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, details, got)
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(now, tempToken))
+
+	// The persisted lock still locks the resource
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/dir", Duration: time.Hour})
+	assert.Equal(t, webdav.ErrLocked, err)
+
+	// Unlocking removes it from the database
+	require.NoError(t, ls.Unlock(now, token))
+	require.NoError(t, ls.close())
+	ls, err = newLockSystem(ctx, f)
+	require.NoError(t, err)
+	assert.Len(t, ls.locks, 0)
+	require.NoError(t, ls.close())
+}
+
+func TestWebDavPersistLocks(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+
+	opt := DefaultOpt
+	opt.HTTP.ListenAddr = []string{testBindAddress}
+	opt.PersistLocks = true
+	w, err := newWebDAV(ctx, f, &opt, &vfscommon.Opt, &proxyflags.Opt)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, w.Shutdown())
+	}()
+	require.NotNil(t, w.lockSystem)
+	assert.Equal(t, w.lockSystem, w.webdavhandler.LockSystem)
+
+	// A LOCK request should be stored in the lock system
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+  <D:lockscope><D:exclusive/></D:lockscope>
+  <D:locktype><D:write/></D:locktype>
+  <D:owner><D:href>me</D:href></D:owner>
+</D:lockinfo>`
+	req := httptest.NewRequest("LOCK", "/file.txt", strings.NewReader(body))
+	req.Header.Set("Timeout", "Second-3600")
+	rr := httptest.NewRecorder()
+	w.webdavhandler.ServeHTTP(rr, req)
+	assert.Contains(t, []int{http.StatusOK, http.StatusCreated}, rr.Code)
+	token := rr.Header().Get("Lock-Token")
+	require.NotEqual(t, "", token)
+	assert.Len(t, w.lockSystem.locks, 1)
+
+	// UNLOCK should remove it
+	req = httptest.NewRequest("UNLOCK", "/file.txt", nil)
+	req.Header.Set("Lock-Token", token)
+	rr = httptest.NewRecorder()
+	w.webdavhandler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Len(t, w.lockSystem.locks, 0)
+}
@@ -0,0 +1,121 @@
+package webdav
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/net/webdav"
+)
+
+// deadPropsKey is the metadata key the dead properties are stored in
+const deadPropsKey = "webdav-dead-props"
+
+// deadProp is a dead property as stored in the metadata
+type deadProp struct {
+	Space    string
+	Local    string
+	Lang     string `json:",omitempty"`
+	InnerXML string
+}
+
+// decodeDeadProps decodes the dead properties from a metadata value
+//
+// The value is base64 encoded JSON so it can be stored in any
+// backend which supports user metadata.
+func decodeDeadProps(value string) (map[xml.Name]webdav.Property, error) {
+	props := make(map[xml.Name]webdav.Property)
+	if value == "" {
+		return props, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode dead properties: %w", err)
+	}
+	var items []deadProp
+	err = json.Unmarshal(data, &items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dead properties: %w", err)
+	}
+	for _, item := range items {
+		name := xml.Name{Space: item.Space, Local: item.Local}
+		props[name] = webdav.Property{
+			XMLName:  name,
+			Lang:     item.Lang,
+			InnerXML: []byte(item.InnerXML),
+		}
+	}
+	return props, nil
+}
+
+// encodeDeadProps encodes the dead properties into a metadata value
+//
+// An empty set of properties encodes as an empty string.
+func encodeDeadProps(props map[xml.Name]webdav.Property) (string, error) {
+	if len(props) == 0 {
+		return "", nil
+	}
+	items := make([]deadProp, 0, len(props))
+	for name, prop := range props {
+		items = append(items, deadProp{
+			Space:    name.Space,
+			Local:    name.Local,
+			Lang:     prop.Lang,
+			InnerXML: string(prop.InnerXML),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Space != items[j].Space {
+			return items[i].Space < items[j].Space
+		}
+		return items[i].Local < items[j].Local
+	})
+	data, err := json.Marshal(items)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal dead properties: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// deadPropsEntry returns the directory entry for node if dead
+// properties can be stored in its metadata, or nil if not.
+func deadPropsEntry(node vfs.Node) fs.DirEntry {
+	if !node.VFS().Fs().Features().UserMetadata {
+		return nil
+	}
+	entry := node.DirEntry()
+	if _, ok := entry.(fs.Metadataer); !ok {
+		return nil
+	}
+	if _, ok := entry.(fs.SetMetadataer); !ok {
+		return nil
+	}
+	return entry
+}
+
+// readDeadProps reads the dead properties stored in entry
+func readDeadProps(ctx context.Context, entry fs.DirEntry) (map[xml.Name]webdav.Property, error) {
+	metadata, err := entry.(fs.Metadataer).Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	return decodeDeadProps(metadata[deadPropsKey])
+}
+
+// writeDeadProps writes the dead properties to entry
+func writeDeadProps(ctx context.Context, entry fs.DirEntry, props map[xml.Name]webdav.Property) error {
+	value, err := encodeDeadProps(props)
+	if err != nil {
+		return err
+	}
+	err = entry.(fs.SetMetadataer).SetMetadata(ctx, fs.Metadata{deadPropsKey: value})
+	if err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
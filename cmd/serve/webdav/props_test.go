@@ -0,0 +1,154 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestDeadPropsEncodeDecode(t *testing.T) {
+	props, err := decodeDeadProps("")
+	require.NoError(t, err)
+	assert.Len(t, props, 0)
+
+	value, err := encodeDeadProps(props)
+	require.NoError(t, err)
+	assert.Equal(t, "", value)
+
+	name1 := xml.Name{Space: "http://example.com/ns", Local: "colour"}
+	name2 := xml.Name{Space: "urn:schemas-microsoft-com:", Local: "Win32FileAttributes"}
+	props = map[xml.Name]webdav.Property{
+		name1: {XMLName: name1, Lang: "en", InnerXML: []byte("<b>Grün</b>")},
+		name2: {XMLName: name2, InnerXML: []byte("00000020")},
+	}
+	value, err = encodeDeadProps(props)
+	require.NoError(t, err)
+	got, err := decodeDeadProps(value)
+	require.NoError(t, err)
+	assert.Equal(t, props, got)
+
+	_, err = decodeDeadProps("not base64!")
+	assert.Error(t, err)
+}
+
+func TestDeadPropsPatch(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(dir+"/file.txt", []byte("hello"), 0666))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	opt := DefaultOpt
+	opt.HTTP.ListenAddr = []string{testBindAddress}
+	opt.DeadProps = true
+	w, err := newWebDAV(ctx, f, &opt, &vfscommon.Opt, &proxyflags.Opt)
+	require.NoError(t, err)
+
+	name := xml.Name{Space: "http://example.com/ns", Local: "colour"}
+	patch := func(remove bool, value string) int {
+		file, err := w.OpenFile(ctx, "file.txt", os.O_RDWR, 0)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, file.Close())
+		}()
+		stats, err := file.(webdav.DeadPropsHolder).Patch([]webdav.Proppatch{{
+			Remove: remove,
+			Props:  []webdav.Property{{XMLName: name, InnerXML: []byte(value)}},
+		}})
+		require.NoError(t, err)
+		require.NotEmpty(t, stats)
+		return stats[0].Status
+	}
+	read := func() map[xml.Name]webdav.Property {
+		file, err := w.OpenFile(ctx, "file.txt", os.O_RDONLY, 0)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, file.Close())
+		}()
+		props, err := file.(webdav.DeadPropsHolder).DeadProps()
+		require.NoError(t, err)
+		return props
+	}
+
+	if patch(false, "blue") == http.StatusForbidden {
+		t.Skip("dead properties not stored - xattrs probably not supported")
+	}
+	props := read()
+	assert.Equal(t, "blue", string(props[name].InnerXML))
+	assert.Contains(t, props, xml.Name{Space: "DAV:", Local: "lastmodified"})
+
+	// Check it is stored in the metadata
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	metadata, err := fs.GetMetadata(ctx, o)
+	require.NoError(t, err)
+	assert.NotEqual(t, "", metadata[deadPropsKey])
+
+	assert.Equal(t, http.StatusOK, patch(false, "red"))
+	assert.Equal(t, "red", string(read()[name].InnerXML))
+
+	assert.Equal(t, http.StatusOK, patch(true, ""))
+	assert.NotContains(t, read(), name)
+}
+
+func TestDeadPropsForbidden(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(dir+"/file.txt", []byte("hello"), 0666))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	// Dead properties aren't stored without --dead-props
+	opt := DefaultOpt
+	opt.HTTP.ListenAddr = []string{testBindAddress}
+	w, err := newWebDAV(ctx, f, &opt, &vfscommon.Opt, &proxyflags.Opt)
+	require.NoError(t, err)
+
+	name := xml.Name{Space: "http://example.com/ns", Local: "colour"}
+	lastModified := xml.Name{Space: "DAV:", Local: "lastmodified"}
+	patch := func(props ...webdav.Property) []webdav.Propstat {
+		file, err := w.OpenFile(ctx, "file.txt", os.O_RDWR, 0)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, file.Close())
+		}()
+		stats, err := file.(webdav.DeadPropsHolder).Patch([]webdav.Proppatch{{Props: props}})
+		require.NoError(t, err)
+		return stats
+	}
+	modTime := func() time.Time {
+		fi, err := w.Stat(ctx, "file.txt")
+		require.NoError(t, err)
+		return fi.ModTime()
+	}
+	before := modTime()
+
+	// Nothing is changed if a property can't be stored
+	stats := patch(
+		webdav.Property{XMLName: name, InnerXML: []byte("blue")},
+		webdav.Property{XMLName: lastModified, InnerXML: []byte("1000000000")},
+	)
+	assert.Equal(t, []webdav.Propstat{
+		{Status: http.StatusForbidden, Props: []webdav.Property{{XMLName: name}}},
+		{Status: http.StatusFailedDependency, Props: []webdav.Property{{XMLName: lastModified}}},
+	}, stats)
+	assert.Equal(t, before, modTime())
+
+	// The modification time can always be set
+	stats = patch(webdav.Property{XMLName: lastModified, InnerXML: []byte("1000000000")})
+	assert.Equal(t, []webdav.Propstat{
+		{Status: http.StatusOK, Props: []webdav.Property{{XMLName: lastModified}}},
+	}, stats)
+	assert.Equal(t, time.Unix(1000000000, 0).Unix(), modTime().Unix())
+}
@@ -17,14 +17,16 @@ import (
 	chi "github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rclone/rclone/cmd"
+	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/flags"
 	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/rc"
 	libhttp "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/http/serve"
-	"github.com/rclone/rclone/lib/systemd"
+	"github.com/rclone/rclone/lib/kv"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/rclone/rclone/vfs/vfsflags"
@@ -40,6 +42,8 @@ type Options struct {
 	HashName      string
 	HashType      hash.Type
 	DisableGETDir bool
+	PersistLocks  bool
+	DeadProps     bool
 }
 
 // DefaultOpt is the default values used for Options
@@ -49,6 +53,8 @@ var DefaultOpt = Options{
 	Template:      libhttp.DefaultTemplateCfg(),
 	HashType:      hash.None,
 	DisableGETDir: false,
+	PersistLocks:  false,
+	DeadProps:     false,
 }
 
 // Opt is options set by command line flags
@@ -67,6 +73,17 @@ func init() {
 	proxyflags.AddFlags(flagSet)
 	flags.StringVarP(flagSet, &Opt.HashName, "etag-hash", "", "", "Which hash to use for the ETag, or auto or blank for off", "")
 	flags.BoolVarP(flagSet, &Opt.DisableGETDir, "disable-dir-list", "", false, "Disable HTML directory list on GET request for a directory", "")
+	flags.BoolVarP(flagSet, &Opt.PersistLocks, "persist-locks", "", false, "Store WebDAV locks in a database so they survive a restart", "")
+	flags.BoolVarP(flagSet, &Opt.DeadProps, "dead-props", "", false, "Store WebDAV dead properties in the metadata of the files", "")
+	cmdserve.Command.AddCommand(Command)
+	cmdserve.Register("webdav", func(ctx context.Context, f fs.Fs, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options, in rc.Params) (cmdserve.Protocol, error) {
+		opt := Opt
+		err := in.GetStructMissingOK("opt", &opt)
+		if err != nil {
+			return nil, err
+		}
+		return newWebDAV(ctx, f, &opt, vfsOpt, proxyOpt)
+	})
 }
 
 // Command definition for cobra
@@ -89,6 +106,39 @@ supported hash on the backend or you can use a named hash such as
 "MD5" or "SHA-1". Use the [hashsum](/commands/rclone_hashsum/) command
 to see the full list.
 
+#### --persist-locks
+
+By default WebDAV locks are kept in memory so they are lost if the
+server is restarted. If this flag is set then the locks are stored in
+a database in the rclone cache directory (see
+[--cache-dir](/docs/#cache-dir-dir)) so clients such as Microsoft
+Office and macOS Finder keep their locks across restarts.
+
+#### Downloading directories
+
+Unless ` + "`--disable-dir-list`" + ` is set, directories can be downloaded as
+an archive with a GET request with ` + "`?download=zip`" + ` or
+` + "`?download=tar.gz`" + ` added to the URL of the directory. The archive is
+streamed directly from the remote. Files excluded by the filters
+(e.g. ` + "`--max-size`" + `) are left out of the archive.
+
+### Dead properties
+
+WebDAV clients can store arbitrary properties on files and directories
+with PROPPATCH. If ` + "`--dead-props`" + ` is set and the backend supports user
+metadata and can set it on existing objects (for example the local
+backend, where they are stored in extended attributes) then these
+properties will be stored in the metadata key ` + "`" + deadPropsKey + "`" + ` and
+returned by PROPFIND.
+
+Note that with ` + "`--dead-props`" + ` every PROPFIND reads the metadata of
+each file it lists. On backends such as s3 this means an extra HEAD
+request for each file, which makes directory listings much slower.
+
+Without ` + "`--dead-props`" + `, or if the backend can't store them, a
+PROPPATCH which sets any property other than ` + "`DAV:lastmodified`" + ` fails
+with 403 Forbidden and none of its changes are made.
+
 ### Access WebDAV on Windows
 
 WebDAV shared folder can be mapped as a drive on Windows, however the default settings prevent it.
@@ -141,30 +191,12 @@ done by the permissions on the socket.
 		} else {
 			cmd.CheckArgs(0, 0, command, args)
 		}
-		Opt.HashType = hash.None
-		if Opt.HashName == "auto" {
-			Opt.HashType = f.Hashes().GetOne()
-		} else if Opt.HashName != "" {
-			err := Opt.HashType.Set(Opt.HashName)
-			if err != nil {
-				return err
-			}
-		}
-		if Opt.HashType != hash.None {
-			fs.Debugf(f, "Using hash %v for ETag", Opt.HashType)
-		}
 		cmd.Run(false, false, command, func() error {
-			s, err := newWebDAV(context.Background(), f, &Opt)
+			s, err := newWebDAV(context.Background(), f, &Opt, &vfscommon.Opt, &proxyflags.Opt)
 			if err != nil {
 				return err
 			}
-			err = s.serve()
-			if err != nil {
-				return err
-			}
-			defer systemd.Notify()()
-			s.Wait()
-			return nil
+			return cmdserve.Run("webdav", s)
 		})
 		return nil
 	},
@@ -188,26 +220,49 @@ type WebDAV struct {
 	f             fs.Fs
 	_vfs          *vfs.VFS // don't use directly, use getVFS
 	webdavhandler *webdav.Handler
+	lockSystem    *lockSystem // nil if locks are stored in memory
 	proxy         *proxy.Proxy
 	ctx           context.Context // for global config
+	vfsOpt        vfscommon.Options
 }
 
 // check interface
-var _ webdav.FileSystem = (*WebDAV)(nil)
+var (
+	_ webdav.FileSystem = (*WebDAV)(nil)
+	_ cmdserve.Protocol = (*WebDAV)(nil)
+)
 
 // Make a new WebDAV to serve the remote
-func newWebDAV(ctx context.Context, f fs.Fs, opt *Options) (w *WebDAV, err error) {
+func newWebDAV(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (w *WebDAV, err error) {
 	w = &WebDAV{
-		f:   f,
-		ctx: ctx,
-		opt: *opt,
+		f:      f,
+		ctx:    ctx,
+		opt:    *opt,
+		vfsOpt: *vfsOpt,
+	}
+	if w.opt.HashName == "auto" {
+		if f == nil {
+			return nil, errors.New("can't use --etag-hash auto with --auth-proxy")
+		}
+		w.opt.HashType = f.Hashes().GetOne()
+	} else if w.opt.HashName != "" {
+		err := w.opt.HashType.Set(w.opt.HashName)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if proxyflags.Opt.AuthProxy != "" {
-		w.proxy = proxy.New(ctx, &proxyflags.Opt)
+	if w.opt.HashType != hash.None {
+		fs.Debugf(f, "Using hash %v for ETag", w.opt.HashType)
+	}
+	if proxyOpt.AuthProxy != "" {
+		w.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		// override auth
 		w.opt.Auth.CustomAuthFn = w.auth
 	} else {
-		w._vfs = vfs.New(f, &vfscommon.Opt)
+		w._vfs, err = vfs.NewE(f, vfsOpt)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	w.Server, err = libhttp.NewServer(ctx,
@@ -222,10 +277,25 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options) (w *WebDAV, err error
 	// Make sure BaseURL starts with a / and doesn't end with one
 	w.opt.HTTP.BaseURL = "/" + strings.Trim(w.opt.HTTP.BaseURL, "/")
 
+	var ls webdav.LockSystem
+	if w.opt.PersistLocks {
+		w.lockSystem, err = newLockSystem(ctx, f)
+		if errors.Is(err, kv.ErrUnsupported) {
+			fs.Logf(f, "Persistent WebDAV locks are not supported on this OS - using memory")
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to open WebDAV lock database: %w", err)
+		} else {
+			ls = w.lockSystem
+		}
+	}
+	if ls == nil {
+		ls = webdav.NewMemLS()
+	}
+
 	webdavHandler := &webdav.Handler{
 		Prefix:     w.opt.HTTP.BaseURL,
 		FileSystem: w,
-		LockSystem: webdav.NewMemLS(),
+		LockSystem: ls,
 		Logger:     w.logRequest, // FIXME
 	}
 	w.webdavhandler = webdavHandler
@@ -370,6 +440,10 @@ func (w *WebDAV) serveDir(rw http.ResponseWriter, r *http.Request, dirRemote str
 		return
 	}
 	dir := node.(*vfs.Dir)
+	if format := serve.ArchiveFormat(r); format != "" {
+		serve.ServeArchive(rw, r, dir, format)
+		return
+	}
 	dirEntries, err := dir.ReadDirAll()
 
 	if err != nil {
@@ -380,7 +454,7 @@ func (w *WebDAV) serveDir(rw http.ResponseWriter, r *http.Request, dirRemote str
 	// Make the entries for display
 	directory := serve.NewDirectory(dirRemote, w.Server.HTMLTemplate())
 	for _, node := range dirEntries {
-		if vfscommon.Opt.NoModTime {
+		if w.vfsOpt.NoModTime {
 			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), time.Time{})
 		} else {
 			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), node.ModTime().UTC())
@@ -396,13 +470,43 @@ func (w *WebDAV) serveDir(rw http.ResponseWriter, r *http.Request, dirRemote str
 
 // serve runs the http server in the background.
 //
-// Use s.Close() and s.Wait() to shutdown server
+// Use s.Shutdown() and s.Wait() to shutdown server
 func (w *WebDAV) serve() error {
-	w.Serve()
+	w.Server.Serve()
 	fs.Logf(w.f, "WebDav Server started on %s", w.URLs())
 	return nil
 }
 
+// Serve runs the server - it doesn't return until Shutdown is called
+func (w *WebDAV) Serve() error {
+	err := w.serve()
+	if err != nil {
+		return err
+	}
+	w.Wait()
+	return nil
+}
+
+// Addr returns the first address the server is listening on
+func (w *WebDAV) Addr() string {
+	urls := w.URLs()
+	if len(urls) == 0 {
+		return ""
+	}
+	return urls[0]
+}
+
+// Shutdown the server, waiting for in flight requests to finish
+func (w *WebDAV) Shutdown() error {
+	err := w.Server.Shutdown()
+	if w.lockSystem != nil {
+		if closeErr := w.lockSystem.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
 // logRequest is called by the webdav module on every request
 func (w *WebDAV) logRequest(r *http.Request, err error) {
 	fs.Infof(r.URL.Path, "%s from %s", r.Method, r.RemoteAddr)
@@ -509,12 +613,23 @@ func (h Handle) Stat() (fi os.FileInfo, err error) {
 }
 
 // DeadProps returns extra properties about the handle
+//
+// These are any properties stored with Patch along with some
+// properties computed from the object.
 func (h Handle) DeadProps() (map[xml.Name]webdav.Property, error) {
 	var (
 		xmlName    xml.Name
 		property   webdav.Property
 		properties = make(map[xml.Name]webdav.Property)
 	)
+	if entry := h.deadPropsEntry(); entry != nil {
+		stored, err := readDeadProps(h.ctx, entry)
+		if err == nil {
+			properties = stored
+		} else {
+			fs.Errorf(entry, "failed to read dead properties: %v", err)
+		}
+	}
 	if h.w.opt.HashType != hash.None {
 		entry := h.Handle.Node().DirEntry()
 		if o, ok := entry.(fs.Object); ok {
@@ -544,27 +659,102 @@ func (h Handle) DeadProps() (map[xml.Name]webdav.Property, error) {
 	return properties, nil
 }
 
-// Patch changes modtime of the underlying resources, it returns ok for all properties, the error is from setModtime if any
-// FIXME does not check for invalid property and SetModTime error
+// deadPropsEntry returns the directory entry to store dead properties
+// in or nil if they can't be stored.
+func (h Handle) deadPropsEntry() fs.DirEntry {
+	if !h.w.opt.DeadProps {
+		return nil
+	}
+	return deadPropsEntry(h.Handle.Node())
+}
+
+// Patch changes modtime of the underlying resources and stores any
+// other properties in the metadata if --dead-props is set and the
+// backend supports it.
+//
+// The owncloud checksums are computed from the object so setting them
+// is accepted and ignored, as rclone's webdav backend sends them.
+//
+// As required by RFC 4918 the patch is applied atomically. If any
+// property can't be stored then it is returned with 403 Forbidden,
+// the others with 424 Failed Dependency, and nothing is changed.
 func (h Handle) Patch(proppatches []webdav.Proppatch) ([]webdav.Propstat, error) {
 	var (
-		stat webdav.Propstat
-		err  error
+		entry     = h.deadPropsEntry()
+		ok        = webdav.Propstat{Status: http.StatusOK}
+		forbidden = webdav.Propstat{Status: http.StatusForbidden}
+		failed    = webdav.Propstat{Status: http.StatusFailedDependency}
+		modTime   time.Time
+		isLast    = func(name xml.Name) bool {
+			return name.Space == "DAV:" && name.Local == "lastmodified"
+		}
+		isChecksums = func(name xml.Name) bool {
+			return name.Space == "http://owncloud.org/ns" && name.Local == "checksums"
+		}
 	)
-	stat.Status = http.StatusOK
 	for _, patch := range proppatches {
 		for _, prop := range patch.Props {
-			stat.Props = append(stat.Props, webdav.Property{XMLName: prop.XMLName})
-			if prop.XMLName.Space == "DAV:" && prop.XMLName.Local == "lastmodified" {
-				var modtimeUnix int64
-				modtimeUnix, err = strconv.ParseInt(string(prop.InnerXML), 10, 64)
-				if err == nil {
-					err = h.Handle.Node().SetModTime(time.Unix(modtimeUnix, 0))
+			name := webdav.Property{XMLName: prop.XMLName}
+			if isLast(prop.XMLName) {
+				if patch.Remove {
+					ok.Props = append(ok.Props, name)
+					continue
+				}
+				modtimeUnix, err := strconv.ParseInt(string(prop.InnerXML), 10, 64)
+				if err != nil {
+					forbidden.Props = append(forbidden.Props, name)
+					continue
+				}
+				modTime = time.Unix(modtimeUnix, 0)
+			} else if isChecksums(prop.XMLName) {
+				ok.Props = append(ok.Props, name)
+				continue
+			} else if entry == nil {
+				forbidden.Props = append(forbidden.Props, name)
+				continue
+			}
+			ok.Props = append(ok.Props, name)
+		}
+	}
+	if len(forbidden.Props) > 0 {
+		failed.Props = ok.Props
+		if len(failed.Props) == 0 {
+			return []webdav.Propstat{forbidden}, nil
+		}
+		return []webdav.Propstat{forbidden, failed}, nil
+	}
+
+	if entry != nil {
+		props, err := readDeadProps(h.ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+		dirty := false
+		for _, patch := range proppatches {
+			for _, prop := range patch.Props {
+				if isLast(prop.XMLName) || isChecksums(prop.XMLName) {
+					continue
+				}
+				if patch.Remove {
+					delete(props, prop.XMLName)
+				} else {
+					props[prop.XMLName] = prop
 				}
+				dirty = true
 			}
 		}
+		if dirty {
+			if err := writeDeadProps(h.ctx, entry, props); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !modTime.IsZero() {
+		if err := h.Handle.Node().SetModTime(modTime); err != nil {
+			return nil, err
+		}
 	}
-	return []webdav.Propstat{stat}, err
+	return []webdav.Propstat{ok}, nil
 }
 
 // FileInfo represents info about a file satisfying os.FileInfo and
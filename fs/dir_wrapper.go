@@ -75,6 +75,21 @@ func (d *DirWrapper) SetMetadata(ctx context.Context, metadata Metadata) error {
 	return do.SetMetadata(ctx, metadata)
 }
 
+// RemoveMetadata removes user metadata keys from an DirEntry
+//
+// It should return fs.ErrorNotImplemented if it can't remove metadata
+func (d *DirWrapper) RemoveMetadata(ctx context.Context, keys []string) error {
+	do, ok := d.Directory.(RemoveMetadataer)
+	if !ok {
+		if d.failSilently {
+			Debugf(d, "Can't RemoveMetadata for this directory (%T from %v) -- skipping", d.Directory, d.Fs())
+			return nil
+		}
+		return ErrorNotImplemented
+	}
+	return do.RemoveMetadata(ctx, keys)
+}
+
 // SetModTime sets the metadata on the DirEntry to set the modification date
 //
 // If there is any other metadata it does not overwrite it.
@@ -159,6 +159,19 @@ type Features struct {
 	// of listing recursively that doing a directory traversal.
 	ListR ListRFn
 
+	// ListAt lists the objects and directories in dir as they were
+	// at the time at, using old versions of the objects.
+	//
+	// dir should be "" to list the root, and should not have
+	// trailing slashes.
+	//
+	// This should return ErrDirNotFound if the directory isn't
+	// found.
+	//
+	// The objects returned should read the data of the version
+	// listed. They need not support being modified.
+	ListAt func(ctx context.Context, dir string, at time.Time) (entries DirEntries, err error)
+
 	// About gets quota information from the Fs
 	About func(ctx context.Context) (*Usage, error)
 
@@ -327,6 +340,9 @@ func (ft *Features) Fill(ctx context.Context, f Fs) *Features {
 	if do, ok := f.(ListRer); ok {
 		ft.ListR = do.ListR
 	}
+	if do, ok := f.(ListAter); ok {
+		ft.ListAt = do.ListAt
+	}
 	if do, ok := f.(Abouter); ok {
 		ft.About = do.About
 	}
@@ -435,6 +451,9 @@ func (ft *Features) Mask(ctx context.Context, f Fs) *Features {
 	if mask.ListR == nil {
 		ft.ListR = nil
 	}
+	if mask.ListAt == nil {
+		ft.ListAt = nil
+	}
 	if mask.About == nil {
 		ft.About = nil
 	}
@@ -663,6 +682,22 @@ type ListRer interface {
 	ListR(ctx context.Context, dir string, callback ListRCallback) error
 }
 
+// ListAter is an optional interface for Fs
+type ListAter interface {
+	// ListAt lists the objects and directories in dir as they were
+	// at the time at, using old versions of the objects.
+	//
+	// dir should be "" to list the root, and should not have
+	// trailing slashes.
+	//
+	// This should return ErrDirNotFound if the directory isn't
+	// found.
+	//
+	// The objects returned should read the data of the version
+	// listed. They need not support being modified.
+	ListAt(ctx context.Context, dir string, at time.Time) (entries DirEntries, err error)
+}
+
 // RangeSeeker is the interface that wraps the RangeSeek method.
 //
 // Some of the returns from Object.Open() may optionally implement
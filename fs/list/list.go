@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/filter"
@@ -26,6 +27,28 @@ func DirSorted(ctx context.Context, f fs.Fs, includeAll bool, dir string) (entri
 	if err != nil {
 		return nil, err
 	}
+	return dirSorted(ctx, f, includeAll, dir, entries)
+}
+
+// DirSortedAt is like DirSorted but reads the entries as they were
+// at the time at.
+//
+// The Fs must support ListAt.
+func DirSortedAt(ctx context.Context, f fs.Fs, includeAll bool, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	listAt := f.Features().ListAt
+	if listAt == nil {
+		return nil, fmt.Errorf("%v can't list old versions: %w", f, fs.ErrorNotImplemented)
+	}
+	// Get unfiltered entries from the fs
+	entries, err = listAt(ctx, dir, at)
+	if err != nil {
+		return nil, err
+	}
+	return dirSorted(ctx, f, includeAll, dir, entries)
+}
+
+// dirSorted filters and sorts the unfiltered entries of dir
+func dirSorted(ctx context.Context, f fs.Fs, includeAll bool, dir string, entries fs.DirEntries) (fs.DirEntries, error) {
 	// This should happen only if exclude files lives in the
 	// starting directory, otherwise ListDirSorted should not be
 	// called.
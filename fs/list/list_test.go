@@ -7,6 +7,7 @@ import (
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fstest/mockdir"
+	"github.com/rclone/rclone/fstest/mockfs"
 	"github.com/rclone/rclone/fstest/mockobject"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -106,3 +107,11 @@ func TestFilterAndSortUnknown(t *testing.T) {
 	assert.Error(t, err, "error")
 	assert.Nil(t, newEntries)
 }
+
+func TestDirSortedAtNotImplemented(t *testing.T) {
+	ctx := context.Background()
+	f, err := mockfs.NewFs(ctx, "mock", "", nil)
+	require.NoError(t, err)
+	_, err = DirSortedAt(ctx, f, false, "", time.Now())
+	assert.ErrorIs(t, err, fs.ErrorNotImplemented)
+}
@@ -210,6 +210,15 @@ type SetMetadataer interface {
 	SetMetadata(ctx context.Context, metadata Metadata) error
 }
 
+// RemoveMetadataer is an optional interface for DirEntry
+type RemoveMetadataer interface {
+	// RemoveMetadata removes the user metadata keys from the DirEntry
+	//
+	// Keys which aren't set are ignored. It should return
+	// fs.ErrorNotImplemented if it can't remove metadata
+	RemoveMetadata(ctx context.Context, keys []string) error
+}
+
 // SetModTimer is an optional interface for Directory.
 //
 // Object implements this as part of its requires set of interfaces.
@@ -278,6 +287,9 @@ func ObjectOptionalInterfaces(o Object) (supported, unsupported []string) {
 	_, ok = o.(SetMetadataer)
 	store(ok, "SetMetadata")
 
+	_, ok = o.(RemoveMetadataer)
+	store(ok, "RemoveMetadata")
+
 	return supported, unsupported
 }
 
@@ -298,6 +310,9 @@ func DirectoryOptionalInterfaces(d Directory) (supported, unsupported []string)
 	_, ok = d.(SetMetadataer)
 	store(ok, "SetMetadata")
 
+	_, ok = d.(RemoveMetadataer)
+	store(ok, "RemoveMetadata")
+
 	_, ok = d.(SetModTimer)
 	store(ok, "SetModTime")
 
@@ -70,3 +70,21 @@ const SetSparseImplemented = false
 func SetSparse(out *os.File) error {
 	return nil
 }
+
+// PunchHoleImplemented is a constant indicating whether the
+// implementation of PunchHole actually does anything.
+const PunchHoleImplemented = true
+
+// PunchHole frees the disk space used by size bytes at offset in the
+// file, which then read as zeros, without changing its size.
+func PunchHole(out *os.File, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	for {
+		err := unix.Fallocate(int(out.Fd()), unix.FALLOC_FL_KEEP_SIZE|unix.FALLOC_FL_PUNCH_HOLE, offset, size)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
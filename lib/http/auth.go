@@ -43,6 +43,34 @@ Use ` + "`--{{ .Prefix }}realm`" + ` to set the authentication realm.
 
 Use ` + "`--{{ .Prefix }}salt`" + ` to change the password hashing salt from the default.
 
+##### JWT bearer tokens
+
+Use ` + "`--{{ .Prefix }}jwks`" + ` to authenticate clients with JWT bearer tokens, for
+example ones issued by an OpenID Connect provider. The clients must
+send an ` + "`Authorization: Bearer <token>`" + ` header. This takes precedence
+over the other authentication methods above.
+
+The tokens are verified against the JSON Web Key Set given, which can
+be a local file or an ` + "`http://`" + ` or ` + "`https://`" + ` URL, such as the
+` + "`jwks_uri`" + ` of the provider. RSA, ECDSA, Ed25519 and HMAC keys are
+supported. If a token is signed with a key ID which isn't known then
+the key set will be reloaded, at most once a minute, so key rotation
+at the provider is picked up.
+
+Tokens must have an ` + "`exp`" + ` claim and must not have expired. Use
+` + "`--{{ .Prefix }}jwt-issuer`" + ` and ` + "`--{{ .Prefix }}jwt-audience`" + ` to require the ` + "`iss`" + `
+and ` + "`aud`" + ` claims to have the given values. This is strongly
+recommended when using the key set of a shared provider.
+
+The user name is read from the claim given by
+` + "`--{{ .Prefix }}jwt-user-claim`" + ` which defaults to ` + "`sub`" + `. You may wish to use
+` + "`preferred_username`" + ` or ` + "`email`" + ` instead.
+
+If the command supports ` + "`--auth-proxy`" + ` then the user name from the token
+is passed to the proxy program with an empty ` + "`pass`" + `, so the proxy can
+choose the backend for each user. The token has already been verified
+at this point so the proxy should not reject the empty password.
+
 `
 	tmpl, err := template.New("auth help").Parse(help)
 	if err != nil {
@@ -93,6 +121,22 @@ var AuthConfigInfo = fs.Options{{
 	Name:    "user_from_header",
 	Default: "",
 	Help:    "User name from a defined HTTP header",
+}, {
+	Name:    "jwks",
+	Default: "",
+	Help:    "JWKS file or URL for validating JWT bearer tokens - if set bearer token authentication is used",
+}, {
+	Name:    "jwt_issuer",
+	Default: "",
+	Help:    "Required issuer (iss) of JWT bearer tokens",
+}, {
+	Name:    "jwt_audience",
+	Default: "",
+	Help:    "Required audience (aud) of JWT bearer tokens",
+}, {
+	Name:    "jwt_user_claim",
+	Default: "sub",
+	Help:    "Claim in JWT bearer tokens to use as the user name",
 }}
 
 // AuthConfig contains options for the http authentication
@@ -103,6 +147,10 @@ type AuthConfig struct {
 	BasicPass      string       `config:"pass"`             // password for BasicUser
 	Salt           string       `config:"salt"`             // password hashing salt
 	UserFromHeader string       `config:"user_from_header"` // retrieve user name from a defined HTTP header
+	JWKS           string       `config:"jwks"`             // JWKS file or URL for validating JWT bearer tokens
+	JWTIssuer      string       `config:"jwt_issuer"`       // required issuer of JWT bearer tokens
+	JWTAudience    string       `config:"jwt_audience"`     // required audience of JWT bearer tokens
+	JWTUserClaim   string       `config:"jwt_user_claim"`   // claim to use as the user name
 	CustomAuthFn   CustomAuthFn `json:"-" config:"-"`       // custom Auth (not set by command line flags)
 }
 
@@ -114,6 +162,10 @@ func (cfg *AuthConfig) AddFlagsPrefix(flagSet *pflag.FlagSet, prefix string) {
 	flags.StringVarP(flagSet, &cfg.BasicPass, prefix+"pass", "", cfg.BasicPass, "Password for authentication", prefix)
 	flags.StringVarP(flagSet, &cfg.Salt, prefix+"salt", "", cfg.Salt, "Password hashing salt", prefix)
 	flags.StringVarP(flagSet, &cfg.UserFromHeader, prefix+"user-from-header", "", cfg.UserFromHeader, "Retrieve the username from a specified HTTP header if no other authentication methods are configured (ideal for proxied setups)", prefix)
+	flags.StringVarP(flagSet, &cfg.JWKS, prefix+"jwks", "", cfg.JWKS, "JWKS file or URL for validating JWT bearer tokens - if set bearer token authentication is used", prefix)
+	flags.StringVarP(flagSet, &cfg.JWTIssuer, prefix+"jwt-issuer", "", cfg.JWTIssuer, "Required issuer (iss) of JWT bearer tokens", prefix)
+	flags.StringVarP(flagSet, &cfg.JWTAudience, prefix+"jwt-audience", "", cfg.JWTAudience, "Required audience (aud) of JWT bearer tokens", prefix)
+	flags.StringVarP(flagSet, &cfg.JWTUserClaim, prefix+"jwt-user-claim", "", cfg.JWTUserClaim, "Claim in JWT bearer tokens to use as the user name", prefix)
 }
 
 // AddAuthFlagsPrefix adds flags to the flag set for AuthConfig
@@ -127,6 +179,7 @@ func AddAuthFlagsPrefix(flagSet *pflag.FlagSet, prefix string, cfg *AuthConfig)
 // can be removed when all callers have been converted.
 func DefaultAuthCfg() AuthConfig {
 	return AuthConfig{
-		Salt: "dlPL2MqE",
+		Salt:         "dlPL2MqE",
+		JWTUserClaim: "sub",
 	}
 }
@@ -0,0 +1,356 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksRefreshInterval is the minimum time between reloads of
+	// the JWKS when a token with an unknown key ID is seen
+	jwksRefreshInterval = time.Minute
+	// jwksMaxSize is the maximum size of JWKS we will read
+	jwksMaxSize = 1024 * 1024
+	// jwksTimeout is the timeout for fetching the JWKS from a URL
+	jwksTimeout = 30 * time.Second
+)
+
+// jwtValidMethods are the signing methods accepted in bearer tokens
+var jwtValidMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"HS256", "HS384", "HS512",
+	"EdDSA",
+}
+
+// jsonWebKey is a single key from a JSON Web Key Set as defined in
+// RFC 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC or OKP curve
+	X   string `json:"x"`   // EC or OKP x coordinate
+	Y   string `json:"y"`   // EC y coordinate
+	K   string `json:"k"`   // symmetric key
+}
+
+// jwtKey is a parsed key ready for verifying tokens
+type jwtKey struct {
+	alg string      // algorithm the key is restricted to, if set
+	key interface{} // *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey or []byte
+}
+
+// decodeB64 decodes the unpadded base64url encoding used in JWKs
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// decodeBigInt decodes a base64url encoded big endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := decodeB64(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// parse turns the JSON Web Key into a key usable for verification
+func (k *jsonWebKey) parse() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("bad RSA modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("bad RSA exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("bad EC x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("bad EC y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := decodeB64(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("bad Ed25519 key: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key length")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		key, err := decodeB64(k.K)
+		if err != nil {
+			return nil, fmt.Errorf("bad symmetric key: %w", err)
+		}
+		if len(key) == 0 {
+			return nil, errors.New("empty symmetric key")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// parseJWKS parses a JSON Web Key Set returning the keys indexed by
+// key ID. Keys which can't be used for verifying signatures are
+// skipped.
+func parseJWKS(data []byte) (map[string]jwtKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	keys := make(map[string]jwtKey, len(set.Keys))
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.parse()
+		if err != nil {
+			fs.Errorf(nil, "JWKS: skipping key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = jwtKey{alg: k.Alg, key: key}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable keys found in JWKS")
+	}
+	return keys, nil
+}
+
+// jwtAuth validates JWT bearer tokens against a JWKS
+type jwtAuth struct {
+	ctx      context.Context
+	source   string // file name or URL of the JWKS
+	issuer   string // required issuer if set
+	audience string // required audience if set
+	claim    string // claim to read the user name from
+	// reload makes sure only one reload of the JWKS runs at once
+	reload singleflight.Group
+	// mu protects keys and loaded
+	mu     sync.Mutex
+	keys   map[string]jwtKey
+	loaded time.Time
+}
+
+// newJWTAuth makes a new jwtAuth from the config and loads the keys
+func newJWTAuth(ctx context.Context, cfg *AuthConfig) (*jwtAuth, error) {
+	a := &jwtAuth{
+		ctx:      ctx,
+		source:   cfg.JWKS,
+		issuer:   cfg.JWTIssuer,
+		audience: cfg.JWTAudience,
+		claim:    cfg.JWTUserClaim,
+	}
+	if a.claim == "" {
+		a.claim = "sub"
+	}
+	a.loaded = time.Now()
+	keys, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	a.keys = keys
+	return a, nil
+}
+
+// read reads the JWKS from a file or URL
+func (a *jwtAuth) read() (data []byte, err error) {
+	if !strings.HasPrefix(a.source, "http://") && !strings.HasPrefix(a.source, "https://") {
+		return os.ReadFile(a.source)
+	}
+	// This can't use fshttp as that would make an import cycle
+	ctx, cancel := context.WithTimeout(a.ctx, jwksTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", a.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, jwksMaxSize))
+}
+
+// load reads the keys from the JWKS
+//
+// This may fetch the JWKS over the network so don't call it with mu
+// held.
+func (a *jwtAuth) load() (map[string]jwtKey, error) {
+	data, err := a.read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS from %q: %w", a.source, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", a.source, err)
+	}
+	fs.Debugf(nil, "Loaded %d keys from JWKS %q", len(keys), a.source)
+	return keys, nil
+}
+
+// reloadKeys reloads the keys from the JWKS unless they have been
+// reloaded recently
+//
+// Concurrent callers share a single reload and the keys are replaced
+// only if it succeeds. Tokens with known keys are checked with the old
+// keys while the reload runs.
+func (a *jwtAuth) reloadKeys() {
+	_, _, _ = a.reload.Do("", func() (interface{}, error) {
+		a.mu.Lock()
+		if time.Since(a.loaded) < jwksRefreshInterval {
+			a.mu.Unlock()
+			return nil, nil
+		}
+		a.loaded = time.Now()
+		a.mu.Unlock()
+		keys, err := a.load()
+		if err != nil {
+			fs.Errorf(nil, "%v", err)
+			return nil, err
+		}
+		a.mu.Lock()
+		a.keys = keys
+		a.mu.Unlock()
+		return nil, nil
+	})
+}
+
+// findKey returns the key for kid, reloading the JWKS if it isn't
+// found and it hasn't been reloaded recently
+func (a *jwtAuth) findKey(kid string) (jwtKey, error) {
+	lookup := func() (k jwtKey, ok bool, stale bool) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		stale = time.Since(a.loaded) >= jwksRefreshInterval
+		if kid == "" && len(a.keys) == 1 {
+			for _, k := range a.keys {
+				return k, true, stale
+			}
+		}
+		k, ok = a.keys[kid]
+		return k, ok, stale
+	}
+	k, ok, stale := lookup()
+	if ok {
+		return k, nil
+	}
+	if stale {
+		a.reloadKeys()
+		if k, ok, _ = lookup(); ok {
+			return k, nil
+		}
+	}
+	return jwtKey{}, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// keyFunc finds the key to verify token with
+func (a *jwtAuth) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, err := a.findKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if k.alg != "" && k.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("key %q can't be used with algorithm %q", kid, token.Method.Alg())
+	}
+	return k.key, nil
+}
+
+// check validates the bearer token and returns the user name from it
+func (a *jwtAuth) check(tokenString string) (user string, err error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtValidMethods))
+	_, err = parser.ParseWithClaims(tokenString, claims, a.keyFunc)
+	if err != nil {
+		return "", err
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return "", errors.New("token has no expiry")
+	}
+	if a.issuer != "" && !claims.VerifyIssuer(a.issuer, true) {
+		return "", errors.New("token has wrong issuer")
+	}
+	if a.audience != "" && !claims.VerifyAudience(a.audience, true) {
+		return "", errors.New("token has wrong audience")
+	}
+	user, _ = claims[a.claim].(string)
+	if user == "" {
+		return "", fmt.Errorf("token has no %q claim", a.claim)
+	}
+	if !validUsernameRegexp.MatchString(user) {
+		return "", fmt.Errorf("invalid user name %q in %q claim", user, a.claim)
+	}
+	return user, nil
+}
+
+// parseBearer returns the token from a bearer Authorization header
+func parseBearer(r *http.Request) (token string, ok bool) {
+	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(s) != 2 || !strings.EqualFold(s[0], "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(s[1])
+	return token, token != ""
+}
@@ -0,0 +1,278 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testJWTKeys are the keys used to sign tokens in the tests
+type testJWTKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	hmac []byte
+}
+
+func newTestJWTKeys(t *testing.T) *testJWTKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &testJWTKeys{
+		rsa:  rsaKey,
+		ec:   ecKey,
+		hmac: []byte("potato-potato-potato-potato-potato"),
+	}
+}
+
+// jwks returns the JWKS for the keys
+func (k *testJWTKeys) jwks(t *testing.T) []byte {
+	b64 := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "rsa",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   b64(k.rsa.N.Bytes()),
+			"e":   b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		}, {
+			"kty": "EC",
+			"kid": "ec",
+			"crv": "P-256",
+			"x":   b64(k.ec.X.Bytes()),
+			"y":   b64(k.ec.Y.Bytes()),
+		}, {
+			"kty": "oct",
+			"kid": "hmac",
+			"k":   b64(k.hmac),
+		}, {
+			"kty": "RSA",
+			"kid": "enc",
+			"use": "enc",
+			"n":   b64(k.rsa.N.Bytes()),
+			"e":   b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		}},
+	})
+	require.NoError(t, err)
+	return data
+}
+
+// sign makes a token with the claims signed with the key kid
+func (k *testJWTKeys) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	var (
+		method jwt.SigningMethod
+		key    interface{}
+	)
+	switch kid {
+	case "rsa", "enc":
+		method, key = jwt.SigningMethodRS256, k.rsa
+	case "ec":
+		method, key = jwt.SigningMethodES256, k.ec
+	case "hmac", "unknown":
+		method, key = jwt.SigningMethodHS256, k.hmac
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func testClaims(user string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": user,
+		"iss": "https://issuer.example.com",
+		"aud": []string{"rclone", "other"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	got, err := parseJWKS(keys.jwks(t))
+	require.NoError(t, err)
+	assert.Len(t, got, 3)
+	assert.Equal(t, "RS256", got["rsa"].alg)
+	assert.Equal(t, &keys.rsa.PublicKey, got["rsa"].key)
+	assert.Equal(t, &keys.ec.PublicKey, got["ec"].key)
+	assert.Equal(t, keys.hmac, got["hmac"].key)
+
+	_, err = parseJWKS([]byte(`{"keys":[{"kty":"potato"}]}`))
+	assert.Error(t, err)
+	_, err = parseJWKS([]byte(`potato`))
+	assert.Error(t, err)
+}
+
+func TestJWTAuthCheck(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(jwks, keys.jwks(t), 0600))
+	a, err := newJWTAuth(context.Background(), &AuthConfig{
+		JWKS:        jwks,
+		JWTIssuer:   "https://issuer.example.com",
+		JWTAudience: "rclone",
+	})
+	require.NoError(t, err)
+
+	for _, kid := range []string{"rsa", "ec", "hmac"} {
+		user, err := a.check(keys.sign(t, kid, testClaims("user-"+kid)))
+		require.NoError(t, err, kid)
+		assert.Equal(t, "user-"+kid, user)
+	}
+
+	for _, test := range []struct {
+		name   string
+		kid    string
+		modify func(jwt.MapClaims)
+	}{
+		{"Expired", "rsa", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"NoExpiry", "rsa", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"WrongIssuer", "rsa", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"WrongAudience", "rsa", func(c jwt.MapClaims) { c["aud"] = "potato" }},
+		{"NoUser", "rsa", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"BadUser", "rsa", func(c jwt.MapClaims) { c["sub"] = "../etc" }},
+		{"UnknownKey", "unknown", func(c jwt.MapClaims) {}},
+		{"EncryptionKey", "enc", func(c jwt.MapClaims) {}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			claims := testClaims("user")
+			test.modify(claims)
+			_, err := a.check(keys.sign(t, test.kid, claims))
+			assert.Error(t, err)
+		})
+	}
+
+	// A token signed with the RSA public key as an HMAC secret must fail
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("user"))
+	token.Header["kid"] = "rsa"
+	s, err := token.SignedString(keys.rsa.N.Bytes())
+	require.NoError(t, err)
+	_, err = a.check(s)
+	assert.Error(t, err)
+
+	// Check the user claim can be changed
+	a.claim = "email"
+	claims := testClaims("user")
+	claims["email"] = "user@example.com"
+	user, err := a.check(keys.sign(t, "ec", claims))
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", user)
+}
+
+func TestMiddlewareAuthJWT(t *testing.T) {
+	keys := newTestJWTKeys(t)
+
+	// Serve the JWKS from a URL
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(keys.jwks(t))
+	}))
+	defer jwksServer.Close()
+
+	var proxyUser, proxyPass string
+	s, err := NewServer(context.Background(), WithConfig(Config{
+		ListenAddr: []string{"127.0.0.1:0"},
+	}), WithAuth(AuthConfig{
+		Realm:       "test",
+		JWKS:        jwksServer.URL,
+		JWTAudience: "rclone",
+		CustomAuthFn: func(user, pass string) (value interface{}, err error) {
+			proxyUser, proxyPass = user, pass
+			return nil, nil
+		},
+	}))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Shutdown())
+	}()
+	assert.True(t, s.UsingAuth())
+	s.Router().Mount("/", testAuthUserHandler())
+	s.Serve()
+	url := testGetServerURL(t, s)
+
+	get := func(auth string) *http.Response {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	resp := get("")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="test"`, resp.Header.Get("WWW-Authenticate"))
+
+	resp = get("Basic dXNlcjpwYXNz")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get("Bearer potato")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="test", error="invalid_token"`, resp.Header.Get("WWW-Authenticate"))
+
+	resp = get("Bearer " + keys.sign(t, "rsa", testClaims("jwtuser")))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	testExpectRespBody(t, resp, []byte("jwtuser"))
+	assert.Equal(t, "jwtuser", proxyUser)
+	assert.Equal(t, "", proxyPass)
+}
+
+func TestJWTAuthReloadUnlocked(t *testing.T) {
+	keys := newTestJWTKeys(t)
+	block := make(chan struct{})
+	requests := make(chan struct{}, 10)
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		if len(requests) > 1 {
+			<-block
+		}
+		_, _ = w.Write(keys.jwks(t))
+	}))
+	defer jwksServer.Close()
+	a, err := newJWTAuth(context.Background(), &AuthConfig{JWKS: jwksServer.URL})
+	require.NoError(t, err)
+
+	// Start a reload with an unknown key which blocks in the server
+	a.mu.Lock()
+	a.loaded = time.Time{}
+	a.mu.Unlock()
+	done := make(chan error)
+	go func() {
+		_, err := a.check(keys.sign(t, "unknown", testClaims("user")))
+		done <- err
+	}()
+	assert.Eventually(t, func() bool { return len(requests) == 2 }, 10*time.Second, 10*time.Millisecond)
+
+	// Known keys are still usable while it is running
+	user, err := a.check(keys.sign(t, "rsa", testClaims("user")))
+	require.NoError(t, err)
+	assert.Equal(t, "user", user)
+
+	// Another unknown key doesn't start another reload
+	go func() {
+		_, _ = a.check(keys.sign(t, "unknown", testClaims("user")))
+	}()
+	close(block)
+	assert.Error(t, <-done)
+	assert.Equal(t, 2, len(requests))
+}
@@ -145,15 +145,50 @@ func MiddlewareAuthCustom(fn CustomAuthFn, realm string, userFromContext bool) M
 				return
 			}
 
+			ctx := context.WithValue(r.Context(), ctxKeyUser, user)
 			if value != nil {
-				r = r.WithContext(context.WithValue(r.Context(), ctxKeyAuth, value))
+				ctx = context.WithValue(ctx, ctxKeyAuth, value)
 			}
+			r = r.WithContext(ctx)
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// MiddlewareAuthJWT instantiates middleware that authenticates using
+// JWT bearer tokens validated with a.
+func MiddlewareAuthJWT(a *jwtAuth, realm string) Middleware {
+	fs.Infof(nil, "Using JWT bearer token authentication with keys from %q", a.source)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// skip auth for CORS preflight
+			if r.Method == "OPTIONS" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			challenge := fmt.Sprintf(`Bearer realm=%q`, realm)
+			token, ok := parseBearer(r)
+			if ok {
+				user, err := a.check(token)
+				if err == nil {
+					r = r.WithContext(context.WithValue(r.Context(), ctxKeyUser, user))
+					next.ServeHTTP(w, r)
+					return
+				}
+				fs.Infof(r.URL.Path, "%s: Unauthorized request: invalid bearer token: %v", r.RemoteAddr, err)
+				challenge += `, error="invalid_token"`
+			}
+
+			code := http.StatusUnauthorized
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, http.StatusText(code), code)
+		})
+	}
+}
+
 var validUsernameRegexp = regexp.MustCompile(`^[\p{L}\d@._-]+$`)
 
 // MiddlewareAuthGetUserFromHeader middleware that bypasses authentication and extracts the user via a specified HTTP header(ideal for proxied setups).
@@ -0,0 +1,259 @@
+package serve
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/vfs"
+)
+
+// Archive formats which can be passed to ServeArchive
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// archiveWriter is the interface used by ServeArchive to write an
+// archive in a given format
+type archiveWriter interface {
+	// addDir adds an entry for a directory
+	addDir(name string, node vfs.Node) error
+	// addFile adds a file, calling copy to write the contents
+	addFile(name string, node vfs.Node, copy func(io.Writer) error) error
+	// Close finishes the archive
+	Close() error
+}
+
+// zipArchive writes a zip file
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) addDir(name string, node vfs.Node) error {
+	hdr := &zip.FileHeader{
+		Name:     name + "/",
+		Modified: node.ModTime(),
+	}
+	hdr.SetMode(os.ModeDir | 0755)
+	_, err := a.zw.CreateHeader(hdr)
+	return err
+}
+
+func (a *zipArchive) addFile(name string, node vfs.Node, copy func(io.Writer) error) error {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Modified: node.ModTime(),
+		Method:   zip.Deflate,
+	}
+	hdr.SetMode(0644)
+	w, err := a.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	return copy(w)
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// tarGzArchive writes a gzipped tar file
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarGzArchive) addDir(name string, node vfs.Node) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  node.ModTime(),
+	})
+}
+
+func (a *tarGzArchive) addFile(name string, node vfs.Node, copy func(io.Writer) error) error {
+	size := node.Size()
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  node.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	// The size must be exactly as given in the header
+	cw := &countingWriter{w: a.tw}
+	err = copy(cw)
+	if err == nil && cw.n != size {
+		err = fmt.Errorf("size changed while archiving: expecting %d bytes but read %d", size, cw.n)
+	}
+	return err
+}
+
+func (a *tarGzArchive) Close() error {
+	err := a.tw.Close()
+	if gzErr := a.gz.Close(); err == nil {
+		err = gzErr
+	}
+	return err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// newArchiveWriter returns an archiveWriter for format writing to w
+// or nil if the format isn't known
+func newArchiveWriter(format string, w io.Writer) archiveWriter {
+	switch format {
+	case ArchiveZip:
+		return &zipArchive{zw: zip.NewWriter(w)}
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return nil
+}
+
+// ServeArchive streams the directory tree rooted at dir to w as an
+// archive in format, which should be ArchiveZip or ArchiveTarGz.
+//
+// Nothing is staged on disk. The files are read through the VFS and
+// are accounted as transfers so they show in the stats. Any files
+// and directories excluded by the filters (including --max-size) are
+// left out of the archive.
+func ServeArchive(w http.ResponseWriter, r *http.Request, dir *vfs.Dir, format string) {
+	ctx := r.Context()
+	dirRemote := dir.Path()
+	aw := newArchiveWriter(format, w)
+	if aw == nil {
+		http.Error(w, fmt.Sprintf("Unknown archive format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	name := path.Base("/" + dirRemote)
+	if name == "/" {
+		name = "root"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	switch format {
+	case ArchiveZip:
+		w.Header().Set("Content-Type", "application/zip")
+	case ArchiveTarGz:
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+
+	fs.Infof(dirRemote, "%s: Serving directory as %s", r.RemoteAddr, format)
+	err := addDirToArchive(ctx, aw, dir, "")
+	if closeErr := aw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// The headers have been sent so all we can do is log the
+		// error - the client will get a truncated archive.
+		Error(ctx, dirRemote, nil, "Failed to write archive", err)
+	}
+}
+
+// addDirToArchive adds the contents of dir to the archive with names
+// starting with prefix
+func addDirToArchive(ctx context.Context, aw archiveWriter, dir *vfs.Dir, prefix string) error {
+	fi := filter.GetConfig(ctx)
+	nodes, err := dir.ReadDirAll()
+	if err != nil {
+		return fmt.Errorf("failed to list %q: %w", dir.Path(), err)
+	}
+	for _, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := node.Name()
+		if prefix != "" {
+			name = prefix + "/" + name
+		}
+		remote := node.Path()
+		if subDir, ok := node.(*vfs.Dir); ok {
+			if !fi.IncludeRemote(remote + "/") {
+				continue
+			}
+			if err := aw.addDir(name, node); err != nil {
+				return err
+			}
+			if err := addDirToArchive(ctx, aw, subDir, name); err != nil {
+				return err
+			}
+			continue
+		}
+		file, ok := node.(*vfs.File)
+		if !ok {
+			continue
+		}
+		if o, ok := node.DirEntry().(fs.Object); ok {
+			if !fi.IncludeObject(ctx, o) {
+				continue
+			}
+		} else if !fi.Include(remote, node.Size(), node.ModTime(), nil) {
+			continue
+		}
+		if node.Size() < 0 {
+			fs.Errorf(remote, "Skipping file of unknown size in archive")
+			continue
+		}
+		err := aw.addFile(name, node, func(out io.Writer) error {
+			return copyFile(ctx, out, file)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add %q: %w", remote, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies the contents of file to out accounting the transfer
+func copyFile(ctx context.Context, out io.Writer, file *vfs.File) (err error) {
+	var tr *accounting.Transfer
+	if o, ok := file.DirEntry().(fs.Object); ok {
+		tr = accounting.Stats(ctx).NewTransfer(o, nil)
+	} else {
+		tr = accounting.Stats(ctx).NewTransferRemoteSize(file.Path(), file.Size(), nil, nil)
+	}
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	in, err := file.Open(os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	// The account closes in when the transfer is done
+	_, err = io.Copy(out, tr.Account(ctx, in))
+	return err
+}
+
+// ArchiveFormat returns the archive format requested in r, or "" if
+// none was requested.
+func ArchiveFormat(r *http.Request) string {
+	return strings.ToLower(r.URL.Query().Get("download"))
+}
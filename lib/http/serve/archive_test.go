@@ -0,0 +1,141 @@
+package serve
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeArchiveDir makes a VFS with some files in for archiving
+func makeArchiveDir(t *testing.T) *vfs.Dir {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "empty"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "one.txt"), []byte("one"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.txt"), bytes.Repeat([]byte("x"), 1000), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "two.txt"), []byte("two two"), 0666))
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	opt := vfscommon.Opt
+	VFS := vfs.New(f, &opt)
+	t.Cleanup(VFS.Shutdown)
+	root, err := VFS.Root()
+	require.NoError(t, err)
+	return root
+}
+
+// serveArchive serves the archive and returns the response
+func serveArchive(t *testing.T, ctx context.Context, dir *vfs.Dir, format string) *http.Response {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/?download="+format, nil).WithContext(ctx)
+	assert.Equal(t, format, ArchiveFormat(r))
+	ServeArchive(w, r, dir, format)
+	return w.Result()
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, file := range zr.File {
+		in, err := file.Open()
+		require.NoError(t, err)
+		contents, err := io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		files[file.Name] = string(contents)
+	}
+	return files
+}
+
+func readTarGz(t *testing.T, data []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		contents, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(contents)
+	}
+	return files
+}
+
+func TestServeArchive(t *testing.T) {
+	ctx := context.Background()
+	dir := makeArchiveDir(t)
+	want := map[string]string{
+		"big.txt":     string(bytes.Repeat([]byte("x"), 1000)),
+		"one.txt":     "one",
+		"sub/":        "",
+		"sub/empty/":  "",
+		"sub/two.txt": "two two",
+	}
+
+	resp := serveArchive(t, ctx, dir, ArchiveZip)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=root.zip`, resp.Header.Get("Content-Disposition"))
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, want, readZip(t, data))
+
+	resp = serveArchive(t, ctx, dir, ArchiveTarGz)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, want, readTarGz(t, data))
+
+	// Check a sub directory
+	node, err := dir.Stat("sub")
+	require.NoError(t, err)
+	resp = serveArchive(t, ctx, node.(*vfs.Dir), ArchiveZip)
+	assert.Equal(t, `attachment; filename=sub.zip`, resp.Header.Get("Content-Disposition"))
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"empty/": "", "two.txt": "two two"}, readZip(t, data))
+
+	// Check unknown formats are rejected
+	resp = serveArchive(t, ctx, dir, "potato")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestServeArchiveFilter(t *testing.T) {
+	ctx := context.Background()
+	ctx, fi := filter.AddConfig(ctx)
+	fi.Opt.MaxSize = 100
+	require.NoError(t, fi.AddRule("- sub/**"))
+	dir := makeArchiveDir(t)
+
+	resp := serveArchive(t, ctx, dir, ArchiveTarGz)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var names []string
+	for name := range readTarGz(t, data) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"one.txt"}, names)
+}
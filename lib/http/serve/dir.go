@@ -22,6 +22,7 @@ type DirEntry struct {
 	remote  string
 	URL     string
 	Leaf    string
+	Name    string // Leaf without the trailing / for directories
 	IsDir   bool
 	Size    int64
 	ModTime time.Time
@@ -38,6 +39,8 @@ type Directory struct {
 	Breadcrumb   []Crumb
 	Sort         string
 	Order        string
+	ReadWrite    bool   // set to show the upload, mkdir, rename and delete forms
+	CSRFToken    string // token which must be sent with the forms
 }
 
 // Crumb is a breadcrumb entry
@@ -91,6 +94,7 @@ func (d *Directory) AddHTMLEntry(remote string, isDir bool, size int64, modTime
 	if leaf == "." {
 		leaf = ""
 	}
+	name := leaf
 	urlRemote := leaf
 	if isDir {
 		leaf += "/"
@@ -100,6 +104,7 @@ func (d *Directory) AddHTMLEntry(remote string, isDir bool, size int64, modTime
 		remote:  remote,
 		URL:     rest.URLPathEscape(urlRemote) + d.Query,
 		Leaf:    leaf,
+		Name:    name,
 		IsDir:   isDir,
 		Size:    size,
 		ModTime: modTime,
@@ -112,6 +117,7 @@ func (d *Directory) AddEntry(remote string, isDir bool) {
 	if leaf == "." {
 		leaf = ""
 	}
+	name := leaf
 	urlRemote := leaf
 	if isDir {
 		leaf += "/"
@@ -121,6 +127,7 @@ func (d *Directory) AddEntry(remote string, isDir bool) {
 		remote: remote,
 		URL:    rest.URLPathEscape(urlRemote) + d.Query,
 		Leaf:   leaf,
+		Name:   name,
 	})
 }
 
@@ -329,7 +329,10 @@ func NewServer(ctx context.Context, options ...Option) (*Server, error) {
 
 	s.mux.Use(MiddlewareCORS(s.cfg.AllowOrigin))
 
-	s.initAuth()
+	err = s.initAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// (Only) listen on FDs provided by the service manager, if any.
 	sdListeners, err := sdActivation.ListenersWithNames()
@@ -390,8 +393,23 @@ func NewServer(ctx context.Context, options ...Option) (*Server, error) {
 	return s, nil
 }
 
-func (s *Server) initAuth() {
+func (s *Server) initAuth(ctx context.Context) error {
 	s.usingAuth = false
+
+	if s.auth.JWKS != "" {
+		a, err := newJWTAuth(ctx, &s.auth)
+		if err != nil {
+			return err
+		}
+		s.usingAuth = true
+		s.mux.Use(MiddlewareAuthJWT(a, s.auth.Realm))
+		// Pass the user from the token on to the custom auth, eg the auth proxy
+		if s.auth.CustomAuthFn != nil {
+			s.mux.Use(MiddlewareAuthCustom(s.auth.CustomAuthFn, s.auth.Realm, true))
+		}
+		return nil
+	}
+
 	altUsernameEnabled := s.auth.HtPasswd == "" && s.auth.BasicUser == ""
 
 	if altUsernameEnabled {
@@ -409,20 +427,22 @@ func (s *Server) initAuth() {
 	if s.auth.CustomAuthFn != nil {
 		s.usingAuth = true
 		s.mux.Use(MiddlewareAuthCustom(s.auth.CustomAuthFn, s.auth.Realm, altUsernameEnabled))
-		return
+		return nil
 	}
 
 	if s.auth.HtPasswd != "" {
 		s.usingAuth = true
 		s.mux.Use(MiddlewareAuthHtpasswd(s.auth.HtPasswd, s.auth.Realm))
-		return
+		return nil
 	}
 
 	if s.auth.BasicUser != "" {
 		s.usingAuth = true
 		s.mux.Use(MiddlewareAuthBasic(s.auth.BasicUser, s.auth.BasicPass, s.auth.Realm, s.auth.Salt))
-		return
+		return nil
 	}
+
+	return nil
 }
 
 func (s *Server) initTemplate() error {
@@ -32,12 +32,15 @@ to be used within the template to server pages:
 | .Order      | The current ordering used.  This is changeable via ?order= parameter |
 |             | Order Options: asc,desc (default asc) |
 | .Query      | Currently unused. |
+| .ReadWrite  | Boolean set if the upload, mkdir, rename and delete forms should be shown. |
+| .CSRFToken  | Token which must be sent as the csrf field of any form which modifies files. |
 | .Breadcrumb | Allows for creating a relative navigation |
 |-- .Link     | The relative to the root link of the Text. |
 |-- .Text     | The Name of the directory. |
 | .Entries    | Information about a specific file/directory. |
 |-- .URL      | The 'url' of an entry.  |
 |-- .Leaf     | Currently same as 'URL' but intended to be 'just' the name. |
+|-- .Name     | Same as .Leaf but without the trailing / for directories. |
 |-- .IsDir    | Boolean for if an entry is a directory or not. |
 |-- .Size     | Size in Bytes of the entry. |
 |-- .ModTime  | The UTC timestamp of an entry. |
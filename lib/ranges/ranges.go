@@ -231,6 +231,23 @@ func (rs Ranges) Intersection(r Range) (newRs Ranges) {
 	return newRs
 }
 
+// Remove returns a new Ranges with the parts of rs within r removed
+func (rs Ranges) Remove(r Range) (newRs Ranges) {
+	for _, curr := range rs {
+		if curr.Intersection(r).IsEmpty() {
+			newRs = append(newRs, curr)
+			continue
+		}
+		if curr.Pos < r.Pos {
+			newRs = append(newRs, Range{Pos: curr.Pos, Size: r.Pos - curr.Pos})
+		}
+		if curr.End() > r.End() {
+			newRs = append(newRs, Range{Pos: r.End(), Size: curr.End() - r.End()})
+		}
+	}
+	return newRs
+}
+
 // Equal returns true if rs == bs
 func (rs Ranges) Equal(bs Ranges) bool {
 	if len(rs) != len(bs) {
@@ -18,6 +18,7 @@ import (
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsdircache"
 	"golang.org/x/text/unicode/norm"
 )
 
@@ -28,14 +29,16 @@ type Dir struct {
 	f            fs.Fs       // read only
 	cleanupTimer *time.Timer // read only: timer to call cacheCleanup
 
-	mu      sync.RWMutex // protects the following
-	parent  *Dir         // parent, nil for root
-	path    string
-	entry   fs.Directory
-	read    time.Time         // time directory entry last read
-	items   map[string]Node   // directory entries - can be empty but not nil
-	virtual map[string]vState // virtual directory entries - may be nil
-	sys     atomic.Value      // user defined info to be attached here
+	mu       sync.RWMutex // protects the following
+	parent   *Dir         // parent, nil for root
+	path     string
+	entry    fs.Directory
+	metadata fs.Metadata       // metadata of entry - nil if not read yet
+	perms    *metadataPerms    // permissions parsed from metadata - nil if not read yet
+	read     time.Time         // time directory entry last read
+	items    map[string]Node   // directory entries - can be empty but not nil
+	virtual  map[string]vState // virtual directory entries - may be nil
+	sys      atomic.Value      // user defined info to be attached here
 
 	modTimeMu sync.Mutex // protects the following
 	modTime   time.Time
@@ -86,7 +89,7 @@ func (d *Dir) cacheCleanup() {
 	d.mu.Unlock()
 
 	if stale {
-		d.ForgetAll()
+		d.forgetAll()
 	}
 }
 
@@ -159,7 +162,11 @@ func (d *Dir) IsDir() bool {
 
 // Mode bits of the directory - satisfies Node interface
 func (d *Dir) Mode() (mode os.FileMode) {
-	return os.FileMode(d.vfs.Opt.DirPerms)
+	mode = os.FileMode(d.vfs.Opt.DirPerms)
+	if p := readPerms(d); p.hasMode {
+		mode = mode&^os.ModePerm | p.mode
+	}
+	return mode
 }
 
 // Name (base) of the directory - satisfies Node interface
@@ -217,10 +224,26 @@ func (d *Dir) addVirtual(n int32) {
 //
 // It does not invalidate or clear the cache of the parent directory.
 //
+// The listings in the persistent directory cache are removed too so
+// the directories are read from the remote next time.
+//
 // It returns true if the directory or any of its children had virtual
 // entries so could not be forgotten. Children which didn't have
 // virtual entries will be forgotten even if true is returned.
 func (d *Dir) ForgetAll() (hasVirtual bool) {
+	d.mu.RLock()
+	dirPath := d.path
+	d.mu.RUnlock()
+	d.vfs.forgetPersistedDir(dirPath, true)
+	return d.forgetAll()
+}
+
+// forgetAll forgets directory entries for this directory and any
+// children leaving the persistent directory cache alone.
+//
+// It returns true if the directory or any of its children had virtual
+// entries so could not be forgotten.
+func (d *Dir) forgetAll() (hasVirtual bool) {
 	// We run this part with RLock only to avoid deadlocks in the recursion
 
 	d.mu.RLock()
@@ -228,7 +251,7 @@ func (d *Dir) ForgetAll() (hasVirtual bool) {
 	fs.Debugf(d.path, "forgetting directory cache")
 	for _, node := range d.items {
 		if dir, ok := node.(*Dir); ok {
-			dir.ForgetAll()
+			dir.forgetAll()
 		}
 	}
 
@@ -262,15 +285,20 @@ func (d *Dir) ForgetAll() (hasVirtual bool) {
 //
 // It does not invalidate or clear the cache of the parent directory.
 func (d *Dir) forgetDirPath(relativePath string) {
+	d.mu.RLock()
+	absPath := path.Join(d.path, relativePath)
+	d.mu.RUnlock()
+	d.vfs.forgetPersistedDir(absPath, true)
 	dir := d.cachedDir(relativePath)
 	if dir == nil {
 		return
 	}
-	dir.ForgetAll()
+	dir.forgetAll()
 }
 
 // invalidateDir invalidates the directory cache for absPath relative to the root
 func (d *Dir) invalidateDir(absPath string) {
+	d.vfs.forgetPersistedDir(absPath, false)
 	node := d.vfs.root.cachedNode(absPath)
 	if dir, ok := node.(*Dir); ok {
 		dir.mu.Lock()
@@ -379,6 +407,7 @@ func (d *Dir) renameTree(dirPath string) {
 		d.path = dirPath
 		d.parent.items[name(d.path)] = d
 		d.entry = fs.NewDirCopy(context.TODO(), d.entry).SetRemote(dirPath)
+		d._clearMetadata()
 	}
 
 	// Do the same to any child directories and files
@@ -399,7 +428,7 @@ func (d *Dir) renameTree(dirPath string) {
 // Reset the directory to new state, discarding all the objects and
 // reading everything again
 func (d *Dir) rename(newParent *Dir, fsDir fs.Directory) {
-	d.ForgetAll()
+	d.forgetAll()
 
 	d.modTimeMu.Lock()
 	d.modTime = fsDir.ModTime(context.TODO())
@@ -408,12 +437,14 @@ func (d *Dir) rename(newParent *Dir, fsDir fs.Directory) {
 	oldPath := d.path
 	d.parent = newParent
 	d.entry = fsDir
+	d._clearMetadata()
 	d.path = fsDir.Remote()
 	newPath := d.path
 	delete(d.parent.items, name(oldPath))
 	d.parent.items[name(d.path)] = d
 	d.read = time.Time{}
 	d.mu.Unlock()
+	d.vfs.forgetPersistedDir(oldPath, true)
 
 	// Rename any remaining items in the tree that we couldn't forget
 	d.renameTree(d.path)
@@ -457,7 +488,9 @@ func (d *Dir) addObject(node Node) {
 	}
 	d.virtual[leaf] = vAdd
 	fs.Debugf(d.path, "Added virtual directory entry %v: %q", vAdd, leaf)
+	dirPath := d.path
 	d.mu.Unlock()
+	d.vfs.forgetPersistedDir(dirPath, false)
 }
 
 // AddVirtual adds a virtual object of name and size to the directory
@@ -513,7 +546,9 @@ func (d *Dir) delObject(leaf string) {
 	}
 	d.virtual[leaf] = vDel
 	fs.Debugf(d.path, "Added virtual directory entry %v: %q", vDel, leaf)
+	dirPath := d.path
 	d.mu.Unlock()
+	d.vfs.forgetPersistedDir(dirPath, false)
 }
 
 // DelVirtual removes an object from the directory listing
@@ -527,22 +562,18 @@ func (d *Dir) DelVirtual(leaf string) {
 	d.delObject(leaf)
 }
 
-// read the directory and sets d.items - must be called with the lock held
-func (d *Dir) _readDir() error {
-	when := time.Now()
-	if age, stale := d._age(when); stale {
-		if age != 0 {
-			fs.Debugf(d.path, "Re-reading directory (%v old)", age)
-		}
+// listRemote lists dirPath on the remote
+func (d *Dir) listRemote(dirPath string) (entries fs.DirEntries, err error) {
+	if d.vfs.Opt.SnapshotTime.IsSet() {
+		entries, err = list.DirSortedAt(context.TODO(), d.f, false, dirPath, time.Time(d.vfs.Opt.SnapshotTime))
 	} else {
-		return nil
+		entries, err = list.DirSorted(context.TODO(), d.f, false, dirPath)
 	}
-	entries, err := list.DirSorted(context.TODO(), d.f, false, d.path)
 	if err == fs.ErrorDirNotFound {
 		// We treat directory not found as empty because we
 		// create directories on the fly
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 
 	if d.vfs.Opt.BlockNormDupes { // do this only if requested, as it will have a performance hit
@@ -573,6 +604,31 @@ func (d *Dir) _readDir() error {
 		}
 		entries = filteredEntries
 	}
+	return entries, nil
+}
+
+// read the directory and sets d.items - must be called with the lock held
+func (d *Dir) _readDir() error {
+	when := time.Now()
+	if age, stale := d._age(when); stale {
+		if age != 0 {
+			fs.Debugf(d.path, "Re-reading directory (%v old)", age)
+		} else if d._readDirFromPersisted(when) {
+			return nil
+		}
+	} else {
+		return nil
+	}
+	return d._readDirFromRemote(when)
+}
+
+// _readDirFromRemote reads the directory from the remote and sets
+// d.items - must be called with the lock held
+func (d *Dir) _readDirFromRemote(when time.Time) error {
+	entries, err := d.listRemote(d.path)
+	if err != nil {
+		return err
+	}
 
 	err = d._readDirFromEntries(entries, nil, time.Time{})
 	if err != nil {
@@ -581,10 +637,79 @@ func (d *Dir) _readDir() error {
 
 	d.read = when
 	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
+	d.vfs.persistDir(d.path, entries, when)
 
 	return nil
 }
 
+// _readDirFromPersisted reads the directory from the persistent
+// directory cache if possible returning true if it did.
+//
+// If the listing is older than --dir-cache-time it is used anyway so
+// the directory can be browsed straight away, and read again from the
+// remote in the background.
+//
+// must be called with the lock held
+func (d *Dir) _readDirFromPersisted(when time.Time) bool {
+	dirCache := d.vfs.dirCache
+	if dirCache == nil {
+		return false
+	}
+	entries, listed, err := dirCache.Get(context.TODO(), d.path)
+	if err != nil {
+		if err != vfsdircache.ErrNotFound {
+			fs.Errorf(d.path, "Failed to read directory cache: %v", err)
+		}
+		return false
+	}
+	err = d._readDirFromEntries(entries, nil, time.Time{})
+	if err != nil {
+		fs.Errorf(d.path, "Failed to use directory cache: %v", err)
+		return false
+	}
+	age := when.Sub(listed)
+	if age > time.Duration(d.vfs.Opt.DirCacheTime) {
+		fs.Debugf(d.path, "Read directory from cache (%v old) - re-reading in the background", age)
+		d.read = when
+		go d.revalidate(when)
+	} else {
+		fs.Debugf(d.path, "Read directory from cache (%v old)", age)
+		d.read = listed
+	}
+	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
+	return true
+}
+
+// revalidate reads the directory from the remote replacing the
+// listing read from the persistent directory cache at read.
+//
+// It does nothing if the directory has been read or invalidated
+// since.
+func (d *Dir) revalidate(read time.Time) {
+	d.mu.RLock()
+	dirPath := d.path
+	d.mu.RUnlock()
+	when := time.Now()
+	entries, err := d.listRemote(dirPath)
+	if err != nil {
+		fs.Errorf(dirPath, "Failed to re-read directory: %v", err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.read.Equal(read) || d.path != dirPath {
+		return
+	}
+	err = d._readDirFromEntries(entries, nil, time.Time{})
+	if err != nil {
+		d.read = time.Time{}
+		return
+	}
+	fs.Debugf(dirPath, "Re-read directory in the background")
+	d.read = when
+	d.vfs.persistDir(dirPath, entries, when)
+}
+
 // update d.items for each dir in the DirTree below this one and
 // set the last read time - must be called with the lock held
 func (d *Dir) _readDirFromDirTree(dirTree dirtree.DirTree, when time.Time) error {
@@ -646,7 +771,7 @@ func (d *Dir) _purgeVirtual() {
 				// if writing in progress then leave virtual
 				continue
 			}
-			if d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal && d.vfs.cache.InUse(f.CachePath()) {
+			if d.vfs.cache != nil && d.vfs.cache.InUse(f.CachePath()) {
 				// if object in use or dirty then leave virtual
 				continue
 			}
@@ -761,6 +886,7 @@ func (d *Dir) _readDirFromEntries(entries fs.DirEntries, dirTree dirtree.DirTree
 			dir.mu.Lock()
 			dir.modTime = item.ModTime(context.TODO())
 			dir.entry = item
+			dir._clearMetadata()
 			if dirTree != nil {
 				err = dir._readDirFromDirTree(dirTree, when)
 				if err != nil {
@@ -785,8 +911,29 @@ func (d *Dir) _readDirFromEntries(entries fs.DirEntries, dirTree dirtree.DirTree
 	return nil
 }
 
+// readDirTreeSnapshot reads the complete directory tree a directory
+// at a time as old versions can't be listed recursively
+func (d *Dir) readDirTreeSnapshot() error {
+	nodes, err := d.ReadDirAll()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if dir, ok := node.(*Dir); ok {
+			err = dir.readDirTreeSnapshot()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // readDirTree forces a refresh of the complete directory tree
 func (d *Dir) readDirTree() error {
+	if d.vfs.Opt.SnapshotTime.IsSet() {
+		return d.readDirTreeSnapshot()
+	}
 	d.mu.RLock()
 	f, path := d.f, d.path
 	d.mu.RUnlock()
@@ -806,15 +953,18 @@ func (d *Dir) readDirTree() error {
 	fs.Debugf(d.path, "Reading directory tree done in %s", time.Since(when))
 	d.read = when
 	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
+	for dirPath, entries := range dt {
+		d.vfs.persistDir(dirPath, entries, when)
+	}
 	return nil
 }
 
-// readDir forces a refresh of the directory
+// readDir forces a refresh of the directory from the remote
 func (d *Dir) readDir() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.read = time.Time{}
-	return d._readDir()
+	return d._readDirFromRemote(time.Now())
 }
 
 // stat a single item in the directory
@@ -881,13 +1031,25 @@ func (d *Dir) Size() int64 {
 
 // SetModTime sets the modTime for this dir
 func (d *Dir) SetModTime(modTime time.Time) error {
-	if d.vfs.Opt.ReadOnly {
+	if d.vfs.readOnly(d.Path()) {
 		return EROFS
 	}
+	d.setModTime(modTime)
+	return nil
+}
+
+// setModTime sets the modTime for this dir without checking it is
+// writable. This is used when the contents of the dir change.
+func (d *Dir) setModTime(modTime time.Time) {
 	d.modTimeMu.Lock()
 	d.modTime = modTime
 	d.modTimeMu.Unlock()
-	return nil
+}
+
+// readOnly returns true if the entry name in this dir may not be
+// created, modified or removed
+func (d *Dir) readOnly(name string) bool {
+	return d.vfs.readOnly(path.Join(d.Path(), name))
 }
 
 func (d *Dir) cachedDir(relativePath string) (dir *Dir) {
@@ -988,20 +1150,17 @@ func (d *Dir) Create(name string, flags int) (*File, error) {
 		return nil, err
 	}
 	// node doesn't exist so create it
-	if d.vfs.Opt.ReadOnly {
+	if d.readOnly(name) {
 		return nil, EROFS
 	}
-	if err = d.SetModTime(time.Now()); err != nil {
-		fs.Errorf(d, "Dir.Create failed to set modtime on parent dir: %v", err)
-		return nil, err
-	}
+	d.setModTime(time.Now())
 	// This gets added to the directory when the file is opened for write
 	return newFile(d, d.Path(), nil, name), nil
 }
 
 // Mkdir creates a new directory
 func (d *Dir) Mkdir(name string) (*Dir, error) {
-	if d.vfs.Opt.ReadOnly {
+	if d.readOnly(name) {
 		return nil, EROFS
 	}
 	path := path.Join(d.path, name)
@@ -1029,17 +1188,14 @@ func (d *Dir) Mkdir(name string) (*Dir, error) {
 	fsDir := fs.NewDir(path, time.Now())
 	dir := newDir(d.vfs, d.f, d, fsDir)
 	d.addObject(dir)
-	if err = d.SetModTime(time.Now()); err != nil {
-		fs.Errorf(d, "Dir.Mkdir failed to set modtime on parent dir: %v", err)
-		return nil, err
-	}
+	d.setModTime(time.Now())
 	// fs.Debugf(path, "Dir.Mkdir OK")
 	return dir, nil
 }
 
 // Remove the directory
 func (d *Dir) Remove() error {
-	if d.vfs.Opt.ReadOnly {
+	if d.vfs.readOnly(d.Path()) {
 		return EROFS
 	}
 	// Check directory is empty first
@@ -1067,7 +1223,7 @@ func (d *Dir) Remove() error {
 
 // RemoveAll removes the directory and any contents recursively
 func (d *Dir) RemoveAll() error {
-	if d.vfs.Opt.ReadOnly {
+	if d.vfs.readOnly(d.Path()) {
 		return EROFS
 	}
 	// Remove contents of the directory
@@ -1095,7 +1251,7 @@ func (d *Dir) DirEntry() (entry fs.DirEntry) {
 // which must be a directory.  The entry to be removed may correspond
 // to a file (unlink) or to a directory (rmdir).
 func (d *Dir) RemoveName(name string) error {
-	if d.vfs.Opt.ReadOnly {
+	if d.readOnly(name) {
 		return EROFS
 	}
 	// fs.Debugf(path, "Dir.Remove")
@@ -1104,17 +1260,14 @@ func (d *Dir) RemoveName(name string) error {
 		fs.Errorf(d, "Dir.Remove error: %v", err)
 		return err
 	}
-	if err = d.SetModTime(time.Now()); err != nil {
-		fs.Errorf(d, "Dir.Remove failed to set modtime on parent dir: %v", err)
-		return err
-	}
+	d.setModTime(time.Now())
 	return node.Remove()
 }
 
 // Rename the file
 func (d *Dir) Rename(oldName, newName string, destDir *Dir) error {
 	// fs.Debugf(d, "BEFORE\n%s", d.dump())
-	if d.vfs.Opt.ReadOnly {
+	if d.readOnly(oldName) || destDir.readOnly(newName) {
 		return EROFS
 	}
 	oldPath := path.Join(d.path, oldName)
@@ -1148,6 +1301,10 @@ func (d *Dir) Rename(oldName, newName string, destDir *Dir) error {
 			return err
 		}
 	case fs.Directory:
+		// Moving the directory moves everything inside it too
+		if d.vfs.readOnlyWithin(oldPath) || d.vfs.readOnlyWithin(newPath) {
+			return EROFS
+		}
 		features := d.f.Features()
 		if features.DirMove == nil && features.Move == nil && features.Copy == nil {
 			err := fmt.Errorf("Fs %q can't rename directories (no DirMove, Move or Copy)", d.f)
@@ -1178,10 +1335,7 @@ func (d *Dir) Rename(oldName, newName string, destDir *Dir) error {
 	// Show moved - delete from old dir and add to new
 	d.delObject(oldName)
 	destDir.addObject(oldNode)
-	if err = d.SetModTime(time.Now()); err != nil {
-		fs.Errorf(d, "Dir.Rename failed to set modtime on parent dir: %v", err)
-		return err
-	}
+	d.setModTime(time.Now())
 
 	// fs.Debugf(newPath, "Dir.Rename renamed from %q", oldPath)
 	// fs.Debugf(d, "AFTER\n%s", d.dump())
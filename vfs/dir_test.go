@@ -13,6 +13,8 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsdircache"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -687,3 +689,210 @@ func TestDirEntryModTimeInvalidation(t *testing.T) {
 		t.Error("ModTime not invalidated")
 	}
 }
+
+func TestDirCachePersist(t *testing.T) {
+	ctx := context.Background()
+	opt := vfscommon.Opt
+	opt.DirCachePersist = true
+	r, vfs1 := newTestVFSOpt(t, &opt)
+	file1 := r.WriteObject(ctx, "dir/file1", "file1 contents", t1)
+	r.CheckRemoteItems(t, file1)
+
+	names := func(vfs *VFS) []string {
+		node, err := vfs.Stat("dir")
+		require.NoError(t, err)
+		items, err := node.(*Dir).ReadDirAll()
+		require.NoError(t, err)
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Name())
+		}
+		return out
+	}
+	assert.Equal(t, []string{"file1"}, names(vfs1))
+	flushDirCache(vfs1)
+
+	// Change the remote behind the VFS's back
+	file2 := r.WriteObject(ctx, "dir/file2", "file2 contents", t2)
+	r.CheckRemoteItems(t, file1, file2)
+
+	// A new VFS uses the saved listing while it is fresh
+	opt2 := opt
+	opt2.DirCacheTime = fs.Duration(time.Hour)
+	vfs2 := New(r.Fremote, &opt2)
+	defer cleanupVFS(t, vfs2)
+	assert.Equal(t, []string{"file1"}, names(vfs2))
+
+	// The placeholder can be opened
+	fd, err := vfs2.OpenFile("dir/file1", os.O_RDONLY, 0)
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = fd.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "file1", string(buf))
+	require.NoError(t, fd.Close())
+
+	// A stale listing is used then read again in the background
+	opt3 := opt
+	opt3.DirCacheTime = fs.Duration(90 * time.Minute)
+	vfs3 := New(r.Fremote, &opt3)
+	defer cleanupVFS(t, vfs3)
+	entries, _, err := vfs3.dirCache.Get(ctx, "dir")
+	require.NoError(t, err)
+	require.NoError(t, vfs3.dirCache.Put(ctx, "dir", entries, time.Now().Add(-2*time.Hour)))
+	node, err := vfs3.Stat("dir")
+	require.NoError(t, err)
+	dir := node.(*Dir)
+	dir.mu.Lock()
+	require.NoError(t, dir._readDir())
+	assert.Len(t, dir.items, 1)
+	dir.mu.Unlock()
+	assert.Eventually(t, func() bool {
+		dir.mu.RLock()
+		defer dir.mu.RUnlock()
+		return len(dir.items) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+	flushDirCache(vfs3)
+
+	// The background read updated the saved listing
+	opt4 := opt2
+	opt4.DirCacheTime = fs.Duration(2 * time.Hour)
+	vfs4 := New(r.Fremote, &opt4)
+	defer cleanupVFS(t, vfs4)
+	assert.Equal(t, []string{"file1", "file2"}, names(vfs4))
+
+	// Changes through the VFS remove the saved listing
+	require.NoError(t, vfs4.Remove("dir/file2"))
+	_, _, err = vfs4.dirCache.Get(ctx, "dir")
+	assert.Equal(t, vfsdircache.ErrNotFound, err)
+}
+
+// flushDirCache waits for the queued writes to the persistent
+// directory cache to finish
+func flushDirCache(vfs *VFS) {
+	<-vfs.dirCacheDo(func(*vfsdircache.Cache) {})
+}
+
+// Test forgetting and refreshing directories reads them from the
+// remote and not the persistent directory cache
+func TestDirCachePersistForget(t *testing.T) {
+	ctx := context.Background()
+	opt := vfscommon.Opt
+	opt.DirCachePersist = true
+	opt.DirCacheTime = fs.Duration(time.Hour)
+	r, vfs := newTestVFSOpt(t, &opt)
+	file1 := r.WriteObject(ctx, "dir/file1", "file1 contents", t1)
+	r.CheckRemoteItems(t, file1)
+
+	names := func() []string {
+		node, err := vfs.Stat("dir")
+		require.NoError(t, err)
+		items, err := node.(*Dir).ReadDirAll()
+		require.NoError(t, err)
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Name())
+		}
+		return out
+	}
+	assert.Equal(t, []string{"file1"}, names())
+	flushDirCache(vfs)
+
+	// Forgetting everything removes the saved listings
+	file2 := r.WriteObject(ctx, "dir/file2", "file2 contents", t2)
+	r.CheckRemoteItems(t, file1, file2)
+	vfs.FlushDirCache()
+	_, _, err := vfs.dirCache.Get(ctx, "dir")
+	assert.Equal(t, vfsdircache.ErrNotFound, err)
+	assert.Equal(t, []string{"file1", "file2"}, names())
+	flushDirCache(vfs)
+
+	// Forgetting a directory removes its saved listing
+	file3 := r.WriteObject(ctx, "dir/file3", "file3 contents", t3)
+	r.CheckRemoteItems(t, file1, file2, file3)
+	vfs.root.ForgetPath("dir", fs.EntryDirectory)
+	_, _, err = vfs.dirCache.Get(ctx, "dir")
+	assert.Equal(t, vfsdircache.ErrNotFound, err)
+	assert.Equal(t, []string{"file1", "file2", "file3"}, names())
+
+	// Refreshing a directory reads it from the remote
+	require.NoError(t, r.Fremote.Mkdir(ctx, "dir/sub"))
+	node, err := vfs.Stat("dir")
+	require.NoError(t, err)
+	require.NoError(t, node.(*Dir).readDir())
+	assert.Equal(t, []string{"file1", "file2", "file3", "sub"}, names())
+}
+
+// snapshotFs pretends to list old versions of the objects in the Fs
+// it wraps by leaving out objects modified after the time asked for
+type snapshotFs struct {
+	fs.Fs
+	features *fs.Features
+}
+
+// Features returns the optional features of this Fs
+func (f *snapshotFs) Features() *fs.Features {
+	return f.features
+}
+
+// ListAt lists the objects in dir modified before at
+func (f *snapshotFs) ListAt(ctx context.Context, dir string, at time.Time) (entries fs.DirEntries, err error) {
+	all, err := f.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range all {
+		if o, ok := entry.(fs.Object); ok && o.ModTime(ctx).After(at) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func TestDirSnapshot(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	r.WriteObject(ctx, "dir/old", "old contents", t1)
+	r.WriteObject(ctx, "dir/new", "new contents", t3)
+	r.WriteObject(ctx, "top", "top contents", t1)
+	f := &snapshotFs{Fs: r.Fremote}
+	f.features = (&fs.Features{}).Fill(ctx, f)
+
+	opt := vfscommon.Opt
+	opt.SnapshotTime = fs.Time(t2)
+	vfs := New(f, &opt)
+	defer cleanupVFS(t, vfs)
+	assert.True(t, vfs.Opt.ReadOnly)
+
+	checkNames := func(dirPath string, want []string) {
+		node, err := vfs.Stat(dirPath)
+		require.NoError(t, err)
+		nodes, err := node.(*Dir).ReadDirAll()
+		require.NoError(t, err)
+		var got []string
+		for _, node := range nodes {
+			got = append(got, node.Name())
+		}
+		assert.Equal(t, want, got)
+	}
+	checkNames("", []string{"dir", "top"})
+	checkNames("dir", []string{"old"})
+	_, err := vfs.Stat("dir/new")
+	assert.Equal(t, ENOENT, err)
+
+	// Files can be read but not written
+	data, err := vfs.ReadFile("dir/old")
+	require.NoError(t, err)
+	assert.Equal(t, "old contents", string(data))
+	assert.Equal(t, EROFS, vfs.WriteFile("dir/old", []byte("x"), 0600))
+
+	// Refreshing the tree uses the snapshot too
+	require.NoError(t, vfs.root.readDirTree())
+	checkNames("dir", []string{"old"})
+
+	// A remote which can't list old versions can't make a VFS
+	opt.SnapshotTime = fs.Time(t1)
+	_, err = NewE(r.Fremote, &opt)
+	assert.ErrorContains(t, err, "--vfs-snapshot-time is not supported")
+}
@@ -21,6 +21,9 @@ const (
 	EROFS
 	ENOSYS
 	ELOOP
+	ENOATTR
+	ENOTSUP
+	EAGAIN
 )
 
 // Errors which have exact counterparts in os
@@ -40,6 +43,9 @@ var errorNames = []string{
 	EROFS:     "Read only file system",
 	ENOSYS:    "Function not implemented",
 	ELOOP:     "Too many symbolic links",
+	ENOATTR:   "No such attribute",
+	ENOTSUP:   "Operation not supported",
+	EAGAIN:    "Resource temporarily unavailable",
 }
 
 // Error renders the error as a string
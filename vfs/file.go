@@ -16,6 +16,7 @@ import (
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsdircache"
 )
 
 // The File object is tightly coupled to the Dir object. Since they
@@ -47,6 +48,8 @@ type File struct {
 	d                *Dir                            // parent directory
 	dPath            string                          // path of parent directory. NB dir rename means all Files are flushed
 	o                fs.Object                       // NB o may be nil if file is being written
+	metadata         fs.Metadata                     // metadata of o - nil if not read yet
+	perms            *metadataPerms                  // permissions parsed from metadata - nil if not read yet
 	leaf             string                          // leaf name of the object
 	writers          []Handle                        // writers for this file
 	virtualModTime   *time.Time                      // modtime for backends with Precision == fs.ModTimeNotSupported
@@ -118,6 +121,7 @@ func (f *File) setSymlink() {
 
 // Mode bits of the file or directory - satisfies Node interface
 func (f *File) Mode() (mode os.FileMode) {
+	p := readPerms(f)
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 	if f.isLink {
@@ -128,6 +132,9 @@ func (f *File) Mode() (mode os.FileMode) {
 			mode |= os.ModeAppend
 		}
 	}
+	if p.hasMode {
+		mode = mode&^os.ModePerm | p.mode
+	}
 	return mode
 }
 
@@ -243,6 +250,9 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 	// File.mu is unlocked here to call Dir.Path()
 	newPath := path.Join(destDir.Path(), newCacheName)
 
+	// Move any locks to the new name
+	d.vfs.locks.rename(f.Path(), path.Join(destDir.Path(), newName))
+
 	renameCall := func(ctx context.Context) (err error) {
 		// chain rename calls if any
 		if oldPendingRenameFun != nil {
@@ -263,6 +273,13 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 				return nil // no need to rename
 			}
 
+			// the object may have come from the persistent directory cache
+			o, err = vfsdircache.Resolve(ctx, o)
+			if err != nil {
+				fs.Errorf(f.Path(), "File.Rename error: %v", err)
+				return err
+			}
+
 			// do the move of the remote object
 			dstOverwritten, _ := d.Fs().NewObject(ctx, newPath)
 			newObject, err = operations.Move(ctx, d.Fs(), dstOverwritten, newPath, o)
@@ -290,6 +307,7 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 		f.mu.Lock()
 		if newObject != nil {
 			f.o = newObject
+			f._clearMetadata()
 			f._setIsLink()
 		}
 		f.pendingRenameFun = nil
@@ -308,7 +326,7 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 
 	// Delay the rename if not using RW caching. For the minimal case we
 	// need to look in the cache to see if caching is in use.
-	CacheMode := d.vfs.Opt.CacheMode
+	CacheMode := d.vfs.cacheMode(oldPath)
 	if writing &&
 		(CacheMode < vfscommon.CacheModeMinimal ||
 			(CacheMode == vfscommon.CacheModeMinimal && !destDir.vfs.cache.Exists(oldPath))) {
@@ -393,7 +411,7 @@ func (f *File) ModTime() (modTime time.Time) {
 		return d.ModTime()
 	}
 	// Read the modtime from a dirty item if it exists
-	if f.d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal {
+	if f.d.vfs.cache != nil {
 		if item := f.d.vfs.cache.DirtyItem(f._cachePath()); item != nil {
 			modTime, err := item.GetModTime()
 			if err != nil {
@@ -430,7 +448,7 @@ func (f *File) Size() int64 {
 	defer f.mu.RUnlock()
 
 	// Read the size from a dirty item if it exists
-	if f.d.vfs.Opt.CacheMode >= vfscommon.CacheModeMinimal {
+	if f.d.vfs.cache != nil {
 		if item := f.d.vfs.cache.DirtyItem(f._cachePath()); item != nil {
 			size, err := item.GetSize()
 			if err != nil {
@@ -457,7 +475,7 @@ func (f *File) SetModTime(modTime time.Time) error {
 	if f.d.vfs.Opt.NoModTime {
 		return nil
 	}
-	if f.d.vfs.Opt.ReadOnly {
+	if f.d.vfs.readOnly(f._path()) {
 		return EROFS
 	}
 
@@ -540,6 +558,7 @@ func (f *File) setSize(n int64) {
 func (f *File) setObject(o fs.Object) {
 	f.mu.Lock()
 	f.o = o
+	f._clearMetadata()
 	f._setIsLink()
 	_ = f._applyPendingModTime()
 	d := f.d
@@ -554,6 +573,7 @@ func (f *File) setObject(o fs.Object) {
 func (f *File) setObjectNoUpdate(o fs.Object) {
 	f.mu.Lock()
 	f.o = o
+	f._clearMetadata()
 	f._setIsLink()
 	f.virtualModTime = nil
 	fs.Debugf(f._path(), "Reset virtual modtime")
@@ -618,7 +638,7 @@ func (f *File) openWrite(flags int) (fh *WriteFileHandle, err error) {
 	d := f.d
 	f.mu.RUnlock()
 
-	if d.vfs.Opt.ReadOnly {
+	if d.vfs.readOnly(f.Path()) {
 		return nil, EROFS
 	}
 	// fs.Debugf(f.Path(), "File.openWrite")
@@ -640,7 +660,7 @@ func (f *File) openRW(flags int) (fh *RWFileHandle, err error) {
 	f.mu.RUnlock()
 
 	// FIXME chunked
-	if flags&accessModeMask != os.O_RDONLY && d.vfs.Opt.ReadOnly {
+	if flags&accessModeMask != os.O_RDONLY && d.vfs.readOnly(f.Path()) {
 		return nil, EROFS
 	}
 	// fs.Debugf(f.Path(), "File.openRW")
@@ -667,7 +687,7 @@ func (f *File) Remove() (err error) {
 	d := f.d
 	f.mu.RUnlock()
 
-	if d.vfs.Opt.ReadOnly {
+	if d.vfs.readOnly(f.Path()) {
 		return EROFS
 	}
 
@@ -888,8 +908,8 @@ func (f *File) Open(flags int) (fd Handle, err error) {
 	f.mu.RLock()
 	d := f.d
 	f.mu.RUnlock()
-	CacheMode := d.vfs.Opt.CacheMode
-	if CacheMode >= vfscommon.CacheModeMinimal && (d.vfs.cache.InUse(f.CachePath()) || d.vfs.cache.Exists(f.CachePath())) {
+	CacheMode := d.vfs.cacheMode(f.Path())
+	if d.vfs.cache != nil && (d.vfs.cache.InUse(f.CachePath()) || d.vfs.cache.Exists(f.CachePath())) {
 		fd, err = f.openRW(flags)
 	} else if read && write {
 		if CacheMode >= vfscommon.CacheModeMinimal {
@@ -0,0 +1,372 @@
+// Advisory file locking
+
+package vfs
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// LockType is the type of an advisory lock
+type LockType byte
+
+// Lock types - these match F_UNLCK, F_RDLCK and F_WRLCK in meaning
+const (
+	LockUnlock LockType = iota // no lock or release the lock
+	LockRead                   // shared lock
+	LockWrite                  // exclusive lock
+)
+
+// LockEOF is the End of a lock which extends to the end of the file
+const LockEOF = math.MaxUint64
+
+// FileLock describes an advisory lock on a range of bytes of a file
+//
+// Locks with Flock set have flock(2) semantics: they cover the whole
+// file and don't interact with fcntl(2) byte range locks.
+type FileLock struct {
+	Start uint64   // first byte locked
+	End   uint64   // last byte locked (inclusive) or LockEOF
+	Type  LockType // type of lock
+	Owner uint64   // owner of the lock
+	Pid   uint32   // process which took the lock, for information only
+	Flock bool     // set for flock(2) style locks
+}
+
+// overlaps returns true if the byte ranges of a and b overlap
+func (a *FileLock) overlaps(b *FileLock) bool {
+	return a.Start <= b.End && b.Start <= a.End
+}
+
+// conflicts returns true if a and b can't both be held
+func (a *FileLock) conflicts(b *FileLock) bool {
+	return a.Owner != b.Owner && a.Flock == b.Flock && a.overlaps(b) &&
+		(a.Type == LockWrite || b.Type == LockWrite)
+}
+
+// fileLocks is the locks held on a single file
+type fileLocks struct {
+	locks      []FileLock
+	changed    chan struct{} // closed when the locks change
+	acquiring  bool          // set while the lock on the remote is being taken
+	remoteHeld bool          // set if we hold the lock on the remote
+}
+
+// notify wakes anything waiting for the locks to change
+func (fl *fileLocks) notify() {
+	close(fl.changed)
+	fl.changed = make(chan struct{})
+}
+
+// conflict returns the first lock which conflicts with lk or nil
+func (fl *fileLocks) conflict(lk *FileLock) *FileLock {
+	for i := range fl.locks {
+		if fl.locks[i].conflicts(lk) {
+			return &fl.locks[i]
+		}
+	}
+	return nil
+}
+
+// hasWrite returns true if any exclusive locks are held
+func (fl *fileLocks) hasWrite() bool {
+	for i := range fl.locks {
+		if fl.locks[i].Type == LockWrite {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets the locks of lk.Owner in the range of lk to lk.Type,
+// splitting any existing locks of that owner which straddle it.
+func (fl *fileLocks) apply(lk *FileLock) {
+	locks := fl.locks[:0:0]
+	for _, old := range fl.locks {
+		if old.Owner != lk.Owner || old.Flock != lk.Flock || !old.overlaps(lk) {
+			locks = append(locks, old)
+			continue
+		}
+		if old.Start < lk.Start {
+			before := old
+			before.End = lk.Start - 1
+			locks = append(locks, before)
+		}
+		if old.End > lk.End {
+			after := old
+			after.Start = lk.End + 1
+			locks = append(locks, after)
+		}
+	}
+	if lk.Type != LockUnlock {
+		locks = append(locks, *lk)
+	}
+	fl.locks = locks
+}
+
+// release removes all the locks held by owner, returning true if
+// any were removed.
+func (fl *fileLocks) release(owner uint64) bool {
+	locks := fl.locks[:0]
+	for _, lk := range fl.locks {
+		if lk.Owner != owner {
+			locks = append(locks, lk)
+		}
+	}
+	found := len(locks) != len(fl.locks)
+	fl.locks = locks
+	return found
+}
+
+// lockManager holds the advisory locks of a VFS indexed by path
+type lockManager struct {
+	mu     sync.Mutex
+	files  map[string]*fileLocks
+	remote *remoteLocks // nil if locks aren't shared on the remote
+}
+
+// newLockManager makes a lockManager, sharing write locks in lock
+// files on the remote if --vfs-lock-remote is set.
+func newLockManager(vfs *VFS) *lockManager {
+	m := &lockManager{
+		files: make(map[string]*fileLocks),
+	}
+	if vfs.Opt.LockRemote != "" {
+		if !vfs.Opt.Locks {
+			fs.Logf(vfs.f, "Mounts won't pass locks to rclone without --vfs-locks so --vfs-lock-remote will only be used by rclone itself")
+		}
+		remote, err := newRemoteLocks(context.Background(), vfs.Opt.LockRemote, vfs.Opt.LockExpiry)
+		if err != nil {
+			fs.Errorf(vfs.f, "Not sharing locks: %v", err)
+		} else {
+			m.remote = remote
+		}
+	}
+	return m
+}
+
+// _get returns the locks for path, creating them if create is set
+//
+// Call with mu held
+func (m *lockManager) _get(path string, create bool) *fileLocks {
+	fl := m.files[path]
+	if fl == nil && create {
+		fl = &fileLocks{changed: make(chan struct{})}
+		m.files[path] = fl
+	}
+	return fl
+}
+
+// _tidy removes path if it has no locks left. It returns true if
+// the lock on the remote is no longer needed and must be released by
+// the caller.
+//
+// Call with mu held
+func (m *lockManager) _tidy(path string, fl *fileLocks) (releaseRemote bool) {
+	if fl.remoteHeld && !fl.hasWrite() {
+		fl.remoteHeld = false
+		releaseRemote = true
+	}
+	if len(fl.locks) == 0 && !fl.acquiring && !fl.remoteHeld {
+		delete(m.files, path)
+	}
+	return releaseRemote
+}
+
+// getLock returns the first lock on path which conflicts with lk or
+// a lock of type LockUnlock if there isn't one.
+func (m *lockManager) getLock(path string, lk FileLock) FileLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if fl := m._get(path, false); fl != nil {
+		if conflict := fl.conflict(&lk); conflict != nil {
+			return *conflict
+		}
+	}
+	lk.Type = LockUnlock
+	return lk
+}
+
+// setLock takes, changes or releases the lock lk on path
+//
+// If wait is set it blocks until the lock can be taken or ctx is
+// cancelled, otherwise it returns EAGAIN if the lock is held by
+// someone else.
+func (m *lockManager) setLock(ctx context.Context, path string, lk FileLock, wait bool) error {
+	if lk.Flock {
+		lk.Start, lk.End = 0, LockEOF
+	}
+	if lk.Start > lk.End {
+		return EINVAL
+	}
+	m.mu.Lock()
+	for {
+		fl := m._get(path, true)
+		changed := fl.changed
+		if lk.Type != LockUnlock && fl.conflict(&lk) != nil {
+			releaseRemote := m._tidy(path, fl)
+			m.mu.Unlock()
+			m.releaseRemote(path, releaseRemote)
+			if !wait {
+				return EAGAIN
+			}
+			if err := waitChanged(ctx, changed); err != nil {
+				return err
+			}
+			m.mu.Lock()
+			continue
+		}
+
+		// Take the lock on the remote before the first exclusive lock
+		if m.remote != nil && lk.Type == LockWrite && !fl.remoteHeld {
+			if fl.acquiring {
+				m.mu.Unlock()
+				if err := waitChanged(ctx, changed); err != nil {
+					return err
+				}
+				m.mu.Lock()
+				continue
+			}
+			fl.acquiring = true
+			m.mu.Unlock()
+			err := m.remote.lock(ctx, path, wait)
+			m.mu.Lock()
+			fl.acquiring = false
+			fl.remoteHeld = err == nil
+			fl.notify()
+			if err != nil {
+				m._tidy(path, fl)
+				m.mu.Unlock()
+				return err
+			}
+			// Check for conflicts again as the mutex was dropped
+			continue
+		}
+
+		fl.apply(&lk)
+		fl.notify()
+		releaseRemote := m._tidy(path, fl)
+		m.mu.Unlock()
+		m.releaseRemote(path, releaseRemote)
+		return nil
+	}
+}
+
+// waitChanged waits for changed to be closed or ctx to be cancelled
+func waitChanged(ctx context.Context, changed <-chan struct{}) error {
+	select {
+	case <-changed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseLocks releases all the locks held by owner on path
+func (m *lockManager) releaseLocks(path string, owner uint64) {
+	m.mu.Lock()
+	fl := m._get(path, false)
+	if fl == nil || !fl.release(owner) {
+		m.mu.Unlock()
+		return
+	}
+	fl.notify()
+	releaseRemote := m._tidy(path, fl)
+	m.mu.Unlock()
+	m.releaseRemote(path, releaseRemote)
+}
+
+// releaseRemote releases the lock on the remote for path if release
+// is set
+func (m *lockManager) releaseRemote(path string, release bool) {
+	if release {
+		m.remote.unlock(path)
+	}
+}
+
+// rename moves the locks held on oldPath to newPath
+//
+// The lock on the remote can't be renamed so is released and taken
+// again on newPath if possible.
+func (m *lockManager) rename(oldPath, newPath string) {
+	m.mu.Lock()
+	fl := m._get(oldPath, false)
+	if fl == nil || m.files[newPath] != nil {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.files, oldPath)
+	m.files[newPath] = fl
+	relock := fl.remoteHeld
+	m.mu.Unlock()
+	if relock {
+		m.remote.unlock(oldPath)
+		if err := m.remote.lock(context.Background(), newPath, false); err != nil {
+			fs.Errorf(newPath, "Failed to take lock on remote after rename: %v", err)
+			m.mu.Lock()
+			fl.remoteHeld = false
+			m.mu.Unlock()
+		}
+	}
+}
+
+// shutdown releases all the locks on the remote
+func (m *lockManager) shutdown() {
+	if m.remote != nil {
+		m.remote.shutdown()
+	}
+}
+
+// handleLockOwner returns the owner used for locks taken with
+// Handle.Lock which is unique for each open handle.
+func handleLockOwner(h Handle) uint64 {
+	return uint64(reflect.ValueOf(h).Pointer())
+}
+
+// lockHandle takes an exclusive flock(2) style lock on the file of
+// h, waiting until it is available.
+func lockHandle(h Handle, f *File) error {
+	return f.SetLock(context.Background(), FileLock{
+		Type:  LockWrite,
+		Owner: handleLockOwner(h),
+		Flock: true,
+	}, true)
+}
+
+// unlockHandle releases the lock taken with lockHandle
+func unlockHandle(h Handle, f *File) error {
+	return f.SetLock(context.Background(), FileLock{
+		Type:  LockUnlock,
+		Owner: handleLockOwner(h),
+		Flock: true,
+	}, false)
+}
+
+// GetLock returns a lock held on the file which would stop lk being
+// taken, or lk with Type set to LockUnlock if it could be taken.
+func (f *File) GetLock(lk FileLock) FileLock {
+	return f.d.vfs.locks.getLock(f.Path(), lk)
+}
+
+// SetLock takes, changes or releases (if lk.Type is LockUnlock) the
+// advisory lock lk on the file.
+//
+// If wait is set it waits until the lock is available or ctx is
+// cancelled, otherwise it returns EAGAIN if it is held by another
+// owner.
+//
+// If --vfs-lock-remote is set then the first exclusive lock on the
+// file also takes a lock on the remote which is released with the
+// last one.
+func (f *File) SetLock(ctx context.Context, lk FileLock, wait bool) error {
+	return f.d.vfs.locks.setLock(ctx, f.Path(), lk, wait)
+}
+
+// ReleaseLocks releases all the locks held by owner on the file
+func (f *File) ReleaseLocks(owner uint64) {
+	f.d.vfs.locks.releaseLocks(f.Path(), owner)
+}
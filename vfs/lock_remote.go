@@ -0,0 +1,228 @@
+// Sharing locks between rclone instances with lock files on a remote
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+)
+
+const (
+	// lockFileSuffix is added to the path of a file to make the
+	// name of its lock file
+	lockFileSuffix = ".lock"
+	// remoteLockPoll is how often to retry a lock held elsewhere
+	remoteLockPoll = time.Second
+)
+
+// lockFile is the contents of a lock file on the remote
+type lockFile struct {
+	Owner   string    `json:"owner"`   // instance holding the lock
+	Expires time.Time `json:"expires"` // lock is stale after this
+}
+
+// remoteLocks shares exclusive locks with other rclone instances
+// using lock files on a remote.
+//
+// Backends can't create objects atomically so this is best effort:
+// after writing a lock file it is read back to check nobody else
+// wrote theirs at the same time. Lock files are refreshed while held
+// so the locks of instances which die expire.
+type remoteLocks struct {
+	f      fs.Fs
+	id     string        // identifies this instance in lock files
+	expiry time.Duration // lock files not refreshed for this long are stale
+	mu     sync.Mutex
+	held   map[string]*heldLock // locks we hold by path
+}
+
+// heldLock is a lock held on the remote
+type heldLock struct {
+	cancel context.CancelFunc // stops the refresh
+	done   chan struct{}      // closed when the refresh has stopped
+}
+
+// newRemoteLocks makes a remoteLocks storing lock files in fsPath
+func newRemoteLocks(ctx context.Context, fsPath string, expiry fs.Duration) (*remoteLocks, error) {
+	f, err := cache.Get(ctx, fsPath)
+	if err != nil && !errors.Is(err, fs.ErrorIsFile) {
+		return nil, fmt.Errorf("failed to make lock remote %q: %w", fsPath, err)
+	}
+	if expiry <= 0 {
+		return nil, errors.New("--vfs-lock-expiry must be greater than 0")
+	}
+	hostname, _ := os.Hostname()
+	return &remoteLocks{
+		f:      f,
+		id:     fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), random.String(8)),
+		expiry: time.Duration(expiry),
+		held:   make(map[string]*heldLock),
+	}, nil
+}
+
+// read reads the lock file for path returning nil if there isn't one
+func (r *remoteLocks) read(ctx context.Context, path string) (lf *lockFile, err error) {
+	o, err := r.f.NewObject(ctx, path+lockFileSuffix)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	lf = new(lockFile)
+	if err = json.Unmarshal(data, lf); err != nil {
+		fs.Errorf(path, "Ignoring corrupted lock file: %v", err)
+		return nil, nil
+	}
+	return lf, nil
+}
+
+// write writes our lock file for path
+func (r *remoteLocks) write(ctx context.Context, path string) error {
+	data, err := json.Marshal(lockFile{
+		Owner:   r.id,
+		Expires: time.Now().Add(r.expiry),
+	})
+	if err != nil {
+		return err
+	}
+	info := object.NewStaticObjectInfo(path+lockFileSuffix, time.Now(), int64(len(data)), true, nil, r.f)
+	_, err = r.f.Put(ctx, bytes.NewReader(data), info)
+	return err
+}
+
+// tryLock takes the lock on path returning EAGAIN if it is held by
+// another instance.
+func (r *remoteLocks) tryLock(ctx context.Context, path string) error {
+	lf, err := r.read(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to read lock file: %w", err)
+	}
+	if lf != nil && lf.Owner != r.id && time.Now().Before(lf.Expires) {
+		fs.Debugf(path, "Locked on the remote by %q", lf.Owner)
+		return EAGAIN
+	}
+	if err := r.write(ctx, path); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	// Check we won any race to write the lock file
+	lf, err = r.read(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to read lock file: %w", err)
+	}
+	if lf == nil || lf.Owner != r.id {
+		fs.Debugf(path, "Lost race for the lock on the remote")
+		return EAGAIN
+	}
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	h := &heldLock{cancel: cancel, done: make(chan struct{})}
+	r.mu.Lock()
+	r.held[path] = h
+	r.mu.Unlock()
+	go r.refresh(refreshCtx, path, h.done)
+	return nil
+}
+
+// lock takes the lock on path. If wait is set it retries until it is
+// taken or ctx is cancelled, otherwise it returns EAGAIN if it is held
+// by another instance.
+func (r *remoteLocks) lock(ctx context.Context, path string, wait bool) error {
+	for {
+		err := r.tryLock(ctx, path)
+		if err != EAGAIN || !wait {
+			return err
+		}
+		select {
+		case <-time.After(remoteLockPoll):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// refresh rewrites the lock file for path until ctx is cancelled so
+// it doesn't expire, closing done when finished
+func (r *remoteLocks) refresh(ctx context.Context, path string, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(r.expiry / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.write(ctx, path); err != nil {
+				fs.Errorf(path, "Failed to refresh lock file: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// unlock releases the lock on path, removing the lock file if it
+// is still ours.
+func (r *remoteLocks) unlock(path string) {
+	r.mu.Lock()
+	h := r.held[path]
+	delete(r.held, path)
+	r.mu.Unlock()
+	if h == nil {
+		return
+	}
+	h.cancel()
+	<-h.done
+	ctx := context.Background()
+	lf, err := r.read(ctx, path)
+	if err != nil {
+		fs.Errorf(path, "Failed to read lock file: %v", err)
+		return
+	}
+	if lf == nil {
+		fs.Errorf(path, "Lock file was removed while we held it")
+		return
+	}
+	if lf.Owner != r.id {
+		fs.Errorf(path, "Lock file was taken by %q while we held it", lf.Owner)
+		return
+	}
+	o, err := r.f.NewObject(ctx, path+lockFileSuffix)
+	if err == nil {
+		err = o.Remove(ctx)
+	}
+	if err != nil {
+		fs.Errorf(path, "Failed to remove lock file: %v", err)
+	}
+}
+
+// shutdown releases all the locks held
+func (r *remoteLocks) shutdown() {
+	r.mu.Lock()
+	paths := make([]string, 0, len(r.held))
+	for path := range r.held {
+		paths = append(paths, path)
+	}
+	r.mu.Unlock()
+	for _, path := range paths {
+		r.unlock(path)
+	}
+}
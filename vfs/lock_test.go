@@ -0,0 +1,213 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLocksApply(t *testing.T) {
+	var fl fileLocks
+	fl.apply(&FileLock{Start: 0, End: 99, Type: LockRead, Owner: 1})
+	fl.apply(&FileLock{Start: 10, End: 19, Type: LockWrite, Owner: 1})
+	fl.apply(&FileLock{Start: 0, End: LockEOF, Type: LockRead, Owner: 2, Flock: true})
+	assert.Equal(t, []FileLock{
+		{Start: 0, End: 9, Type: LockRead, Owner: 1},
+		{Start: 20, End: 99, Type: LockRead, Owner: 1},
+		{Start: 10, End: 19, Type: LockWrite, Owner: 1},
+		{Start: 0, End: LockEOF, Type: LockRead, Owner: 2, Flock: true},
+	}, fl.locks)
+
+	// Unlocking the middle splits the lock
+	fl.apply(&FileLock{Start: 50, End: 59, Type: LockUnlock, Owner: 1})
+	assert.ElementsMatch(t, []FileLock{
+		{Start: 0, End: 9, Type: LockRead, Owner: 1},
+		{Start: 10, End: 19, Type: LockWrite, Owner: 1},
+		{Start: 0, End: LockEOF, Type: LockRead, Owner: 2, Flock: true},
+		{Start: 20, End: 49, Type: LockRead, Owner: 1},
+		{Start: 60, End: 99, Type: LockRead, Owner: 1},
+	}, fl.locks)
+
+	assert.True(t, fl.release(1))
+	assert.False(t, fl.release(1))
+	assert.Len(t, fl.locks, 1)
+}
+
+func TestFileLockConflicts(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		a, b FileLock
+		want bool
+	}{
+		{"ReadRead", FileLock{End: 9, Type: LockRead, Owner: 1}, FileLock{End: 9, Type: LockRead, Owner: 2}, false},
+		{"ReadWrite", FileLock{End: 9, Type: LockRead, Owner: 1}, FileLock{End: 9, Type: LockWrite, Owner: 2}, true},
+		{"SameOwner", FileLock{End: 9, Type: LockWrite, Owner: 1}, FileLock{End: 9, Type: LockWrite, Owner: 1}, false},
+		{"NoOverlap", FileLock{End: 9, Type: LockWrite, Owner: 1}, FileLock{Start: 10, End: 19, Type: LockWrite, Owner: 2}, false},
+		{"Touching", FileLock{End: 10, Type: LockWrite, Owner: 1}, FileLock{Start: 10, End: 19, Type: LockWrite, Owner: 2}, true},
+		{"FlockAndPosix", FileLock{End: LockEOF, Type: LockWrite, Owner: 1, Flock: true}, FileLock{End: 9, Type: LockWrite, Owner: 2}, false},
+	} {
+		assert.Equal(t, test.want, test.a.conflicts(&test.b), test.name)
+		assert.Equal(t, test.want, test.b.conflicts(&test.a), test.name)
+	}
+}
+
+func TestFileSetLock(t *testing.T) {
+	_, _, file, _ := fileCreate(t, vfscommon.CacheModeOff)
+	ctx := context.Background()
+
+	// Shared locks can overlap
+	require.NoError(t, file.SetLock(ctx, FileLock{Start: 0, End: 9, Type: LockRead, Owner: 1}, false))
+	require.NoError(t, file.SetLock(ctx, FileLock{Start: 5, End: 14, Type: LockRead, Owner: 2}, false))
+
+	// But not with exclusive ones
+	assert.Equal(t, EAGAIN, file.SetLock(ctx, FileLock{Start: 8, End: 8, Type: LockWrite, Owner: 3}, false))
+	conflict := file.GetLock(FileLock{Start: 8, End: 8, Type: LockWrite, Owner: 3})
+	assert.Equal(t, LockRead, conflict.Type)
+	assert.Equal(t, uint64(0), conflict.Start)
+	assert.Equal(t, uint64(9), conflict.End)
+	noConflict := file.GetLock(FileLock{Start: 20, End: 29, Type: LockWrite, Owner: 3})
+	assert.Equal(t, LockUnlock, noConflict.Type)
+
+	// flock locks don't interact with fcntl locks
+	require.NoError(t, file.SetLock(ctx, FileLock{Type: LockWrite, Owner: 3, Flock: true}, false))
+	assert.Equal(t, EAGAIN, file.SetLock(ctx, FileLock{Type: LockRead, Owner: 4, Flock: true}, false))
+
+	// Wait for a lock to be released
+	done := make(chan error)
+	go func() {
+		done <- file.SetLock(ctx, FileLock{Start: 0, End: LockEOF, Type: LockWrite, Owner: 3}, true)
+	}()
+	require.NoError(t, file.SetLock(ctx, FileLock{Start: 0, End: 9, Type: LockUnlock, Owner: 1}, false))
+	select {
+	case <-done:
+		t.Fatal("lock taken while still held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	file.ReleaseLocks(2)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for lock")
+	}
+
+	// Waiting can be cancelled
+	cancelCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, file.SetLock(cancelCtx, FileLock{Type: LockRead, Owner: 1}, true))
+
+	assert.Equal(t, EINVAL, file.SetLock(ctx, FileLock{Start: 10, End: 9, Type: LockRead, Owner: 1}, false))
+}
+
+func TestFileLockRename(t *testing.T) {
+	_, vfs, file, _ := fileCreate(t, vfscommon.CacheModeOff)
+	ctx := context.Background()
+	require.NoError(t, file.SetLock(ctx, FileLock{End: LockEOF, Type: LockWrite, Owner: 1}, false))
+	require.NoError(t, vfs.Rename("dir/file1", "dir/file2"))
+	node, err := vfs.Stat("dir/file2")
+	require.NoError(t, err)
+	file2 := node.(*File)
+	assert.Equal(t, EAGAIN, file2.SetLock(ctx, FileLock{Type: LockRead, Owner: 2}, false))
+	file2.ReleaseLocks(1)
+	assert.NoError(t, file2.SetLock(ctx, FileLock{Type: LockRead, Owner: 2}, false))
+}
+
+func TestHandleLock(t *testing.T) {
+	_, vfs, file, _ := fileCreate(t, vfscommon.CacheModeOff)
+	fh1, err := vfs.OpenFile("dir/file1", os.O_RDONLY, 0)
+	require.NoError(t, err)
+	fh2, err := vfs.OpenFile("dir/file1", os.O_RDONLY, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, fh1.Lock())
+	assert.Equal(t, LockWrite, file.GetLock(FileLock{Type: LockRead, Owner: 1, Flock: true}).Type)
+	require.NoError(t, fh1.Unlock())
+	require.NoError(t, fh2.Lock())
+
+	// Closing the handle releases its locks
+	require.NoError(t, fh2.Close())
+	assert.Equal(t, LockUnlock, file.GetLock(FileLock{Type: LockRead, Owner: 1, Flock: true}).Type)
+	require.NoError(t, fh1.Close())
+}
+
+func TestRemoteLocks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	r1, err := newRemoteLocks(ctx, dir, fs.Duration(time.Minute))
+	require.NoError(t, err)
+	r2, err := newRemoteLocks(ctx, dir, fs.Duration(time.Minute))
+	require.NoError(t, err)
+	assert.NotEqual(t, r1.id, r2.id)
+	lockPath := filepath.Join(dir, "sub", "file.txt.lock")
+
+	require.NoError(t, r1.lock(ctx, "sub/file.txt", false))
+	assert.FileExists(t, lockPath)
+	assert.Equal(t, EAGAIN, r2.lock(ctx, "sub/file.txt", false))
+
+	// Wait for the lock
+	done := make(chan error)
+	go func() {
+		done <- r2.lock(ctx, "sub/file.txt", true)
+	}()
+	r1.unlock("sub/file.txt")
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for lock")
+	}
+	r2.shutdown()
+	assert.NoFileExists(t, lockPath)
+
+	// Stale lock files are ignored
+	r1.expiry = -time.Second
+	require.NoError(t, r1.write(ctx, "file2"))
+	require.NoError(t, r2.lock(ctx, "file2", false))
+	r2.shutdown()
+}
+
+func TestFileSetLockRemote(t *testing.T) {
+	lockDir := t.TempDir()
+	opt := vfscommon.Opt
+	opt.LockRemote = lockDir
+	_, vfs := newTestVFSOpt(t, &opt)
+	require.NoError(t, vfs.WriteFile("file1", []byte("hello"), 0666))
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	file := node.(*File)
+	ctx := context.Background()
+	lockPath := filepath.Join(lockDir, "file1.lock")
+
+	// Shared locks don't take the lock on the remote
+	require.NoError(t, file.SetLock(ctx, FileLock{End: LockEOF, Type: LockRead, Owner: 1}, false))
+	assert.NoFileExists(t, lockPath)
+
+	// Exclusive locks do
+	require.NoError(t, file.SetLock(ctx, FileLock{End: LockEOF, Type: LockWrite, Owner: 1}, false))
+	assert.FileExists(t, lockPath)
+
+	// Another instance can't take it
+	other, err := newRemoteLocks(ctx, lockDir, fs.Duration(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, EAGAIN, other.lock(ctx, "file1", false))
+
+	// Downgrading releases it
+	require.NoError(t, file.SetLock(ctx, FileLock{End: LockEOF, Type: LockRead, Owner: 1}, false))
+	assert.NoFileExists(t, lockPath)
+
+	// An exclusive lock fails if another instance holds the lock
+	require.NoError(t, other.lock(ctx, "file1", false))
+	assert.Equal(t, EAGAIN, file.SetLock(ctx, FileLock{End: LockEOF, Type: LockWrite, Owner: 2}, false))
+	other.shutdown()
+	require.NoError(t, file.SetLock(ctx, FileLock{End: LockEOF, Type: LockUnlock, Owner: 1}, false))
+	require.NoError(t, file.SetLock(ctx, FileLock{End: LockEOF, Type: LockWrite, Owner: 2}, false))
+	file.ReleaseLocks(2)
+	assert.NoFileExists(t, lockPath)
+}
@@ -0,0 +1,183 @@
+// Permissions and ownership stored in metadata
+
+package vfs
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Unix file type bits as used in the "mode" metadata
+const (
+	modeTypeDir  = 0040000
+	modeTypeFile = 0100000
+	modeTypeLink = 0120000
+)
+
+// metadataPerms is the permissions and ownership read from metadata
+type metadataPerms struct {
+	mode    os.FileMode // permission bits
+	uid     uint32
+	gid     uint32
+	hasMode bool
+	hasUID  bool
+	hasGID  bool
+}
+
+// parseMetadataPerms reads the "mode", "uid" and "gid" keys from the
+// metadata in the format the local backend uses.
+func parseMetadataPerms(remote string, metadata fs.Metadata) (p metadataPerms) {
+	if value, ok := metadata["mode"]; ok {
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			fs.Debugf(remote, "failed to parse metadata mode: %q: %v", value, err)
+		} else {
+			p.mode = os.FileMode(mode) & os.ModePerm
+			p.hasMode = true
+		}
+	}
+	parseID := func(key string, id *uint32, has *bool) {
+		value, ok := metadata[key]
+		if !ok {
+			return
+		}
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			fs.Debugf(remote, "failed to parse metadata %s: %q: %v", key, value, err)
+			return
+		}
+		*id, *has = uint32(parsed), true
+	}
+	parseID("uid", &p.uid, &p.hasUID)
+	parseID("gid", &p.gid, &p.hasGID)
+	return p
+}
+
+// readPerms reads the permissions and ownership of n from its
+// metadata if --vfs-metadata-perms is set.
+//
+// The parsed permissions are kept on the node with its metadata so
+// they are only parsed again when the metadata changes.
+func readPerms(n xattrNode) (p metadataPerms) {
+	if !n.VFS().Opt.MetadataPerms {
+		return p
+	}
+	if cached := n.cachedPerms(); cached != nil {
+		return *cached
+	}
+	metadata, err := n.readMetadata()
+	if err != nil {
+		fs.Debugf(n.Path(), "failed to read metadata for permissions: %v", err)
+		return p
+	}
+	p = parseMetadataPerms(n.Path(), metadata)
+	n.setCachedPerms(p)
+	return p
+}
+
+// owner returns the uid and gid of n
+func owner(n xattrNode) (uid, gid uint32) {
+	opt := &n.VFS().Opt
+	uid, gid = opt.UID, opt.GID
+	p := readPerms(n)
+	if p.hasUID {
+		uid = p.uid
+	}
+	if p.hasGID {
+		gid = p.gid
+	}
+	return uid, gid
+}
+
+// chmod stores the permission bits of mode in the metadata of n.
+// modeType is the unix file type of n.
+func chmod(n xattrNode, mode os.FileMode, modeType uint32) error {
+	VFS := n.VFS()
+	if !VFS.Opt.MetadataPerms {
+		return nil
+	}
+	if VFS.readOnly(n.Path()) {
+		return EROFS
+	}
+	value := modeType | uint32(mode&os.ModePerm)
+	return n.writeMetadata(fs.Metadata{"mode": strconv.FormatUint(uint64(value), 8)})
+}
+
+// chown stores uid and gid in the metadata of n. A value of -1 means
+// don't change that id.
+func chown(n xattrNode, uid, gid int) error {
+	VFS := n.VFS()
+	if !VFS.Opt.MetadataPerms {
+		return nil
+	}
+	if VFS.readOnly(n.Path()) {
+		return EROFS
+	}
+	metadata := fs.Metadata{}
+	if uid >= 0 {
+		metadata["uid"] = strconv.Itoa(uid)
+	}
+	if gid >= 0 {
+		metadata["gid"] = strconv.Itoa(gid)
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return n.writeMetadata(metadata)
+}
+
+// Owner returns the uid and gid of the file
+//
+// If --vfs-metadata-perms is set these are read from the metadata,
+// otherwise they are --uid and --gid.
+func (f *File) Owner() (uid, gid uint32) {
+	return owner(f)
+}
+
+// Chmod changes the permission bits of the file
+//
+// If --vfs-metadata-perms is set these are stored in the "mode"
+// metadata, otherwise this does nothing.
+func (f *File) Chmod(mode os.FileMode) error {
+	modeType := uint32(modeTypeFile)
+	if f.IsSymlink() {
+		modeType = modeTypeLink
+	}
+	return chmod(f, mode, modeType)
+}
+
+// Chown changes the owner of the file
+//
+// If --vfs-metadata-perms is set these are stored in the "uid" and
+// "gid" metadata, otherwise this does nothing. Pass -1 to leave an id
+// unchanged.
+func (f *File) Chown(uid, gid int) error {
+	return chown(f, uid, gid)
+}
+
+// Owner returns the uid and gid of the directory
+//
+// If --vfs-metadata-perms is set these are read from the metadata,
+// otherwise they are --uid and --gid.
+func (d *Dir) Owner() (uid, gid uint32) {
+	return owner(d)
+}
+
+// Chmod changes the permission bits of the directory
+//
+// If --vfs-metadata-perms is set these are stored in the "mode"
+// metadata, otherwise this does nothing.
+func (d *Dir) Chmod(mode os.FileMode) error {
+	return chmod(d, mode, modeTypeDir)
+}
+
+// Chown changes the owner of the directory
+//
+// If --vfs-metadata-perms is set these are stored in the "uid" and
+// "gid" metadata, otherwise this does nothing. Pass -1 to leave an id
+// unchanged.
+func (d *Dir) Chown(uid, gid int) error {
+	return chown(d, uid, gid)
+}
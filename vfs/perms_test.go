@@ -0,0 +1,153 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMetadataPerms(t *testing.T) {
+	p := parseMetadataPerms("file", fs.Metadata{
+		"mode": "100640",
+		"uid":  "1000",
+		"gid":  "100",
+	})
+	assert.Equal(t, metadataPerms{
+		mode:    0640,
+		uid:     1000,
+		gid:     100,
+		hasMode: true,
+		hasUID:  true,
+		hasGID:  true,
+	}, p)
+
+	p = parseMetadataPerms("file", fs.Metadata{
+		"mode": "potato",
+		"uid":  "-1",
+	})
+	assert.Equal(t, metadataPerms{}, p)
+}
+
+// newPermsVFS makes a VFS with --vfs-metadata-perms
+func newPermsVFS(t *testing.T, mode vfscommon.CacheMode) *VFS {
+	opt := vfscommon.Opt
+	opt.CacheMode = mode
+	opt.WriteBack = writeBackDelay
+	opt.MetadataPerms = true
+	_, vfs := newTestVFSOpt(t, &opt)
+	if !vfs.f.Features().ReadMetadata {
+		t.Skip("backend doesn't support metadata")
+	}
+	return vfs
+}
+
+func TestPermsDisabled(t *testing.T) {
+	_, vfs, file, _ := fileCreate(t, vfscommon.CacheModeOff)
+	mode := file.Mode()
+	require.NoError(t, file.Chmod(0600))
+	require.NoError(t, file.Chown(1234, 5678))
+	assert.Equal(t, mode, file.Mode())
+	uid, gid := file.Owner()
+	assert.Equal(t, vfs.Opt.UID, uid)
+	assert.Equal(t, vfs.Opt.GID, gid)
+}
+
+func TestPermsFile(t *testing.T) {
+	vfs := newPermsVFS(t, vfscommon.CacheModeOff)
+	require.NoError(t, vfs.WriteFile("file1", []byte("hello"), 0666))
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+
+	err = node.Chmod(0751)
+	if err == ENOTSUP {
+		t.Skip("backend can't set metadata")
+	}
+	require.NoError(t, err)
+	metadata, err := fs.GetMetadata(context.Background(), node.DirEntry().(fs.Object))
+	require.NoError(t, err)
+	if _, found := metadata["mode"]; !found {
+		t.Skip("backend doesn't store mode in metadata")
+	}
+	assert.Equal(t, os.FileMode(0751), node.Mode().Perm())
+	assert.True(t, node.Mode().IsRegular())
+
+	uid, gid := node.Owner()
+	err = node.Chown(int(uid), -1)
+	require.NoError(t, err)
+	newUID, newGID := node.Owner()
+	assert.Equal(t, uid, newUID)
+	assert.Equal(t, gid, newGID)
+
+	vfs.Opt.ReadOnly = true
+	assert.Equal(t, EROFS, node.Chmod(0600))
+	assert.Equal(t, EROFS, node.Chown(0, 0))
+}
+
+func TestPermsCached(t *testing.T) {
+	vfs := newPermsVFS(t, vfscommon.CacheModeOff)
+	require.NoError(t, vfs.WriteFile("file1", []byte("hello"), 0666))
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	file := node.(*File)
+
+	// The permissions are kept once read
+	assert.Nil(t, file.cachedPerms())
+	_ = file.Mode()
+	assert.NotNil(t, file.cachedPerms())
+
+	// Chmod invalidates them
+	err = file.Chmod(0751)
+	if err == ENOTSUP {
+		t.Skip("backend can't set metadata")
+	}
+	require.NoError(t, err)
+	assert.Nil(t, file.cachedPerms())
+	_ = file.Mode()
+	require.NotNil(t, file.cachedPerms())
+
+	// As does Chown
+	require.NoError(t, file.Chown(1234, -1))
+	assert.Nil(t, file.cachedPerms())
+	_, _ = file.Owner()
+	assert.NotNil(t, file.cachedPerms())
+
+	// And a new object
+	file.setObjectNoUpdate(file.getObject())
+	assert.Nil(t, file.cachedPerms())
+}
+
+func TestPermsPendingUpload(t *testing.T) {
+	vfs := newPermsVFS(t, vfscommon.CacheModeWrites)
+	if !vfs.f.Features().WriteMetadata {
+		t.Skip("backend doesn't support writing metadata")
+	}
+
+	fd, err := vfs.OpenFile("file1", os.O_CREATE|os.O_WRONLY, 0666)
+	require.NoError(t, err)
+	_, err = fd.Write([]byte("hello"))
+	require.NoError(t, err)
+	node := fd.Node()
+
+	// Change the mode while the file is being written
+	require.NoError(t, node.Chmod(0604))
+	assert.Equal(t, os.FileMode(0604), node.Mode().Perm())
+
+	require.NoError(t, fd.Close())
+	vfs.WaitForWriters(waitForWritersDelay)
+
+	// Check the mode was uploaded with the file
+	o, err := vfs.f.NewObject(context.Background(), "file1")
+	require.NoError(t, err)
+	metadata, err := fs.GetMetadata(context.Background(), o)
+	require.NoError(t, err)
+	if _, found := metadata["mode"]; !found {
+		t.Skip("backend doesn't store mode in metadata")
+	}
+	p := parseMetadataPerms("file1", metadata)
+	assert.Equal(t, os.FileMode(0604), p.mode)
+}
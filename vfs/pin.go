@@ -0,0 +1,302 @@
+// Pinning files and directories so they are kept in the cache
+
+package vfs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// pinBufferSize is the size of the buffer used to read pinned files
+const pinBufferSize = 1024 * 1024
+
+// pinner downloads pinned files and directories into the cache in
+// the background.
+//
+// The pins themselves are kept in the cache so it doesn't purge
+// them. The pinner just makes sure the data is there.
+type pinner struct {
+	vfs  *VFS
+	wake chan struct{} // kicked when something is added to the queue
+
+	mu         sync.Mutex
+	queue      []string // pinned paths waiting to be downloaded
+	current    string   // pinned path being downloaded
+	filesTotal int64    // number of files found in pinned paths
+	filesDone  int64    // number of those files downloaded
+	bytesTotal int64    // size of files found in pinned paths
+	bytesDone  int64    // bytes of those files downloaded
+	errors     int64    // number of errors
+	lastError  string   // the last error
+}
+
+// newPinner makes a pinner for vfs which runs until ctx is cancelled
+func newPinner(ctx context.Context, vfs *VFS) *pinner {
+	p := &pinner{
+		vfs:  vfs,
+		wake: make(chan struct{}, 1),
+	}
+	go p.run(ctx)
+	return p
+}
+
+// add queues pinPath to be downloaded
+func (p *pinner) add(pinPath string) {
+	p.mu.Lock()
+	found := false
+	for _, queued := range p.queue {
+		if queued == pinPath {
+			found = true
+			break
+		}
+	}
+	if !found {
+		p.queue = append(p.queue, pinPath)
+	}
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove takes pinPath off the download queue
+func (p *pinner) remove(pinPath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queue := p.queue[:0]
+	for _, queued := range p.queue {
+		if queued != pinPath {
+			queue = append(queue, queued)
+		}
+	}
+	p.queue = queue
+}
+
+// next takes the next path off the queue returning false if it is
+// empty
+func (p *pinner) next() (pinPath string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) == 0 {
+		return "", false
+	}
+	pinPath = p.queue[0]
+	p.queue = p.queue[1:]
+	p.current = pinPath
+	return pinPath, true
+}
+
+// run downloads pinned paths as they are queued until ctx is
+// cancelled
+func (p *pinner) run(ctx context.Context) {
+	for {
+		pinPath, ok := p.next()
+		if !ok {
+			select {
+			case <-p.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		p.download(ctx, pinPath)
+		p.mu.Lock()
+		p.current = ""
+		p.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// error records an error downloading pinPath
+func (p *pinner) error(pinPath string, err error) {
+	fs.Errorf(pinPath, "Failed to download pinned file: %v", err)
+	p.mu.Lock()
+	p.errors++
+	p.lastError = fmt.Sprintf("%s: %v", pinPath, err)
+	p.mu.Unlock()
+}
+
+// download downloads all the files in pinPath into the cache
+func (p *pinner) download(ctx context.Context, pinPath string) {
+	if !p.vfs.cache.IsPinned(pinPath) {
+		return
+	}
+	node, err := p.vfs.Stat(pinPath)
+	if err != nil {
+		p.error(pinPath, err)
+		return
+	}
+	var files []*File
+	switch x := node.(type) {
+	case *File:
+		files = append(files, x)
+	case *Dir:
+		files, err = pinFiles(x, files)
+		if err != nil {
+			p.error(pinPath, err)
+		}
+	}
+	fs.Debugf(pinPath, "Downloading %d pinned files", len(files))
+	p.mu.Lock()
+	p.filesTotal += int64(len(files))
+	for _, file := range files {
+		p.bytesTotal += file.Size()
+	}
+	p.mu.Unlock()
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		// skip files which have been unpinned while we were busy
+		if p.vfs.cache.IsPinned(file.Path()) {
+			if err := p.downloadFile(ctx, file); err != nil && ctx.Err() == nil {
+				p.error(file.Path(), err)
+			}
+		}
+		p.mu.Lock()
+		p.filesDone++
+		p.mu.Unlock()
+	}
+}
+
+// pinFiles appends all the files in dir and its subdirectories to files
+func pinFiles(dir *Dir, files []*File) ([]*File, error) {
+	nodes, err := dir.ReadDirAll()
+	if err != nil {
+		return files, err
+	}
+	for _, node := range nodes {
+		switch x := node.(type) {
+		case *File:
+			files = append(files, x)
+		case *Dir:
+			files, err = pinFiles(x, files)
+			if err != nil {
+				return files, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// downloadFile reads file through the cache so all its data is stored
+// there
+func (p *pinner) downloadFile(ctx context.Context, file *File) (err error) {
+	fh, err := file.Open(os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(fh, &err)
+	_, err = io.CopyBuffer(&pinWriter{ctx: ctx, p: p}, fh, make([]byte, pinBufferSize))
+	return err
+}
+
+// pinWriter counts the bytes downloaded and stops the download when
+// ctx is cancelled
+type pinWriter struct {
+	ctx context.Context
+	p   *pinner
+}
+
+// Write discards b after counting it
+func (w *pinWriter) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	w.p.mu.Lock()
+	w.p.bytesDone += int64(len(b))
+	w.p.mu.Unlock()
+	return len(b), nil
+}
+
+// stats returns the pins and the progress downloading them
+func (p *pinner) stats() rc.Params {
+	pins := p.vfs.cache.Pins()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return rc.Params{
+		"paths":      pins,
+		"queued":     len(p.queue),
+		"current":    p.current,
+		"filesTotal": p.filesTotal,
+		"filesDone":  p.filesDone,
+		"bytesTotal": p.bytesTotal,
+		"bytesDone":  p.bytesDone,
+		"errors":     p.errors,
+		"lastError":  p.lastError,
+	}
+}
+
+// cleanPinPath returns name in the form used for pins
+func cleanPinPath(name string) string {
+	name = path.Clean(strings.Trim(name, "/"))
+	if name == "." {
+		name = ""
+	}
+	return name
+}
+
+// Pin keeps name, a file or a directory, downloaded in the cache.
+//
+// The files are downloaded in the background and aren't removed from
+// the cache by --vfs-cache-max-age or --vfs-cache-max-size while they
+// stay pinned. Files added to a pinned directory later are only
+// downloaded when they are used or Pin is called again.
+//
+// This needs --vfs-cache-mode full.
+func (vfs *VFS) Pin(name string) error {
+	if vfs.pinner == nil {
+		return errors.New("pinning needs --vfs-cache-mode full")
+	}
+	name = cleanPinPath(name)
+	vfs.cache.Pin(name)
+	vfs.pinner.add(name)
+	return nil
+}
+
+// Unpin removes a pin added with Pin. The files stay in the cache but
+// can be removed from it as normal.
+func (vfs *VFS) Unpin(name string) error {
+	if vfs.pinner == nil {
+		return errors.New("pinning needs --vfs-cache-mode full")
+	}
+	name = cleanPinPath(name)
+	if !vfs.cache.Unpin(name) {
+		return fmt.Errorf("%q is not pinned", name)
+	}
+	vfs.pinner.remove(name)
+	return nil
+}
+
+// pinFrom pins the paths in the file pinFrom, one per line
+func (vfs *VFS) pinFrom(pinFrom string) (err error) {
+	in, err := os.Open(pinFrom)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if err := vfs.Pin(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
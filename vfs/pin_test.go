@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/ranges"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForPins waits for the pinned files to be downloaded
+func waitForPins(t *testing.T, vfs *VFS) rc.Params {
+	var stats rc.Params
+	require.Eventually(t, func() bool {
+		stats = vfs.pinner.stats()
+		return stats["queued"] == 0 && stats["current"] == "" && stats["filesDone"] == stats["filesTotal"]
+	}, 10*time.Second, 10*time.Millisecond)
+	return stats
+}
+
+// assertCached checks all the data of name is in the cache
+func assertCached(t *testing.T, vfs *VFS, name string, size int64) {
+	item := vfs.cache.Item(name)
+	assert.True(t, item.HasRange(ranges.Range{Pos: 0, Size: size}), name)
+}
+
+func TestPin(t *testing.T) {
+	ctx := context.Background()
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	r, vfs := newTestVFSOpt(t, &opt)
+	r.WriteObject(ctx, "project/a.txt", "contents of a", t1)
+	r.WriteObject(ctx, "project/sub/b.txt", "contents of b", t2)
+	r.WriteObject(ctx, "other.txt", "other", t3)
+
+	call := rc.Calls.Get("vfs/pin")
+	require.NotNil(t, call)
+	out, err := call.Fn(ctx, rc.Params{"path": "/project/"})
+	require.NoError(t, err)
+	assert.Equal(t, rc.Params{"pinned": []string{"project"}}, out)
+
+	stats := waitForPins(t, vfs)
+	assert.Equal(t, []string{"project"}, stats["paths"])
+	assert.Equal(t, int64(2), stats["filesDone"])
+	assert.Equal(t, int64(26), stats["bytesTotal"])
+	assert.Equal(t, int64(26), stats["bytesDone"])
+	assert.Equal(t, int64(0), stats["errors"])
+	assertCached(t, vfs, "project/a.txt", 13)
+	assertCached(t, vfs, "project/sub/b.txt", 13)
+	assert.False(t, vfs.cache.Exists("other.txt"))
+	assert.Equal(t, stats, vfs.Stats()["pins"])
+
+	// Pinning a missing path records an error
+	require.NoError(t, vfs.Pin("missing"))
+	stats = waitForPins(t, vfs)
+	assert.Equal(t, int64(1), stats["errors"])
+	assert.Contains(t, stats["lastError"], "missing")
+
+	// Unpin
+	call = rc.Calls.Get("vfs/unpin")
+	require.NotNil(t, call)
+	out, err = call.Fn(ctx, rc.Params{"path": "project", "path2": "missing"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"project", "missing"}, out["unpinned"])
+	assert.Equal(t, []string{}, vfs.cache.Pins())
+	_, err = call.Fn(ctx, rc.Params{"path": "project"})
+	assert.ErrorContains(t, err, "not pinned")
+	_, err = call.Fn(ctx, rc.Params{"file": "project"})
+	assert.ErrorContains(t, err, "unknown key")
+}
+
+func TestPinFrom(t *testing.T) {
+	ctx := context.Background()
+	pinFrom := filepath.Join(t.TempDir(), "pins")
+	require.NoError(t, os.WriteFile(pinFrom, []byte("# files to pin\n\n  file1  \n;dir\n"), 0600))
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.PinFrom = pinFrom
+	r, vfs := newTestVFSOpt(t, &opt)
+	r.WriteObject(ctx, "file1", "file1 contents", t1)
+
+	// The pins are read before the file is written so pin again
+	assert.Equal(t, []string{"file1"}, vfs.cache.Pins())
+	require.NoError(t, vfs.Pin("file1"))
+	waitForPins(t, vfs)
+	assertCached(t, vfs, "file1", 14)
+}
+
+func TestPinNeedsCacheModeFull(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeWrites
+	_, vfs := newTestVFSOpt(t, &opt)
+	assert.ErrorContains(t, vfs.Pin("file"), "--vfs-cache-mode full")
+	assert.ErrorContains(t, vfs.Unpin("file"), "--vfs-cache-mode full")
+	assert.Nil(t, vfs.Stats()["pins"])
+}
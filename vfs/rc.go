@@ -11,6 +11,7 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscache"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
 )
 
@@ -423,9 +424,25 @@ This returns stats for the selected VFS.
             "CacheMaxAge": 3600000000000,
             // ...
             "WriteWait": 1000000000
+        },
+        // Pinned paths and download progress - only present if --vfs-cache-mode full
+        "pins": {
+            "bytesDone": 1048576,
+            "bytesTotal": 4194304,
+            "current": "projects/website",
+            "errors": 0,
+            "filesDone": 3,
+            "filesTotal": 10,
+            "lastError": "",
+            "paths": ["projects/website"],
+            "queued": 0
         }
     }
 
+The "pins" totals count all the files found each time a path is
+pinned, so compare "filesDone" with "filesTotal" to see if the
+downloads are complete.
+
 ` + getVFSHelp,
 		Fn: rcStats,
 	})
@@ -439,6 +456,91 @@ func rcStats(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	return vfs.Stats(), nil
 }
 
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/pin",
+		Title: "Keep files or directories downloaded in the VFS cache.",
+		Help: strings.ReplaceAll(`
+This pins the paths passed in so their data is downloaded into the VFS
+cache in the background and kept there, so they can be used while the
+remote is unavailable.
+
+Pass files or directories in as |path=dir|. Any parameter key starting
+with |path| will be pinned, e.g.
+
+    rclone rc vfs/pin path=projects/website path2=notes.txt
+
+Pinned files are not removed from the cache by |--vfs-cache-max-age|
+or |--vfs-cache-max-size|. Files added to a pinned directory later are
+only downloaded when they are used or when |vfs/pin| is called again.
+Use |vfs/stats| to see the progress of the downloads.
+
+This needs |--vfs-cache-mode full|. Pins last until rclone exits - use
+|--vfs-pin-from| to pin paths every time rclone starts.
+
+It returns a list of the paths pinned under the key |pinned|.
+`, "|", "`") + getVFSHelp,
+		Fn: rcPin,
+	})
+}
+
+func rcPin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	return rcPinPaths(in, "pinned", (*VFS).Pin)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/unpin",
+		Title: "Remove pins added with vfs/pin.",
+		Help: strings.ReplaceAll(`
+This removes pins added with |vfs/pin| or |--vfs-pin-from|. The files
+stay in the VFS cache but can be removed from it as normal.
+
+Pass the paths pinned in as |path=dir|. Any parameter key starting
+with |path| will be unpinned, e.g.
+
+    rclone rc vfs/unpin path=projects/website
+
+It returns a list of the paths unpinned under the key |unpinned|, or
+an error if a path isn't pinned.
+`, "|", "`") + getVFSHelp,
+		Fn: rcUnpin,
+	})
+}
+
+func rcUnpin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	return rcPinPaths(in, "unpinned", (*VFS).Unpin)
+}
+
+// rcPinPaths calls fn on the VFS for each path parameter in in,
+// returning the paths under key.
+func rcPinPaths(in rc.Params, key string, fn func(*VFS, string) error) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(in) == 0 {
+		return nil, rc.NewErrParamInvalid(errors.New("need at least one path parameter"))
+	}
+	paths := []string{}
+	for k, v := range in {
+		if !strings.HasPrefix(k, "path") {
+			return nil, fmt.Errorf("unknown key %q", k)
+		}
+		path, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("value must be string %q=%v", k, v)
+		}
+		if err := fn(vfs, path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, cleanPinPath(path))
+	}
+	return rc.Params{
+		key: paths,
+	}, nil
+}
+
 func init() {
 	rc.Add(rc.Call{
 		Path:  "vfs/queue",
@@ -460,6 +562,8 @@ the |--vfs-cache-mode| is off, it will return an empty result.
                 "tries":     1,        // integer: number of times we have tried to upload
                 "delay":     5.0,      // float: seconds between upload attempts
                 "uploading": false,    // boolean: true if item is being uploaded
+                "failed":    false,    // boolean: true if rclone has given up uploading the item
+                "lastError": "",       // string: the error from the last upload attempt
             },
        ],
     }
@@ -471,6 +575,11 @@ only transfers |--transfers| files at once, only the lowest
 may be files with negative expiry times for which |uploading| is
 |false|.
 
+If |--vfs-write-back-max-tries| is set, rclone gives up uploading a
+file after that many failed tries and it is shown with |failed| as
+|true|. Use |vfs/queue-retry| to try it again or |vfs/queue-discard|
+to throw away its changes.
+
 `, "|", "`") + getVFSHelp,
 		Fn: rcQueue,
 	})
@@ -554,3 +663,75 @@ func rcQueueSetExpiry(ctx context.Context, in rc.Params) (out rc.Params, err err
 	err = vfs.cache.QueueSetExpiry(writeback.Handle(id), refTime, time.Duration(float64(time.Second)*expiry))
 	return nil, err
 }
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/queue-retry",
+		Title: "Retry the upload of an item in the upload queue.",
+		Help: strings.ReplaceAll(`
+
+Use this to upload an item in the upload queue now, including an item
+which rclone has given up uploading because it failed
+|--vfs-write-back-max-tries| times. The number of tries of the item
+is reset. You will need to read the |id| of the item using |vfs/queue|
+before using this call.
+
+This will return an error if called with |--vfs-cache-mode| off, if
+the |id| passed is not found or if the item is being uploaded.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |id| - a numeric ID as returned from |vfs/queue|
+
+This returns an empty result on success, or an error.
+
+`, "|", "`") + getVFSHelp,
+		Fn: func(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+			return rcQueueItem(in, (*vfscache.Cache).QueueRetry)
+		},
+	})
+	rc.Add(rc.Call{
+		Path:  "vfs/queue-discard",
+		Title: "Discard an item which has failed to upload.",
+		Help: strings.ReplaceAll(`
+
+Use this to remove an item which rclone has given up uploading because
+it failed |--vfs-write-back-max-tries| times from the upload queue and
+the cache. **The changes to the file which weren't uploaded are
+lost.** You will need to read the |id| of the item using |vfs/queue|
+before using this call.
+
+This will return an error if called with |--vfs-cache-mode| off, if
+the |id| passed is not found or if the item hasn't failed.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |id| - a numeric ID as returned from |vfs/queue|
+
+This returns an empty result on success, or an error.
+
+`, "|", "`") + getVFSHelp,
+		Fn: func(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+			return rcQueueItem(in, (*vfscache.Cache).QueueDiscard)
+		},
+	})
+}
+
+// rcQueueItem calls fn with the item in the upload queue with the id
+// passed in
+func rcQueueItem(in rc.Params, fn func(*vfscache.Cache, writeback.Handle) error) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.cache == nil {
+		return nil, rc.NewErrParamInvalid(errors.New("can't call this unless using the VFS cache"))
+	}
+	id, err := in.GetInt64("id")
+	if err != nil {
+		return nil, err
+	}
+	return nil, fn(vfs.cache, writeback.Handle(id))
+}
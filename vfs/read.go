@@ -108,6 +108,16 @@ func (fh *ReadFileHandle) Node() Node {
 	return fh.file
 }
 
+// Chmod changes the mode of the file to mode.
+func (fh *ReadFileHandle) Chmod(mode os.FileMode) error {
+	return fh.Node().(*File).Chmod(mode)
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (fh *ReadFileHandle) Chown(uid, gid int) error {
+	return fh.Node().(*File).Chown(uid, gid)
+}
+
 // seek to a new offset
 //
 // if reopen is true, then we won't attempt to use an io.Seeker interface
@@ -415,6 +425,7 @@ func (fh *ReadFileHandle) close() error {
 		return ECLOSED
 	}
 	fh.closed = true
+	fh.file.ReleaseLocks(handleLockOwner(fh))
 
 	if fh.opened {
 		var err error
@@ -435,6 +446,17 @@ func (fh *ReadFileHandle) close() error {
 	return nil
 }
 
+// Lock takes an exclusive advisory lock on the file, waiting until
+// it is available
+func (fh *ReadFileHandle) Lock() error {
+	return lockHandle(fh, fh.file)
+}
+
+// Unlock releases the lock taken with Lock
+func (fh *ReadFileHandle) Unlock() error {
+	return unlockHandle(fh, fh.file)
+}
+
 // Close closes the file
 func (fh *ReadFileHandle) Close() error {
 	fh.mu.Lock()
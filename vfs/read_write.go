@@ -30,14 +30,15 @@ type RWFileHandle struct {
 	writeCalled bool // if any Write() methods have been called
 }
 
-// Lock performs Unix locking, not supported
+// Lock takes an exclusive advisory lock on the file, waiting until
+// it is available
 func (fh *RWFileHandle) Lock() error {
-	return os.ErrInvalid
+	return lockHandle(fh, fh.file)
 }
 
-// Unlock performs Unix unlocking, not supported
+// Unlock releases the lock taken with Lock
 func (fh *RWFileHandle) Unlock() error {
-	return os.ErrInvalid
+	return unlockHandle(fh, fh.file)
 }
 
 func newRWFileHandle(d *Dir, f *File, flags int) (fh *RWFileHandle, err error) {
@@ -163,6 +164,7 @@ func (fh *RWFileHandle) close() (err error) {
 	}
 
 	fh.closed = true
+	fh.file.ReleaseLocks(handleLockOwner(fh))
 	fh.updateSize()
 	if fh.opened {
 		err = fh.item.Close(fh.file.setObject)
@@ -440,12 +442,12 @@ func (fh *RWFileHandle) Chdir() error {
 
 // Chmod changes the mode of the file to mode.
 func (fh *RWFileHandle) Chmod(mode os.FileMode) error {
-	return ENOSYS
+	return fh.Node().(*File).Chmod(mode)
 }
 
 // Chown changes the numeric uid and gid of the named file.
 func (fh *RWFileHandle) Chown(uid, gid int) error {
-	return ENOSYS
+	return fh.Node().(*File).Chown(uid, gid)
 }
 
 // Fd returns the integer Unix file descriptor referencing the open file.
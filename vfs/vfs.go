@@ -41,6 +41,7 @@ import (
 	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/vfs/vfscache"
 	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsdircache"
 )
 
 //go:embed vfs.md
@@ -66,6 +67,13 @@ type Node interface {
 	Truncate(size int64) error
 	Path() string
 	SetSys(interface{})
+	ListXattr() ([]string, error)
+	GetXattr(name string) ([]byte, error)
+	SetXattr(name string, value []byte, flags int) error
+	RemoveXattr(name string) error
+	Owner() (uid, gid uint32)
+	Chmod(mode os.FileMode) error
+	Chown(uid, gid int) error
 }
 
 // Check interfaces
@@ -182,7 +190,14 @@ type VFS struct {
 	usageTime   time.Time
 	usage       *fs.Usage
 	pollChan    chan time.Duration
-	inUse       atomic.Int32 // count of number of opens
+	inUse       atomic.Int32       // count of number of opens
+	locks       *lockManager       // advisory locks on files
+	dirCache    *vfsdircache.Cache // persistent directory listings if set
+	dirCacheMu  sync.Mutex         // protects dirCacheOps
+	dirCacheOps chan func()        // writes to dirCache run in order in the background - nil when stopped
+	dirCacheWG  sync.WaitGroup     // wait for the dirCache writer to finish
+	pinner      *pinner            // downloads pinned paths if --vfs-cache-mode full
+	policies    vfscommon.Policies // per directory overrides of Opt
 }
 
 // Keep track of active VFS keyed on fs.ConfigString(f)
@@ -193,7 +208,21 @@ var (
 
 // New creates a new VFS and root directory.  If opt is nil, then
 // DefaultOpt will be used
+//
+// It is fatal if the VFS can't be created, use NewE to get an error
+// instead.
 func New(f fs.Fs, opt *vfscommon.Options) *VFS {
+	vfs, err := NewE(f, opt)
+	if err != nil {
+		fs.Fatalf(f, "Failed to create VFS: %v", err)
+	}
+	return vfs
+}
+
+// NewE creates a new VFS and root directory returning an error if it
+// couldn't be created, for example if --vfs-policy-file couldn't be
+// read. If opt is nil, then DefaultOpt will be used
+func NewE(f fs.Fs, opt *vfscommon.Options) (*VFS, error) {
 	fsDir := fs.NewDir("", time.Now())
 	vfs := &VFS{
 		f: f,
@@ -210,6 +239,21 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	// Fill out anything else
 	vfs.Opt.Init()
 
+	// Read the per directory overrides of the options
+	if vfs.Opt.PolicyFile != "" {
+		policies, err := vfscommon.ReadPolicies(vfs.Opt.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --vfs-policy-file: %w", err)
+		}
+		vfs.policies = policies
+	}
+
+	// A snapshot can only be shown if the remote can list the past
+	features := f.Features()
+	if vfs.Opt.SnapshotTime.IsSet() && features.ListAt == nil {
+		return nil, fmt.Errorf("--vfs-snapshot-time is not supported by %v as it can't list old versions of files", f)
+	}
+
 	// Find a VFS with the same name and options and return it if possible
 	activeMu.Lock()
 	defer activeMu.Unlock()
@@ -218,7 +262,7 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 		if vfs.Opt == activeVFS.Opt {
 			fs.Debugf(f, "Reusing VFS from active cache")
 			activeVFS.inUse.Add(1)
-			return activeVFS
+			return activeVFS, nil
 		}
 	}
 	// Put the VFS into the active cache
@@ -228,8 +272,9 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	vfs.root = newDir(vfs, f, nil, fsDir)
 
 	// Start polling function
-	features := vfs.f.Features()
-	if do := features.ChangeNotify; do != nil {
+	if vfs.Opt.SnapshotTime.IsSet() {
+		fs.Infof(f, "Showing remote as it was at %v", vfs.Opt.SnapshotTime)
+	} else if do := features.ChangeNotify; do != nil {
 		vfs.pollChan = make(chan time.Duration)
 		do(context.TODO(), vfs.root.changeNotify, vfs.pollChan)
 		vfs.pollChan <- time.Duration(vfs.Opt.PollInterval)
@@ -242,6 +287,22 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 		fs.Logf(f, "--vfs-cache-mode writes or full is recommended for this remote as it can't stream")
 	}
 
+	// Set up the advisory locks
+	vfs.locks = newLockManager(vfs)
+
+	// Open the persistent directory cache if required
+	if vfs.Opt.DirCachePersist && !vfs.Opt.SnapshotTime.IsSet() {
+		dirCache, err := vfsdircache.New(context.Background(), f)
+		if err != nil {
+			fs.Errorf(f, "Not saving directory listings: %v", err)
+		} else {
+			vfs.dirCache = dirCache
+			vfs.dirCacheOps = make(chan func(), 64)
+			vfs.dirCacheWG.Add(1)
+			go vfs.dirCacheWriter(vfs.dirCacheOps)
+		}
+	}
+
 	// Warn if we handle symlinks
 	if vfs.Opt.Links {
 		fs.Logf(f, "Symlinks support enabled")
@@ -260,7 +321,7 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	// This can take some time so do it after the Pin
 	vfs.SetCacheMode(vfs.Opt.CacheMode)
 
-	return vfs
+	return vfs, nil
 }
 
 // refresh the directory cache for all directories
@@ -295,6 +356,9 @@ func (vfs *VFS) Stats() (out rc.Params) {
 	if vfs.cache != nil {
 		out["diskCache"] = vfs.cache.Stats()
 	}
+	if vfs.pinner != nil {
+		out["pins"] = vfs.pinner.stats()
+	}
 	return out
 }
 
@@ -317,13 +381,45 @@ func (vfs *VFS) Fs() fs.Fs {
 	return vfs.f
 }
 
+// optFor returns the options to use for the path given with any
+// overrides from --vfs-policy-file applied. The result should not be
+// modified.
+func (vfs *VFS) optFor(name string) *vfscommon.Options {
+	return vfs.policies.Options(&vfs.Opt, name)
+}
+
+// readOnly returns true if the path given may not be modified
+func (vfs *VFS) readOnly(name string) bool {
+	// The policies can't make a snapshot writable
+	return vfs.Opt.SnapshotTime.IsSet() || vfs.optFor(name).ReadOnly
+}
+
+// readOnlyWithin returns true if any path inside the directory given
+// may not be modified
+func (vfs *VFS) readOnlyWithin(dir string) bool {
+	return vfs.Opt.SnapshotTime.IsSet() || vfs.policies.ReadOnlyWithin(dir)
+}
+
+// cacheMode returns the cache mode to use for the path given
+func (vfs *VFS) cacheMode(name string) vfscommon.CacheMode {
+	if vfs.cache == nil {
+		return vfscommon.CacheModeOff
+	}
+	return vfs.optFor(name).CacheMode
+}
+
 // SetCacheMode change the cache mode
+//
+// The cache is started if cacheMode or any of the policies from
+// --vfs-policy-file need it.
 func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 	vfs.shutdownCache()
 	vfs.cache = nil
-	if cacheMode > vfscommon.CacheModeOff {
+	vfs.pinner = nil
+	maxCacheMode := vfs.policies.MaxCacheMode(cacheMode)
+	if maxCacheMode > vfscommon.CacheModeOff {
 		ctx, cancel := context.WithCancel(context.Background())
-		cache, err := vfscache.New(ctx, vfs.f, &vfs.Opt, vfs.AddVirtual) // FIXME pass on context or get from Opt?
+		cache, err := vfscache.New(ctx, vfs.f, &vfs.Opt, vfs.policies, vfs.AddVirtual) // FIXME pass on context or get from Opt?
 		if err != nil {
 			fs.Errorf(nil, "Failed to create vfs cache - disabling: %v", err)
 			vfs.Opt.CacheMode = vfscommon.CacheModeOff
@@ -333,6 +429,14 @@ func (vfs *VFS) SetCacheMode(cacheMode vfscommon.CacheMode) {
 		vfs.Opt.CacheMode = cacheMode
 		vfs.cancelCache = cancel
 		vfs.cache = cache
+		if maxCacheMode >= vfscommon.CacheModeFull {
+			vfs.pinner = newPinner(ctx, vfs)
+		}
+	}
+	if vfs.Opt.PinFrom != "" {
+		if err := vfs.pinFrom(vfs.Opt.PinFrom); err != nil {
+			fs.Errorf(nil, "Failed to pin paths from %q: %v", vfs.Opt.PinFrom, err)
+		}
 	}
 }
 
@@ -365,6 +469,17 @@ func (vfs *VFS) Shutdown() {
 	activeMu.Unlock()
 
 	vfs.shutdownCache()
+	vfs.locks.shutdown()
+	if vfs.dirCache != nil {
+		vfs.dirCacheMu.Lock()
+		close(vfs.dirCacheOps)
+		vfs.dirCacheOps = nil
+		vfs.dirCacheMu.Unlock()
+		vfs.dirCacheWG.Wait()
+		if err := vfs.dirCache.Close(); err != nil {
+			fs.Errorf(vfs.f, "Failed to close directory cache: %v", err)
+		}
+	}
 
 	if vfs.pollChan != nil {
 		close(vfs.pollChan)
@@ -372,9 +487,75 @@ func (vfs *VFS) Shutdown() {
 	}
 }
 
+// dirCacheWriter runs the writes to the persistent directory cache
+// in the order they were queued
+func (vfs *VFS) dirCacheWriter(ops <-chan func()) {
+	defer vfs.dirCacheWG.Done()
+	for fn := range ops {
+		fn()
+	}
+}
+
+// dirCacheDo queues fn to run on the persistent directory cache so
+// the caller doesn't wait for the database while holding a Dir lock.
+//
+// It returns a channel which is closed when fn has run or nil if
+// the cache isn't in use.
+func (vfs *VFS) dirCacheDo(fn func(dirCache *vfsdircache.Cache)) (done chan struct{}) {
+	if vfs.dirCache == nil {
+		return nil
+	}
+	vfs.dirCacheMu.Lock()
+	defer vfs.dirCacheMu.Unlock()
+	if vfs.dirCacheOps == nil {
+		return nil
+	}
+	done = make(chan struct{})
+	vfs.dirCacheOps <- func() {
+		defer close(done)
+		fn(vfs.dirCache)
+	}
+	return done
+}
+
+// persistDir saves the listing of dirPath read at when to the
+// persistent directory cache if in use.
+//
+// The listing is written in the background.
+func (vfs *VFS) persistDir(dirPath string, entries fs.DirEntries, when time.Time) {
+	vfs.dirCacheDo(func(dirCache *vfsdircache.Cache) {
+		if err := dirCache.Put(context.TODO(), dirPath, entries, when); err != nil {
+			fs.Errorf(dirPath, "Failed to save directory listing: %v", err)
+		}
+	})
+}
+
+// forgetPersistedDir removes the listing of dirPath from the
+// persistent directory cache if in use as it has changed. If tree is
+// set the listings of all the directories below are removed too.
+//
+// It waits for any listings queued before it to be written so a
+// listing read afterwards can't be stale.
+func (vfs *VFS) forgetPersistedDir(dirPath string, tree bool) {
+	done := vfs.dirCacheDo(func(dirCache *vfsdircache.Cache) {
+		var err error
+		if tree {
+			err = dirCache.RemoveTree(context.TODO(), dirPath)
+		} else {
+			err = dirCache.Remove(context.TODO(), dirPath)
+		}
+		if err != nil {
+			fs.Errorf(dirPath, "Failed to remove directory listing from cache: %v", err)
+		}
+	})
+	if done != nil {
+		<-done
+	}
+}
+
 // CleanUp deletes the contents of the on disk cache
 func (vfs *VFS) CleanUp() error {
-	if vfs.Opt.CacheMode == vfscommon.CacheModeOff {
+	if vfs.cache == nil {
 		return nil
 	}
 	return vfs.cache.CleanUp()
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -440,6 +441,76 @@ func TestVFSMkdirAll(t *testing.T) {
 	r.CheckRemoteListing(t, nil, []string{"a", "a/b", "a/b/c", "a/b/c/d"})
 }
 
+func TestVFSPolicies(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policies")
+	require.NoError(t, os.WriteFile(policyFile, []byte(`
+/work/**    cache_mode=full write_back=0s
+/archive/** read_only=true
+`), 0600))
+	opt := vfscommon.Opt
+	opt.PolicyFile = policyFile
+	opt.CacheMode = vfscommon.CacheModeOff
+	r, vfs := newTestVFSOpt(t, &opt)
+
+	// The cache is needed for work
+	require.NotNil(t, vfs.cache)
+	assert.Equal(t, vfscommon.CacheModeOff, vfs.Opt.CacheMode)
+	assert.Equal(t, vfscommon.CacheModeOff, vfs.cacheMode("file.txt"))
+	assert.Equal(t, vfscommon.CacheModeFull, vfs.cacheMode("work/file.txt"))
+
+	require.NoError(t, vfs.Mkdir("work", 0777))
+	require.NoError(t, vfs.Mkdir("archive", 0777))
+
+	// Files in work are opened with the cache
+	fd, err := vfs.OpenFile("work/file.txt", os.O_WRONLY|os.O_CREATE, 0777)
+	require.NoError(t, err)
+	_, ok := fd.(*RWFileHandle)
+	assert.True(t, ok, "want RWFileHandle got %T", fd)
+	_, err = fd.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+
+	// Files elsewhere are streamed
+	fd, err = vfs.OpenFile("file.txt", os.O_WRONLY|os.O_CREATE, 0777)
+	require.NoError(t, err)
+	_, ok = fd.(*WriteFileHandle)
+	assert.True(t, ok, "want WriteFileHandle got %T", fd)
+	_, err = fd.Write([]byte("potato"))
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+
+	// Nothing can be changed in archive
+	_, err = vfs.OpenFile("archive/file.txt", os.O_WRONLY|os.O_CREATE, 0777)
+	assert.Equal(t, EROFS, err)
+	assert.Equal(t, EROFS, vfs.Mkdir("archive/dir", 0777))
+	assert.Equal(t, EROFS, vfs.Rename("file.txt", "archive/file.txt"))
+
+	// Directories with read only paths inside can't be moved
+	assert.Equal(t, EROFS, vfs.Rename("archive", "archive2"))
+	assert.Equal(t, EROFS, vfs.Rename("work", "archive"))
+
+	vfs.WaitForWriters(waitForWritersDelay)
+	fstest.CheckListingWithPrecision(t, r.Fremote, []fstest.Item{
+		fstest.NewItem("file.txt", "potato", t1),
+		fstest.NewItem("work/file.txt", "hello", t1),
+	}, []string{"archive", "work"}, fs.ModTimeNotSupported)
+}
+
+func TestVFSPoliciesBad(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policies")
+	require.NoError(t, os.WriteFile(policyFile, []byte("/work/** potato=true\n"), 0600))
+	opt := vfscommon.Opt
+	opt.PolicyFile = policyFile
+	r := fstest.NewRun(t)
+
+	_, err := NewE(r.Fremote, &opt)
+	assert.ErrorContains(t, err, "policies:1:")
+
+	opt.PolicyFile = policyFile + "-notfound"
+	_, err = NewE(r.Fremote, &opt)
+	assert.Error(t, err)
+}
+
 func TestFillInMissingSizes(t *testing.T) {
 	const unknownFree = 10
 	for _, test := range []struct {
@@ -45,22 +45,26 @@ type Cache struct {
 	fcache     fs.Fs                // fs for the cache directory
 	fcacheMeta fs.Fs                // fs for the cache metadata directory
 	opt        *vfscommon.Options   // vfs Options
+	policies   vfscommon.Policies   // per directory overrides of opt
 	root       string               // root of the cache directory
 	metaRoot   string               // root of the cache metadata directory
+	queueRoot  string               // root of the writeback queue journal directory
 	hashType   hash.Type            // hash to use locally and remotely
 	hashOption *fs.HashesOption     // corresponding OpenOption
 	writeback  *writeback.WriteBack // holds Items for writeback
+	store      *store               // content store for deduplicating data
 	avFn       AddVirtualFn         // if set, can be called to add dir entries
 
-	mu            sync.Mutex       // protects the following variables
-	cond          sync.Cond        // cond lock for synchronous cache cleaning
-	item          map[string]*Item // files/directories in the cache
-	errItems      map[string]error // items in error state
-	used          int64            // total size of files in the cache
-	outOfSpace    bool             // out of space
-	cleanerKicked bool             // some thread kicked the cleaner upon out of space
-	kickerMu      sync.Mutex       // mutex for cleanerKicked
-	kick          chan struct{}    // channel for kicking clear to start
+	mu            sync.Mutex          // protects the following variables
+	cond          sync.Cond           // cond lock for synchronous cache cleaning
+	item          map[string]*Item    // files/directories in the cache
+	errItems      map[string]error    // items in error state
+	used          int64               // total size of files in the cache
+	outOfSpace    bool                // out of space
+	cleanerKicked bool                // some thread kicked the cleaner upon out of space
+	kickerMu      sync.Mutex          // mutex for cleanerKicked
+	kick          chan struct{}       // channel for kicking clear to start
+	pins          map[string]struct{} // pinned files and directories which aren't purged
 
 }
 
@@ -74,9 +78,12 @@ type AddVirtualFn func(remote string, size int64, isDir bool) error
 
 // New creates a new cache hierarchy for fremote
 //
+// Items use the options in opt with any overrides from policies for
+// their path applied.
+//
 // This starts background goroutines which can be cancelled with the
 // context passed in.
-func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVirtualFn) (*Cache, error) {
+func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, policies vfscommon.Policies, avFn AddVirtualFn) (*Cache, error) {
 	// Get cache root path.
 	// We need it in two variants: OS path as an absolute path with UNC prefix,
 	// OS-specific path separators, and encoded with OS-specific encoder. Standard path
@@ -95,7 +102,12 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 			relativeDirPath = relativeDirPath[2:] // Trim off the "//" for the result to be a valid when appending to another path
 		}
 	}
-	relativeDirPath = fremote.Name() + "/" + relativeDirPath
+	name := fremote.Name()
+	if opt.SnapshotTime.IsSet() {
+		// Keep the data of old versions apart from the current data
+		name += "{snapshot-" + time.Time(opt.SnapshotTime).UTC().Format("20060102T150405.999999999Z") + "}"
+	}
+	relativeDirPath = name + "/" + relativeDirPath
 	relativeDirOSPath := toOSPath(relativeDirPath)
 
 	// Create cache root dirs
@@ -113,19 +125,36 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 	}
 	hashType, hashOption := operations.CommonHash(ctx, fdata, fremote)
 
+	// Create the content store
+	st, err := newStore(parentOSPath, relativeDirOSPath, hashType, opt.CacheDedupe)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the writeback queue with its journal
+	queueOSPath, err := createRootDir(parentOSPath, "vfsQueue", relativeDirOSPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create writeback queue directory: %w", err)
+	}
+	wb := writeback.New(ctx, opt, policies, filepath.Join(queueOSPath, "queue.json"))
+
 	// Create the cache object
 	c := &Cache{
 		fremote:    fremote,
 		fcache:     fdata,
 		fcacheMeta: fmeta,
 		opt:        opt,
+		policies:   policies,
 		root:       dataOSPath,
 		metaRoot:   metaOSPath,
+		queueRoot:  queueOSPath,
 		item:       make(map[string]*Item),
 		errItems:   make(map[string]error),
+		pins:       make(map[string]struct{}),
 		hashType:   hashType,
 		hashOption: hashOption,
-		writeback:  writeback.New(ctx, opt),
+		writeback:  wb,
+		store:      st,
 		avFn:       avFn,
 	}
 
@@ -134,6 +163,13 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 	if err != nil {
 		return nil, fmt.Errorf("failed to load cache: %w", err)
 	}
+	c.writeback.EndRestore()
+
+	// Remove any data left in the content store by an earlier run
+	// with --vfs-cache-dedupe which isn't used any more
+	if !opt.CacheDedupe {
+		c.cleanStore()
+	}
 
 	// Remove any empty directories
 	c.purgeEmptyDirs("", true)
@@ -147,6 +183,11 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 	return c, nil
 }
 
+// optFor returns the options to use for the item called name
+func (c *Cache) optFor(name string) *vfscommon.Options {
+	return c.policies.Options(c.opt, name)
+}
+
 // Stats returns info about the Cache
 func (c *Cache) Stats() (out rc.Params) {
 	out = make(rc.Params)
@@ -185,6 +226,110 @@ func (c *Cache) QueueSetExpiry(id writeback.Handle, expiry time.Time, relative t
 	return c.writeback.SetExpiry(id, expiry, relative)
 }
 
+// QueueRetry uploads a single item in the upload queue now, including
+// an item which has failed to upload.
+func (c *Cache) QueueRetry(id writeback.Handle) error {
+	return c.writeback.Retry(id)
+}
+
+// QueueDiscard removes a single item which has failed to upload from
+// the upload queue and the cache so its changes are lost.
+func (c *Cache) QueueDiscard(id writeback.Handle) error {
+	name, err := c.writeback.Failed(id)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	item := c.item[name]
+	if item != nil {
+		delete(c.item, name)
+	}
+	c.mu.Unlock()
+	if item == nil {
+		c.writeback.Remove(id)
+		return nil
+	}
+	fs.Logf(name, "vfs cache: discarding changes which failed to upload")
+	item.remove("failed upload discarded")
+	return nil
+}
+
+// Pin marks name, which may be a file or a directory, as pinned so
+// it and everything below it isn't removed from the cache when it is
+// too old or over quota.
+func (c *Cache) Pin(name string) {
+	name = clean(name)
+	c.mu.Lock()
+	c.pins[name] = struct{}{}
+	c.mu.Unlock()
+}
+
+// Unpin removes the pin on name returning false if it wasn't pinned
+func (c *Cache) Unpin(name string) bool {
+	name = clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, found := c.pins[name]
+	delete(c.pins, name)
+	return found
+}
+
+// Pins returns the pinned names sorted
+func (c *Cache) Pins() []string {
+	c.mu.Lock()
+	pins := make([]string, 0, len(c.pins))
+	for name := range c.pins {
+		pins = append(pins, name)
+	}
+	c.mu.Unlock()
+	sort.Strings(pins)
+	return pins
+}
+
+// IsPinned returns true if name is pinned or is below a pinned
+// directory
+func (c *Cache) IsPinned(name string) bool {
+	name = clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c._isPinned(name)
+}
+
+// _isPinned returns true if the cleaned name is pinned
+//
+// must be called with mu held.
+func (c *Cache) _isPinned(name string) bool {
+	for pin := range c.pins {
+		if pin == "" || name == pin || strings.HasPrefix(name, pin+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// _renamePins moves the pins on oldName and anything below it to
+// newName
+//
+// must be called with mu held.
+func (c *Cache) _renamePins(oldName string, newName string) {
+	oldName, newName = clean(oldName), clean(newName)
+	for pin := range c.pins {
+		var newPin string
+		switch {
+		case pin == oldName:
+			newPin = newName
+		case oldName == "":
+			continue
+		case strings.HasPrefix(pin, oldName+"/"):
+			newPin = newName + pin[len(oldName):]
+		default:
+			continue
+		}
+		delete(c.pins, pin)
+		c.pins[newPin] = struct{}{}
+	}
+}
+
 // createDir creates a directory path, along with any necessary parents
 func createDir(dir string) error {
 	return file.MkdirAll(dir, 0700)
@@ -422,12 +567,13 @@ func (c *Cache) Rename(name string, newName string, newObj fs.Object) (err error
 		return err
 	}
 
-	// Move the item in the cache
+	// Move the item and any pin on it in the cache
 	c.mu.Lock()
 	if item, ok := c.item[name]; ok {
 		c.item[newName] = item
 		delete(c.item, name)
 	}
+	c._renamePins(name, newName)
 	c.mu.Unlock()
 
 	fs.Infof(name, "vfs cache: renamed in cache to %q", newName)
@@ -470,6 +616,11 @@ func (c *Cache) DirRename(oldDirName string, newDirName string) (err error) {
 		}
 	}
 
+	// Move any pins on the directory or below it
+	c.mu.Lock()
+	c._renamePins(oldDirName, newDirName)
+	c.mu.Unlock()
+
 	// Old path should be empty now so remove it
 	c.purgeEmptyDirs(oldDirName[:len(oldDirName)-1], false)
 
@@ -501,14 +652,30 @@ func (c *Cache) SetModTime(name string, modTime time.Time) {
 	item.setModTime(modTime)
 }
 
+// SetMetadata sets the metadata to be uploaded with the item
+func (c *Cache) SetMetadata(name string, metadata fs.Metadata) {
+	item, _ := c.get(name)
+	item.SetMetadata(metadata)
+}
+
 // CleanUp empties the cache of everything
 func (c *Cache) CleanUp() error {
+	// Stop the journal being written to the queue directory
+	c.writeback.StopJournal()
 	err1 := os.RemoveAll(c.root)
 	err2 := os.RemoveAll(c.metaRoot)
+	err3 := os.RemoveAll(filepath.Dir(c.store.root))
+	err4 := os.RemoveAll(c.queueRoot)
 	if err1 != nil {
 		return err1
 	}
-	return err2
+	if err2 != nil {
+		return err2
+	}
+	if err3 != nil {
+		return err3
+	}
+	return err4
 }
 
 // walk walks the cache calling the function
@@ -602,6 +769,19 @@ func (c *Cache) removeNotInUse(item *Item, maxAge time.Duration, emptyOnly bool)
 	}
 }
 
+// evictBlock evicts a block of an item not in use
+// called with cache mutex locked and up-to-date c.used (as we update it directly here)
+func (c *Cache) evictBlock(block evictBlock) {
+	removed, spaceFreed := block.item.EvictBlock(block.index)
+	c.used -= spaceFreed
+	if removed {
+		fs.Infof(c.fremote, "vfs cache EvictBlock: item %s was removed, freed %d bytes", block.item.GetName(), spaceFreed)
+		delete(c.item, block.item.name)
+	} else {
+		fs.Debugf(c.fremote, "vfs cache EvictBlock: block %d of item %s evicted, freed %d bytes", block.index, block.item.GetName(), spaceFreed)
+	}
+}
+
 // Retry failed resets during purgeClean()
 func (c *Cache) retryFailedResets() {
 	// Some items may have failed to reset because there was not enough space
@@ -627,6 +807,9 @@ func (c *Cache) retryFailedResets() {
 }
 
 // Remove cache files that are not dirty until the quota is satisfied
+//
+// Pinned files are never reset. If the quota can't be met without
+// them the pins need more space than the cache has.
 func (c *Cache) purgeClean() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -637,11 +820,17 @@ func (c *Cache) purgeClean() {
 
 	var items Items
 
-	// Make a slice of clean cache files
+	// Make a slice of clean cache files which aren't pinned
+	pinned := 0
 	for _, item := range c.item {
-		if !item.IsDirty() {
-			items = append(items, item)
+		if item.IsDirty() {
+			continue
 		}
+		if c._isPinned(item.name) {
+			pinned++
+			continue
+		}
+		items = append(items, item)
 	}
 
 	sort.Sort(items)
@@ -664,6 +853,9 @@ func (c *Cache) purgeClean() {
 			c.errItems[item.name] = err
 		}
 	}
+	if pinned > 0 && !c.quotasOK() {
+		fs.Errorf(c.fremote, "vfs cache purgeClean: still over quota with %d pinned files kept - pinned files need more space than the cache has", pinned)
+	}
 
 	// Reset outOfSpace without checking whether we have reduced cache space below the quota.
 	// This allows some files to reduce their pendingAccesses count to allow them to be reset
@@ -679,6 +871,9 @@ func (c *Cache) purgeOld(maxAge time.Duration) {
 	defer c.mu.Unlock()
 	// cutoff := time.Now().Add(-maxAge)
 	for _, item := range c.item {
+		if c._isPinned(item.name) {
+			continue
+		}
 		c.removeNotInUse(item, maxAge, false)
 	}
 	if c.quotasOK() {
@@ -706,13 +901,35 @@ func (c *Cache) updateUsed() (used int64) {
 	defer c.mu.Unlock()
 
 	newUsed := int64(0)
+	shared := make(map[string]struct{})
 	for _, item := range c.item {
+		// count data shared in the content store once
+		if h := item.getHash(); h != "" {
+			if _, found := shared[h]; found {
+				continue
+			}
+			shared[h] = struct{}{}
+		}
 		newUsed += item.getDiskSize()
 	}
 	c.used = newUsed
 	return newUsed
 }
 
+// cleanStore removes data which isn't used any more from the content
+// store
+func (c *Cache) cleanStore() {
+	c.mu.Lock()
+	used := make(map[string]struct{})
+	for _, item := range c.item {
+		if h := item.getHash(); h != "" {
+			used[c.store.path(h)] = struct{}{}
+		}
+	}
+	c.mu.Unlock()
+	c.store.clean(used)
+}
+
 // Check the available space for a disk is in limits.
 func (c *Cache) minFreeSpaceQuotaOK() bool {
 	if c.opt.CacheMinFreeSpace <= 0 {
@@ -767,16 +984,38 @@ func (c *Cache) purgeOverQuota() {
 
 	// Make a slice of unused files
 	for _, item := range c.item {
-		if !item.inUse() {
+		if !item.inUse() && !c._isPinned(item.name) {
 			items = append(items, item)
 		}
 	}
 
-	sort.Sort(items)
+	// Evict the least recently used blocks until the quota is OK
+	var blocks []evictBlock
+	for _, item := range items {
+		blocks = append(blocks, item.evictableBlocks()...)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].atime < blocks[j].atime
+	})
+	for _, block := range blocks {
+		if c.quotasOK() {
+			break
+		}
+		if c.item[block.item.name] != block.item {
+			continue // removed already
+		}
+		if block.index < 0 {
+			c.removeNotInUse(block.item, 0, false)
+		} else {
+			c.evictBlock(block)
+		}
+	}
 
-	// Remove items until the quota is OK
+	// Remove any empty items left
 	for _, item := range items {
-		c.removeNotInUse(item, 0, c.quotasOK())
+		if c.item[item.name] == item {
+			c.removeNotInUse(item, 0, true)
+		}
 	}
 	if c.quotasOK() {
 		c.outOfSpace = false
@@ -809,6 +1048,11 @@ func (c *Cache) clean(kicked bool) {
 		c.retryFailedResets()
 	}
 
+	// Remove data from the content store which is no longer used
+	if c.opt.CacheDedupe {
+		c.cleanStore()
+	}
+
 	// Was kicked?
 	if kicked {
 		c.kickerMu.Lock() // Make sure this is called with cache mutex unlocked
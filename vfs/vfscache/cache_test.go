@@ -14,6 +14,8 @@ import (
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/lib/diskusage"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/ranges"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
@@ -91,7 +93,7 @@ func newTestCacheOpt(t *testing.T, opt vfscommon.Options) (r *fstest.Run, c *Cac
 	ctx, cancel := context.WithCancel(context.Background())
 
 	avInfos = nil
-	c, err := New(ctx, r.Fremote, &opt, addVirtual)
+	c, err := New(ctx, r.Fremote, &opt, nil, addVirtual)
 	require.NoError(t, err)
 
 	t.Cleanup(func() {
@@ -340,6 +342,89 @@ func TestCachePurgeOld(t *testing.T) {
 	assert.Equal(t, []string(nil), itemAsString(c))
 }
 
+func TestCachePurgePinned(t *testing.T) {
+	_, c := newTestCache(t)
+
+	for _, name := range []string{"pinned/file", "pinned/sub/file", "pinnedfile", "other"} {
+		item := c.Item(name)
+		require.NoError(t, item.Open(nil))
+		require.NoError(t, item.Truncate(5))
+		require.NoError(t, item.Close(nil))
+	}
+	c.Pin("/pinned/")
+	c.Pin("pinnedfile")
+	assert.Equal(t, []string{"pinned", "pinnedfile"}, c.Pins())
+	assert.True(t, c.IsPinned("pinned/sub/file"))
+	assert.False(t, c.IsPinned("pinnedfile2"))
+
+	c.purgeOld(-10 * time.Second)
+	assert.Equal(t, []string{
+		`name="pinned/file" opens=0 size=5`,
+		`name="pinned/sub/file" opens=0 size=5`,
+		`name="pinnedfile" opens=0 size=5`,
+	}, itemAsString(c))
+
+	// Pinned items are kept even if over quota
+	c.opt.CacheMaxSize = 1
+	c.purgeOverQuota()
+	assert.Equal(t, int64(15), c.used)
+	assert.Len(t, itemAsString(c), 3)
+
+	assert.True(t, c.Unpin("pinned"))
+	assert.False(t, c.Unpin("pinned"))
+	c.purgeOld(-10 * time.Second)
+	assert.Equal(t, []string{
+		`name="pinnedfile" opens=0 size=5`,
+	}, itemAsString(c))
+
+	// Pinning the root pins everything
+	c.Pin("")
+	assert.True(t, c.IsPinned("any/thing"))
+}
+
+func TestCachePurgeCleanPinned(t *testing.T) {
+	_, c := newTestCache(t)
+
+	for _, name := range []string{"pinned", "other"} {
+		item := c.Item(name)
+		require.NoError(t, item.Open(nil))
+		require.NoError(t, item.Truncate(5))
+		require.NoError(t, item.Close(nil))
+	}
+	c.Pin("pinned")
+	c.opt.CacheMaxSize = 1
+	c.updateUsed()
+
+	// Only the unpinned item is reset
+	c.purgeClean()
+	assert.Equal(t, []string{
+		`name="pinned" opens=0 size=5`,
+	}, itemAsString(c))
+}
+
+func TestCacheRenamePinned(t *testing.T) {
+	_, c := newTestCache(t)
+
+	for _, name := range []string{"dir/file", "file"} {
+		item := c.Item(name)
+		require.NoError(t, item.Open(nil))
+		require.NoError(t, item.Truncate(5))
+		require.NoError(t, item.Close(nil))
+	}
+	c.Pin("file")
+	c.Pin("dir")
+	c.Pin("dir/file")
+	c.Pin("dirfile")
+
+	require.NoError(t, c.Rename("file", "newfile", nil))
+	assert.Equal(t, []string{"dir", "dir/file", "dirfile", "newfile"}, c.Pins())
+
+	require.NoError(t, c.DirRename("dir", "newdir"))
+	assert.Equal(t, []string{"dirfile", "newdir", "newdir/file", "newfile"}, c.Pins())
+	assert.True(t, c.IsPinned("newdir/file"))
+	assert.False(t, c.IsPinned("dir/file"))
+}
+
 func TestCachePurgeOverQuota(t *testing.T) {
 	_, c := newTestCache(t)
 
@@ -403,6 +488,7 @@ func TestCachePurgeOverQuota(t *testing.T) {
 	// make potato definitely after potato2
 	t2 := t1.Add(20 * time.Second)
 	potato.info.ATime = t2
+	potato.info.BlockATimes = map[int64]int64{0: t2.UnixNano()}
 
 	// Check only potato2 removed to get below quota
 	c.opt.CacheMaxSize = 10
@@ -428,6 +514,65 @@ func TestCachePurgeOverQuota(t *testing.T) {
 	assert.Equal(t, []string(nil), itemAsString(c))
 }
 
+func TestCachePurgeOverQuotaBlocks(t *testing.T) {
+	if !file.PunchHoleImplemented {
+		t.Skip("punching holes not supported on this OS")
+	}
+	oldEvictBlockSize := evictBlockSize
+	evictBlockSize = 4
+	defer func() { evictBlockSize = oldEvictBlockSize }()
+
+	_, c := newTestCache(t)
+	c.opt.CacheMaxSize = 100
+
+	// Write a file of 3 blocks
+	big := c.Item("big")
+	itemWrite(t, big, "AAAABBBBCCCC")
+	require.NoError(t, big.Close(nil))
+	c.updateUsed()
+	assert.Len(t, big.info.BlockATimes, 3)
+
+	// Make the middle block the coldest and the first the hottest
+	now := time.Now()
+	big.info.BlockATimes = map[int64]int64{
+		0: now.UnixNano(),
+		1: now.Add(-2 * time.Hour).UnixNano(),
+		2: now.Add(-time.Hour).UnixNano(),
+	}
+
+	checkData := func(want string, wantRs ranges.Ranges) {
+		data, err := os.ReadFile(c.toOSPath("big"))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data))
+		assert.Equal(t, wantRs, big.info.Rs)
+	}
+
+	// Only the coldest block is evicted to get below quota
+	c.opt.CacheMaxSize = 9
+	c.purgeOverQuota()
+	assert.Equal(t, int64(8), c.used)
+	checkData("AAAA\x00\x00\x00\x00CCCC", ranges.Ranges{{Pos: 0, Size: 4}, {Pos: 8, Size: 4}})
+	assert.Equal(t, []string{`name="big" opens=0 size=12`}, itemAsString(c))
+
+	// The metadata is saved
+	_, err := big.load()
+	require.NoError(t, err)
+	assert.Equal(t, ranges.Ranges{{Pos: 0, Size: 4}, {Pos: 8, Size: 4}}, big.info.Rs)
+
+	// Then the next coldest
+	c.opt.CacheMaxSize = 5
+	c.purgeOverQuota()
+	assert.Equal(t, int64(4), c.used)
+	checkData("AAAA\x00\x00\x00\x00\x00\x00\x00\x00", ranges.Ranges{{Pos: 0, Size: 4}})
+
+	// Evicting the last block removes the item
+	c.opt.CacheMaxSize = 1
+	c.purgeOverQuota()
+	assert.Equal(t, int64(0), c.used)
+	assert.Equal(t, []string(nil), itemAsString(c))
+	assertPathNotExist(t, c.toOSPath("big"))
+}
+
 func TestCachePurgeMinFreeSpace(t *testing.T) {
 	du, err := diskusage.New(config.GetCacheDir())
 	if err == diskusage.ErrUnsupported {
@@ -751,3 +896,13 @@ func TestCacheQueueSetExpiry(t *testing.T) {
 	err := c.QueueSetExpiry(123123, time.Now(), 0)
 	assert.Equal(t, writeback.ErrorIDNotFound, err)
 }
+
+func TestCacheQueueRetryDiscard(t *testing.T) {
+	_, c := newTestCache(t)
+
+	// Check these return the correct error when called so we know
+	// they are plumbed in correctly. The actual tests are done in
+	// writeback.
+	assert.Equal(t, writeback.ErrorIDNotFound, c.QueueRetry(123123))
+	assert.Equal(t, writeback.ErrorIDNotFound, c.QueueDiscard(123123))
+}
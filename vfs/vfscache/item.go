@@ -6,12 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/lib/file"
 	"github.com/rclone/rclone/lib/ranges"
@@ -68,18 +70,26 @@ type Item struct {
 	pendingAccesses int                      // number of threads - cache reset not allowed if not zero
 	modified        bool                     // set if the file has been modified since the last Open
 	beingReset      bool                     // cache cleaner is resetting the cache file, access not allowed
+	oldFds          []*os.File               // handles replaced when unsharing data, closed with fd
 }
 
 // Info is persisted to backing store
 type Info struct {
-	ModTime     time.Time     // last time file was modified
-	ATime       time.Time     // last time file was accessed
-	Size        int64         // size of the file
-	Rs          ranges.Ranges // which parts of the file are present
-	Fingerprint string        // fingerprint of remote object
-	Dirty       bool          // set if the backing file has been modified
+	ModTime     time.Time       // last time file was modified
+	ATime       time.Time       // last time file was accessed
+	Size        int64           // size of the file
+	Rs          ranges.Ranges   // which parts of the file are present
+	Fingerprint string          // fingerprint of remote object
+	Dirty       bool            // set if the backing file has been modified
+	Metadata    fs.Metadata     // metadata to set on the remote object when uploaded
+	Hash        string          // hash of the data if shared in the content store
+	BlockATimes map[int64]int64 `json:",omitempty"` // last access time in unix nanoseconds of each block of the file
 }
 
+// evictBlockSize is the size of the blocks of the cache files which
+// have their access times tracked so they can be evicted separately
+var evictBlockSize int64 = 16 * 1024 * 1024
+
 // Items are a slice of *Item ordered by ATime
 type Items []*Item
 
@@ -182,6 +192,14 @@ func (item *Item) getDiskSize() int64 {
 	return item.info.Rs.Size()
 }
 
+// getHash returns the hash the data is shared under in the content
+// store or "" if it isn't shared
+func (item *Item) getHash() string {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	return item.info.Hash
+}
+
 // load reads an item from the disk or returns nil if not found
 func (item *Item) load() (exists bool, err error) {
 	item.mu.Lock()
@@ -233,6 +251,15 @@ func (item *Item) _truncate(size int64) (err error) {
 		return nil
 	}
 
+	// Changing the size changes the data so it can't be shared
+	if item.info.Hash != "" {
+		if fi, err := item._stat(); err == nil && fi.Size() != size {
+			if err := item._unshare(); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Use open handle if available
 	fd := item.fd
 	if fd == nil {
@@ -535,6 +562,9 @@ func (item *Item) open(o fs.Object) (err error) {
 		return nil
 	}
 
+	// Use the data from the content store if it has it
+	item._linkFromStore(osPath)
+
 	err = item._createFile(osPath)
 	if err != nil {
 		item._remove("item.open failed on _createFile, remove cache data/metadata files")
@@ -567,7 +597,7 @@ func (item *Item) open(o fs.Object) (err error) {
 
 	// Create the downloaders
 	if item.o != nil {
-		item.downloaders = downloaders.New(item, item.c.opt, item.name, item.o)
+		item.downloaders = downloaders.New(item, item.c.optFor(item.name), item.name, item.o)
 	}
 
 	return err
@@ -598,6 +628,14 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 	// Object has disappeared if cacheObj == nil
 	if cacheObj != nil {
 		o, name := item.o, item.name
+		metadata := maps.Clone(item.info.Metadata)
+		if metadata != nil {
+			// Upload the metadata with the object
+			var ci *fs.ConfigInfo
+			ctx, ci = fs.AddConfig(ctx)
+			ci.Metadata = true
+			cacheObj = &metadataObject{Object: cacheObj, metadata: metadata}
+		}
 		unlockMutexForCall(&item.mu, func() {
 			o, err = operations.Copy(ctx, item.c.fremote, o, name, cacheObj)
 		})
@@ -610,6 +648,11 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 		}
 		item.o = o
 		item._updateFingerprint()
+		// The metadata is on the remote now unless it was changed
+		// during the upload
+		if metadata != nil && maps.Equal(metadata, item.info.Metadata) {
+			item.info.Metadata = nil
+		}
 	}
 
 	// Write the object back to the VFS layer before we mark it as
@@ -647,12 +690,10 @@ func (item *Item) Close(storeFn StoreFn) (err error) {
 	// defer log.Trace(item.o, "Item.Close")("err=%v", &err)
 	item.preAccess()
 	defer item.postAccess()
-	var (
-		downloaders   *downloaders.Downloaders
-		syncWriteBack = item.c.opt.WriteBack <= 0
-	)
+	var downloaders *downloaders.Downloaders
 	item.mu.Lock()
 	defer item.mu.Unlock()
+	syncWriteBack := item.c.optFor(item.name).WriteBack <= 0
 
 	item.info.ATime = time.Now()
 	item.opens--
@@ -711,6 +752,7 @@ func (item *Item) Close(storeFn StoreFn) (err error) {
 		checkErr(item.fd.Close())
 		item.fd = nil
 	}
+	checkErr(item._closeOldFds())
 
 	// save the metadata once more since it may be dirty
 	// after the downloader
@@ -726,9 +768,14 @@ func (item *Item) Close(storeFn StoreFn) (err error) {
 		}
 	}
 
+	// share the data with items with the same contents
+	if !item.info.Dirty {
+		item._dedupe()
+	}
+
 	// upload the file to backing store if changed
 	if item.info.Dirty {
-		fs.Infof(item.name, "vfs cache: queuing for upload in %v", item.c.opt.WriteBack)
+		fs.Infof(item.name, "vfs cache: queuing for upload in %v", item.c.optFor(item.name).WriteBack)
 		if syncWriteBack {
 			// do synchronous writeback
 			checkErr(item._store(context.Background(), storeFn))
@@ -943,6 +990,100 @@ func (item *Item) RemoveNotInUse(maxAge time.Duration, emptyOnly bool) (removed
 	return
 }
 
+// evictBlock is a block of a cache file which may be evicted
+type evictBlock struct {
+	item  *Item
+	index int64 // index of the block in the file or -1 for the whole file
+	atime int64 // last access time of the block in unix nanoseconds
+}
+
+// evictableBlocks returns the cached blocks of the item with their
+// access times if it isn't in use.
+//
+// Items which can't have single blocks evicted, either because the
+// OS can't punch holes in files or because their data is shared in
+// the content store, are returned as a single block standing for the
+// whole file.
+func (item *Item) evictableBlocks() (blocks []evictBlock) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item.opens != 0 || item.info.Dirty {
+		return nil
+	}
+	atime := item.info.ATime.UnixNano()
+	if !file.PunchHoleImplemented || item.info.Hash != "" || item.info.Rs.Size() == 0 {
+		return []evictBlock{{item: item, index: -1, atime: atime}}
+	}
+	last := int64(-1)
+	for _, r := range item.info.Rs {
+		for index := r.Pos / evictBlockSize; index*evictBlockSize < r.End(); index++ {
+			if index <= last {
+				continue
+			}
+			last = index
+			blockATime, found := item.info.BlockATimes[index]
+			if !found {
+				// blocks cached before access times were tracked
+				blockATime = atime
+			}
+			blocks = append(blocks, evictBlock{item: item, index: index, atime: blockATime})
+		}
+	}
+	return blocks
+}
+
+// EvictBlock removes the block with index from the cache file if it
+// isn't in use by punching a hole in the file. If that leaves nothing
+// cached, or the OS can't punch the hole, the whole file is removed.
+func (item *Item) EvictBlock(index int64) (removed bool, spaceFreed int64) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+
+	if item.opens != 0 || item.info.Dirty {
+		return false, 0
+	}
+	oldSize := item.info.Rs.Size()
+	r := ranges.Range{Pos: index * evictBlockSize, Size: evictBlockSize}
+	rs := item.info.Rs.Remove(r)
+	delete(item.info.BlockATimes, index)
+	if rs.Size() == oldSize {
+		return false, 0
+	}
+	removeIt := func(reason string) (bool, int64) {
+		if item._remove(reason) {
+			fs.Errorf(item.name, "item removed when it was writing/uploaded")
+		}
+		return true, oldSize
+	}
+	if rs.Size() == 0 {
+		return removeIt("evicting its last cold block")
+	}
+	err := item._punchHole(r)
+	if err != nil {
+		fs.Debugf(item.name, "vfs cache: failed to evict block %d so removing file: %v", index, err)
+		return removeIt("failed to evict a cold block")
+	}
+	item.info.Rs = rs
+	err = item._save()
+	if err != nil {
+		fs.Errorf(item.name, "vfs cache: failed to save metadata after evicting block %d: %v", index, err)
+	}
+	return false, oldSize - rs.Size()
+}
+
+// _punchHole frees the space used by r in the cache file
+//
+// call with lock held
+func (item *Item) _punchHole(r ranges.Range) (err error) {
+	osPath := item.c.toOSPath(item.name) // No locking in Cache
+	fd, err := file.OpenFile(osPath, os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(fd, &err)
+	return file.PunchHole(fd, r.Pos, r.Size)
+}
+
 // Reset is called by the cache purge functions only to reset (empty the contents) cache files that
 // are not dirty.  It is used when cache space runs out and we see some ENOSPC error.
 func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
@@ -1027,6 +1168,7 @@ func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
 		}
 		item.fd = nil
 	}
+	checkErr(item._closeOldFds())
 
 	spaceFreed = item.info.Rs.Size()
 
@@ -1058,7 +1200,7 @@ func (item *Item) Reset() (rr ResetResult, spaceFreed int64, err error) {
 
 	// Create the downloaders
 	if item.o != nil {
-		item.downloaders = downloaders.New(item, item.c.opt, item.name, item.o)
+		item.downloaders = downloaders.New(item, item.c.optFor(item.name), item.name, item.o)
 	}
 
 	/* The item will stay in the beingReset state if we get an error that prevents us from
@@ -1170,7 +1312,7 @@ func (item *Item) _ensure(offset, size int64) (err error) {
 			}
 			item.o = o
 		}
-		item.downloaders = downloaders.New(item, item.c.opt, item.name, item.o)
+		item.downloaders = downloaders.New(item, item.c.optFor(item.name), item.name, item.o)
 	}
 	return item.downloaders.Download(r)
 }
@@ -1188,6 +1330,25 @@ func (item *Item) _ensure(offset, size int64) (err error) {
 func (item *Item) _written(offset, size int64) {
 	// defer log.Trace(item.name, "offset=%d, size=%d", offset, size)("")
 	item.info.Rs.Insert(ranges.Range{Pos: offset, Size: size})
+	item._touchBlocks(offset, size, time.Now())
+}
+
+// _touchBlocks records the blocks containing size bytes at offset as
+// accessed at now so the least recently used blocks can be evicted
+// when the cache is over quota.
+//
+// call with lock held
+func (item *Item) _touchBlocks(offset, size int64, now time.Time) {
+	if size <= 0 || !item.c.haveQuotas() {
+		return
+	}
+	if item.info.BlockATimes == nil {
+		item.info.BlockATimes = make(map[int64]int64)
+	}
+	end := offset + size
+	for index := offset / evictBlockSize; index*evictBlockSize < end; index++ {
+		item.info.BlockATimes[index] = now.UnixNano()
+	}
 }
 
 // update the fingerprint of the object if any
@@ -1230,6 +1391,38 @@ func (item *Item) setModTime(modTime time.Time) {
 	item.mu.Unlock()
 }
 
+// GetMetadata returns a copy of the metadata to be set on the remote
+// object when the item is uploaded, or nil if there isn't any.
+func (item *Item) GetMetadata() fs.Metadata {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	return maps.Clone(item.info.Metadata)
+}
+
+// SetMetadata sets the metadata to be set on the remote object when
+// the item is uploaded, replacing any set previously.
+func (item *Item) SetMetadata(metadata fs.Metadata) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	item.info.Metadata = maps.Clone(metadata)
+	err := item._save()
+	if err != nil {
+		fs.Errorf(item.name, "vfs cache: SetMetadata: failed to save item info: %v", err)
+	}
+}
+
+// metadataObject overrides the metadata of the cache object so the
+// metadata set on the item is uploaded with it
+type metadataObject struct {
+	fs.Object
+	metadata fs.Metadata
+}
+
+// Metadata returns the metadata to upload with the object
+func (o *metadataObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	return o.metadata, nil
+}
+
 // GetModTime of the cache file
 func (item *Item) GetModTime() (modTime time.Time, err error) {
 	// defer log.Trace(item.name, "modTime=%v", modTime)("")
@@ -1298,6 +1491,7 @@ func (item *Item) readAt(b []byte, off int64) (n int, err error) {
 	}
 
 	item.info.ATime = time.Now()
+	item._touchBlocks(off, int64(len(b)), item.info.ATime)
 	// Do the reading with Item.mu unlocked and cache protected by preAccess
 	n, err = item.fd.ReadAt(b, off)
 	return n, err
@@ -1312,6 +1506,10 @@ func (item *Item) WriteAt(b []byte, off int64) (n int, err error) {
 		item.mu.Unlock()
 		return 0, errors.New("vfs cache item WriteAt: internal error: didn't Open file")
 	}
+	if err = item._unshare(); err != nil {
+		item.mu.Unlock()
+		return 0, err
+	}
 	item.mu.Unlock()
 	// Do the writing with Item.mu unlocked
 	n, err = item.fd.WriteAt(b, off)
@@ -1451,3 +1649,94 @@ func (item *Item) rename(name string, newName string, newObj fs.Object) (err err
 	item.c.writeback.Rename(id, newName)
 	return err
 }
+
+// _dedupe shares the data of a complete, clean item in the content
+// store so items with the same contents only store it once.
+//
+// call with lock held and the file closed
+func (item *Item) _dedupe() {
+	st := item.c.store
+	if !item.c.opt.CacheDedupe || st.hashType == hash.None || item.info.Hash != "" || item.o == nil || item.info.Size <= 0 || !item._present() {
+		return
+	}
+	h, err := item.o.Hash(context.TODO(), st.hashType)
+	if err != nil || h == "" {
+		fs.Debugf(item.name, "vfs cache: not sharing data as no %v hash: %v", st.hashType, err)
+		return
+	}
+	err = st.add(item.c.toOSPath(item.name), h, item.info.Size)
+	if err != nil {
+		fs.Debugf(item.name, "vfs cache: failed to share data in content store: %v", err)
+		return
+	}
+	fs.Debugf(item.name, "vfs cache: sharing data in content store with %v hash %q", st.hashType, h)
+	item.info.Hash = h
+	if err = item._save(); err != nil {
+		fs.Errorf(item.name, "vfs cache: failed to save item info: %v", err)
+	}
+}
+
+// _linkFromStore fills an empty item with the data of the remote
+// object from the content store if it has it. This means files with
+// the same contents, for example files renamed on the remote, don't
+// need to be downloaded again.
+//
+// call with lock held and the file closed
+func (item *Item) _linkFromStore(osPath string) {
+	st := item.c.store
+	if !item.c.opt.CacheDedupe || st.hashType == hash.None || item.info.Hash != "" || item.o == nil || item.info.Size <= 0 || item.info.Rs.Size() != 0 || item.info.Dirty {
+		return
+	}
+	h, err := item.o.Hash(context.TODO(), st.hashType)
+	if err != nil || h == "" {
+		return
+	}
+	if !st.link(osPath, h, item.info.Size) {
+		return
+	}
+	fs.Debugf(item.name, "vfs cache: using data from content store with %v hash %q", st.hashType, h)
+	item.info.Hash = h
+	item.info.Rs = nil
+	item._written(0, item.info.Size)
+}
+
+// _unshare gives the item its own copy of its data if it is shared in
+// the content store so it can be modified.
+//
+// The old file handle is kept open until the item is closed as it may
+// be in use by readers.
+//
+// call with lock held
+func (item *Item) _unshare() (err error) {
+	if item.info.Hash == "" {
+		return nil
+	}
+	fs.Debugf(item.name, "vfs cache: unsharing data from content store")
+	osPath := item.c.toOSPath(item.name)
+	if err = item.c.store.unshare(osPath); err != nil {
+		return err
+	}
+	if item.fd != nil {
+		fd, err := file.OpenFile(osPath, os.O_RDWR, 0600)
+		if err != nil {
+			return fmt.Errorf("vfs cache: failed to reopen unshared cache file: %w", err)
+		}
+		item.oldFds = append(item.oldFds, item.fd)
+		item.fd = fd
+	}
+	item.info.Hash = ""
+	return item._save()
+}
+
+// _closeOldFds closes the handles replaced by _unshare
+//
+// call with lock held
+func (item *Item) _closeOldFds() (err error) {
+	for _, fd := range item.oldFds {
+		if closeErr := fd.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	item.oldFds = nil
+	return err
+}
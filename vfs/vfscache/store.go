@@ -0,0 +1,183 @@
+// Content addressed store of cached data
+
+package vfscache
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/random"
+)
+
+// store keeps the data of complete cache files indexed by the hash
+// of the remote object.
+//
+// Cache files with the same hash are hard links to the same file in
+// the store so their data is only stored once. Items using the store
+// have Info.Hash set and must be unshared with unshare before they
+// are modified.
+type store struct {
+	root     string    // OS path of the store
+	hashType hash.Type // type of hash used to index the store
+}
+
+// newStore makes the store for the cache of relativeDirOSPath
+//
+// The directory for the store is only created if create is set.
+// Otherwise the store is only used to unshare and clean up data left
+// in it by an earlier run.
+func newStore(parentOSPath string, relativeDirOSPath string, hashType hash.Type, create bool) (*store, error) {
+	root := file.UNCPath(filepath.Join(parentOSPath, "vfsStore", relativeDirOSPath))
+	if create {
+		if err := createDir(root); err != nil {
+			return nil, fmt.Errorf("failed to create content store directory: %w", err)
+		}
+	}
+	return &store{
+		root:     filepath.Join(root, strings.ToLower(hashType.String())),
+		hashType: hashType,
+	}, nil
+}
+
+// isLowerHex returns true if s is only lower case hex digits
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// path returns the OS path of the data with hash h
+func (s *store) path(h string) string {
+	// Hashes which aren't hex may contain / or only differ in
+	// case so encode them
+	if !isLowerHex(h) {
+		h = hex.EncodeToString([]byte(h))
+	}
+	if len(h) < 2 {
+		return filepath.Join(s.root, h)
+	}
+	return filepath.Join(s.root, h[:2], h)
+}
+
+// has returns true if the store has data with hash h and the given size
+func (s *store) has(h string, size int64) bool {
+	fi, err := os.Stat(s.path(h))
+	return err == nil && fi.Size() == size
+}
+
+// replace replaces osPath with a hard link to the data at storePath
+func (s *store) replace(storePath string, osPath string) error {
+	tmp := s.tmpPath()
+	if err := os.Link(storePath, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, osPath); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// add shares the complete cache file at osPath with hash h in the
+// store. If the store has the data already osPath is replaced with
+// it, otherwise osPath is added to the store.
+func (s *store) add(osPath string, h string, size int64) error {
+	storePath := s.path(h)
+	if s.has(h, size) {
+		return s.replace(storePath, osPath)
+	}
+	if err := file.MkdirAll(filepath.Dir(storePath), 0700); err != nil {
+		return err
+	}
+	_ = os.Remove(storePath) // remove any stale data with the wrong size
+	return os.Link(osPath, storePath)
+}
+
+// link replaces osPath with the data with hash h from the store,
+// returning false if it isn't there.
+func (s *store) link(osPath string, h string, size int64) bool {
+	if !s.has(h, size) {
+		return false
+	}
+	if err := s.replace(s.path(h), osPath); err != nil {
+		fs.Debugf(nil, "vfs cache: failed to use content store: %v", err)
+		return false
+	}
+	return true
+}
+
+// clean removes data from the store which isn't in use. used should
+// contain the paths of the data in use.
+func (s *store) clean(used map[string]struct{}) {
+	err := filepath.Walk(s.root, func(osPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if _, found := used[osPath]; found {
+			return nil
+		}
+		fs.Debugf(nil, "vfs cache: removing unused data %q from content store", filepath.Base(osPath))
+		if err := os.Remove(osPath); err != nil {
+			fs.Errorf(nil, "vfs cache: failed to remove %q from content store: %v", osPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		fs.Errorf(nil, "vfs cache: failed to clean content store: %v", err)
+	}
+}
+
+// tmpPath returns a path for a temporary file in the store
+func (s *store) tmpPath() string {
+	return filepath.Join(s.root, "tmp-"+random.String(16))
+}
+
+// unshare gives the cache file at osPath its own copy of data shared
+// with the store so it can be modified.
+func (s *store) unshare(osPath string) (err error) {
+	if err = file.MkdirAll(s.root, 0700); err != nil {
+		return err
+	}
+	tmp := s.tmpPath()
+	err = copyFile(osPath, tmp)
+	if err == nil {
+		err = os.Rename(tmp, osPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("vfs cache: failed to unshare cache file: %w", err)
+	}
+	return nil
+}
+
+// copyFile copies the file src to dst
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	out, err := file.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(out, &err)
+	_, err = io.Copy(out, in)
+	return err
+}
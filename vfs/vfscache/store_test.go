@@ -0,0 +1,145 @@
+package vfscache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/ranges"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorePath(t *testing.T) {
+	s := &store{root: "root"}
+	assert.Equal(t, filepath.Join("root", "ab", "abcdef"), s.path("abcdef"))
+	// Hashes which aren't lower case hex are encoded
+	assert.Equal(t, filepath.Join("root", "41", "412f62"), s.path("A/b"))
+}
+
+func TestStoreAddLinkUnshare(t *testing.T) {
+	dir := t.TempDir()
+	s := &store{root: filepath.Join(dir, "store")}
+	file1 := filepath.Join(dir, "file1")
+	file2 := filepath.Join(dir, "file2")
+	require.NoError(t, os.WriteFile(file1, []byte("hello"), 0600))
+	require.NoError(t, os.WriteFile(file2, []byte("hellp"), 0600))
+
+	assert.False(t, s.link(file2, "abcd", 5))
+	require.NoError(t, s.add(file1, "abcd", 5))
+	assert.True(t, s.has("abcd", 5))
+	assert.False(t, s.has("abcd", 6))
+
+	// Adding a file with the same hash shares the data
+	require.NoError(t, s.add(file2, "abcd", 5))
+	fi1, err := os.Stat(file1)
+	require.NoError(t, err)
+	fi2, err := os.Stat(file2)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(fi1, fi2))
+
+	// Unsharing makes a copy
+	require.NoError(t, s.unshare(file2))
+	fi2, err = os.Stat(file2)
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(fi1, fi2))
+	require.NoError(t, os.WriteFile(file2, []byte("world"), 0600))
+	data, err := os.ReadFile(file1)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// Link a file in from the store
+	assert.True(t, s.link(file2, "abcd", 5))
+	data, err = os.ReadFile(file2)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// Clean removes unused data only
+	require.NoError(t, s.add(file1, "ef01", 5))
+	s.clean(map[string]struct{}{s.path("abcd"): {}})
+	assert.True(t, s.has("abcd", 5))
+	assert.False(t, s.has("ef01", 5))
+}
+
+func TestCacheDedupe(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.CacheDedupe = true
+	r, c := newTestCacheOpt(t, opt)
+	if c.hashType == hash.None {
+		t.Skip("no common hash")
+	}
+	ctx := context.Background()
+	contents := "the same contents in two places"
+	size := int64(len(contents))
+
+	read := func(name string) *Item {
+		r.WriteObject(ctx, name, contents, time.Now())
+		o, err := r.Fremote.NewObject(ctx, name)
+		require.NoError(t, err)
+		item, _ := c.get(name)
+		require.NoError(t, item.Open(o))
+		buf := make([]byte, size)
+		_, err = item.ReadAt(buf, 0)
+		require.NoError(t, err)
+		assert.Equal(t, contents, string(buf))
+		require.NoError(t, item.Close(nil))
+		return item
+	}
+
+	// Reading a whole file puts it in the store
+	item1 := read("dir/file1")
+	h := item1.getHash()
+	assert.NotEqual(t, "", h)
+	assert.True(t, c.store.has(h, size))
+
+	// A second file with the same contents is linked in without
+	// downloading it and shares the space
+	r.WriteObject(ctx, "dir/file2", contents, time.Now())
+	o2, err := r.Fremote.NewObject(ctx, "dir/file2")
+	require.NoError(t, err)
+	item2, _ := c.get("dir/file2")
+	require.NoError(t, item2.Open(o2))
+	assert.True(t, item2.HasRange(ranges.Range{Pos: 0, Size: size}))
+	assert.Equal(t, h, item2.getHash())
+	fi1, err := os.Stat(c.toOSPath("dir/file1"))
+	require.NoError(t, err)
+	fi2, err := os.Stat(c.toOSPath("dir/file2"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(fi1, fi2))
+	assert.Equal(t, size, c.updateUsed())
+
+	// Writing to it unshares the data
+	_, err = item2.WriteAt([]byte("THE"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "", item2.getHash())
+	require.NoError(t, item2.Close(nil))
+	data, err := os.ReadFile(c.toOSPath("dir/file1"))
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(data))
+	data, err = os.ReadFile(c.toOSPath("dir/file2"))
+	require.NoError(t, err)
+	assert.Equal(t, "THE"+contents[3:], string(data))
+
+	// Unused data is removed from the store
+	c.cleanStore()
+	assert.True(t, c.store.has(h, size))
+	item1.remove("test")
+	c.cleanStore()
+	assert.False(t, c.store.has(h, size))
+}
+
+func TestCacheNoDedupe(t *testing.T) {
+	_, c := newTestCache(t)
+
+	// The store is only made and cleaned with --vfs-cache-dedupe
+	root := filepath.Dir(c.store.root)
+	assertPathNotExist(t, root)
+	c.clean(false)
+	assertPathNotExist(t, root)
+}
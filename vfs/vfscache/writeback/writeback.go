@@ -5,18 +5,24 @@ package writeback
 import (
 	"container/heap"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/random"
 	"github.com/rclone/rclone/vfs/vfscommon"
 )
 
 const (
-	maxUploadDelay = 5 * time.Minute // max delay between upload attempts
+	maxUploadDelay   = 5 * time.Minute // max delay between upload attempts if not set in the options
+	journalSaveDelay = time.Second     // changes to the queue within this time are saved to the journal together
 )
 
 // PutFn is the interface that item provides to store the data
@@ -30,30 +36,201 @@ type WriteBack struct {
 	// read and written with atomic, must be 64-bit aligned
 	id Handle // id of the last writeBackItem created
 
-	ctx     context.Context
-	mu      sync.Mutex
-	items   writeBackItems            // priority queue of *writeBackItem - writeBackItems are in here while awaiting transfer only
-	lookup  map[Handle]*writeBackItem // for getting a *writeBackItem from a Handle - writeBackItems are in here until cancelled
-	opt     *vfscommon.Options        // VFS options
-	timer   *time.Timer               // next scheduled time for the uploader
-	expiry  time.Time                 // time the next item expires or IsZero
-	uploads int                       // number of uploads in progress
+	ctx      context.Context
+	mu       sync.Mutex
+	items    writeBackItems            // priority queue of *writeBackItem - writeBackItems are in here while awaiting transfer only
+	lookup   map[Handle]*writeBackItem // for getting a *writeBackItem from a Handle - writeBackItems are in here until cancelled
+	opt      *vfscommon.Options        // VFS options
+	policies vfscommon.Policies        // per directory overrides of opt
+	timer    *time.Timer               // next scheduled time for the uploader
+	expiry   time.Time                 // time the next item expires or IsZero
+	uploads  int                       // number of uploads in progress
+	journal  string                    // path of the file the queue is saved in or "" for none
+	// state of items saved in the journal by a previous run not yet added again
+	restored map[string]journalEntry
+	// pending save of the journal or nil if it is up to date
+	journalTimer *time.Timer
+
+	journalMu sync.Mutex // held while writing the journal so writes are in order
 }
 
 // New make a new WriteBack
 //
+// If journal is set the queue is saved in that file shortly after it
+// changes and when ctx is cancelled. The state of items in the
+// journal from a previous run,
+// such as the number of tries and the last error, is restored when
+// they are added again. Call EndRestore when that is done.
+//
+// The write back delay of each item is read from policies if it has
+// an override for it.
+//
 // cancel the context to stop the background processing
-func New(ctx context.Context, opt *vfscommon.Options) *WriteBack {
+func New(ctx context.Context, opt *vfscommon.Options, policies vfscommon.Policies, journal string) *WriteBack {
 	wb := &WriteBack{
-		ctx:    ctx,
-		items:  writeBackItems{},
-		lookup: make(map[Handle]*writeBackItem),
-		opt:    opt,
+		ctx:      ctx,
+		items:    writeBackItems{},
+		lookup:   make(map[Handle]*writeBackItem),
+		opt:      opt,
+		policies: policies,
+		journal:  journal,
+		restored: make(map[string]journalEntry),
 	}
 	heap.Init(&wb.items)
+	if journal != "" {
+		err := wb.loadJournal()
+		if err != nil {
+			fs.Errorf(nil, "vfs cache: failed to read writeback journal: %v", err)
+		}
+		go func() {
+			<-ctx.Done()
+			wb.saveJournal()
+		}()
+	}
 	return wb
 }
 
+// journalEntry is the state of a writeBackItem saved in the journal
+type journalEntry struct {
+	Name      string        `json:"name"`                // name of the item
+	Size      int64         `json:"size"`                // size of the item
+	Expiry    time.Time     `json:"expiry"`              // when it is due to be uploaded
+	Tries     int           `json:"tries"`               // number of times we have tried to upload
+	Delay     time.Duration `json:"delay"`               // delay between upload attempts
+	LastError string        `json:"lastError,omitempty"` // the error from the last upload attempt
+	Failed    bool          `json:"failed,omitempty"`    // set if we have given up uploading
+}
+
+// loadJournal reads the journal saved by a previous run
+func (wb *WriteBack) loadJournal() error {
+	data, err := os.ReadFile(wb.journal)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []journalEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return fmt.Errorf("corrupt journal %q: %w", wb.journal, err)
+	}
+	for _, entry := range entries {
+		wb.restored[entry.Name] = entry
+	}
+	fs.Debugf(nil, "vfs cache: read %d items from writeback journal", len(entries))
+	return nil
+}
+
+// _saveJournal schedules a save of the queue to the journal
+//
+// The journal is written journalSaveDelay later so a burst of changes
+// to the queue is only written once.
+//
+// call with the lock held
+func (wb *WriteBack) _saveJournal() {
+	if wb.journal == "" || wb.journalTimer != nil {
+		return
+	}
+	wb.journalTimer = time.AfterFunc(journalSaveDelay, wb.saveJournal)
+}
+
+// _journalEntries returns the entries to save in the journal
+//
+// call with the lock held
+func (wb *WriteBack) _journalEntries() []journalEntry {
+	entries := make([]journalEntry, 0, len(wb.lookup)+len(wb.restored))
+	for _, wbItem := range wb.lookup {
+		entries = append(entries, journalEntry{
+			Name:      wbItem.name,
+			Size:      wbItem.size,
+			Expiry:    wbItem.expiry,
+			Tries:     wbItem.tries,
+			Delay:     wbItem.delay,
+			LastError: wbItem.lastError,
+			Failed:    wbItem.failed,
+		})
+	}
+	for _, entry := range wb.restored {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// saveJournal writes the queue to the journal now
+//
+// The queue is copied with the lock held but written without it so
+// uploads and changes to the queue aren't held up.
+func (wb *WriteBack) saveJournal() {
+	wb.journalMu.Lock()
+	defer wb.journalMu.Unlock()
+	wb.mu.Lock()
+	if wb.journalTimer != nil {
+		wb.journalTimer.Stop()
+		wb.journalTimer = nil
+	}
+	journal := wb.journal
+	entries := wb._journalEntries()
+	wb.mu.Unlock()
+	if journal == "" {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	err := writeFileAtomic(journal, entries)
+	if err != nil {
+		fs.Errorf(nil, "vfs cache: failed to write writeback journal: %v", err)
+	}
+}
+
+// StopJournal stops saving the queue to the journal and cancels any
+// pending save
+//
+// Call this before removing the directory the journal is in.
+func (wb *WriteBack) StopJournal() {
+	wb.journalMu.Lock()
+	defer wb.journalMu.Unlock()
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if wb.journalTimer != nil {
+		wb.journalTimer.Stop()
+		wb.journalTimer = nil
+	}
+	wb.journal = ""
+}
+
+// writeFileAtomic writes v as JSON to osPath replacing it atomically
+// so a crash can't leave it half written
+func writeFileAtomic(osPath string, v any) (err error) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(osPath), "."+filepath.Base(osPath)+"."+random.String(8)+".tmp")
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, osPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// EndRestore should be called when all the items from the journal
+// which still need uploading have been added again. The state of any
+// items left over is discarded.
+func (wb *WriteBack) EndRestore() {
+	wb.mu.Lock()
+	for name := range wb.restored {
+		fs.Debugf(name, "vfs cache: discarding writeback journal entry for item no longer dirty")
+	}
+	wb.restored = make(map[string]journalEntry)
+	wb.mu.Unlock()
+	wb.saveJournal()
+}
+
 // writeBackItem stores an Item awaiting writeback
 //
 // These are stored on the items heap when awaiting transfer but
@@ -74,6 +251,8 @@ type writeBackItem struct {
 	putFn     PutFn              // To write the object data
 	tries     int                // number of times we have tried to upload
 	delay     time.Duration      // delay between upload attempts
+	lastError string             // the error from the last upload attempt
+	failed    bool               // set if we have given up uploading - not on the heap
 }
 
 // A writeBackItems implements a priority queue by implementing
@@ -122,32 +301,64 @@ func (ws *writeBackItems) _update(item *writeBackItem, expiry time.Time) {
 	heap.Fix(ws, item.index)
 }
 
-// return a new expiry time based from now until the WriteBack timeout
+// return the WriteBack timeout for the item called name
+func (wb *WriteBack) writeBack(name string) time.Duration {
+	return time.Duration(wb.policies.Options(wb.opt, name).WriteBack)
+}
+
+// return a new expiry time for the item called name based from now
+// until the WriteBack timeout
 //
 // call with lock held
-func (wb *WriteBack) _newExpiry() time.Time {
+func (wb *WriteBack) _newExpiry(name string) time.Time {
 	expiry := time.Now()
-	if wb.opt.WriteBack > 0 {
-		expiry = expiry.Add(time.Duration(wb.opt.WriteBack))
+	if writeBack := wb.writeBack(name); writeBack > 0 {
+		expiry = expiry.Add(writeBack)
 	}
 	// expiry = expiry.Round(time.Millisecond)
 	return expiry
 }
 
+// return the maximum delay between upload attempts
+func (wb *WriteBack) maxDelay() time.Duration {
+	if wb.opt.WriteBackMaxDelay > 0 {
+		return time.Duration(wb.opt.WriteBackMaxDelay)
+	}
+	return maxUploadDelay
+}
+
 // make a new writeBackItem
 //
+// If there is an entry for name in the journal from a previous run,
+// the item carries on from where it left off.
+//
 // call with the lock held
 func (wb *WriteBack) _newItem(id Handle, name string, size int64) *writeBackItem {
 	wb.SetID(&id)
 	wbItem := &writeBackItem{
 		name:   name,
 		size:   size,
-		expiry: wb._newExpiry(),
-		delay:  time.Duration(wb.opt.WriteBack),
+		expiry: wb._newExpiry(name),
+		delay:  wb.writeBack(name),
 		id:     id,
 	}
+	if entry, found := wb.restored[name]; found {
+		delete(wb.restored, name)
+		wbItem.tries = entry.Tries
+		wbItem.lastError = entry.LastError
+		wbItem.failed = entry.Failed
+		if entry.Delay > 0 {
+			wbItem.delay = entry.Delay
+		}
+		if entry.Expiry.After(wbItem.expiry) {
+			wbItem.expiry = entry.Expiry
+		}
+		fs.Debugf(name, "vfs cache: restored writeback state from journal: tries=%d, failed=%v", wbItem.tries, wbItem.failed)
+	}
 	wb._addItem(wbItem)
-	wb._pushItem(wbItem)
+	if !wbItem.failed {
+		wb._pushItem(wbItem)
+	}
 	return wbItem
 }
 
@@ -271,12 +482,17 @@ func (wb *WriteBack) Add(id Handle, name string, size int64, modified bool, putF
 			// We are uploading already so cancel the upload
 			wb._cancelUpload(wbItem)
 		}
+		if wbItem.failed && modified {
+			// Modifying an item we gave up on starts it again
+			wb._retry(wbItem)
+		}
 		// Kick the timer on
-		wb.items._update(wbItem, wb._newExpiry())
+		wb.items._update(wbItem, wb._newExpiry(wbItem.name))
 	}
 	wbItem.putFn = putFn
 	wbItem.size = size
 	wb._resetTimer()
+	wb._saveJournal()
 	return wbItem.id
 }
 
@@ -297,6 +513,7 @@ func (wb *WriteBack) _remove(id Handle) (found bool) {
 		wb._removeItem(wbItem)
 		// Remove the item from the lookup map
 		wb._delItem(wbItem)
+		wb._saveJournal()
 	}
 	wb._resetTimer()
 	return found
@@ -338,9 +555,10 @@ func (wb *WriteBack) Rename(id Handle, name string) {
 
 	wbItem.name = name
 	// Kick the timer on
-	wb.items._update(wbItem, wb._newExpiry())
+	wb.items._update(wbItem, wb._newExpiry(wbItem.name))
 
 	wb._resetTimer()
+	wb._saveJournal()
 }
 
 // upload the item - called as a goroutine
@@ -351,6 +569,8 @@ func (wb *WriteBack) upload(ctx context.Context, wbItem *writeBackItem) {
 	defer wb.mu.Unlock()
 	putFn := wbItem.putFn
 	wbItem.tries++
+	// save the try so it counts even if we crash while uploading
+	wb._saveJournal()
 
 	fs.Debugf(wbItem.name, "vfs cache: starting upload")
 
@@ -364,27 +584,35 @@ func (wb *WriteBack) upload(ctx context.Context, wbItem *writeBackItem) {
 	wb.uploads--
 
 	if err != nil {
-		// FIXME should this have a max number of transfer attempts?
 		wbItem.delay *= 2
-		if wbItem.delay > maxUploadDelay {
-			wbItem.delay = maxUploadDelay
+		if wbItem.delay > wb.maxDelay() {
+			wbItem.delay = wb.maxDelay()
 		}
 		if errors.Is(err, context.Canceled) {
 			fs.Infof(wbItem.name, "vfs cache: upload canceled")
 			// Upload was cancelled so reset timer
-			wbItem.delay = time.Duration(wb.opt.WriteBack)
+			wbItem.delay = wb.writeBack(wbItem.name)
 		} else {
-			fs.Errorf(wbItem.name, "vfs cache: failed to upload try #%d, will retry in %v: %v", wbItem.tries, wbItem.delay, err)
+			wbItem.lastError = err.Error()
+			if wb.opt.WriteBackMaxTries > 0 && wbItem.tries >= wb.opt.WriteBackMaxTries {
+				fs.Errorf(wbItem.name, "vfs cache: failed to upload try #%d, giving up: %v", wbItem.tries, err)
+				wbItem.failed = true
+			} else {
+				fs.Errorf(wbItem.name, "vfs cache: failed to upload try #%d, will retry in %v: %v", wbItem.tries, wbItem.delay, err)
+			}
+		}
+		if !wbItem.failed {
+			// push the item back on the queue for retry
+			wb._pushItem(wbItem)
+			wb.items._update(wbItem, time.Now().Add(wbItem.delay))
 		}
-		// push the item back on the queue for retry
-		wb._pushItem(wbItem)
-		wb.items._update(wbItem, time.Now().Add(wbItem.delay))
 	} else {
 		fs.Infof(wbItem.name, "vfs cache: upload succeeded try #%d", wbItem.tries)
 		// show that we are done with the item
 		wb._delItem(wbItem)
 	}
 	wb._resetTimer()
+	wb._saveJournal()
 	close(wbItem.done)
 }
 
@@ -478,6 +706,8 @@ type QueueInfo struct {
 	Tries     int     `json:"tries"`     // number of times we have tried to upload
 	Delay     float64 `json:"delay"`     // delay between upload attempts (s)
 	Uploading bool    `json:"uploading"` // true if item is being uploaded
+	Failed    bool    `json:"failed"`    // true if we have given up uploading the item
+	LastError string  `json:"lastError"` // the error from the last upload attempt if any
 }
 
 // Queue return info about the current upload queue
@@ -498,14 +728,19 @@ func (wb *WriteBack) Queue() []QueueInfo {
 			Tries:     wbItem.tries,
 			Delay:     wbItem.delay.Seconds(),
 			Uploading: wbItem.uploading,
+			Failed:    wbItem.failed,
+			LastError: wbItem.lastError,
 		})
 	}
 
-	// Sort by Uploading first then Expiry
+	// Sort by Uploading first then Expiry with Failed last
 	sort.Slice(items, func(i, j int) bool {
 		if items[i].Uploading != items[j].Uploading {
 			return items[i].Uploading
 		}
+		if items[i].Failed != items[j].Failed {
+			return items[j].Failed
+		}
 		return items[i].Expiry < items[j].Expiry
 	})
 
@@ -541,5 +776,67 @@ func (wb *WriteBack) SetExpiry(id Handle, expiry time.Time, relative time.Durati
 	// Update the expiry with the user requested value
 	wb.items._update(wbItem, expiry)
 	wb._resetTimer()
+	wb._saveJournal()
 	return nil
 }
+
+// ErrorNotFailed is returned from Failed when the item hasn't failed
+var ErrorNotFailed = errors.New("upload of item hasn't failed")
+
+// ErrorUploading is returned from Retry when the item is being uploaded
+var ErrorUploading = errors.New("item is being uploaded")
+
+// _retry starts uploading an item again with its tries reset
+//
+// call with the lock held
+func (wb *WriteBack) _retry(wbItem *writeBackItem) {
+	wbItem.failed = false
+	wbItem.tries = 0
+	wbItem.delay = wb.writeBack(wbItem.name)
+	wb._pushItem(wbItem)
+}
+
+// Retry uploads an item in the writeback queue now, including items
+// which have failed, with its number of tries reset.
+//
+// id should be as returned from the Queue call
+//
+// If the item isn't found then it will return ErrorIDNotFound
+func (wb *WriteBack) Retry(id Handle) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wbItem, ok := wb.lookup[id]
+	if !ok {
+		return ErrorIDNotFound
+	}
+	if wbItem.uploading {
+		return ErrorUploading
+	}
+	wb._retry(wbItem)
+	wb.items._update(wbItem, time.Now())
+	wb._resetTimer()
+	wb._saveJournal()
+	return nil
+}
+
+// Failed returns the name of an item in the writeback queue which
+// has failed to upload.
+//
+// id should be as returned from the Queue call
+//
+// If the item isn't found then it will return ErrorIDNotFound and if
+// it hasn't failed ErrorNotFailed
+func (wb *WriteBack) Failed(id Handle) (name string, err error) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wbItem, ok := wb.lookup[id]
+	if !ok {
+		return "", ErrorIDNotFound
+	}
+	if !wbItem.failed {
+		return "", ErrorNotFailed
+	}
+	return wbItem.name, nil
+}
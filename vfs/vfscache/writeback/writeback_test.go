@@ -3,8 +3,11 @@ package writeback
 import (
 	"container/heap"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -20,7 +23,7 @@ func newTestWriteBack(t *testing.T) (wb *WriteBack, cancel func()) {
 	ctx, cancel := context.WithCancel(context.Background())
 	opt := vfscommon.Opt
 	opt.WriteBack = fs.Duration(100 * time.Millisecond)
-	wb = New(ctx, &opt)
+	wb = New(ctx, &opt, nil, "")
 	return wb, cancel
 }
 
@@ -589,6 +592,153 @@ func TestWriteBackSetExpiry(t *testing.T) {
 	assert.LessOrEqual(t, expiry, -100.0)
 }
 
+// Test giving up uploading and restoring the queue from the journal
+func TestWriteBackMaxTriesJournal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opt := vfscommon.Opt
+	opt.WriteBack = fs.Duration(10 * time.Millisecond)
+	opt.WriteBackMaxDelay = fs.Duration(10 * time.Millisecond)
+	opt.WriteBackMaxTries = 2
+	journal := filepath.Join(t.TempDir(), "queue.json")
+	wb := New(ctx, &opt, nil, journal)
+
+	pi := newPutItem(t)
+	id := wb.Add(0, "one", 10, true, pi.put)
+	wbItem := wb.lookup[id]
+
+	_, err := wb.Failed(id)
+	assert.Equal(t, ErrorNotFailed, err)
+	_, err = wb.Failed(123123123)
+	assert.Equal(t, ErrorIDNotFound, err)
+
+	// Fail the upload until we give up
+	for i := 0; i < 2; i++ {
+		<-pi.started
+		pi.finish(errors.New("transfer failed BOOM"))
+		waitUntilNoTransfers(t, wb)
+	}
+	checkNotOnHeap(t, wb, wbItem)
+	checkInLookup(t, wb, wbItem)
+	_, queued := wb.Stats()
+	assert.Equal(t, 0, queued)
+	queue := wb.Queue()
+	require.Len(t, queue, 1)
+	assert.True(t, queue[0].Failed)
+	assert.Equal(t, 2, queue[0].Tries)
+	assert.Equal(t, "transfer failed BOOM", queue[0].LastError)
+	name, err := wb.Failed(id)
+	require.NoError(t, err)
+	assert.Equal(t, "one", name)
+
+	// Changes are saved to the journal shortly after they are made
+	assert.Eventually(t, func() bool {
+		wb.mu.Lock()
+		defer wb.mu.Unlock()
+		return wb.journalTimer == nil
+	}, 10*time.Second, 10*time.Millisecond)
+	_, err = os.Stat(journal)
+	require.NoError(t, err)
+
+	// Restart the queue from the journal
+	cancel()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	wb = New(ctx, &opt, nil, journal)
+	assert.Len(t, wb.restored, 1)
+
+	// The failed item stays failed when added again
+	id = wb.Add(0, "one", 10, false, pi.put)
+	wbItem = wb.lookup[id]
+	checkNotOnHeap(t, wb, wbItem)
+	assert.True(t, wbItem.failed)
+	assert.Equal(t, 2, wbItem.tries)
+	assert.Equal(t, "transfer failed BOOM", wbItem.lastError)
+
+	// Retrying it uploads it
+	assert.Equal(t, ErrorIDNotFound, wb.Retry(123123123))
+	require.NoError(t, wb.Retry(id))
+	<-pi.started
+	pi.finish(nil)
+	waitUntilNoTransfers(t, wb)
+	checkNotInLookup(t, wb, wbItem)
+
+	// Entries which aren't added again are removed at the end of the restore
+	wb.mu.Lock()
+	opt.WriteBack = fs.Duration(time.Hour) // so "two" isn't uploaded
+	wb.mu.Unlock()
+	wb.Add(0, "two", 10, true, nil)
+	wb.mu.Lock()
+	wb.restored["three"] = journalEntry{Name: "three"}
+	wb.mu.Unlock()
+	wb.saveJournal()
+	readJournal := func() (names []string) {
+		data, err := os.ReadFile(journal)
+		require.NoError(t, err)
+		var entries []journalEntry
+		require.NoError(t, json.Unmarshal(data, &entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"three", "two"}, readJournal())
+	wb.EndRestore()
+	assert.Equal(t, []string{"two"}, readJournal())
+}
+
+// Test changes to the queue are saved to the journal together
+func TestWriteBackJournalBatched(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	opt := vfscommon.Opt
+	opt.WriteBack = fs.Duration(time.Hour)
+	journal := filepath.Join(t.TempDir(), "queue.json")
+	wb := New(ctx, &opt, nil, journal)
+
+	for i := 0; i < 100; i++ {
+		wb.Add(0, fmt.Sprintf("file%03d", i), 10, true, nil)
+	}
+	_, err := os.Stat(journal)
+	assert.True(t, os.IsNotExist(err), "journal written before the save delay")
+
+	// Cancelling the context saves the journal
+	cancel()
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(journal)
+		if err != nil {
+			return false
+		}
+		var entries []journalEntry
+		return json.Unmarshal(data, &entries) == nil && len(entries) == 100
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+// Test the journal isn't written after it has been stopped
+func TestWriteBackStopJournal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	opt := vfscommon.Opt
+	opt.WriteBack = fs.Duration(time.Hour)
+	journal := filepath.Join(t.TempDir(), "queue.json")
+	wb := New(ctx, &opt, nil, journal)
+
+	// Schedule a save then stop the journal
+	wb.Add(0, "one", 10, true, nil)
+	wb.mu.Lock()
+	assert.NotNil(t, wb.journalTimer)
+	wb.mu.Unlock()
+	wb.StopJournal()
+	wb.mu.Lock()
+	assert.Nil(t, wb.journalTimer)
+	wb.mu.Unlock()
+
+	// Neither changes nor cancelling the context save it
+	wb.Add(0, "two", 10, true, nil)
+	cancel()
+	time.Sleep(2 * journalSaveDelay)
+	_, err := os.Stat(journal)
+	assert.True(t, os.IsNotExist(err), "journal written after it was stopped")
+}
+
 // Test queuing more than fs.Config.Transfers
 func TestWriteBackMaxQueue(t *testing.T) {
 	ctx := context.Background()
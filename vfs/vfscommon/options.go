@@ -30,6 +30,16 @@ var OptionsInfo = fs.Options{{
 	Default: fs.Duration(5 * 60 * time.Second),
 	Help:    "Time to cache directory entries for",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_dir_cache_persist",
+	Default: false,
+	Help:    "Save directory listings on disk so they are available after a restart",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_snapshot_time",
+	Default: fs.Time{},
+	Help:    "Show the remote read only as it was at this time using old versions of files",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_refresh",
 	Default: false,
@@ -130,6 +140,16 @@ var OptionsInfo = fs.Options{{
 	Default: fs.Duration(5 * time.Second),
 	Help:    "Time to writeback files after last use when using cache",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_write_back_max_tries",
+	Default: 0,
+	Help:    "Give up uploading a file after this many failed tries (0 for unlimited)",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_write_back_max_delay",
+	Default: fs.Duration(5 * time.Minute),
+	Help:    "Maximum time to wait between tries to upload a file",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_read_ahead",
 	Default: 0 * fs.Mebi,
@@ -145,6 +165,46 @@ var OptionsInfo = fs.Options{{
 	Default: false,
 	Help:    "Use fast (less accurate) fingerprints for change detection",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_cache_dedupe",
+	Default: false,
+	Help:    "Store the data of cached files with the same hash only once",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_metadata_xattrs",
+	Default: false,
+	Help:    "Expose metadata as extended attributes under user.rclone.* on mounts",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_metadata_perms",
+	Default: false,
+	Help:    "Store permissions and ownership set with chmod and chown in metadata",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_locks",
+	Default: false,
+	Help:    "Pass advisory file locks (fcntl and flock) from mounts to rclone",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_lock_remote",
+	Default: "",
+	Help:    "Remote path to store lock files in to share write locks between mounts",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_lock_expiry",
+	Default: fs.Duration(60 * time.Second),
+	Help:    "Time after which a lock file on the remote is considered stale",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_pin_from",
+	Default: "",
+	Help:    "Read paths to keep downloaded in the cache from file",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_policy_file",
+	Default: "",
+	Help:    "Read per directory overrides of the VFS options from file",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_disk_space_total_size",
 	Default: fs.SizeSuffix(-1),
@@ -197,12 +257,24 @@ type Options struct {
 	CachePollInterval  fs.Duration   `config:"vfs_cache_poll_interval"`
 	CaseInsensitive    bool          `config:"vfs_case_insensitive"`
 	BlockNormDupes     bool          `config:"vfs_block_norm_dupes"`
-	WriteWait          fs.Duration   `config:"vfs_write_wait"`       // time to wait for in-sequence write
-	ReadWait           fs.Duration   `config:"vfs_read_wait"`        // time to wait for in-sequence read
-	WriteBack          fs.Duration   `config:"vfs_write_back"`       // time to wait before writing back dirty files
-	ReadAhead          fs.SizeSuffix `config:"vfs_read_ahead"`       // bytes to read ahead in cache mode "full"
-	UsedIsSize         bool          `config:"vfs_used_is_size"`     // if true, use the `rclone size` algorithm for Used size
-	FastFingerprint    bool          `config:"vfs_fast_fingerprint"` // if set use fast fingerprints
+	WriteWait          fs.Duration   `config:"vfs_write_wait"`           // time to wait for in-sequence write
+	ReadWait           fs.Duration   `config:"vfs_read_wait"`            // time to wait for in-sequence read
+	WriteBack          fs.Duration   `config:"vfs_write_back"`           // time to wait before writing back dirty files
+	WriteBackMaxTries  int           `config:"vfs_write_back_max_tries"` // give up uploading after this many tries if > 0
+	WriteBackMaxDelay  fs.Duration   `config:"vfs_write_back_max_delay"` // maximum delay between upload tries
+	ReadAhead          fs.SizeSuffix `config:"vfs_read_ahead"`           // bytes to read ahead in cache mode "full"
+	UsedIsSize         bool          `config:"vfs_used_is_size"`         // if true, use the `rclone size` algorithm for Used size
+	FastFingerprint    bool          `config:"vfs_fast_fingerprint"`     // if set use fast fingerprints
+	CacheDedupe        bool          `config:"vfs_cache_dedupe"`         // if set share the data of cached files with the same hash
+	MetadataXattrs     bool          `config:"vfs_metadata_xattrs"`      // if set expose metadata as extended attributes
+	MetadataPerms      bool          `config:"vfs_metadata_perms"`       // if set store permissions and ownership in metadata
+	Locks              bool          `config:"vfs_locks"`                // if set mounts pass advisory locks to the VFS
+	LockRemote         string        `config:"vfs_lock_remote"`          // if set store lock files here to share write locks
+	LockExpiry         fs.Duration   `config:"vfs_lock_expiry"`          // lock files older than this are stale
+	DirCachePersist    bool          `config:"vfs_dir_cache_persist"`    // if set save directory listings on disk
+	SnapshotTime       fs.Time       `config:"vfs_snapshot_time"`        // if set show the remote as it was at this time
+	PinFrom            string        `config:"vfs_pin_from"`             // file of paths to pin in the cache
+	PolicyFile         string        `config:"vfs_policy_file"`          // file of per directory option overrides
 	DiskSpaceTotalSize fs.SizeSuffix `config:"vfs_disk_space_total_size"`
 }
 
@@ -218,6 +290,11 @@ func (opt *Options) Init() {
 		opt.Links = true
 	}
 
+	// The past can't be changed
+	if opt.SnapshotTime.IsSet() {
+		opt.ReadOnly = true
+	}
+
 	// Mask the permissions with the umask
 	opt.DirPerms &= ^opt.Umask
 	opt.FilePerms &= ^opt.Umask
@@ -0,0 +1,196 @@
+package vfscommon
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+)
+
+// Policy overrides some of the Options for the paths matching a glob
+type Policy struct {
+	Glob      string         // the glob this applies to
+	CacheMode *CacheMode     // if set override vfs_cache_mode
+	ReadOnly  *bool          // if set override read_only
+	WriteBack *fs.Duration   // if set override vfs_write_back
+	ReadAhead *fs.SizeSuffix // if set override vfs_read_ahead
+	re        *regexp.Regexp // the compiled Glob
+}
+
+// ParsePolicy parses a line of a policy file which looks like
+//
+//	glob key=value [key=value...]
+//
+// The glob uses the same syntax as the filters. The keys are the
+// names of the options, optionally with dashes in place of
+// underscores, a leading "--" or without the "vfs_" prefix, so
+// "vfs_cache_mode", "--vfs-cache-mode" and "cache_mode" are the same.
+func ParsePolicy(line string) (p *Policy, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("policy %q needs a glob and at least one key=value", line)
+	}
+	p = &Policy{Glob: fields[0]}
+	p.re, err = filter.GlobPathToRegexp(p.Glob, false)
+	if err != nil {
+		return nil, fmt.Errorf("bad glob in policy %q: %w", line, err)
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("expecting key=value in policy %q but got %q", line, field)
+		}
+		err = p.set(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", line, err)
+		}
+	}
+	return p, nil
+}
+
+// set the override key to value
+func (p *Policy) set(key, value string) (err error) {
+	key = strings.ReplaceAll(strings.TrimPrefix(key, "--"), "-", "_")
+	switch strings.TrimPrefix(key, "vfs_") {
+	case "cache_mode":
+		p.CacheMode = new(CacheMode)
+		err = p.CacheMode.Set(value)
+	case "read_only":
+		var readOnly bool
+		readOnly, err = strconv.ParseBool(value)
+		p.ReadOnly = &readOnly
+	case "write_back":
+		p.WriteBack = new(fs.Duration)
+		err = p.WriteBack.Set(value)
+	case "read_ahead":
+		p.ReadAhead = new(fs.SizeSuffix)
+		err = p.ReadAhead.Set(value)
+	default:
+		return fmt.Errorf("can't override %q - must be one of vfs_cache_mode, read_only, vfs_write_back or vfs_read_ahead", key)
+	}
+	if err != nil {
+		return fmt.Errorf("bad value for %q: %w", key, err)
+	}
+	return nil
+}
+
+// Match returns true if the policy applies to remote
+func (p *Policy) Match(remote string) bool {
+	return p.re.MatchString(remote)
+}
+
+// apply the overrides in p to opt
+func (p *Policy) apply(opt *Options) {
+	if p.CacheMode != nil {
+		opt.CacheMode = *p.CacheMode
+	}
+	if p.ReadOnly != nil {
+		opt.ReadOnly = *p.ReadOnly
+	}
+	if p.WriteBack != nil {
+		opt.WriteBack = *p.WriteBack
+	}
+	if p.ReadAhead != nil {
+		opt.ReadAhead = *p.ReadAhead
+	}
+}
+
+// Policies is a list of Policy. The first one which matches a path
+// is the one which is used.
+type Policies []*Policy
+
+// ReadPolicies reads a policy file with one policy per line as
+// described in ParsePolicy. Blank lines and lines starting with # or
+// ; are ignored.
+func ReadPolicies(file string) (ps Policies, err error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	scanner := bufio.NewScanner(in)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		p, err := ParsePolicy(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, lineNumber, err)
+		}
+		ps = append(ps, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Find returns the first Policy which matches remote or nil if none do
+func (ps Policies) Find(remote string) *Policy {
+	for _, p := range ps {
+		if p.Match(remote) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Options returns the options to use for remote.
+//
+// This is opt if no Policy matches remote, otherwise it is a copy of
+// opt with the overrides of the matching Policy applied. The result
+// should not be modified.
+func (ps Policies) Options(opt *Options, remote string) *Options {
+	p := ps.Find(remote)
+	if p == nil {
+		return opt
+	}
+	newOpt := *opt
+	p.apply(&newOpt)
+	return &newOpt
+}
+
+// ReadOnlyWithin returns true if a policy could make a path inside
+// dir read only.
+//
+// This compares the part of each glob before its first wildcard with
+// dir so it may return true when no path inside dir is read only. A
+// glob which doesn't start with "/" can match inside any directory.
+func (ps Policies) ReadOnlyWithin(dir string) bool {
+	dir += "/"
+	for _, p := range ps {
+		if p.ReadOnly == nil || !*p.ReadOnly {
+			continue
+		}
+		if !strings.HasPrefix(p.Glob, "/") {
+			return true
+		}
+		prefix := p.Glob[1:]
+		if i := strings.IndexAny(prefix, "*?[{\\"); i >= 0 {
+			prefix = prefix[:i]
+		}
+		if dir == "/" || strings.HasPrefix(prefix, dir) || strings.HasPrefix(dir, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxCacheMode returns the highest of cacheMode and the cache modes
+// set by the policies.
+func (ps Policies) MaxCacheMode(cacheMode CacheMode) CacheMode {
+	for _, p := range ps {
+		if p.CacheMode != nil && *p.CacheMode > cacheMode {
+			cacheMode = *p.CacheMode
+		}
+	}
+	return cacheMode
+}
@@ -0,0 +1,101 @@
+package vfscommon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("/archive/** read_only=true --vfs-cache-mode=off")
+	require.NoError(t, err)
+	assert.Equal(t, "/archive/**", p.Glob)
+	require.NotNil(t, p.ReadOnly)
+	assert.True(t, *p.ReadOnly)
+	require.NotNil(t, p.CacheMode)
+	assert.Equal(t, CacheModeOff, *p.CacheMode)
+	assert.Nil(t, p.WriteBack)
+	assert.Nil(t, p.ReadAhead)
+
+	p, err = ParsePolicy("*.mkv  vfs_read_ahead=128M  write-back=10s")
+	require.NoError(t, err)
+	require.NotNil(t, p.ReadAhead)
+	assert.Equal(t, fs.SizeSuffix(128*1024*1024), *p.ReadAhead)
+	require.NotNil(t, p.WriteBack)
+	assert.Equal(t, fs.Duration(10*time.Second), *p.WriteBack)
+
+	for _, line := range []string{
+		"",
+		"/archive/**",
+		"/archive/** read_only",
+		"/archive/** read_only=potato",
+		"/archive/** cache_mode=potato",
+		"/archive/** dir_cache_time=1h",
+		"/archive/{** read_only=true",
+	} {
+		_, err = ParsePolicy(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestPoliciesOptions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "policies")
+	require.NoError(t, os.WriteFile(file, []byte(`# comment
+/work/**    cache_mode=full write_back=10s
+; another comment
+
+/archive/** read_only=true cache_mode=off
+*.mkv       cache_mode=writes read_ahead=1M
+`), 0600))
+	ps, err := ReadPolicies(file)
+	require.NoError(t, err)
+	require.Len(t, ps, 3)
+
+	opt := &Options{CacheMode: CacheModeMinimal, WriteBack: fs.Duration(5 * time.Second)}
+
+	// No match returns the original options
+	assert.True(t, opt == ps.Options(opt, "other/file.txt"))
+	assert.Nil(t, ps.Find("other/file.txt"))
+
+	got := ps.Options(opt, "work/dir/file.txt")
+	assert.Equal(t, CacheModeFull, got.CacheMode)
+	assert.Equal(t, fs.Duration(10*time.Second), got.WriteBack)
+	assert.False(t, got.ReadOnly)
+	assert.Equal(t, CacheModeMinimal, opt.CacheMode, "original must not be modified")
+
+	got = ps.Options(opt, "archive/film.mkv")
+	assert.Equal(t, CacheModeOff, got.CacheMode, "first match wins")
+	assert.True(t, got.ReadOnly)
+	assert.Equal(t, fs.Duration(5*time.Second), got.WriteBack)
+
+	got = ps.Options(opt, "films/film.mkv")
+	assert.Equal(t, CacheModeWrites, got.CacheMode)
+	assert.Equal(t, fs.SizeSuffix(1024*1024), got.ReadAhead)
+
+	assert.Equal(t, CacheModeFull, ps.MaxCacheMode(CacheModeOff))
+	assert.Equal(t, CacheModeWrites, ps[1:].MaxCacheMode(CacheModeOff))
+	assert.Equal(t, CacheModeMinimal, Policies(nil).MaxCacheMode(CacheModeMinimal))
+
+	assert.True(t, ps.ReadOnlyWithin(""))
+	assert.True(t, ps.ReadOnlyWithin("archive"))
+	assert.True(t, ps.ReadOnlyWithin("archive/dir"))
+	assert.False(t, ps.ReadOnlyWithin("work"))
+	assert.False(t, ps.ReadOnlyWithin("arch"))
+	assert.False(t, ps[:1].ReadOnlyWithin(""))
+
+	ps = Policies{{Glob: "*.iso", ReadOnly: &[]bool{true}[0]}}
+	assert.True(t, ps.ReadOnlyWithin("work"))
+
+	require.NoError(t, os.WriteFile(file, []byte("/work/** potato=true\n"), 0600))
+	_, err = ReadPolicies(file)
+	assert.ErrorContains(t, err, "policies:1:")
+
+	_, err = ReadPolicies(filepath.Join(dir, "notfound"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+package vfsdircache
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Object is an fs.Object read from the directory cache.
+//
+// It knows the attributes stored in the listing and reads the obj
+// object from the remote the first time it is needed for anything
+// else. After that all calls go to the obj object.
+type Object struct {
+	f       fs.Fs
+	remote  string
+	size    int64
+	modTime time.Time // zero if not stored
+
+	mu sync.Mutex
+	o  fs.Object // the obj object once read
+}
+
+// newObject makes an Object from the attributes in the cache
+func newObject(f fs.Fs, remote string, size int64, modTime time.Time) *Object {
+	return &Object{
+		f:       f,
+		remote:  remote,
+		size:    size,
+		modTime: modTime,
+	}
+}
+
+// Resolve returns the obj object if o came from the directory cache
+// or o otherwise.
+func Resolve(ctx context.Context, o fs.Object) (fs.Object, error) {
+	if cached, ok := o.(*Object); ok {
+		return cached.resolve(ctx)
+	}
+	return o, nil
+}
+
+// resolve reads the obj object from the remote if not done already
+func (o *Object) resolve(ctx context.Context) (fs.Object, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.o != nil {
+		return o.o, nil
+	}
+	obj, err := o.f.NewObject(ctx, o.remote)
+	if err != nil {
+		return nil, err
+	}
+	o.o = obj
+	return obj, nil
+}
+
+// resolved returns the obj object if it has been read already
+func (o *Object) resolved() fs.Object {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.o
+}
+
+// hasModTime returns true if the modification time is known without
+// reading the obj object
+func (o *Object) hasModTime() bool {
+	return !o.modTime.IsZero() || o.resolved() != nil
+}
+
+// Fs returns the remote the object is on
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// String returns a description of the Object
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	if obj := o.resolved(); obj != nil {
+		return obj.ModTime(ctx)
+	}
+	if !o.modTime.IsZero() {
+		return o.modTime
+	}
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		fs.Debugf(o, "Failed to read object for modification time: %v", err)
+		return time.Now()
+	}
+	return obj.ModTime(ctx)
+}
+
+// Size returns the size of the object
+func (o *Object) Size() int64 {
+	if obj := o.resolved(); obj != nil {
+		return obj.Size()
+	}
+	return o.size
+}
+
+// Hash returns the selected checksum of the object
+func (o *Object) Hash(ctx context.Context, ty hash.Type) (string, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return "", err
+	}
+	return obj.Hash(ctx, ty)
+}
+
+// Storable says whether this object can be stored
+func (o *Object) Storable() bool {
+	return true
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, t time.Time) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.SetModTime(ctx, t)
+}
+
+// Open opens the object for reading
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Open(ctx, options...)
+}
+
+// Update replaces the contents of the object
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Update(ctx, in, src, options...)
+}
+
+// Remove removes the object
+func (o *Object) Remove(ctx context.Context) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Remove(ctx)
+}
+
+// MimeType returns the content type of the object if known
+func (o *Object) MimeType(ctx context.Context) string {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return ""
+	}
+	return fs.MimeType(ctx, obj)
+}
+
+// Metadata returns the metadata of the object
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetMetadata(ctx, obj)
+}
+
+// SetMetadata sets the metadata of the object
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	do, ok := obj.(fs.SetMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.SetMetadata(ctx, metadata)
+}
+
+// UnWrap returns the obj object, reading it if necessary, or nil if
+// it couldn't be read
+func (o *Object) UnWrap() fs.Object {
+	obj, err := o.resolve(context.TODO())
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object          = (*Object)(nil)
+	_ fs.MimeTyper       = (*Object)(nil)
+	_ fs.Metadataer      = (*Object)(nil)
+	_ fs.SetMetadataer   = (*Object)(nil)
+	_ fs.ObjectUnWrapper = (*Object)(nil)
+)
@@ -0,0 +1,207 @@
+// Package vfsdircache stores the directory listings of the VFS on
+// disk so they survive a restart.
+package vfsdircache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// facility is the name of the kv database the listings are stored in
+const facility = "vfs-dir-cache"
+
+// ErrNotFound is returned by Get if there is no listing stored
+var ErrNotFound = errors.New("directory listing not in cache")
+
+// Cache is a persistent cache of the directory listings of a remote
+type Cache struct {
+	f      fs.Fs
+	db     *kv.DB
+	prefix string // prefix for keys so remotes can share the db
+}
+
+// entry is a single directory entry as stored in the database
+type entry struct {
+	Name    string `json:"n"`
+	Dir     bool   `json:"d,omitempty"`
+	Size    int64  `json:"s"`
+	ModTime int64  `json:"t,omitempty"` // in ns since the epoch or 0 if not stored
+}
+
+// listing is a directory listing as stored in the database
+type listing struct {
+	When    time.Time `json:"when"` // when the listing was read from the remote
+	Entries []entry   `json:"entries"`
+}
+
+// New opens the directory cache for f
+func New(ctx context.Context, f fs.Fs) (*Cache, error) {
+	db, err := kv.Start(ctx, facility, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open directory cache: %w", err)
+	}
+	fs.Debugf(f, "Using directory cache %q", db.Path())
+	return &Cache{
+		f:      f,
+		db:     db,
+		prefix: fs.ConfigString(f) + "\x00",
+	}, nil
+}
+
+// key returns the database key for dir
+func (c *Cache) key(dir string) []byte {
+	return []byte(c.prefix + dir)
+}
+
+// Get returns the listing of dir and when it was read from the
+// remote, or ErrNotFound if it isn't in the cache.
+//
+// Objects returned are *Object which read the real object from the
+// remote when needed.
+func (c *Cache) Get(ctx context.Context, dir string) (entries fs.DirEntries, when time.Time, err error) {
+	op := &opGet{key: c.key(dir)}
+	err = c.db.Do(false, op)
+	if errors.Is(err, kv.ErrEmpty) || (err == nil && op.data == nil) {
+		return nil, when, ErrNotFound
+	}
+	if err != nil {
+		return nil, when, err
+	}
+	var l listing
+	if err = json.Unmarshal(op.data, &l); err != nil {
+		return nil, when, fmt.Errorf("corrupted directory cache entry: %w", err)
+	}
+	entries = make(fs.DirEntries, 0, len(l.Entries))
+	for _, e := range l.Entries {
+		remote := path.Join(dir, e.Name)
+		var modTime time.Time
+		if e.ModTime != 0 {
+			modTime = time.Unix(0, e.ModTime)
+		}
+		if e.Dir {
+			entries = append(entries, fs.NewDir(remote, modTime).SetSize(e.Size))
+		} else {
+			entries = append(entries, newObject(c.f, remote, e.Size, modTime))
+		}
+	}
+	return entries, l.When, nil
+}
+
+// Put stores the listing of dir read from the remote at when
+func (c *Cache) Put(ctx context.Context, dir string, entries fs.DirEntries, when time.Time) error {
+	// Don't read the modification times of objects if that
+	// needs a transaction per object
+	slowModTime := c.f.Features().SlowModTime
+	l := listing{
+		When:    when,
+		Entries: make([]entry, 0, len(entries)),
+	}
+	for _, item := range entries {
+		e := entry{
+			Name: path.Base(item.Remote()),
+			Size: item.Size(),
+		}
+		_, isObject := item.(fs.Object)
+		e.Dir = !isObject
+		if o, ok := item.(*Object); ok && !o.hasModTime() {
+			// not known yet so don't read it
+		} else if e.Dir || !slowModTime {
+			e.ModTime = item.ModTime(ctx).UnixNano()
+		}
+		l.Entries = append(l.Entries, e)
+	}
+	data, err := json.Marshal(&l)
+	if err != nil {
+		return err
+	}
+	return c.db.Do(true, &opPut{key: c.key(dir), data: data})
+}
+
+// Remove removes the listing of dir from the cache
+func (c *Cache) Remove(ctx context.Context, dir string) error {
+	err := c.db.Do(true, &opDelete{key: c.key(dir)})
+	if errors.Is(err, kv.ErrEmpty) {
+		err = nil
+	}
+	return err
+}
+
+// RemoveTree removes the listings of dir and all the directories
+// below it from the cache
+func (c *Cache) RemoveTree(ctx context.Context, dir string) error {
+	prefix := c.prefix
+	if dir != "" {
+		prefix += dir + "/"
+	}
+	err := c.db.Do(true, &opDeleteTree{key: c.key(dir), prefix: []byte(prefix)})
+	if errors.Is(err, kv.ErrEmpty) {
+		err = nil
+	}
+	return err
+}
+
+// Close the cache
+func (c *Cache) Close() error {
+	return c.db.Stop(false)
+}
+
+// opGet reads a key from the database
+type opGet struct {
+	key  []byte
+	data []byte
+}
+
+func (op *opGet) Do(ctx context.Context, b kv.Bucket) error {
+	if data := b.Get(op.key); data != nil {
+		// data is only valid during the transaction
+		op.data = append([]byte(nil), data...)
+	}
+	return nil
+}
+
+// opPut writes a key to the database
+type opPut struct {
+	key  []byte
+	data []byte
+}
+
+func (op *opPut) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Put(op.key, op.data)
+}
+
+// opDelete removes a key from the database
+type opDelete struct {
+	key []byte
+}
+
+func (op *opDelete) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete(op.key)
+}
+
+// opDeleteTree removes key and all the keys starting with prefix
+type opDeleteTree struct {
+	key    []byte
+	prefix []byte
+}
+
+func (op *opDeleteTree) Do(ctx context.Context, b kv.Bucket) error {
+	keys := [][]byte{op.key}
+	c := b.Cursor()
+	for k, _ := c.Seek(op.prefix); k != nil && bytes.HasPrefix(k, op.prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package vfsdircache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local" // import the local backend
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain drives the tests
+func TestMain(m *testing.M) {
+	fstest.TestMain(m)
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteObject(ctx, "dir/file1", "hello", t1)
+	r.CheckRemoteItems(t, file1)
+
+	c, err := New(ctx, r.Fremote)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+
+	_, _, err = c.Get(ctx, "dir")
+	assert.Equal(t, ErrNotFound, err)
+
+	entries, err := r.Fremote.List(ctx, "dir")
+	require.NoError(t, err)
+	entries = append(entries, fs.NewDir("dir/sub", t1).SetSize(-1))
+	when := time.Now().Add(-time.Minute).Round(0)
+	require.NoError(t, c.Put(ctx, "dir", entries, when))
+
+	got, gotWhen, err := c.Get(ctx, "dir")
+	require.NoError(t, err)
+	assert.True(t, when.Equal(gotWhen))
+	require.Len(t, got, 2)
+
+	o, ok := got[0].(*Object)
+	require.True(t, ok)
+	assert.Equal(t, "dir/file1", o.Remote())
+	assert.Equal(t, int64(5), o.Size())
+	assert.Nil(t, o.resolved())
+	fstest.AssertTimeEqualWithPrecision(t, o.Remote(), t1, o.ModTime(ctx), r.Fremote.Precision())
+	assert.Nil(t, o.resolved(), "ModTime shouldn't read the object")
+
+	obj, err := Resolve(ctx, o)
+	require.NoError(t, err)
+	assert.NotEqual(t, o, obj)
+	assert.Equal(t, "dir/file1", obj.Remote())
+
+	d, ok := got[1].(fs.Directory)
+	require.True(t, ok)
+	assert.Equal(t, "dir/sub", d.Remote())
+	assert.Equal(t, int64(-1), d.Size())
+
+	// Placeholders for missing objects fail to resolve
+	missing := newObject(r.Fremote, "dir/missing", 1, t1)
+	_, err = missing.Open(ctx)
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+
+	// Removing a tree removes the listings below it only
+	require.NoError(t, c.Put(ctx, "dir/sub", nil, when))
+	require.NoError(t, c.Put(ctx, "dir2", nil, when))
+	require.NoError(t, c.RemoveTree(ctx, "dir"))
+	for _, dir := range []string{"dir", "dir/sub"} {
+		_, _, err = c.Get(ctx, dir)
+		assert.Equal(t, ErrNotFound, err, dir)
+	}
+	_, _, err = c.Get(ctx, "dir2")
+	assert.NoError(t, err)
+
+	require.NoError(t, c.Remove(ctx, "dir2"))
+	_, _, err = c.Get(ctx, "dir2")
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, c.Remove(ctx, "dir2"))
+}
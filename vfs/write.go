@@ -107,6 +107,16 @@ func (fh *WriteFileHandle) Node() Node {
 	return fh.file
 }
 
+// Chmod changes the mode of the file to mode.
+func (fh *WriteFileHandle) Chmod(mode os.FileMode) error {
+	return fh.Node().(*File).Chmod(mode)
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (fh *WriteFileHandle) Chown(uid, gid int) error {
+	return fh.Node().(*File).Chown(uid, gid)
+}
+
 // WriteAt writes len(p) bytes from p to the underlying data stream at offset
 // off. It returns the number of bytes written from p (0 <= n <= len(p)) and
 // any error encountered that caused the write to stop early. WriteAt must
@@ -190,6 +200,7 @@ func (fh *WriteFileHandle) close() (err error) {
 		return ECLOSED
 	}
 	fh.closed = true
+	fh.file.ReleaseLocks(handleLockOwner(fh))
 	// leave writer open until file is transferred
 	defer func() {
 		fh.file.delWriter(fh)
@@ -213,6 +224,17 @@ func (fh *WriteFileHandle) close() (err error) {
 	return err
 }
 
+// Lock takes an exclusive advisory lock on the file, waiting until
+// it is available
+func (fh *WriteFileHandle) Lock() error {
+	return lockHandle(fh, fh.file)
+}
+
+// Unlock releases the lock taken with Lock
+func (fh *WriteFileHandle) Unlock() error {
+	return unlockHandle(fh, fh.file)
+}
+
 // Close closes the file
 func (fh *WriteFileHandle) Close() error {
 	fh.mu.Lock()
@@ -0,0 +1,422 @@
+// Extended attributes
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscache"
+)
+
+// XattrPrefix is the prefix of the names of the extended attributes
+// used to expose the metadata of files and directories.
+//
+// The metadata key "content-type" is read and written as the extended
+// attribute "user.rclone.content-type".
+const XattrPrefix = "user.rclone."
+
+// Flags for SetXattr - these have the same values as in setxattr(2)
+const (
+	XattrCreate  = 1 // fail if the attribute already exists
+	XattrReplace = 2 // fail if the attribute does not exist
+)
+
+// xattrNode is implemented by the Nodes which support extended attributes
+type xattrNode interface {
+	Node
+	// readMetadata reads the metadata for the node
+	readMetadata() (fs.Metadata, error)
+	// writeMetadata merges metadata into the metadata of the node
+	writeMetadata(metadata fs.Metadata) error
+	// removeMetadata removes key from the metadata of the node
+	removeMetadata(key string) error
+	// cachedPerms returns the permissions kept with the metadata or nil
+	cachedPerms() *metadataPerms
+	// setCachedPerms keeps p with the metadata until it changes
+	setCachedPerms(p metadataPerms)
+}
+
+// xattrKey returns the metadata key for the extended attribute name
+// or false if it isn't in our namespace.
+func xattrKey(name string) (key string, ok bool) {
+	key, ok = strings.CutPrefix(name, XattrPrefix)
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// translateMetadataError converts errors from setting metadata into
+// errors the VFS can return
+func translateMetadataError(err error) error {
+	if errors.Is(err, fs.ErrorNotImplemented) {
+		return ENOTSUP
+	}
+	return err
+}
+
+// listXattr returns the names of the extended attributes of n
+func listXattr(n xattrNode) ([]string, error) {
+	if !n.VFS().Opt.MetadataXattrs {
+		return nil, ENOSYS
+	}
+	metadata, err := n.readMetadata()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(metadata))
+	for k := range metadata {
+		names = append(names, XattrPrefix+k)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// getXattr returns the value of the extended attribute name of n
+func getXattr(n xattrNode, name string) ([]byte, error) {
+	if !n.VFS().Opt.MetadataXattrs {
+		return nil, ENOSYS
+	}
+	key, ok := xattrKey(name)
+	if !ok {
+		return nil, ENOATTR
+	}
+	metadata, err := n.readMetadata()
+	if err != nil {
+		return nil, err
+	}
+	value, found := metadata[key]
+	if !found {
+		return nil, ENOATTR
+	}
+	return []byte(value), nil
+}
+
+// setXattr sets the extended attribute name of n to value
+func setXattr(n xattrNode, name string, value []byte, flags int) error {
+	VFS := n.VFS()
+	if !VFS.Opt.MetadataXattrs {
+		return ENOSYS
+	}
+	key, ok := xattrKey(name)
+	if !ok {
+		return ENOTSUP
+	}
+	if VFS.readOnly(n.Path()) {
+		return EROFS
+	}
+	if flags&(XattrCreate|XattrReplace) != 0 {
+		metadata, err := n.readMetadata()
+		if err != nil {
+			return err
+		}
+		_, found := metadata[key]
+		if found && flags&XattrCreate != 0 {
+			return EEXIST
+		}
+		if !found && flags&XattrReplace != 0 {
+			return ENOATTR
+		}
+	}
+	return n.writeMetadata(fs.Metadata{key: string(value)})
+}
+
+// removeXattr removes the extended attribute name from n
+func removeXattr(n xattrNode, name string) error {
+	VFS := n.VFS()
+	if !VFS.Opt.MetadataXattrs {
+		return ENOSYS
+	}
+	key, ok := xattrKey(name)
+	if !ok {
+		return ENOATTR
+	}
+	if VFS.readOnly(n.Path()) {
+		return EROFS
+	}
+	metadata, err := n.readMetadata()
+	if err != nil {
+		return err
+	}
+	if _, found := metadata[key]; !found {
+		return ENOATTR
+	}
+	return n.removeMetadata(key)
+}
+
+// ListXattr returns the names of the extended attributes of the file
+//
+// These are only available if --vfs-metadata-xattrs is set.
+func (f *File) ListXattr() ([]string, error) {
+	return listXattr(f)
+}
+
+// GetXattr returns the value of the extended attribute name
+func (f *File) GetXattr(name string) ([]byte, error) {
+	return getXattr(f, name)
+}
+
+// SetXattr sets the extended attribute name to value
+//
+// flags may contain XattrCreate or XattrReplace.
+func (f *File) SetXattr(name string, value []byte, flags int) error {
+	return setXattr(f, name, value, flags)
+}
+
+// RemoveXattr removes the extended attribute name
+func (f *File) RemoveXattr(name string) error {
+	return removeXattr(f, name)
+}
+
+// copyMetadata returns a copy of metadata which the caller may modify
+func copyMetadata(metadata fs.Metadata) fs.Metadata {
+	out := make(fs.Metadata, len(metadata))
+	out.Merge(metadata)
+	return out
+}
+
+// _clearMetadata forgets the metadata read from the object
+//
+// Call with f.mu held
+func (f *File) _clearMetadata() {
+	f.metadata = nil
+	f.perms = nil
+}
+
+// clearMetadata forgets the metadata read from the object
+func (f *File) clearMetadata() {
+	f.mu.Lock()
+	f._clearMetadata()
+	f.mu.Unlock()
+}
+
+// cachedPerms returns the permissions kept with the metadata or nil
+func (f *File) cachedPerms() *metadataPerms {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.perms
+}
+
+// setCachedPerms keeps p with the metadata until it changes
+//
+// p isn't kept if the metadata has been cleared since it was read.
+func (f *File) setCachedPerms(p metadataPerms) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.metadata != nil {
+		f.perms = &p
+	}
+}
+
+// readMetadata reads the metadata of the file including any metadata
+// waiting to be uploaded with it.
+//
+// The metadata of the object is read once and kept on the File until
+// the object changes or its metadata is written.
+func (f *File) readMetadata() (metadata fs.Metadata, err error) {
+	f.mu.RLock()
+	o, cachePath, cached := f.o, f._cachePath(), f.metadata
+	f.mu.RUnlock()
+	if o != nil && cached == nil {
+		cached, err = fs.GetMetadata(context.TODO(), o)
+		if err != nil {
+			return nil, err
+		}
+		if cached == nil {
+			cached = fs.Metadata{}
+		}
+		f.mu.Lock()
+		if f.o == o {
+			f.metadata = cached
+		}
+		f.mu.Unlock()
+	}
+	metadata = copyMetadata(cached)
+	if f.d.vfs.cache != nil {
+		if item := f.d.vfs.cache.DirtyItem(cachePath); item != nil {
+			metadata.Merge(item.GetMetadata())
+		}
+	}
+	return metadata, nil
+}
+
+// pendingItem returns the cache item if the file is being written or
+// is waiting to be uploaded from the cache, or nil otherwise.
+func (f *File) pendingItem() *vfscache.Item {
+	cache := f.d.vfs.cache
+	if cache == nil {
+		return nil
+	}
+	f.mu.RLock()
+	o, cachePath := f.o, f._cachePath()
+	f.mu.RUnlock()
+	item := cache.DirtyItem(cachePath)
+	if item == nil && o == nil && cache.Exists(cachePath) {
+		item = cache.Item(cachePath)
+	}
+	return item
+}
+
+// writeMetadata merges metadata into the metadata of the file
+//
+// If the file is waiting to be uploaded from the cache then the
+// change is stored in the cache metadata and uploaded with the file,
+// otherwise the metadata of the object is updated directly.
+func (f *File) writeMetadata(metadata fs.Metadata) error {
+	defer f.clearMetadata()
+	if item := f.pendingItem(); item != nil {
+		pending := item.GetMetadata()
+		pending.Merge(metadata)
+		item.SetMetadata(pending)
+		return nil
+	}
+	o := f.getObject()
+	if o == nil {
+		fs.Debugf(f.Path(), "Can't set metadata while the file is being uploaded without --vfs-cache-mode")
+		return ENOTSUP
+	}
+	do, ok := o.(fs.SetMetadataer)
+	if !ok {
+		return ENOTSUP
+	}
+	return translateMetadataError(do.SetMetadata(context.TODO(), metadata))
+}
+
+// removeMetadata removes key from the metadata of the file
+//
+// If the key is waiting to be uploaded with the file then it is
+// removed from the cache metadata, otherwise it is removed from the
+// object if the backend supports it.
+func (f *File) removeMetadata(key string) error {
+	defer f.clearMetadata()
+	if item := f.pendingItem(); item != nil {
+		pending := item.GetMetadata()
+		if _, found := pending[key]; found {
+			delete(pending, key)
+			item.SetMetadata(pending)
+			return nil
+		}
+	}
+	o := f.getObject()
+	if o == nil {
+		return ENOTSUP
+	}
+	do, ok := o.(fs.RemoveMetadataer)
+	if !ok {
+		return ENOTSUP
+	}
+	return translateMetadataError(do.RemoveMetadata(context.TODO(), []string{key}))
+}
+
+// ListXattr returns the names of the extended attributes of the directory
+//
+// These are only available if --vfs-metadata-xattrs is set.
+func (d *Dir) ListXattr() ([]string, error) {
+	return listXattr(d)
+}
+
+// GetXattr returns the value of the extended attribute name
+func (d *Dir) GetXattr(name string) ([]byte, error) {
+	return getXattr(d, name)
+}
+
+// SetXattr sets the extended attribute name to value
+//
+// flags may contain XattrCreate or XattrReplace.
+func (d *Dir) SetXattr(name string, value []byte, flags int) error {
+	return setXattr(d, name, value, flags)
+}
+
+// RemoveXattr removes the extended attribute name
+func (d *Dir) RemoveXattr(name string) error {
+	return removeXattr(d, name)
+}
+
+// _clearMetadata forgets the metadata read from the directory entry
+//
+// Call with d.mu held
+func (d *Dir) _clearMetadata() {
+	d.metadata = nil
+	d.perms = nil
+}
+
+// cachedPerms returns the permissions kept with the metadata or nil
+func (d *Dir) cachedPerms() *metadataPerms {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.perms
+}
+
+// setCachedPerms keeps p with the metadata until it changes
+//
+// p isn't kept if the metadata has been cleared since it was read.
+func (d *Dir) setCachedPerms(p metadataPerms) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.metadata != nil {
+		d.perms = &p
+	}
+}
+
+// readMetadata reads the metadata of the directory
+//
+// The metadata is read once and kept on the Dir until the directory
+// entry changes or its metadata is written.
+func (d *Dir) readMetadata() (fs.Metadata, error) {
+	d.mu.RLock()
+	entry, cached := d.entry, d.metadata
+	d.mu.RUnlock()
+	if cached == nil {
+		var err error
+		cached, err = fs.GetMetadata(context.TODO(), entry)
+		if err != nil {
+			return nil, err
+		}
+		if cached == nil {
+			cached = fs.Metadata{}
+		}
+		d.mu.Lock()
+		if d.entry == entry {
+			d.metadata = cached
+		}
+		d.mu.Unlock()
+	}
+	return copyMetadata(cached), nil
+}
+
+// writeMetadata merges metadata into the metadata of the directory
+func (d *Dir) writeMetadata(metadata fs.Metadata) error {
+	d.mu.RLock()
+	entry := d.entry
+	d.mu.RUnlock()
+	do, ok := entry.(fs.SetMetadataer)
+	if !ok {
+		return ENOTSUP
+	}
+	err := do.SetMetadata(context.TODO(), metadata)
+	d.mu.Lock()
+	d._clearMetadata()
+	d.mu.Unlock()
+	return translateMetadataError(err)
+}
+
+// removeMetadata removes key from the metadata of the directory if
+// the backend supports it
+func (d *Dir) removeMetadata(key string) error {
+	d.mu.RLock()
+	entry := d.entry
+	d.mu.RUnlock()
+	do, ok := entry.(fs.RemoveMetadataer)
+	if !ok {
+		return ENOTSUP
+	}
+	err := do.RemoveMetadata(context.TODO(), []string{key})
+	d.mu.Lock()
+	d._clearMetadata()
+	d.mu.Unlock()
+	return translateMetadataError(err)
+}
@@ -0,0 +1,233 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXattrKey(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"user.rclone.potato", "potato", true},
+		{"user.rclone.content-type", "content-type", true},
+		{"user.rclone.", "", false},
+		{"user.potato", "", false},
+		{"security.selinux", "", false},
+	} {
+		key, ok := xattrKey(test.name)
+		assert.Equal(t, test.key, key, test.name)
+		assert.Equal(t, test.ok, ok, test.name)
+	}
+}
+
+func TestXattrDisabled(t *testing.T) {
+	_, vfs, file, _ := fileCreate(t, vfscommon.CacheModeOff)
+	_, err := file.ListXattr()
+	assert.Equal(t, ENOSYS, err)
+	_, err = file.GetXattr("user.rclone.mtime")
+	assert.Equal(t, ENOSYS, err)
+	assert.Equal(t, ENOSYS, file.SetXattr("user.rclone.potato", []byte("jersey"), 0))
+	assert.Equal(t, ENOSYS, file.RemoveXattr("user.rclone.potato"))
+
+	root, err := vfs.Root()
+	require.NoError(t, err)
+	_, err = root.ListXattr()
+	assert.Equal(t, ENOSYS, err)
+}
+
+// newXattrVFS makes a VFS with xattrs enabled
+func newXattrVFS(t *testing.T, mode vfscommon.CacheMode) *VFS {
+	opt := vfscommon.Opt
+	opt.CacheMode = mode
+	opt.WriteBack = writeBackDelay
+	opt.MetadataXattrs = true
+	_, vfs := newTestVFSOpt(t, &opt)
+	if !vfs.f.Features().ReadMetadata {
+		t.Skip("backend doesn't support metadata")
+	}
+	return vfs
+}
+
+func TestXattrFile(t *testing.T) {
+	vfs := newXattrVFS(t, vfscommon.CacheModeOff)
+	require.NoError(t, vfs.WriteFile("file1", []byte("hello"), 0666))
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+
+	names, err := node.ListXattr()
+	require.NoError(t, err)
+	assert.Contains(t, names, "user.rclone.mtime")
+
+	value, err := node.GetXattr("user.rclone.mtime")
+	require.NoError(t, err)
+	assert.NotEmpty(t, value)
+
+	_, err = node.GetXattr("user.rclone.potato")
+	assert.Equal(t, ENOATTR, err)
+	_, err = node.GetXattr("user.potato")
+	assert.Equal(t, ENOATTR, err)
+	assert.Equal(t, ENOTSUP, node.SetXattr("user.potato", []byte("jersey"), 0))
+	assert.Equal(t, ENOATTR, node.SetXattr("user.rclone.potato", []byte("jersey"), XattrReplace))
+
+	err = node.SetXattr("user.rclone.potato", []byte("jersey"), XattrCreate)
+	if err == ENOTSUP {
+		t.Skip("backend can't set metadata")
+	}
+	require.NoError(t, err)
+	value, err = node.GetXattr("user.rclone.potato")
+	if err == ENOATTR {
+		t.Skip("metadata not stored - xattrs probably not supported")
+	}
+	require.NoError(t, err)
+	assert.Equal(t, "jersey", string(value))
+	assert.Equal(t, EEXIST, node.SetXattr("user.rclone.potato", []byte("royal"), XattrCreate))
+
+	// Metadata on the remote can be removed if the backend can do it
+	assert.Equal(t, ENOATTR, node.RemoveXattr("user.rclone.missing"))
+	err = node.RemoveXattr("user.rclone.potato")
+	if _, ok := node.(*File).getObject().(fs.RemoveMetadataer); !ok {
+		assert.Equal(t, ENOTSUP, err)
+		return
+	}
+	require.NoError(t, err)
+	_, err = node.GetXattr("user.rclone.potato")
+	assert.Equal(t, ENOATTR, err)
+	o, err := vfs.f.NewObject(context.Background(), "file1")
+	require.NoError(t, err)
+	metadata, err := fs.GetMetadata(context.Background(), o)
+	require.NoError(t, err)
+	assert.NotContains(t, metadata, "potato")
+
+	// System metadata can't be removed
+	assert.Equal(t, ENOTSUP, node.RemoveXattr("user.rclone.mtime"))
+}
+
+func TestXattrCached(t *testing.T) {
+	vfs := newXattrVFS(t, vfscommon.CacheModeOff)
+	require.NoError(t, vfs.WriteFile("file1", []byte("hello"), 0666))
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	file := node.(*File)
+	cached := func() fs.Metadata {
+		file.mu.RLock()
+		defer file.mu.RUnlock()
+		return file.metadata
+	}
+
+	// Metadata is kept on the file once read
+	assert.Nil(t, cached())
+	_, err = file.ListXattr()
+	require.NoError(t, err)
+	assert.NotNil(t, cached())
+
+	// Changes to the returned metadata don't change the cache
+	metadata, err := file.readMetadata()
+	require.NoError(t, err)
+	metadata["potato"] = "jersey"
+	assert.NotContains(t, cached(), "potato")
+
+	// Writing metadata invalidates it
+	err = file.SetXattr("user.rclone.potato", []byte("jersey"), 0)
+	if err == ENOTSUP {
+		t.Skip("backend can't set metadata")
+	}
+	require.NoError(t, err)
+	assert.Nil(t, cached())
+
+	// As does a new object
+	_, err = file.GetXattr("user.rclone.mtime")
+	require.NoError(t, err)
+	assert.NotNil(t, cached())
+	file.setObjectNoUpdate(file.getObject())
+	assert.Nil(t, cached())
+
+	// Directory metadata is kept too
+	root, err := vfs.Root()
+	require.NoError(t, err)
+	_, err = root.ListXattr()
+	require.NoError(t, err)
+	root.mu.RLock()
+	assert.NotNil(t, root.metadata)
+	root.mu.RUnlock()
+}
+
+func TestXattrPendingUpload(t *testing.T) {
+	vfs := newXattrVFS(t, vfscommon.CacheModeWrites)
+	if !vfs.f.Features().WriteMetadata {
+		t.Skip("backend doesn't support writing metadata")
+	}
+
+	fd, err := vfs.OpenFile("file1", os.O_CREATE|os.O_WRONLY, 0666)
+	require.NoError(t, err)
+	_, err = fd.Write([]byte("hello"))
+	require.NoError(t, err)
+	node := fd.Node()
+
+	// Set metadata while the file is being written
+	require.NoError(t, node.SetXattr("user.rclone.potato", []byte("jersey"), 0))
+	require.NoError(t, node.SetXattr("user.rclone.remove", []byte("me"), 0))
+	require.NoError(t, node.RemoveXattr("user.rclone.remove"))
+	value, err := node.GetXattr("user.rclone.potato")
+	require.NoError(t, err)
+	assert.Equal(t, "jersey", string(value))
+	_, err = node.GetXattr("user.rclone.remove")
+	assert.Equal(t, ENOATTR, err)
+
+	require.NoError(t, fd.Close())
+	vfs.WaitForWriters(waitForWritersDelay)
+
+	// Check the metadata was uploaded with the file
+	o, err := vfs.f.NewObject(context.Background(), "file1")
+	require.NoError(t, err)
+	metadata, err := fs.GetMetadata(context.Background(), o)
+	require.NoError(t, err)
+	if _, found := metadata["potato"]; !found {
+		t.Skip("metadata not stored - xattrs probably not supported")
+	}
+	assert.Equal(t, "jersey", metadata["potato"])
+	assert.NotContains(t, metadata, "remove")
+}
+
+func TestXattrReadOnly(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.MetadataXattrs = true
+	r, vfs := newTestVFSOpt(t, &opt)
+	r.WriteObject(context.Background(), "file1", "hello", t1)
+	vfs.Opt.ReadOnly = true
+	node, err := vfs.Stat("file1")
+	require.NoError(t, err)
+	assert.Equal(t, EROFS, node.SetXattr("user.rclone.potato", []byte("jersey"), 0))
+	assert.Equal(t, EROFS, node.RemoveXattr("user.rclone.potato"))
+}
+
+func TestXattrRemoveDir(t *testing.T) {
+	vfs := newXattrVFS(t, vfscommon.CacheModeOff)
+	require.NoError(t, vfs.Mkdir("dir", 0777))
+	node, err := vfs.Stat("dir")
+	require.NoError(t, err)
+	err = node.SetXattr("user.rclone.potato", []byte("jersey"), 0)
+	if err == ENOTSUP {
+		t.Skip("backend can't set metadata")
+	}
+	require.NoError(t, err)
+	if _, err = node.GetXattr("user.rclone.potato"); err == ENOATTR {
+		t.Skip("metadata not stored - xattrs probably not supported")
+	}
+	err = node.RemoveXattr("user.rclone.potato")
+	if _, ok := node.(*Dir).entry.(fs.RemoveMetadataer); !ok {
+		assert.Equal(t, ENOTSUP, err)
+		return
+	}
+	require.NoError(t, err)
+	_, err = node.GetXattr("user.rclone.potato")
+	assert.Equal(t, ENOATTR, err)
+}